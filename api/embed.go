@@ -0,0 +1,10 @@
+// Package api embeds the API contracts shipped with the binaries.
+package api
+
+import "embed"
+
+// Protos holds the protobuf event schemas under proto/, used as the default
+// source of the local schema registry.
+//
+//go:embed proto
+var Protos embed.FS
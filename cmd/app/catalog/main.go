@@ -20,6 +20,10 @@ func main() {
     cfg, err := config.Load(cfgPath)
     if err != nil { slog.Error("config", "err", err); os.Exit(1) }
 
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := bootstrap.RunMigrate(ctx, cfg.Catalog.DB, bootstrap.ServiceCatalog, os.Args[2:], os.Stdout); err != nil { slog.Error("migrate", "err", err); os.Exit(1) }
+        return
+    }
     if err := bootstrap.RunCatalog(ctx, cfg); err != nil {
         slog.Error("catalog", "err", err)
         os.Exit(1)
@@ -2,13 +2,17 @@ package main
 
 import (
     "context"
+    "flag"
+    "fmt"
     "log/slog"
     "os"
     "os/signal"
     "syscall"
+    "time"
 
     "dynamic-pricing/config"
     "dynamic-pricing/internal/bootstrap"
+    "dynamic-pricing/internal/kafkautil"
 )
 
 func main() {
@@ -22,5 +26,43 @@ func main() {
 
     cfg, err := config.Load(cfgPath)
     if err != nil { slog.Error("config", "err", err); os.Exit(1) }
+
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := bootstrap.RunMigrate(ctx, cfg.Pricing.DB, bootstrap.ServicePricing, os.Args[2:], os.Stdout); err != nil { slog.Error("migrate", "err", err); os.Exit(1) }
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "replay" {
+        opts, err := parseReplayFlags(os.Args[2:])
+        if err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(2) }
+        if err := bootstrap.RunPricingReplay(ctx, cfg, opts); err != nil { slog.Error("replay", "err", err); os.Exit(1) }
+        return
+    }
     if err := bootstrap.RunPricing(ctx, cfg); err != nil { slog.Error("pricing", "err", err); os.Exit(1) }
 }
+
+// parseReplayFlags handles: replay (-from RFC3339 | -offset N) [-shadow-table NAME]
+func parseReplayFlags(args []string) (bootstrap.ReplayOptions, error) {
+    var opts bootstrap.ReplayOptions
+    fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+    from := fs.String("from", "", "rewind to the first event at or after this RFC3339 timestamp")
+    offset := fs.Int64("offset", -1, "rewind every partition to this offset")
+    fs.StringVar(&opts.ShadowTable, "shadow-table", "", "write recomputed prices to this table instead of prices")
+    if err := fs.Parse(args); err != nil {
+        return opts, err
+    }
+    switch {
+    case *from != "" && *offset >= 0:
+        return opts, fmt.Errorf("replay: -from and -offset are mutually exclusive")
+    case *from != "":
+        at, err := time.Parse(time.RFC3339, *from)
+        if err != nil {
+            return opts, fmt.Errorf("replay: bad -from: %w", err)
+        }
+        opts.Target = kafkautil.OffsetTarget{At: at}
+    case *offset >= 0:
+        opts.Target = kafkautil.OffsetTarget{Offset: *offset}
+    default:
+        return opts, fmt.Errorf("replay: one of -from or -offset is required")
+    }
+    return opts, nil
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,16 +16,29 @@ type Postgres struct {
 	Password string `yaml:"password"`
 	Name     string `yaml:"name"`
 	SSLMode  string `yaml:"sslmode"`
+	// AutoMigrate applies pending embedded migrations when the service starts.
+	AutoMigrate bool `yaml:"auto_migrate"`
 }
 
 type KafkaCatalog struct {
 	Brokers []string `yaml:"brokers"`
 	Topic   string   `yaml:"topic"`
+	// OrdersTopic drives stock: placed orders sell it, canceled ones return it.
+	OrdersTopic string `yaml:"orders_topic"`
+	GroupID     string `yaml:"group_id"`
 }
 
 type KafkaOrder struct {
 	Brokers []string `yaml:"brokers"`
 	Topic   string   `yaml:"topic"`
+	// CatalogTopic feeds the service's view of catalog products.
+	CatalogTopic string `yaml:"catalog_topic"`
+	// PricingTopic feeds the prices orders are placed at.
+	PricingTopic string `yaml:"pricing_topic"`
+	// UsersTopic receives user_* events; they are not published when it
+	// is empty.
+	UsersTopic string `yaml:"users_topic"`
+	GroupID    string `yaml:"group_id"`
 }
 
 type KafkaPricing struct {
@@ -34,28 +49,197 @@ type KafkaPricing struct {
 	GroupID      string   `yaml:"group_id"`
 }
 
+// Topic holds per-topic settings shared by producers and consumers.
+type Topic struct {
+	// Format is the wire format of events: "json" (default envelope),
+	// "cloudevents" (CloudEvents binary mode over Kafka headers) or
+	// "protobuf" (envelope encoded as the Schema message).
+	Format string `yaml:"format"`
+	// Schema is the fully qualified protobuf message used by "protobuf".
+	Schema string `yaml:"schema"`
+
+	// Settings applied when the topic is created and checked for drift at boot.
+	Partitions        int           `yaml:"partitions"`
+	ReplicationFactor int           `yaml:"replication_factor"`
+	Retention         time.Duration `yaml:"retention"`
+	CleanupPolicy     string        `yaml:"cleanup_policy"`
+}
+
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+	FormatProtobuf    = "protobuf"
+)
+
+// SchemaRegistry points at the directory with .proto event schemas. When Dir
+// is empty the schemas embedded into the binary are used.
+type SchemaRegistry struct {
+	Dir string `yaml:"dir"`
+}
+
+// KafkaAdmin controls the topic check every service runs at boot.
+type KafkaAdmin struct {
+	// OnUnreachable is "fail" to abort startup when topics cannot be ensured,
+	// or "warn" (default) to log and continue.
+	OnUnreachable string `yaml:"on_unreachable"`
+}
+
+const (
+	OnUnreachableFail = "fail"
+	OnUnreachableWarn = "warn"
+)
+
 type Catalog struct {
 	HTTPAddr string       `yaml:"http_addr"`
 	DB       Postgres     `yaml:"db"`
 	Kafka    KafkaCatalog `yaml:"kafka"`
+	// ReservationSweep is how often expired stock reservations are
+	// released.
+	ReservationSweep time.Duration `yaml:"reservation_sweep"`
+}
+
+// SweepInterval returns ReservationSweep, defaulting to 30 seconds.
+func (c Catalog) SweepInterval() time.Duration {
+	if c.ReservationSweep <= 0 {
+		return 30 * time.Second
+	}
+	return c.ReservationSweep
 }
 
 type Order struct {
 	HTTPAddr string     `yaml:"http_addr"`
 	DB       Postgres   `yaml:"db"`
 	Kafka    KafkaOrder `yaml:"kafka"`
+	// Products is where orders are checked against the catalog:
+	// "events" (default) for the copy of products built from
+	// catalog.events, or "catalog" to ask the catalog over HTTP.
+	Products string `yaml:"products"`
+	// Catalog is also used to check the reservations orders redeem.
+	Catalog  CatalogClient `yaml:"catalog"`
+	Segments Segments      `yaml:"segments"`
+}
+
+// Segments configures the customer segments users are put into. Zero
+// fields keep the defaults of order.DefaultSegmentRules.
+type Segments struct {
+	// NewFor is how long after signing up a user is new.
+	NewFor time.Duration `yaml:"new_for"`
+	// LoyalOrders is how many orders make a user loyal.
+	LoyalOrders int `yaml:"loyal_orders"`
+	// Sweep is how often the segments of all users are refreshed.
+	Sweep time.Duration `yaml:"sweep"`
+}
+
+// SweepInterval returns Sweep, defaulting to an hour.
+func (s Segments) SweepInterval() time.Duration {
+	if s.Sweep <= 0 {
+		return time.Hour
+	}
+	return s.Sweep
+}
+
+const (
+	ProductsEvents  = "events"
+	ProductsCatalog = "catalog"
+)
+
+// CatalogClient configures the order service's HTTP client for the catalog.
+// Zero values fall back to the client's defaults.
+type CatalogClient struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	// The breaker opens after BreakerFailures failed calls in a row and
+	// stays open for BreakerCooldown.
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
 }
 
 type Pricing struct {
 	HTTPAddr string       `yaml:"http_addr"`
 	DB       Postgres     `yaml:"db"`
 	Kafka    KafkaPricing `yaml:"kafka"`
+	// Currency is the ISO 4217 code prices are quoted in, USD by default.
+	Currency string `yaml:"currency"`
 }
 
+// Quotes configures signed price quotes. Pricing signs them and order
+// verifies them, so both need the same Key; without a key quotes are off.
+// QuotesKeyEnv, when set, overrides Key.
+type Quotes struct {
+	Key string `yaml:"key"`
+	// TTL is how long a quote is honoured, 5 minutes by default.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// QuotesKeyEnv is the environment variable that holds the quote key.
+const QuotesKeyEnv = "QUOTES_KEY"
+
+// placeholderQuotesKey is the key earlier sample configs shipped with; a
+// service refuses to sign or trust quotes with it.
+const placeholderQuotesKey = "change-me"
+
+// Idempotency configures Idempotency-Key support on POST /products and
+// POST /orders.
+type Idempotency struct {
+	// TTL is how long a key and its response are kept, 24 hours by default.
+	TTL time.Duration `yaml:"ttl"`
+	// Purge is how often expired keys are deleted, hourly by default.
+	Purge time.Duration `yaml:"purge"`
+}
+
+// KeyTTL returns TTL, defaulting to 24 hours.
+func (i Idempotency) KeyTTL() time.Duration {
+	if i.TTL <= 0 {
+		return 24 * time.Hour
+	}
+	return i.TTL
+}
+
+// PurgeInterval returns Purge, defaulting to an hour.
+func (i Idempotency) PurgeInterval() time.Duration {
+	if i.Purge <= 0 {
+		return time.Hour
+	}
+	return i.Purge
+}
+
+// All configures the single-process binary (cmd/app/all).
+type All struct {
+	// Storage is "memory" (default) to keep all data in process, or
+	// "postgres" to use the databases of the individual services.
+	Storage string `yaml:"storage"`
+}
+
+const (
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+)
+
 type Root struct {
-	Catalog Catalog `yaml:"catalog"`
-	Order   Order   `yaml:"order"`
-	Pricing Pricing `yaml:"pricing"`
+	Catalog Catalog          `yaml:"catalog"`
+	Order   Order            `yaml:"order"`
+	Pricing Pricing          `yaml:"pricing"`
+	Quotes  Quotes           `yaml:"quotes"`
+	Topics  map[string]Topic `yaml:"topics"`
+
+	Idempotency    Idempotency    `yaml:"idempotency"`
+	SchemaRegistry SchemaRegistry `yaml:"schema_registry"`
+	KafkaAdmin     KafkaAdmin     `yaml:"kafka_admin"`
+	All            All            `yaml:"all"`
+}
+
+// Topic returns the settings for topic. Zero partitions and replication
+// factor leave them to the broker.
+func (r Root) Topic(name string) Topic {
+	return r.Topics[name]
+}
+
+// TopicFormat returns the configured wire format for topic, FormatJSON if unset.
+func (r Root) TopicFormat(topic string) string {
+	if t, ok := r.Topics[topic]; ok && t.Format != "" {
+		return t.Format
+	}
+	return FormatJSON
 }
 
 func Load(path string) (Root, error) {
@@ -70,5 +254,41 @@ func Load(path string) (Root, error) {
 	if cfg.Catalog.HTTPAddr == "" || cfg.Order.HTTPAddr == "" || cfg.Pricing.HTTPAddr == "" {
 		return cfg, errors.New("invalid config")
 	}
+	if k := os.Getenv(QuotesKeyEnv); k != "" {
+		cfg.Quotes.Key = k
+	}
+	if cfg.Quotes.Key == placeholderQuotesKey {
+		return cfg, fmt.Errorf("invalid config: quotes.key is the placeholder %q; set %s or leave it empty to turn quotes off", placeholderQuotesKey, QuotesKeyEnv)
+	}
+	switch cfg.KafkaAdmin.OnUnreachable {
+	case "", OnUnreachableWarn, OnUnreachableFail:
+	default:
+		return cfg, fmt.Errorf("invalid config: kafka_admin.on_unreachable %q", cfg.KafkaAdmin.OnUnreachable)
+	}
+	switch cfg.Order.Products {
+	case "", ProductsEvents:
+	case ProductsCatalog:
+		if cfg.Order.Catalog.URL == "" {
+			return cfg, errors.New("invalid config: order.products catalog requires order.catalog.url")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid config: order.products %q", cfg.Order.Products)
+	}
+	switch cfg.All.Storage {
+	case "", StorageMemory, StoragePostgres:
+	default:
+		return cfg, fmt.Errorf("invalid config: all.storage %q", cfg.All.Storage)
+	}
+	for name, t := range cfg.Topics {
+		switch t.Format {
+		case "", FormatJSON, FormatCloudEvents:
+		case FormatProtobuf:
+			if t.Schema == "" {
+				return cfg, fmt.Errorf("invalid config: topic %s: protobuf format requires schema", name)
+			}
+		default:
+			return cfg, fmt.Errorf("invalid config: topic %s: unknown format %q", name, t.Format)
+		}
+	}
 	return cfg, nil
 }
@@ -1,10 +1,20 @@
 package catalog_api
 
 import (
+    "encoding/csv"
     "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "mime"
     "net/http"
+    "strconv"
+    "strings"
+    "time"
 
+    "dynamic-pricing/internal/models"
     "dynamic-pricing/internal/services/catalog"
+    "dynamic-pricing/internal/storage"
 
     "github.com/go-chi/chi/v5"
     "github.com/google/uuid"
@@ -12,14 +22,31 @@ import (
 
 type Handler struct {
     svc *catalog.Service
+    // idem wraps the POST routes that honour Idempotency-Key.
+    idem func(http.Handler) http.Handler
 }
 
-func NewHandler(svc *catalog.Service) *Handler { return &Handler{svc: svc} }
+type Option func(*Handler)
+
+// WithIdempotency wraps the creating POST route with mw, usually
+// httpserver.Idempotency. Without it the Idempotency-Key header is ignored.
+func WithIdempotency(mw func(http.Handler) http.Handler) Option {
+    return func(h *Handler) { h.idem = mw }
+}
+
+func NewHandler(svc *catalog.Service, opts ...Option) *Handler {
+    h := &Handler{svc: svc, idem: func(next http.Handler) http.Handler { return next }}
+    for _, o := range opts {
+        o(h)
+    }
+    return h
+}
 
 type createReq struct {
-    Name      string  `json:"name"`
-    BasePrice float64 `json:"base_price"`
-    Stock     int     `json:"stock"`
+    Name       string     `json:"name"`
+    BasePrice  float64    `json:"base_price"`
+    Stock      int        `json:"stock"`
+    CategoryID *uuid.UUID `json:"category_id"`
 }
 
 type updateReq struct {
@@ -29,13 +56,56 @@ type updateReq struct {
 
 type stockReq struct { Stock int `json:"stock"` }
 
+type adjustStockReq struct {
+    Delta  int                   `json:"delta"`
+    Reason models.MovementReason `json:"reason"`
+    Note   string                `json:"note"`
+}
+
+type reserveReq struct {
+    Qty int `json:"qty"`
+    // TTLSeconds defaults to the service's reservation TTL when 0.
+    TTLSeconds int `json:"ttl_seconds"`
+}
+
+type backordersReq struct {
+    Enabled bool `json:"enabled"`
+}
+
+type assignCategoryReq struct {
+    CategoryID *uuid.UUID `json:"category_id"`
+}
+
+type categoryReq struct {
+    Name     string     `json:"name"`
+    ParentID *uuid.UUID `json:"parent_id"`
+}
+
 func (h *Handler) Routes() http.Handler {
     r := chi.NewRouter()
     r.Get("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
-    r.Post("/products", h.create)
+    r.With(h.idem).Post("/products", h.create)
+    r.Get("/products", h.list)
+    r.Post("/products:import", h.importProducts)
+    r.Get("/products:export", h.exportProducts)
     r.Get("/products/{id}", h.get)
     r.Put("/products/{id}", h.update)
     r.Patch("/products/{id}/stock", h.updateStock)
+    r.Post("/products/{id}/stock/adjustments", h.adjustStock)
+    r.Get("/products/{id}/movements", h.movements)
+    r.Put("/products/{id}/backorders", h.setBackorders)
+    r.Post("/products/{id}/reservations", h.reserve)
+    r.Get("/products/{id}/reservations/{rid}", h.getReservation)
+    r.Delete("/products/{id}/reservations/{rid}", h.releaseReservation)
+    r.Post("/products/{id}/archive", h.archive)
+    r.Delete("/products/{id}", h.delete)
+    r.Put("/products/{id}/category", h.assignCategory)
+    r.Post("/categories", h.createCategory)
+    r.Get("/categories", h.listCategories)
+    r.Get("/categories/{id}", h.getCategory)
+    r.Put("/categories/{id}", h.updateCategory)
+    r.Delete("/categories/{id}", h.deleteCategory)
+    r.Get("/categories/{id}/products", h.listByCategory)
     return r
 }
 
@@ -45,12 +115,187 @@ func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "bad json", http.StatusBadRequest)
         return
     }
-    p, err := h.svc.Create(r.Context(), req.Name, req.BasePrice, req.Stock)
+    p, err := h.svc.Create(r.Context(), req.Name, req.BasePrice, req.Stock, req.CategoryID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeProduct(w, p, http.StatusCreated)
+}
+
+// list serves GET /products?q=&category_id=&min_price=&max_price=&in_stock=&include_archived=&sort=&order=&limit=&cursor=
+// A category filter covers its subcategories too.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+    q, err := parseListQuery(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    var page catalog.Page
+    if s := r.URL.Query().Get("category_id"); s != "" {
+        categoryID, err := uuid.Parse(s)
+        if err != nil {
+            http.Error(w, "bad category_id", http.StatusBadRequest)
+            return
+        }
+        page, err = h.svc.ListByCategory(r.Context(), categoryID, q, r.URL.Query().Get("cursor"))
+    } else {
+        page, err = h.svc.List(r.Context(), q, r.URL.Query().Get("cursor"))
+    }
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, page, http.StatusOK)
+}
+
+// importProducts serves POST /products:import. The body is CSV or JSONL,
+// picked by ?format= or else by Content-Type, and is read as it arrives.
+// Rejected rows are listed in the report; the rest are imported.
+func (h *Handler) importProducts(w http.ResponseWriter, r *http.Request) {
+    format := catalog.Format(r.URL.Query().Get("format"))
+    if format == "" {
+        ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+        switch ct {
+        case "text/csv":
+            format = catalog.FormatCSV
+        case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+            format = catalog.FormatJSONL
+        default:
+            http.Error(w, "unsupported content type, want text/csv or application/x-ndjson", http.StatusUnsupportedMediaType)
+            return
+        }
+    }
+    report, err := h.svc.Import(r.Context(), r.Body, format)
+    if err != nil {
+        slog.Error("catalog: import stopped", "err", err, "imported", report.Imported, "failed", report.Failed)
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, report, http.StatusOK)
+}
+
+// exportProducts serves GET /products:export?format=csv|jsonl with the
+// filters and ordering of GET /products, streaming every matching product.
+func (h *Handler) exportProducts(w http.ResponseWriter, r *http.Request) {
+    q, err := parseListQuery(r)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-    writeJSON(w, p, http.StatusCreated)
+    var categoryID *uuid.UUID
+    if s := r.URL.Query().Get("category_id"); s != "" {
+        id, err := uuid.Parse(s)
+        if err != nil {
+            http.Error(w, "bad category_id", http.StatusBadRequest)
+            return
+        }
+        categoryID = &id
+    }
+
+    var write func(models.Product) error
+    var flush func() error
+    switch catalog.Format(r.URL.Query().Get("format")) {
+    case "", catalog.FormatJSONL:
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        enc := json.NewEncoder(w)
+        write = func(p models.Product) error { return enc.Encode(p) }
+        flush = func() error { return nil }
+    case catalog.FormatCSV:
+        w.Header().Set("Content-Type", "text/csv")
+        cw := csv.NewWriter(w)
+        header := false
+        write = func(p models.Product) error {
+            if !header {
+                header = true
+                if err := cw.Write(catalog.CSVColumns); err != nil {
+                    return err
+                }
+            }
+            return cw.Write(catalog.CSVRecord(p))
+        }
+        flush = func() error {
+            if !header {
+                if err := cw.Write(catalog.CSVColumns); err != nil {
+                    return err
+                }
+            }
+            cw.Flush()
+            return cw.Error()
+        }
+    default:
+        http.Error(w, "bad format", http.StatusBadRequest)
+        return
+    }
+
+    // Headers go out with the first product, so errors up to then still get
+    // a proper status; after that the stream is just cut short.
+    started := false
+    fn := func(p models.Product) error {
+        started = true
+        return write(p)
+    }
+    if categoryID != nil {
+        err = h.svc.ExportByCategory(r.Context(), *categoryID, q, fn)
+    } else {
+        err = h.svc.Export(r.Context(), q, fn)
+    }
+    if err == nil {
+        err = flush()
+    }
+    if err != nil {
+        if !started {
+            writeError(w, err)
+            return
+        }
+        slog.Error("catalog: export aborted", "err", err)
+    }
+}
+
+func parseListQuery(r *http.Request) (models.ProductQuery, error) {
+    v := r.URL.Query()
+    q := models.ProductQuery{Search: v.Get("q"), Sort: models.ProductSort(v.Get("sort"))}
+    price := func(key string) (*float64, error) {
+        s := v.Get(key)
+        if s == "" {
+            return nil, nil
+        }
+        f, err := strconv.ParseFloat(s, 64)
+        if err != nil {
+            return nil, fmt.Errorf("bad %s", key)
+        }
+        return &f, nil
+    }
+    var err error
+    if q.MinPrice, err = price("min_price"); err != nil {
+        return q, err
+    }
+    if q.MaxPrice, err = price("max_price"); err != nil {
+        return q, err
+    }
+    if s := v.Get("include_archived"); s != "" {
+        if q.IncludeArchived, err = strconv.ParseBool(s); err != nil {
+            return q, fmt.Errorf("bad include_archived")
+        }
+    }
+    if s := v.Get("in_stock"); s != "" {
+        if q.InStock, err = strconv.ParseBool(s); err != nil {
+            return q, fmt.Errorf("bad in_stock")
+        }
+    }
+    switch v.Get("order") {
+    case "", "asc":
+    case "desc":
+        q.Desc = true
+    default:
+        return q, fmt.Errorf("bad order")
+    }
+    if s := v.Get("limit"); s != "" {
+        if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 {
+            return q, fmt.Errorf("bad limit")
+        }
+    }
+    return q, nil
 }
 
 func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +309,7 @@ func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
         http.Error(w, err.Error(), http.StatusNotFound)
         return
     }
-    writeJSON(w, p, http.StatusOK)
+    writeProduct(w, p, http.StatusOK)
 }
 
 func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
@@ -73,17 +318,21 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "bad id", http.StatusBadRequest)
         return
     }
+    version, ok := requireIfMatch(w, r)
+    if !ok {
+        return
+    }
     var req updateReq
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
         http.Error(w, "bad json", http.StatusBadRequest)
         return
     }
-    p, err := h.svc.Update(r.Context(), id, req.Name, req.BasePrice)
+    p, err := h.svc.Update(r.Context(), id, req.Name, req.BasePrice, version)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, err)
         return
     }
-    writeJSON(w, p, http.StatusOK)
+    writeProduct(w, p, http.StatusOK)
 }
 
 func (h *Handler) updateStock(w http.ResponseWriter, r *http.Request) {
@@ -92,17 +341,333 @@ func (h *Handler) updateStock(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "bad id", http.StatusBadRequest)
         return
     }
+    version, ok := requireIfMatch(w, r)
+    if !ok {
+        return
+    }
     var req stockReq
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
         http.Error(w, "bad json", http.StatusBadRequest)
         return
     }
-    p, err := h.svc.UpdateStock(r.Context(), id, req.Stock)
+    p, err := h.svc.UpdateStock(r.Context(), id, req.Stock, version)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeProduct(w, p, http.StatusOK)
+}
+
+// adjustStock adds a signed delta to the stock, whatever it currently is, so
+// unlike PATCH /products/{id}/stock it needs no If-Match.
+func (h *Handler) adjustStock(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req adjustStockReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    p, m, err := h.svc.AdjustStock(r.Context(), id, req.Delta, req.Reason, req.Note)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    w.Header().Set("ETag", etag(p.Version))
+    writeJSON(w, map[string]any{"product": p, "movement": m}, http.StatusOK)
+}
+
+// movements serves GET /products/{id}/movements?limit=&cursor=, newest first.
+func (h *Handler) movements(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    limit := 0
+    if s := r.URL.Query().Get("limit"); s != "" {
+        if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+            http.Error(w, "bad limit", http.StatusBadRequest)
+            return
+        }
+    }
+    page, err := h.svc.Movements(r.Context(), id, r.URL.Query().Get("cursor"), limit)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, page, http.StatusOK)
+}
+
+func (h *Handler) setBackorders(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req backordersReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    p, err := h.svc.SetBackorders(r.Context(), id, req.Enabled)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeProduct(w, p, http.StatusOK)
+}
+
+// reserve holds stock for a checkout. Like adjustments it works on whatever
+// the stock is, so it needs no If-Match.
+func (h *Handler) reserve(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req reserveReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    res, err := h.svc.Reserve(r.Context(), id, req.Qty, time.Duration(req.TTLSeconds)*time.Second)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, res, http.StatusCreated)
+}
+
+func (h *Handler) getReservation(w http.ResponseWriter, r *http.Request) {
+    id, rid, ok := reservationIDs(w, r)
+    if !ok {
+        return
+    }
+    res, err := h.svc.GetReservation(r.Context(), id, rid)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, err)
         return
     }
-    writeJSON(w, p, http.StatusOK)
+    writeJSON(w, res, http.StatusOK)
+}
+
+func (h *Handler) releaseReservation(w http.ResponseWriter, r *http.Request) {
+    id, rid, ok := reservationIDs(w, r)
+    if !ok {
+        return
+    }
+    res, err := h.svc.Release(r.Context(), id, rid)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, res, http.StatusOK)
+}
+
+// reservationIDs parses the product and reservation IDs from the path.
+func reservationIDs(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return id, id, false
+    }
+    rid, err := uuid.Parse(chi.URLParam(r, "rid"))
+    if err != nil {
+        http.Error(w, "bad reservation id", http.StatusBadRequest)
+        return id, rid, false
+    }
+    return id, rid, true
+}
+
+func (h *Handler) archive(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    p, err := h.svc.Archive(r.Context(), id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeProduct(w, p, http.StatusOK)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    if _, err := h.svc.Delete(r.Context(), id); err != nil {
+        writeError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) assignCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req assignCategoryReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    p, err := h.svc.AssignCategory(r.Context(), id, req.CategoryID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeProduct(w, p, http.StatusOK)
+}
+
+func (h *Handler) createCategory(w http.ResponseWriter, r *http.Request) {
+    var req categoryReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.CreateCategory(r.Context(), req.Name, req.ParentID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, c, http.StatusCreated)
+}
+
+func (h *Handler) listCategories(w http.ResponseWriter, r *http.Request) {
+    cs, err := h.svc.ListCategories(r.Context())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if cs == nil {
+        cs = []models.Category{}
+    }
+    writeJSON(w, cs, http.StatusOK)
+}
+
+func (h *Handler) getCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.GetCategory(r.Context(), id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, c, http.StatusOK)
+}
+
+func (h *Handler) updateCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req categoryReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.UpdateCategory(r.Context(), id, req.Name, req.ParentID)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, c, http.StatusOK)
+}
+
+func (h *Handler) deleteCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    if err := h.svc.DeleteCategory(r.Context(), id); err != nil {
+        writeError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// listByCategory serves GET /categories/{id}/products with the filters of
+// GET /products.
+func (h *Handler) listByCategory(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    q, err := parseListQuery(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page, err := h.svc.ListByCategory(r.Context(), id, q, r.URL.Query().Get("cursor"))
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, page, http.StatusOK)
+}
+
+// writeError maps service and storage errors to status codes.
+func writeError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, storage.ErrNotFound):
+        status = http.StatusNotFound
+    case errors.Is(err, catalog.ErrArchived), errors.Is(err, catalog.ErrInsufficientStock), errors.Is(err, catalog.ErrReservationClosed):
+        status = http.StatusConflict
+    case errors.Is(err, catalog.ErrVersionMismatch):
+        status = http.StatusPreconditionFailed
+    case errors.Is(err, storage.ErrConflict):
+        status = http.StatusConflict
+    case errors.Is(err, catalog.ErrInvalidQuery), errors.Is(err, catalog.ErrInvalidCategory), errors.Is(err, catalog.ErrInvalidImport),
+        errors.Is(err, catalog.ErrInvalidMovement), errors.Is(err, catalog.ErrInvalidReservation):
+        status = http.StatusBadRequest
+    }
+    http.Error(w, err.Error(), status)
+}
+
+// writeProduct writes p with its version as a strong ETag, the value
+// If-Match must carry to change it.
+func writeProduct(w http.ResponseWriter, p models.Product, status int) {
+    w.Header().Set("ETag", etag(p.Version))
+    writeJSON(w, p, status)
+}
+
+func etag(version int64) string { return `"` + strconv.FormatInt(version, 10) + `"` }
+
+// requireIfMatch reads the product version from If-Match; "*" matches any
+// version and yields 0. It answers 428 when the header is missing and 412
+// when it holds anything but one strong ETag.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    v := strings.TrimSpace(r.Header.Get("If-Match"))
+    switch {
+    case v == "":
+        http.Error(w, "If-Match with the product ETag is required", http.StatusPreconditionRequired)
+        return 0, false
+    case v == "*":
+        return 0, true
+    }
+    version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(v, `"`), `"`), 10, 64)
+    if err != nil || version < 1 || v != etag(version) {
+        http.Error(w, "If-Match does not match any version", http.StatusPreconditionFailed)
+        return 0, false
+    }
+    return version, true
 }
 
 func writeJSON(w http.ResponseWriter, v any, status int) {
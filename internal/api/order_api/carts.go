@@ -0,0 +1,111 @@
+package order_api
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "dynamic-pricing/internal/services/order"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+)
+
+type createCartReq struct {
+    UserID uuid.UUID `json:"user_id"`
+}
+
+type cartLineReq struct {
+    Qty int `json:"qty"`
+}
+
+func (h *Handler) createCart(w http.ResponseWriter, r *http.Request) {
+    var req createCartReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.CreateCart(r.Context(), req.UserID)
+    if err != nil {
+        http.Error(w, err.Error(), cartStatus(err))
+        return
+    }
+    writeJSON(w, c, http.StatusCreated)
+}
+
+func (h *Handler) getCart(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.GetCart(r.Context(), id)
+    if err != nil {
+        http.Error(w, err.Error(), cartStatus(err))
+        return
+    }
+    writeJSON(w, c, http.StatusOK)
+}
+
+// putCartLine adds a product to the cart or changes its qty.
+func (h *Handler) putCartLine(w http.ResponseWriter, r *http.Request) {
+    var req cartLineReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    h.setCartLine(w, r, req.Qty)
+}
+
+func (h *Handler) deleteCartLine(w http.ResponseWriter, r *http.Request) {
+    h.setCartLine(w, r, 0)
+}
+
+func (h *Handler) setCartLine(w http.ResponseWriter, r *http.Request, qty int) {
+    id, productID, err := cartLineIDs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    c, err := h.svc.SetCartLine(r.Context(), id, productID, qty)
+    if err != nil {
+        http.Error(w, err.Error(), cartStatus(err))
+        return
+    }
+    writeJSON(w, c, http.StatusOK)
+}
+
+func (h *Handler) checkout(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    o, err := h.svc.Checkout(r.Context(), id)
+    if err != nil {
+        http.Error(w, err.Error(), cartStatus(err))
+        return
+    }
+    writeJSON(w, o, http.StatusCreated)
+}
+
+func cartLineIDs(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        return uuid.Nil, uuid.Nil, errors.New("bad id")
+    }
+    productID, err := uuid.Parse(chi.URLParam(r, "product_id"))
+    if err != nil {
+        return uuid.Nil, uuid.Nil, errors.New("bad product_id")
+    }
+    return id, productID, nil
+}
+
+// cartStatus maps cart errors to status codes; the rest are those of
+// placing an order.
+func cartStatus(err error) int {
+    if errors.Is(err, order.ErrCartCheckedOut) || errors.Is(err, order.ErrCartChanged) {
+        return http.StatusConflict
+    }
+    return placeOrderStatus(err)
+}
@@ -1,51 +1,79 @@
 package order_api
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "net/http"
 
+    "dynamic-pricing/internal/models"
     "dynamic-pricing/internal/services/order"
+    "dynamic-pricing/internal/storage"
 
     "github.com/go-chi/chi/v5"
     "github.com/google/uuid"
 )
 
-type Handler struct{ svc *order.Service }
+type Handler struct {
+    svc *order.Service
+    // idem wraps the POST routes that honour Idempotency-Key.
+    idem func(http.Handler) http.Handler
+}
+
+type Option func(*Handler)
 
-func NewHandler(svc *order.Service) *Handler { return &Handler{svc: svc} }
+// WithIdempotency wraps the creating POST route with mw, usually
+// httpserver.Idempotency. Without it the Idempotency-Key header is ignored.
+func WithIdempotency(mw func(http.Handler) http.Handler) Option {
+    return func(h *Handler) { h.idem = mw }
+}
 
-type createUserReq struct{ Email string `json:"email"` }
+func NewHandler(svc *order.Service, opts ...Option) *Handler {
+    h := &Handler{svc: svc, idem: func(next http.Handler) http.Handler { return next }}
+    for _, o := range opts {
+        o(h)
+    }
+    return h
+}
 
 type placeOrderReq struct {
     UserID    string `json:"user_id"`
     ProductID string `json:"product_id"`
     Qty       int    `json:"qty"`
+    // Lines orders several products at once, instead of ProductID and Qty.
+    Lines []lineReq `json:"lines"`
+    // ReservationID optionally names the catalog reservation holding the
+    // stock for this order.
+    ReservationID *uuid.UUID `json:"reservation_id"`
+    // QuoteToken optionally places the order at a price quoted by pricing.
+    QuoteToken string `json:"quote_token"`
 }
 
 func (h *Handler) Routes() http.Handler {
     r := chi.NewRouter()
     r.Get("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
     r.Post("/users", h.createUser)
-    r.Post("/orders", h.placeOrder)
-    r.Post("/orders/{id}/cancel", h.cancelOrder)
+    r.Get("/users", h.listUsers)
+    r.Get("/users/{id}", h.getUser)
+    r.Patch("/users/{id}", h.updateUser)
+    r.Get("/users/{id}/orders", h.userOrders)
+    r.Get("/orders", h.listOrders)
+    r.With(h.idem).Post("/orders", h.placeOrder)
+    r.Post("/orders/{id}/pay", h.transition(h.svc.PayOrder))
+    r.Post("/orders/{id}/fulfill", h.transition(h.svc.FulfillOrder))
+    r.Post("/orders/{id}/deliver", h.transition(h.svc.DeliverOrder))
+    r.Post("/orders/{id}/cancel", h.transition(h.svc.CancelOrder))
+    r.Post("/orders/{id}/refund", h.transition(h.svc.RefundOrder))
+    r.Get("/orders/{id}/history", h.orderHistory)
     r.Get("/orders/{id}", h.getOrder)
+    r.Post("/carts", h.createCart)
+    r.Get("/carts/{id}", h.getCart)
+    r.Put("/carts/{id}/lines/{product_id}", h.putCartLine)
+    r.Delete("/carts/{id}/lines/{product_id}", h.deleteCartLine)
+    r.Post("/carts/{id}/checkout", h.checkout)
     return r
 }
 
-func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
-    var req createUserReq
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "bad json", http.StatusBadRequest)
-        return
-    }
-    u, err := h.svc.CreateUser(r.Context(), req.Email)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-    writeJSON(w, u, http.StatusCreated)
-}
-
 func (h *Handler) placeOrder(w http.ResponseWriter, r *http.Request) {
     var req placeOrderReq
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -57,31 +85,103 @@ func (h *Handler) placeOrder(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "bad user_id", http.StatusBadRequest)
         return
     }
-    productID, err := uuid.Parse(req.ProductID)
+    lines, err := req.lines()
     if err != nil {
-        http.Error(w, "bad product_id", http.StatusBadRequest)
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-    o, err := h.svc.PlaceOrder(r.Context(), userID, productID, req.Qty)
+    o, err := h.svc.PlaceOrder(r.Context(), userID, lines, req.ReservationID, req.QuoteToken)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        http.Error(w, err.Error(), placeOrderStatus(err))
         return
     }
     writeJSON(w, o, http.StatusCreated)
 }
 
-func (h *Handler) cancelOrder(w http.ResponseWriter, r *http.Request) {
+type lineReq struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Qty       int       `json:"qty"`
+}
+
+// lines returns the order lines of req: its lines, or the single line of
+// product_id and qty.
+func (req placeOrderReq) lines() ([]models.OrderLine, error) {
+    if len(req.Lines) > 0 {
+        if req.ProductID != "" {
+            return nil, errors.New("give product_id and qty, or lines")
+        }
+        lines := make([]models.OrderLine, len(req.Lines))
+        for i, l := range req.Lines {
+            lines[i] = models.OrderLine{ProductID: l.ProductID, Qty: l.Qty}
+        }
+        return lines, nil
+    }
+    productID, err := uuid.Parse(req.ProductID)
+    if err != nil {
+        return nil, errors.New("bad product_id")
+    }
+    return []models.OrderLine{{ProductID: productID, Qty: req.Qty}}, nil
+}
+
+// placeOrderStatus maps the reasons an order is refused to status codes.
+func placeOrderStatus(err error) int {
+    switch {
+    case errors.Is(err, order.ErrInvalidQty), errors.Is(err, order.ErrInvalidLines):
+        return http.StatusBadRequest
+    case errors.Is(err, order.ErrProductArchived), errors.Is(err, order.ErrUnknownProduct), errors.Is(err, order.ErrInvalidQuote):
+        return http.StatusUnprocessableEntity
+    case errors.Is(err, order.ErrOutOfStock):
+        return http.StatusConflict
+    case errors.Is(err, order.ErrQuoteExpired):
+        return http.StatusGone
+    case errors.Is(err, order.ErrUserDeactivated):
+        return http.StatusForbidden
+    case errors.Is(err, order.ErrCatalogUnavailable), errors.Is(err, order.ErrNoPrice):
+        return http.StatusServiceUnavailable
+    case errors.Is(err, storage.ErrNotFound):
+        return http.StatusNotFound
+    }
+    return http.StatusInternalServerError
+}
+
+// transition returns a handler moving the {id} order with move.
+func (h *Handler) transition(move func(context.Context, uuid.UUID) (models.Order, error)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id, err := uuid.Parse(chi.URLParam(r, "id"))
+        if err != nil {
+            http.Error(w, "bad id", http.StatusBadRequest)
+            return
+        }
+        o, err := move(r.Context(), id)
+        switch {
+        case err == nil:
+            writeJSON(w, o, http.StatusOK)
+        case errors.Is(err, order.ErrInvalidTransition):
+            http.Error(w, err.Error(), http.StatusConflict)
+        case errors.Is(err, storage.ErrNotFound):
+            http.Error(w, err.Error(), http.StatusNotFound)
+        default:
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+        }
+    }
+}
+
+func (h *Handler) orderHistory(w http.ResponseWriter, r *http.Request) {
     id, err := uuid.Parse(chi.URLParam(r, "id"))
     if err != nil {
         http.Error(w, "bad id", http.StatusBadRequest)
         return
     }
-    o, err := h.svc.CancelOrder(r.Context(), id)
+    changes, err := h.svc.OrderHistory(r.Context(), id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        status := http.StatusInternalServerError
+        if errors.Is(err, storage.ErrNotFound) {
+            status = http.StatusNotFound
+        }
+        http.Error(w, err.Error(), status)
         return
     }
-    writeJSON(w, o, http.StatusOK)
+    writeJSON(w, changes, http.StatusOK)
 }
 
 func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,111 @@
+package order_api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/services/order"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+)
+
+// listOrders serves GET /orders?user_id=&product_id=&status=&from=&to=&limit=&cursor=,
+// newest first. from and to are RFC 3339 times bounding created_at.
+func (h *Handler) listOrders(w http.ResponseWriter, r *http.Request) {
+    q, err := parseOrderQuery(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page, err := h.svc.ListOrders(r.Context(), q, r.URL.Query().Get("cursor"))
+    writePage(w, page, err)
+}
+
+// userOrders serves GET /users/{id}/orders?limit=&cursor=, newest first.
+func (h *Handler) userOrders(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    limit, err := parseLimit(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page, err := h.svc.UserOrders(r.Context(), id, r.URL.Query().Get("cursor"), limit)
+    writePage(w, page, err)
+}
+
+func writePage(w http.ResponseWriter, page order.Page, err error) {
+    switch {
+    case err == nil:
+        writeJSON(w, page, http.StatusOK)
+    case errors.Is(err, order.ErrInvalidQuery):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, storage.ErrNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func parseOrderQuery(r *http.Request) (models.OrderQuery, error) {
+    v := r.URL.Query()
+    q := models.OrderQuery{Status: v.Get("status")}
+    id := func(key string) (*uuid.UUID, error) {
+        s := v.Get(key)
+        if s == "" {
+            return nil, nil
+        }
+        id, err := uuid.Parse(s)
+        if err != nil {
+            return nil, fmt.Errorf("bad %s", key)
+        }
+        return &id, nil
+    }
+    at := func(key string) (*time.Time, error) {
+        s := v.Get(key)
+        if s == "" {
+            return nil, nil
+        }
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return nil, fmt.Errorf("bad %s", key)
+        }
+        return &t, nil
+    }
+    var err error
+    if q.UserID, err = id("user_id"); err != nil {
+        return q, err
+    }
+    if q.ProductID, err = id("product_id"); err != nil {
+        return q, err
+    }
+    if q.From, err = at("from"); err != nil {
+        return q, err
+    }
+    if q.To, err = at("to"); err != nil {
+        return q, err
+    }
+    q.Limit, err = parseLimit(r)
+    return q, err
+}
+
+func parseLimit(r *http.Request) (int, error) {
+    s := r.URL.Query().Get("limit")
+    if s == "" {
+        return 0, nil
+    }
+    limit, err := strconv.Atoi(s)
+    if err != nil || limit < 1 {
+        return 0, errors.New("bad limit")
+    }
+    return limit, nil
+}
@@ -0,0 +1,97 @@
+package order_api
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "dynamic-pricing/internal/services/order"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+)
+
+type createUserReq struct{ Email string `json:"email"` }
+
+// updateUserReq is the body of PATCH /users/{id}; absent fields are kept.
+type updateUserReq struct {
+    Email  *string `json:"email"`
+    Active *bool   `json:"active"`
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+    var req createUserReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    u, err := h.svc.CreateUser(r.Context(), req.Email)
+    if err != nil {
+        http.Error(w, err.Error(), userStatus(err))
+        return
+    }
+    writeJSON(w, u, http.StatusCreated)
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    u, err := h.svc.GetUser(r.Context(), id)
+    if err != nil {
+        http.Error(w, err.Error(), userStatus(err))
+        return
+    }
+    writeJSON(w, u, http.StatusOK)
+}
+
+// listUsers serves GET /users?email=&limit=&cursor=, oldest first.
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+    limit, err := parseLimit(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page, err := h.svc.ListUsers(r.Context(), r.URL.Query().Get("email"), r.URL.Query().Get("cursor"), limit)
+    if err != nil {
+        http.Error(w, err.Error(), userStatus(err))
+        return
+    }
+    writeJSON(w, page, http.StatusOK)
+}
+
+// updateUser serves PATCH /users/{id}: a new email, or "active": false to
+// deactivate the user (true reactivates).
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    var req updateUserReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    u, err := h.svc.UpdateUser(r.Context(), id, order.UserPatch{Email: req.Email, Active: req.Active})
+    if err != nil {
+        http.Error(w, err.Error(), userStatus(err))
+        return
+    }
+    writeJSON(w, u, http.StatusOK)
+}
+
+func userStatus(err error) int {
+    switch {
+    case errors.Is(err, order.ErrInvalidEmail), errors.Is(err, order.ErrInvalidQuery):
+        return http.StatusBadRequest
+    case errors.Is(err, order.ErrEmailTaken):
+        return http.StatusConflict
+    case errors.Is(err, storage.ErrNotFound):
+        return http.StatusNotFound
+    }
+    return http.StatusInternalServerError
+}
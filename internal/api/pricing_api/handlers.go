@@ -3,13 +3,16 @@ package pricing_api
 import (
     "encoding/json"
     "errors"
+    "math"
     "net/http"
 
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/quote"
     "dynamic-pricing/internal/services/pricing"
+    "dynamic-pricing/internal/storage"
 
     "github.com/go-chi/chi/v5"
     "github.com/google/uuid"
-    "github.com/jackc/pgx/v5"
 )
 
 type Handler struct {
@@ -25,6 +28,15 @@ func (h *Handler) Routes() http.Handler {
     r := chi.NewRouter()
     r.Get("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
     r.Get("/prices/{product_id}", h.getPrice)
+    r.Post("/quotes", h.createQuote)
+    r.Get("/policies", h.listPolicies)
+    r.Get("/policies/{scope}/{id}", h.getPolicy)
+    r.Put("/policies/{scope}/{id}", h.putPolicy)
+    r.Delete("/policies/{scope}/{id}", h.deletePolicy)
+    r.Get("/policies/products/{id}/effective", h.effectiveRules)
+    r.Get("/segments", h.listSegments)
+    r.Put("/segments/{segment}", h.putSegment)
+    r.Delete("/segments/{segment}", h.deleteSegment)
     return r
 }
 
@@ -34,25 +46,186 @@ func (h *Handler) getPrice(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "bad id", http.StatusBadRequest)
         return
     }
-    p, err := h.repo.GetPrice(r.Context(), id)
-    if err == nil {
-        writeJSON(w, p, http.StatusOK)
+    var p models.Price
+    if segment := r.URL.Query().Get("segment"); segment != "" {
+        p, err = h.eng.SegmentPrice(r.Context(), id, segment)
+    } else {
+        p, err = h.eng.CurrentPrice(r.Context(), id)
+    }
+    if err != nil {
+        writeError(w, err)
         return
     }
-    if errors.Is(err, pgx.ErrNoRows) {
-        p2, err2 := h.eng.ComputeAndPersistCurrentPrice(r.Context(), id)
-        if err2 == nil {
-            writeJSON(w, p2, http.StatusOK)
-            return
-        }
-        if errors.Is(err2, pricing.ErrUnknownProduct) {
-            http.Error(w, "price not found (unknown product)", http.StatusNotFound)
-            return
-        }
-        http.Error(w, err2.Error(), http.StatusInternalServerError)
+    writeJSON(w, p, http.StatusOK)
+}
+
+type quoteReq struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Qty       int       `json:"qty"`
+    // Segment is the customer segment of the user who will order; without
+    // it the quote is at the plain price.
+    Segment string `json:"segment"`
+}
+
+type quoteResp struct {
+    quote.Quote
+    Total float64 `json:"total"`
+    // Token is handed to the order service to place the order at this price.
+    Token string `json:"token"`
+}
+
+// createQuote fixes the current price for qty of a product, for customers
+// of a segment, until the quote expires.
+func (h *Handler) createQuote(w http.ResponseWriter, r *http.Request) {
+    var req quoteReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
         return
     }
-    http.Error(w, err.Error(), http.StatusInternalServerError)
+    q, token, err := h.eng.Quote(r.Context(), req.ProductID, req.Qty, req.Segment)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    total := math.Round(q.UnitPrice*float64(q.Qty)*100) / 100
+    writeJSON(w, quoteResp{Quote: q, Total: total, Token: token}, http.StatusCreated)
+}
+
+// policyScopes maps the {scope} path segment to a policy scope.
+var policyScopes = map[string]models.PolicyScope{
+    "categories": models.PolicyScopeCategory,
+    "products":   models.PolicyScopeProduct,
+}
+
+func parsePolicyPath(r *http.Request) (models.PolicyScope, uuid.UUID, error) {
+    scope, ok := policyScopes[chi.URLParam(r, "scope")]
+    if !ok {
+        return "", uuid.Nil, errors.New("bad scope (want categories or products)")
+    }
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        return "", uuid.Nil, errors.New("bad id")
+    }
+    return scope, id, nil
+}
+
+func (h *Handler) listPolicies(w http.ResponseWriter, r *http.Request) {
+    ps := h.eng.Policies()
+    if ps == nil {
+        ps = []models.ScopedPolicy{}
+    }
+    writeJSON(w, ps, http.StatusOK)
+}
+
+func (h *Handler) getPolicy(w http.ResponseWriter, r *http.Request) {
+    scope, id, err := parsePolicyPath(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    p, err := h.eng.Policy(scope, id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, p, http.StatusOK)
+}
+
+// putPolicy replaces the policy of a category or product; the body is a
+// models.PricingPolicy, omitted fields are inherited.
+func (h *Handler) putPolicy(w http.ResponseWriter, r *http.Request) {
+    scope, id, err := parsePolicyPath(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    var policy models.PricingPolicy
+    if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    p, err := h.eng.SetPolicy(r.Context(), models.ScopedPolicy{Scope: scope, ID: id, Policy: policy})
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, p, http.StatusOK)
+}
+
+func (h *Handler) deletePolicy(w http.ResponseWriter, r *http.Request) {
+    scope, id, err := parsePolicyPath(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := h.eng.DeletePolicy(r.Context(), scope, id); err != nil {
+        writeError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// effectiveRules shows the merged rules the engine prices a product with.
+func (h *Handler) effectiveRules(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(chi.URLParam(r, "id"))
+    if err != nil {
+        http.Error(w, "bad id", http.StatusBadRequest)
+        return
+    }
+    rules, err := h.eng.EffectiveRules(id)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, rules, http.StatusOK)
+}
+
+func (h *Handler) listSegments(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, h.eng.SegmentMultipliers(), http.StatusOK)
+}
+
+type segmentReq struct {
+    Multiplier float64 `json:"multiplier"`
+}
+
+// putSegment sets the multiplier a customer segment's prices are computed
+// with.
+func (h *Handler) putSegment(w http.ResponseWriter, r *http.Request) {
+    var req segmentReq
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "bad json", http.StatusBadRequest)
+        return
+    }
+    m, err := h.eng.SetSegmentMultiplier(r.Context(), chi.URLParam(r, "segment"), req.Multiplier)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, m, http.StatusOK)
+}
+
+func (h *Handler) deleteSegment(w http.ResponseWriter, r *http.Request) {
+    if err := h.eng.DeleteSegmentMultiplier(r.Context(), chi.URLParam(r, "segment")); err != nil {
+        writeError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, storage.ErrNotFound), errors.Is(err, pricing.ErrUnknownProduct):
+        status = http.StatusNotFound
+    case errors.Is(err, pricing.ErrArchivedProduct):
+        status = http.StatusGone
+    case errors.Is(err, pricing.ErrInvalidPolicy), errors.Is(err, pricing.ErrInvalidQty),
+        errors.Is(err, pricing.ErrUnknownSegment), errors.Is(err, pricing.ErrInvalidMultiplier):
+        status = http.StatusBadRequest
+    case errors.Is(err, pricing.ErrQuotesDisabled):
+        status = http.StatusNotImplemented
+    }
+    http.Error(w, err.Error(), status)
 }
 
 func writeJSON(w http.ResponseWriter, v any, status int) {
@@ -0,0 +1,198 @@
+package bootstrap
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"dynamic-pricing/config"
+	"dynamic-pricing/internal/api/catalog_api"
+	"dynamic-pricing/internal/api/order_api"
+	"dynamic-pricing/internal/api/pricing_api"
+	"dynamic-pricing/internal/httpserver"
+	"dynamic-pricing/internal/membus"
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/services/order"
+	pricing "dynamic-pricing/internal/services/pricing"
+	"dynamic-pricing/internal/storage/memory"
+	"dynamic-pricing/internal/storage/pg"
+)
+
+// allRepos are the repositories the single-process mode runs on.
+type allRepos struct {
+	catalog catalog.Repository
+	order   order.OrderRepository
+	price   pricing.PriceRepository
+	policy  pricing.PolicyRepository
+	segment pricing.SegmentRepository
+	// catalogKeys and orderKeys hold the Idempotency-Key records.
+	catalogKeys httpserver.IdempotencyStore
+	orderKeys   httpserver.IdempotencyStore
+}
+
+// allInOne is the wired single-process system: the three HTTP APIs sharing
+// one in-memory bus.
+type allInOne struct {
+	bus     *membus.Bus
+	catalog http.Handler
+	order   http.Handler
+	pricing http.Handler
+	subs    []*membus.Subscriber
+}
+
+// RunAll runs catalog, order and pricing in one process. Events travel over
+// an in-memory bus instead of Kafka; data is kept in memory or in the
+// services' databases depending on all.storage. Each service keeps its own
+// HTTP address from cfg.
+func RunAll(ctx context.Context, cfg config.Root) error {
+	repos := allRepos{
+		catalog: memory.NewCatalogRepository(),
+		order:   memory.NewOrderRepository(),
+		price:   memory.NewPriceRepository(),
+		policy:  memory.NewPolicyRepository(),
+		segment: memory.NewSegmentRepository(),
+
+		catalogKeys: memory.NewIdempotencyStore(),
+		orderKeys:   memory.NewIdempotencyStore(),
+	}
+	if cfg.All.Storage == config.StoragePostgres {
+		catalogDB, err := pg.NewPool(ctx, cfg.Catalog.DB)
+		if err != nil {
+			return err
+		}
+		defer catalogDB.Close()
+		if err := migrateOnBoot(ctx, catalogDB, cfg.Catalog.DB, ServiceCatalog); err != nil {
+			return err
+		}
+		orderDB, err := pg.NewPool(ctx, cfg.Order.DB)
+		if err != nil {
+			return err
+		}
+		defer orderDB.Close()
+		if err := migrateOnBoot(ctx, orderDB, cfg.Order.DB, ServiceOrder); err != nil {
+			return err
+		}
+		pricingDB, err := pg.NewPool(ctx, cfg.Pricing.DB)
+		if err != nil {
+			return err
+		}
+		defer pricingDB.Close()
+		if err := migrateOnBoot(ctx, pricingDB, cfg.Pricing.DB, ServicePricing); err != nil {
+			return err
+		}
+		repos = allRepos{
+			catalog: pg.NewCatalogRepository(catalogDB),
+			order:   pg.NewOrderRepository(orderDB),
+			price:   pg.NewPriceRepository(pricingDB),
+			policy:  pg.NewPolicyRepository(pricingDB),
+			segment: pg.NewSegmentRepository(pricingDB),
+
+			catalogKeys: pg.NewIdempotencyStore(catalogDB),
+			orderKeys:   pg.NewIdempotencyStore(orderDB),
+		}
+	}
+
+	app, err := newAllInOne(ctx, cfg, repos)
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	servers := []*httpserver.Server{
+		startHTTP(cfg.Catalog.HTTPAddr, app.catalog),
+		startHTTP(cfg.Order.HTTPAddr, app.order),
+		startHTTP(cfg.Pricing.HTTPAddr, app.pricing),
+	}
+
+	<-ctx.Done()
+	shCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		_ = srv.Shutdown(shCtx)
+	}
+	return nil
+}
+
+// newAllInOne wires the services on repos and starts the subscribers, which
+// stop when ctx is done or close is called.
+func newAllInOne(ctx context.Context, cfg config.Root, repos allRepos) (*allInOne, error) {
+	bus := membus.New()
+
+	catalogSvc := catalog.NewService(repos.catalog, bus.Publisher(cfg.Catalog.Kafka.Topic))
+	orderOpts := append(orderOptions(cfg), order.WithReservationLookup(catalogSvc))
+	if cfg.Order.Kafka.UsersTopic != "" {
+		orderOpts = append(orderOpts, order.WithUserEvents(bus.Publisher(cfg.Order.Kafka.UsersTopic)))
+	}
+	orderSvc := order.NewService(repos.order, bus.Publisher(cfg.Order.Kafka.Topic), orderOpts...)
+	if cfg.Order.Products != config.ProductsCatalog {
+		backfillProducts(ctx, orderSvc, catalogExport{catalogSvc})
+	}
+	eng := pricing.NewEngine(repos.price, bus.Publisher(cfg.Pricing.Kafka.PricingTopic), pricing.WithPolicyRepository(repos.policy), pricing.WithSegmentRepository(repos.segment), pricing.WithCurrency(cfg.Pricing.Currency), pricing.WithQuotes(quoteSigner(cfg), cfg.Quotes.TTL))
+	if err := eng.LoadPolicies(ctx); err != nil {
+		return nil, err
+	}
+	if err := eng.LoadSegments(ctx); err != nil {
+		return nil, err
+	}
+	if err := eng.LoadRetired(ctx); err != nil {
+		return nil, err
+	}
+
+	catalogSub := bus.Subscribe(cfg.Pricing.Kafka.CatalogTopic)
+	ordersSub := bus.Subscribe(cfg.Pricing.Kafka.OrdersTopic)
+	onCatalog, onOrder := pricingHandlers(eng)
+	go catalogSub.Run(ctx, onCatalog)
+	go ordersSub.Run(ctx, onOrder)
+
+	orderCatalogSub := bus.Subscribe(cfg.Order.Kafka.CatalogTopic)
+	go orderCatalogSub.Run(ctx, orderCatalogHandler(orderSvc))
+	orderPricingSub := bus.Subscribe(cfg.Order.Kafka.PricingTopic)
+	go orderPricingSub.Run(ctx, orderPricingHandler(orderSvc))
+	go orderSvc.RunSegmentSweeper(ctx, cfg.Order.Segments.SweepInterval())
+
+	catalogOrdersSub := bus.Subscribe(cfg.Catalog.Kafka.OrdersTopic)
+	go catalogOrdersSub.Run(ctx, catalogOrdersHandler(catalogSvc))
+	go catalogSvc.RunReservationSweeper(ctx, cfg.Catalog.SweepInterval())
+
+	// The order service's copy of prices may miss prices stored before it
+	// was subscribed; announce them all again.
+	if _, err := eng.RepublishPrices(ctx); err != nil {
+		return nil, err
+	}
+
+	return &allInOne{
+		bus:     bus,
+		catalog: catalog_api.NewHandler(catalogSvc, catalog_api.WithIdempotency(idempotency(ctx, cfg, repos.catalogKeys))).Routes(),
+		order:   order_api.NewHandler(orderSvc, order_api.WithIdempotency(idempotency(ctx, cfg, repos.orderKeys))).Routes(),
+		pricing: pricing_api.NewHandler(repos.price, eng).Routes(),
+		subs:    []*membus.Subscriber{catalogSub, ordersSub, orderCatalogSub, orderPricingSub, catalogOrdersSub},
+	}, nil
+}
+
+// catalogExport lists the products of the in-process catalog for the order
+// service's backfill.
+type catalogExport struct{ svc *catalog.Service }
+
+func (e catalogExport) ExportProducts(ctx context.Context, fn func(models.CatalogProduct) error) error {
+	return e.svc.Export(ctx, models.ProductQuery{IncludeArchived: true}, func(p models.Product) error {
+		return fn(models.NewCatalogProduct(p))
+	})
+}
+
+func (a *allInOne) close() {
+	for _, s := range a.subs {
+		_ = s.Close()
+	}
+}
+
+func startHTTP(addr string, routes http.Handler) *httpserver.Server {
+	srv := httpserver.New(addr, httpserver.WithMetrics(httpserver.CORS(routes)))
+	go func() {
+		if err := srv.Start(); err != nil {
+			slog.Error("http", "addr", addr, "err", err)
+		}
+	}()
+	return srv
+}
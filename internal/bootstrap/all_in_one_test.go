@@ -0,0 +1,958 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dynamic-pricing/config"
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() config.Root {
+	var cfg config.Root
+	cfg.Catalog.Kafka.Topic = "catalog.events"
+	cfg.Catalog.Kafka.OrdersTopic = "orders.events"
+	cfg.Order.Kafka.Topic = "orders.events"
+	cfg.Order.Kafka.CatalogTopic = "catalog.events"
+	cfg.Order.Kafka.PricingTopic = "pricing.events"
+	cfg.Order.Kafka.UsersTopic = "users.events"
+	cfg.Pricing.Kafka.CatalogTopic = "catalog.events"
+	cfg.Pricing.Kafka.OrdersTopic = "orders.events"
+	cfg.Pricing.Kafka.PricingTopic = "pricing.events"
+	cfg.Quotes.Key = "test"
+	return cfg
+}
+
+func newTestApp(t *testing.T) (*allInOne, context.Context) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	app, err := newAllInOne(ctx, testConfig(), allRepos{
+		catalog: memory.NewCatalogRepository(),
+		order:   memory.NewOrderRepository(),
+		price:   memory.NewPriceRepository(),
+		policy:  memory.NewPolicyRepository(),
+		segment: memory.NewSegmentRepository(),
+
+		catalogKeys: memory.NewIdempotencyStore(),
+		orderKeys:   memory.NewIdempotencyStore(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(app.close)
+	return app, ctx
+}
+
+func call(t *testing.T, h http.Handler, method, path string, body any, out any) int {
+	t.Helper()
+	code, _ := callHeader(t, h, method, path, nil, body, out)
+	return code
+}
+
+// callHeader is call with request headers, returning the response headers.
+func callHeader(t *testing.T, h http.Handler, method, path string, header http.Header, body any, out any) (int, http.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil && rec.Code < 300 {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+	return rec.Code, rec.Header()
+}
+
+func TestAllInOne_OrderRaisesPrice(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 100.0, price.CurrentPrice, 0.0001)
+
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	var placed struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 2}, &placed))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 104.0, price.CurrentPrice, 0.0001)
+
+	// The order keeps the price it was placed at.
+	var o struct {
+		UnitPrice float64 `json:"unit_price"`
+		Currency  string  `json:"currency"`
+		Total     float64 `json:"total"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/orders/"+placed.ID, nil, &o))
+	require.InDelta(t, 100.0, o.UnitPrice, 0.0001)
+	require.Equal(t, "USD", o.Currency)
+	require.InDelta(t, 200.0, o.Total, 0.0001)
+}
+
+func TestAllInOne_ArchivedProduct(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "POST", "/products/"+product.ID+"/archive", nil, nil))
+	require.Equal(t, http.StatusConflict, call(t, app.catalog, "POST", "/products/"+product.ID+"/archive", nil, nil))
+	code, _ := callHeader(t, app.catalog, "PATCH", "/products/"+product.ID+"/stock", http.Header{"If-Match": {"*"}}, map[string]any{"stock": 1}, nil)
+	require.Equal(t, http.StatusConflict, code)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 1}, nil))
+	require.Equal(t, http.StatusGone, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, nil))
+
+	require.Equal(t, http.StatusNoContent, call(t, app.catalog, "DELETE", "/products/"+product.ID, nil, nil))
+	require.Equal(t, http.StatusNotFound, call(t, app.catalog, "GET", "/products/"+product.ID, nil, nil))
+}
+
+func TestAllInOne_CategoryPolicy(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var food, fruit struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/categories", map[string]any{"name": "Food"}, &food))
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/categories", map[string]any{"name": "Fruit", "parent_id": food.ID}, &fruit))
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 2, "category_id": fruit.ID}, &product))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 120.0, price.CurrentPrice, 0.0001) // default low-stock markup
+
+	// The parent category's policy reaches products of subcategories.
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "PUT", "/policies/categories/"+food.ID, map[string]any{"low_stock_markup": 0.1}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 110.0, price.CurrentPrice, 0.0001)
+
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "PUT", "/policies/products/"+product.ID, map[string]any{"strategy": "fixed"}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 100.0, price.CurrentPrice, 0.0001)
+	require.Equal(t, http.StatusBadRequest, call(t, app.pricing, "PUT", "/policies/products/"+product.ID, map[string]any{"demand_step": -1}, nil))
+
+	// Out of the category, only the product policy is left.
+	require.Equal(t, http.StatusNoContent, call(t, app.pricing, "DELETE", "/policies/products/"+product.ID, nil, nil))
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "PUT", "/products/"+product.ID+"/category", map[string]any{"category_id": nil}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 120.0, price.CurrentPrice, 0.0001)
+
+	require.Equal(t, http.StatusNoContent, call(t, app.catalog, "DELETE", "/categories/"+fruit.ID, nil, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.catalog, "PUT", "/categories/"+food.ID, map[string]any{"name": "Food", "parent_id": food.ID}, nil))
+}
+
+func TestAllInOne_ImportExport(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	req := httptest.NewRequest("POST", "/products:import", strings.NewReader("name,base_price,stock\nA,100,10\nB,-1,1\n"))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rec := httptest.NewRecorder()
+	app.catalog.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"imported":1,"failed":1,"errors":[{"line":3,"error":"base_price must be positive"}]}`, rec.Body.String())
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	rec = httptest.NewRecorder()
+	app.catalog.ServeHTTP(rec, httptest.NewRequest("GET", "/products:export?format=csv", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, "id,name,base_price,stock,category_id,updated_at,archived_at", lines[0])
+	id := strings.Split(lines[1], ",")[0]
+
+	// Imported products are priced like any other.
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+id, nil, &price))
+	require.InDelta(t, 100.0, price.CurrentPrice, 0.0001)
+
+	require.Equal(t, http.StatusUnsupportedMediaType, call(t, app.catalog, "POST", "/products:import", nil, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.catalog, "GET", "/products:export?format=xml", nil, nil))
+}
+
+func TestAllInOne_IfMatch(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	code, h := callHeader(t, app.catalog, "POST", "/products", nil, map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, `"1"`, h.Get("ETag"))
+	path := "/products/" + product.ID
+
+	require.Equal(t, http.StatusPreconditionRequired, call(t, app.catalog, "PUT", path, map[string]any{"name": "B", "base_price": 200}, nil))
+	code, h = callHeader(t, app.catalog, "PUT", path, http.Header{"If-Match": {`"1"`}}, map[string]any{"name": "B", "base_price": 200}, nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `"2"`, h.Get("ETag"))
+
+	// The second writer read version 1 too and must re-read.
+	code, _ = callHeader(t, app.catalog, "PATCH", path+"/stock", http.Header{"If-Match": {`"1"`}}, map[string]any{"stock": 0}, nil)
+	require.Equal(t, http.StatusPreconditionFailed, code)
+	code, _ = callHeader(t, app.catalog, "PATCH", path+"/stock", http.Header{"If-Match": {`W/"2"`}}, map[string]any{"stock": 0}, nil)
+	require.Equal(t, http.StatusPreconditionFailed, code)
+	code, h = callHeader(t, app.catalog, "GET", path, nil, nil, nil)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHeader(t, app.catalog, "PATCH", path+"/stock", http.Header{"If-Match": {h.Get("ETag")}}, map[string]any{"stock": 0}, nil)
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 340.0, price.CurrentPrice, 0.0001)
+}
+
+func TestAllInOne_OrdersMoveStock(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 6}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var order struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 2}, &order))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var got struct{ Stock int }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 4, got.Stock)
+	// Low stock and two units of demand.
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 124.0, price.CurrentPrice, 0.0001)
+
+	require.Equal(t, http.StatusOK, call(t, app.order, "POST", "/orders/"+order.ID+"/cancel", nil, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 6, got.Stock)
+
+	var movements struct {
+		Items []struct {
+			Reason  string `json:"reason"`
+			OrderID string `json:"order_id"`
+		}
+	}
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID+"/movements", nil, &movements))
+	require.Len(t, movements.Items, 2)
+	require.Equal(t, "return", movements.Items[0].Reason)
+	require.Equal(t, order.ID, movements.Items[0].OrderID)
+}
+
+func TestAllInOne_ReservationCheckout(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+
+	var held struct {
+		ID     string
+		Status string
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products/"+product.ID+"/reservations", map[string]any{"qty": 6, "ttl_seconds": 600}, &held))
+	require.Equal(t, "active", held.Status)
+	require.Equal(t, http.StatusConflict, call(t, app.catalog, "POST", "/products/"+product.ID+"/reservations", map[string]any{"qty": 5}, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.catalog, "POST", "/products/"+product.ID+"/reservations", map[string]any{"qty": 1, "ttl_seconds": -1}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	// Only 4 are left to sell, so the price carries the low-stock markup.
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &price))
+	require.InDelta(t, 120.0, price.CurrentPrice, 0.0001)
+
+	var order struct {
+		ID            string
+		ReservationID string `json:"reservation_id"`
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 6, "reservation_id": held.ID}, &order))
+	require.Equal(t, held.ID, order.ReservationID)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var got struct{ Stock, Reserved int }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 4, got.Stock)
+	require.Equal(t, 0, got.Reserved)
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID+"/reservations/"+held.ID, nil, &held))
+	require.Equal(t, "confirmed", held.Status)
+	require.Equal(t, http.StatusConflict, call(t, app.catalog, "DELETE", "/products/"+product.ID+"/reservations/"+held.ID, nil, nil))
+
+	var abandoned struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products/"+product.ID+"/reservations", map[string]any{"qty": 4}, &abandoned))
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "DELETE", "/products/"+product.ID+"/reservations/"+abandoned.ID, nil, nil))
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 0, got.Reserved)
+}
+
+func TestAllInOne_RefundRestocksUnshippedOrders(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 6}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	place := func(qty int, actions ...string) {
+		var order struct{ ID string }
+		require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": qty}, &order))
+		require.NoError(t, app.bus.WaitIdle(ctx))
+		for _, action := range actions {
+			require.Equal(t, http.StatusOK, call(t, app.order, "POST", "/orders/"+order.ID+"/"+action, nil, nil))
+		}
+		require.NoError(t, app.bus.WaitIdle(ctx))
+	}
+	var got struct{ Stock int }
+
+	// Refunded before it shipped: the stock comes back.
+	place(2, "pay", "refund")
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 6, got.Stock)
+
+	// Refunded after delivery: the goods are with the customer.
+	place(1, "pay", "fulfill", "deliver", "refund")
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 5, got.Stock)
+}
+
+func TestAllInOne_OrderChecksProduct(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 2}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	place := func(productID string, qty int, extra map[string]any) int {
+		body := map[string]any{"user_id": user.ID, "product_id": productID, "qty": qty}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return call(t, app.order, "POST", "/orders", body, nil)
+	}
+
+	require.Equal(t, http.StatusUnprocessableEntity, place(uuid.NewString(), 1, nil))
+	require.Equal(t, http.StatusBadRequest, place(product.ID, 0, nil))
+	require.Equal(t, http.StatusConflict, place(product.ID, 3, nil))
+
+	// Reserved stock is not available to others, but is to the order that
+	// redeems the reservation.
+	var held struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products/"+product.ID+"/reservations", map[string]any{"qty": 2}, &held))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusConflict, place(product.ID, 1, nil))
+	// A reservation only counts for what it actually holds.
+	require.Equal(t, http.StatusConflict, place(product.ID, 1, map[string]any{"reservation_id": uuid.NewString()}))
+	require.Equal(t, http.StatusConflict, place(product.ID, 3, map[string]any{"reservation_id": held.ID}))
+	require.Equal(t, http.StatusCreated, place(product.ID, 2, map[string]any{"reservation_id": held.ID}))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusConflict, place(product.ID, 1, nil))
+	require.Equal(t, http.StatusConflict, place(product.ID, 1, map[string]any{"reservation_id": held.ID}))
+
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "PUT", "/products/"+product.ID+"/backorders", map[string]any{"enabled": true}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusCreated, place(product.ID, 1, nil))
+
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "POST", "/products/"+product.ID+"/archive", nil, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusUnprocessableEntity, place(product.ID, 1, nil))
+}
+
+func TestAllInOne_OrderBackfill(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Products and prices stored before the order service was listening.
+	catalogRepo := memory.NewCatalogRepository()
+	live, err := catalogRepo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 1, Stock: 3})
+	require.NoError(t, err)
+	archived, err := catalogRepo.Create(ctx, models.Product{ID: uuid.New(), Name: "B", BasePrice: 1, Stock: 1})
+	require.NoError(t, err)
+	_, err = catalogRepo.Archive(ctx, archived.ID)
+	require.NoError(t, err)
+
+	priceRepo := memory.NewPriceRepository()
+	_, err = priceRepo.UpsertPrice(ctx, live.ID, 1.5)
+	require.NoError(t, err)
+
+	orderRepo := memory.NewOrderRepository()
+	app, err := newAllInOne(ctx, testConfig(), allRepos{
+		catalog: catalogRepo,
+		order:   orderRepo,
+		price:   priceRepo,
+		policy:  memory.NewPolicyRepository(),
+		segment: memory.NewSegmentRepository(),
+
+		catalogKeys: memory.NewIdempotencyStore(),
+		orderKeys:   memory.NewIdempotencyStore(),
+	})
+	require.NoError(t, err)
+	defer app.close()
+
+	p, err := orderRepo.GetProduct(ctx, live.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.Available)
+	require.Nil(t, p.ArchivedAt)
+	p, err = orderRepo.GetProduct(ctx, archived.ID)
+	require.NoError(t, err)
+	require.NotNil(t, p.ArchivedAt)
+
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	price, err := orderRepo.GetPrice(ctx, live.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, price.UnitPrice)
+}
+
+func TestAllInOne_QuotedCheckout(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+
+	var q struct {
+		UnitPrice float64 `json:"unit_price"`
+		Total     float64 `json:"total"`
+		Token     string  `json:"token"`
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.pricing, "POST", "/quotes", map[string]any{"product_id": product.ID, "qty": 2, "segment": "new"}, &q))
+	require.InDelta(t, 100.0, q.UnitPrice, 0.0001)
+	require.InDelta(t, 200.0, q.Total, 0.0001)
+	var loyal struct{ Token string }
+	require.Equal(t, http.StatusCreated, call(t, app.pricing, "POST", "/quotes", map[string]any{"product_id": product.ID, "qty": 2, "segment": "loyal"}, &loyal))
+	var plain struct{ Token string }
+	require.Equal(t, http.StatusCreated, call(t, app.pricing, "POST", "/quotes", map[string]any{"product_id": product.ID, "qty": 2}, &plain), "no segment")
+	require.Equal(t, http.StatusBadRequest, call(t, app.pricing, "POST", "/quotes", map[string]any{"product_id": product.ID, "qty": 2, "segment": "vip"}, nil))
+
+	// Another order raises the price before checkout.
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 1}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	order := func(qty int, token string, out any) int {
+		return call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": qty, "quote_token": token}, out)
+	}
+	require.Equal(t, http.StatusUnprocessableEntity, order(3, q.Token, nil), "quoted for another qty")
+	require.Equal(t, http.StatusUnprocessableEntity, order(2, q.Token+"x", nil), "tampered")
+	require.Equal(t, http.StatusUnprocessableEntity, order(2, loyal.Token, nil), "quoted for another segment")
+
+	var o struct {
+		UnitPrice float64 `json:"unit_price"`
+		Total     float64 `json:"total"`
+	}
+	require.Equal(t, http.StatusCreated, order(2, q.Token, &o))
+	require.InDelta(t, 100.0, o.UnitPrice, 0.0001)
+	require.InDelta(t, 200.0, o.Total, 0.0001)
+	require.Equal(t, http.StatusCreated, order(2, plain.Token, &o), "quotes without a segment are good for anyone")
+	require.InDelta(t, 100.0, o.UnitPrice, 0.0001)
+}
+
+func TestAllInOne_SegmentPricing(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 100}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	var c struct {
+		ID    string
+		Total float64
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/carts", map[string]any{"user_id": user.ID}, &c))
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", "/carts/"+c.ID+"/lines/"+product.ID, map[string]any{"qty": 1}, &c))
+	require.InDelta(t, 100.0, c.Total, 0.0001)
+
+	// New users pay half from the moment the multiplier is set.
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "PUT", "/segments/new", map[string]any{"multiplier": 0.5}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/carts/"+c.ID, nil, &c))
+	require.InDelta(t, 50.0, c.Total, 0.0001)
+	var q struct {
+		UnitPrice float64 `json:"unit_price"`
+		Token     string  `json:"token"`
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.pricing, "POST", "/quotes", map[string]any{"product_id": product.ID, "qty": 1, "segment": "new"}, &q))
+	require.InDelta(t, 50.0, q.UnitPrice, 0.0001)
+	var o struct {
+		UnitPrice float64 `json:"unit_price"`
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 1}, &o))
+	require.InDelta(t, 50.0, o.UnitPrice, 0.0001)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 1, "quote_token": q.Token}, &o))
+	require.InDelta(t, 50.0, o.UnitPrice, 0.0001)
+
+	// Without the multiplier they pay the plain price again.
+	require.Equal(t, http.StatusNoContent, call(t, app.pricing, "DELETE", "/segments/new", nil, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	var plain struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &plain))
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 1}, &o))
+	require.InDelta(t, plain.CurrentPrice, o.UnitPrice, 0.0001)
+}
+
+func TestAllInOne_OrderLifecycle(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var mu sync.Mutex
+	var moves []string
+	sub := app.bus.Subscribe("orders.events")
+	t.Cleanup(func() { _ = sub.Close() })
+	go sub.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+		var ev struct {
+			Type    string
+			Payload struct {
+				Status         string `json:"status"`
+				PreviousStatus string `json:"previous_status"`
+			}
+		}
+		require.NoError(t, json.Unmarshal(msg.Value, &ev))
+		if ev.Type == "order_status_changed" {
+			mu.Lock()
+			moves = append(moves, ev.Payload.PreviousStatus+">"+ev.Payload.Status)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 6}, &product))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	place := func() string {
+		var o struct{ ID string }
+		require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 2}, &o))
+		return o.ID
+	}
+	move := func(id, action string) int {
+		return call(t, app.order, "POST", "/orders/"+id+"/"+action, nil, nil)
+	}
+
+	// Canceling twice returns the stock once.
+	canceled := place()
+	require.Equal(t, http.StatusOK, move(canceled, "cancel"))
+	require.Equal(t, http.StatusConflict, move(canceled, "cancel"))
+	require.Equal(t, http.StatusConflict, move(canceled, "pay"))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	var got struct{ Stock int }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &got))
+	require.Equal(t, 6, got.Stock)
+
+	id := place()
+	require.Equal(t, http.StatusConflict, move(id, "deliver"))
+	for _, action := range []string{"pay", "fulfill", "deliver", "refund"} {
+		require.Equal(t, http.StatusOK, move(id, action), action)
+	}
+	require.Equal(t, http.StatusConflict, move(id, "cancel"))
+	require.Equal(t, http.StatusNotFound, move(uuid.NewString(), "pay"))
+
+	var o struct{ Status string }
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/orders/"+id, nil, &o))
+	require.Equal(t, "refunded", o.Status)
+
+	var history []struct{ From, To string }
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/orders/"+id+"/history", nil, &history))
+	require.Equal(t, []struct{ From, To string }{
+		{"", "placed"}, {"placed", "paid"}, {"paid", "fulfilled"}, {"fulfilled", "delivered"}, {"delivered", "refunded"},
+	}, history)
+
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"placed>canceled", "placed>paid", "paid>fulfilled", "fulfilled>delivered", "delivered>refunded"}, moves)
+}
+
+func TestAllInOne_CartCheckout(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var a, b struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &a))
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "B", "base_price": 10, "stock": 10}, &b))
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	type cart struct {
+		ID     string
+		Status string
+		Lines  []struct {
+			ProductID string  `json:"product_id"`
+			Qty       int     `json:"qty"`
+			UnitPrice float64 `json:"unit_price"`
+		}
+		Total float64
+	}
+	var c cart
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/carts", map[string]any{"user_id": user.ID}, &c))
+	line := func(productID string) string { return "/carts/" + c.ID + "/lines/" + productID }
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", line(a.ID), map[string]any{"qty": 1}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", line(b.ID), map[string]any{"qty": 5}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", line(a.ID), map[string]any{"qty": 2}, nil))
+	require.Equal(t, http.StatusConflict, call(t, app.order, "PUT", line(a.ID), map[string]any{"qty": 11}, nil), "more than available")
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, app.order, "PUT", line(uuid.NewString()), map[string]any{"qty": 1}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/carts/"+c.ID, nil, &c))
+	require.Len(t, c.Lines, 2)
+	require.Equal(t, 2, c.Lines[0].Qty)
+	require.InDelta(t, 250.0, c.Total, 0.0001)
+
+	require.Equal(t, http.StatusOK, call(t, app.order, "DELETE", line(b.ID), nil, &c))
+	require.Len(t, c.Lines, 1)
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", line(b.ID), map[string]any{"qty": 3}, nil))
+
+	var o struct {
+		ID    string
+		Total float64
+		Lines []struct {
+			ProductID string `json:"product_id"`
+			Qty       int
+		}
+	}
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/carts/"+c.ID+"/checkout", nil, &o))
+	require.Len(t, o.Lines, 2)
+	require.InDelta(t, 230.0, o.Total, 0.0001)
+	require.Equal(t, http.StatusConflict, call(t, app.order, "POST", "/carts/"+c.ID+"/checkout", nil, nil))
+	require.Equal(t, http.StatusConflict, call(t, app.order, "PUT", line(a.ID), map[string]any{"qty": 1}, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	// Every line sells its stock and counts as demand for its product.
+	var got struct{ Stock int }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+a.ID, nil, &got))
+	require.Equal(t, 8, got.Stock)
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+b.ID, nil, &got))
+	require.Equal(t, 7, got.Stock)
+	var price struct {
+		CurrentPrice float64 `json:"current_price"`
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+b.ID, nil, &price))
+	require.InDelta(t, 10.6, price.CurrentPrice, 0.0001)
+
+	// Canceling returns the stock of every line.
+	require.Equal(t, http.StatusOK, call(t, app.order, "POST", "/orders/"+o.ID+"/cancel", nil, nil))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+b.ID, nil, &got))
+	require.Equal(t, 10, got.Stock)
+
+	// Orders take lines directly too.
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{
+		"user_id": user.ID,
+		"lines":   []map[string]any{{"product_id": a.ID, "qty": 1}, {"product_id": b.ID, "qty": 1}},
+	}, &o))
+	require.Len(t, o.Lines, 2)
+	require.Equal(t, http.StatusBadRequest, call(t, app.order, "POST", "/orders", map[string]any{
+		"user_id": user.ID,
+		"lines":   []map[string]any{{"product_id": a.ID, "qty": 1}, {"product_id": a.ID, "qty": 1}},
+	}, nil))
+}
+
+func TestAllInOne_IdempotencyKey(t *testing.T) {
+	app, ctx := newTestApp(t)
+	key := func(k string) http.Header { return http.Header{"Idempotency-Key": {k}} }
+
+	create := map[string]any{"name": "A", "base_price": 100, "stock": 10}
+	var product, again struct{ ID string }
+	code, first := callHeader(t, app.catalog, "POST", "/products", key("p1"), create, &product)
+	require.Equal(t, http.StatusCreated, code)
+	code, h := callHeader(t, app.catalog, "POST", "/products", key("p1"), create, &again)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, "true", h.Get("Idempotent-Replayed"))
+	require.NotEmpty(t, first.Get("ETag"))
+	require.Equal(t, first.Get("ETag"), h.Get("ETag"), "the replay carries the response headers")
+	require.Equal(t, first.Get("Content-Type"), h.Get("Content-Type"))
+	require.Equal(t, product.ID, again.ID)
+	code, _ = callHeader(t, app.catalog, "POST", "/products", key("p1"), map[string]any{"name": "B", "base_price": 1, "stock": 1}, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+
+	var user struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &user))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	place := map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 2}
+	var o, retried struct{ ID string }
+	code, _ = callHeader(t, app.order, "POST", "/orders", key("o1"), place, &o)
+	require.Equal(t, http.StatusCreated, code)
+	code, h = callHeader(t, app.order, "POST", "/orders", key("o1"), place, &retried)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, "true", h.Get("Idempotent-Replayed"))
+	require.Equal(t, o.ID, retried.ID)
+	code, _ = callHeader(t, app.order, "POST", "/orders", key("o1"), map[string]any{"user_id": user.ID, "product_id": product.ID, "qty": 3}, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var p struct{ Stock int }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products/"+product.ID, nil, &p))
+	require.Equal(t, 8, p.Stock, "the retry placed no second order")
+
+	var list struct{ Items []struct{ ID string } }
+	require.Equal(t, http.StatusOK, call(t, app.catalog, "GET", "/products", nil, &list))
+	require.Len(t, list.Items, 1)
+}
+
+func TestAllInOne_ListOrders(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var a, b struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &a))
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "B", "base_price": 10, "stock": 10}, &b))
+	var u1, u2 struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &u1))
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "b@ex.com"}, &u2))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	var placed []string
+	for _, o := range []map[string]any{
+		{"user_id": u1.ID, "product_id": a.ID, "qty": 1},
+		{"user_id": u2.ID, "product_id": b.ID, "qty": 1},
+		{"user_id": u1.ID, "lines": []map[string]any{{"product_id": b.ID, "qty": 1}, {"product_id": a.ID, "qty": 1}}},
+	} {
+		var got struct{ ID string }
+		require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", o, &got))
+		placed = append(placed, got.ID)
+		require.NoError(t, app.bus.WaitIdle(ctx))
+	}
+	require.Equal(t, http.StatusOK, call(t, app.order, "POST", "/orders/"+placed[1]+"/pay", nil, nil))
+
+	type page struct {
+		Items      []struct{ ID string }
+		NextCursor string `json:"next_cursor"`
+	}
+	list := func(path string) page {
+		var p page
+		require.Equal(t, http.StatusOK, call(t, app.order, "GET", path, nil, &p))
+		return p
+	}
+	ids := func(p page) []string {
+		var out []string
+		for _, o := range p.Items {
+			out = append(out, o.ID)
+		}
+		return out
+	}
+
+	p := list("/users/" + u1.ID + "/orders?limit=1")
+	require.Equal(t, []string{placed[2]}, ids(p))
+	require.NotEmpty(t, p.NextCursor)
+	p = list("/users/" + u1.ID + "/orders?limit=1&cursor=" + p.NextCursor)
+	require.Equal(t, []string{placed[0]}, ids(p))
+	require.Empty(t, p.NextCursor)
+
+	require.Equal(t, []string{placed[2], placed[0]}, ids(list("/orders?product_id="+a.ID)))
+	require.Equal(t, []string{placed[1]}, ids(list("/orders?status=paid")))
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	require.Equal(t, []string{placed[2], placed[1]}, ids(list("/orders?from="+from+"&product_id="+b.ID)))
+	require.Empty(t, list("/orders?to="+from).Items)
+
+	require.Equal(t, http.StatusBadRequest, call(t, app.order, "GET", "/orders?status=lost", nil, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.order, "GET", "/orders?from=yesterday", nil, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.order, "GET", "/orders?cursor=!!", nil, nil))
+}
+
+func TestAllInOne_Users(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var mu sync.Mutex
+	var seen []string
+	sub := app.bus.Subscribe("users.events")
+	t.Cleanup(func() { _ = sub.Close() })
+	go sub.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+		var ev struct {
+			Type    string
+			Payload struct {
+				Email  string `json:"email"`
+				Active bool   `json:"active"`
+			}
+		}
+		require.NoError(t, json.Unmarshal(msg.Value, &ev))
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("%s %s %t", ev.Type, ev.Payload.Email, ev.Payload.Active))
+		return nil
+	})
+
+	type user struct {
+		ID            string
+		Email         string
+		DeactivatedAt *time.Time `json:"deactivated_at"`
+	}
+	var u user
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "  Ann@Ex.COM "}, &u))
+	require.Equal(t, "ann@ex.com", u.Email)
+	require.Equal(t, http.StatusConflict, call(t, app.order, "POST", "/users", map[string]any{"email": "ANN@ex.com"}, nil))
+	for _, email := range []string{"", "ann", "Ann <ann@ex.com>", "ann@ex.com, bob@ex.com"} {
+		require.Equal(t, http.StatusBadRequest, call(t, app.order, "POST", "/users", map[string]any{"email": email}, nil), email)
+	}
+	var other user
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "bob@ex.com"}, &other))
+
+	var got user
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/users/"+u.ID, nil, &got))
+	require.Equal(t, u, got)
+	require.Equal(t, http.StatusNotFound, call(t, app.order, "GET", "/users/"+uuid.NewString(), nil, nil))
+	var page struct{ Items []user }
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/users?email=BOB@ex.com", nil, &page))
+	require.Equal(t, []user{other}, page.Items)
+
+	require.Equal(t, http.StatusConflict, call(t, app.order, "PATCH", "/users/"+u.ID, map[string]any{"email": "bob@ex.com"}, nil))
+	require.Equal(t, http.StatusOK, call(t, app.order, "PATCH", "/users/"+u.ID, map[string]any{"email": "Ann.B@ex.com"}, &got))
+	require.Equal(t, "ann.b@ex.com", got.Email)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 10}, &product))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	place := map[string]any{"user_id": u.ID, "product_id": product.ID, "qty": 1}
+
+	require.Equal(t, http.StatusOK, call(t, app.order, "PATCH", "/users/"+u.ID, map[string]any{"active": false}, &got))
+	require.NotNil(t, got.DeactivatedAt)
+	require.Equal(t, http.StatusForbidden, call(t, app.order, "POST", "/orders", place, nil))
+	var cart struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/carts", map[string]any{"user_id": u.ID}, &cart))
+	require.Equal(t, http.StatusOK, call(t, app.order, "PUT", "/carts/"+cart.ID+"/lines/"+product.ID, map[string]any{"qty": 1}, nil))
+	require.Equal(t, http.StatusForbidden, call(t, app.order, "POST", "/carts/"+cart.ID+"/checkout", nil, nil))
+
+	var reactivated user
+	require.Equal(t, http.StatusOK, call(t, app.order, "PATCH", "/users/"+u.ID, map[string]any{"active": true}, &reactivated))
+	require.Nil(t, reactivated.DeactivatedAt)
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", place, nil))
+
+	require.NoError(t, app.bus.WaitIdle(ctx))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{
+		"user_created ann@ex.com true",
+		"user_created bob@ex.com true",
+		"user_updated ann.b@ex.com true",
+		"user_deactivated ann.b@ex.com false",
+		"user_reactivated ann.b@ex.com true",
+	}, seen)
+}
+
+func TestAllInOne_Segments(t *testing.T) {
+	app, ctx := newTestApp(t)
+
+	var mu sync.Mutex
+	var changes []string
+	sub := app.bus.Subscribe("users.events")
+	t.Cleanup(func() { _ = sub.Close() })
+	go sub.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+		var ev struct {
+			Type    string
+			Payload struct {
+				Segment         string `json:"segment"`
+				PreviousSegment string `json:"previous_segment"`
+				OrderCount      int    `json:"order_count"`
+			}
+		}
+		require.NoError(t, json.Unmarshal(msg.Value, &ev))
+		if ev.Type != "user_segment_changed" {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, fmt.Sprintf("%s>%s %d", ev.Payload.PreviousSegment, ev.Payload.Segment, ev.Payload.OrderCount))
+		return nil
+	})
+
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "PUT", "/segments/loyal", map[string]any{"multiplier": 0.8}, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.pricing, "PUT", "/segments/vip", map[string]any{"multiplier": 0.8}, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, app.pricing, "PUT", "/segments/new", map[string]any{"multiplier": 0}, nil))
+	var segments []struct {
+		Segment    string
+		Multiplier float64
+	}
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/segments", nil, &segments))
+	require.Len(t, segments, 1)
+	require.Equal(t, "loyal", segments[0].Segment)
+
+	var product struct{ ID string }
+	require.Equal(t, http.StatusCreated, call(t, app.catalog, "POST", "/products", map[string]any{"name": "A", "base_price": 100, "stock": 100}, &product))
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	type user struct {
+		ID         string
+		Segment    string `json:"segment"`
+		OrderCount int    `json:"order_count"`
+	}
+	var u user
+	require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/users", map[string]any{"email": "a@ex.com"}, &u))
+	require.Equal(t, "new", u.Segment)
+
+	// The fifth order makes the user loyal; canceling one makes them new
+	// again, as they signed up just now.
+	var last struct{ ID string }
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusCreated, call(t, app.order, "POST", "/orders", map[string]any{"user_id": u.ID, "product_id": product.ID, "qty": 1}, &last))
+	}
+	var got user
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/users/"+u.ID, nil, &got))
+	require.Equal(t, user{ID: u.ID, Segment: "loyal", OrderCount: 5}, got)
+	require.Equal(t, http.StatusOK, call(t, app.order, "POST", "/orders/"+last.ID+"/cancel", nil, nil))
+	var after user
+	require.Equal(t, http.StatusOK, call(t, app.order, "GET", "/users/"+u.ID, nil, &after))
+	require.Equal(t, user{ID: u.ID, Segment: "new", OrderCount: 4}, after)
+	require.NoError(t, app.bus.WaitIdle(ctx))
+
+	type price struct {
+		CurrentPrice float64 `json:"current_price"`
+		Segment      string  `json:"segment"`
+	}
+	var plain, loyal, regular price
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID, nil, &plain))
+	require.Empty(t, plain.Segment)
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID+"?segment=loyal", nil, &loyal))
+	require.Equal(t, "loyal", loyal.Segment)
+	require.InDelta(t, plain.CurrentPrice*0.8, loyal.CurrentPrice, 0.005)
+	require.Equal(t, http.StatusOK, call(t, app.pricing, "GET", "/prices/"+product.ID+"?segment=regular", nil, &regular))
+	require.Equal(t, plain.CurrentPrice, regular.CurrentPrice)
+	require.Equal(t, http.StatusBadRequest, call(t, app.pricing, "GET", "/prices/"+product.ID+"?segment=vip", nil, nil))
+
+	require.Equal(t, http.StatusNoContent, call(t, app.pricing, "DELETE", "/segments/loyal", nil, nil))
+	require.Equal(t, http.StatusNotFound, call(t, app.pricing, "DELETE", "/segments/loyal", nil, nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"new>loyal 5", "loyal>new 4"}, changes)
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"dynamic-pricing/internal/api/catalog_api"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"dynamic-pricing/config"
+	"dynamic-pricing/internal/consumer"
 	"dynamic-pricing/internal/httpserver"
 	"dynamic-pricing/internal/producer"
 	"dynamic-pricing/internal/services/catalog"
 	"dynamic-pricing/internal/storage/pg"
+
+	"github.com/segmentio/kafka-go"
 )
 
 func RunCatalog(ctx context.Context, cfg config.Root) error {
@@ -20,14 +24,36 @@ func RunCatalog(ctx context.Context, cfg config.Root) error {
 	}
 	defer db.Close()
 
-	prod := producer.New(cfg.Catalog.Kafka.Brokers, cfg.Catalog.Kafka.Topic)
+	if err := migrateOnBoot(ctx, db, cfg.Catalog.DB, ServiceCatalog); err != nil {
+		return err
+	}
+
+	if err := ensureTopics(ctx, cfg, cfg.Catalog.Kafka.Brokers, cfg.Catalog.Kafka.Topic, cfg.Catalog.Kafka.OrdersTopic); err != nil {
+		return err
+	}
+
+	reg, err := loadRegistry(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	prodOpts, err := producerOptions(cfg, reg, cfg.Catalog.Kafka.Topic, "dynamic-pricing/catalog")
+	if err != nil {
+		return err
+	}
+	prod := producer.New(cfg.Catalog.Kafka.Brokers, cfg.Catalog.Kafka.Topic, prodOpts...)
 	defer prod.Close()
 
 	repo := pg.NewCatalogRepository(db)
 	svc := catalog.NewService(repo, prod)
-	h := catalog_api.NewHandler(svc)
+	k := cfg.Catalog.Kafka
+	ordersCons := consumer.New(k.Brokers, k.OrdersTopic, k.GroupID+"-orders", consumerOptions(cfg, reg, k.OrdersTopic)...)
+	defer ordersCons.Close()
+	go ordersCons.Run(ctx, catalogOrdersHandler(svc))
+	go svc.RunReservationSweeper(ctx, cfg.Catalog.SweepInterval())
 
-	srv := httpserver.New(cfg.Catalog.HTTPAddr, httpserver.CORS(h.Routes()))
+	h := catalog_api.NewHandler(svc, catalog_api.WithIdempotency(idempotency(ctx, cfg, pg.NewIdempotencyStore(db))))
+
+	srv := httpserver.New(cfg.Catalog.HTTPAddr, httpserver.WithMetrics(httpserver.CORS(h.Routes())))
 	go func() {
 		if err := srv.Start(); err != nil {
 			slog.Error("http", "err", err)
@@ -39,3 +65,17 @@ func RunCatalog(ctx context.Context, cfg config.Root) error {
 	_ = srv.Shutdown(shCtx)
 	return nil
 }
+
+// idempotency serves Idempotency-Key from store for idempotency.ttl and
+// purges expired keys until ctx is done.
+func idempotency(ctx context.Context, cfg config.Root, store httpserver.IdempotencyStore) func(http.Handler) http.Handler {
+	go httpserver.RunIdempotencyPurger(ctx, store, cfg.Idempotency.PurgeInterval())
+	return httpserver.Idempotency(store, cfg.Idempotency.KeyTTL())
+}
+
+// catalogOrdersHandler feeds orders.events into the catalog's stock.
+func catalogOrdersHandler(svc *catalog.Service) consumer.Handler {
+	return func(ctx context.Context, msg kafka.Message) error {
+		return svc.HandleOrderEvent(ctx, msg.Value)
+	}
+}
@@ -0,0 +1,84 @@
+package bootstrap
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"dynamic-pricing/api"
+	"dynamic-pricing/config"
+	"dynamic-pricing/internal/consumer"
+	"dynamic-pricing/internal/events"
+	"dynamic-pricing/internal/kafkautil"
+	"dynamic-pricing/internal/producer"
+)
+
+// ensureTopics creates the service's topics from their config specs and logs
+// drift on existing ones. Broker errors abort startup only when
+// kafka_admin.on_unreachable is "fail".
+func ensureTopics(ctx context.Context, cfg config.Root, brokers []string, topics ...string) error {
+	specs := make([]kafkautil.TopicSpec, 0, len(topics))
+	for _, name := range topics {
+		t := cfg.Topic(name)
+		specs = append(specs, kafkautil.TopicSpec{
+			Name:              name,
+			Partitions:        t.Partitions,
+			ReplicationFactor: t.ReplicationFactor,
+			Retention:         t.Retention,
+			CleanupPolicy:     t.CleanupPolicy,
+		})
+	}
+	drift, err := kafkautil.EnsureTopics(ctx, brokers, specs...)
+	if err != nil {
+		if cfg.KafkaAdmin.OnUnreachable == config.OnUnreachableFail {
+			return err
+		}
+		slog.Error("kafka ensure topics", "topics", topics, "err", err)
+		return nil
+	}
+	for _, d := range drift {
+		slog.Warn("kafka: topic drift", "topic", d.Topic, "setting", d.Setting, "want", d.Want, "got", d.Got)
+	}
+	return nil
+}
+
+// loadRegistry compiles the event schemas from the configured directory,
+// falling back to the copy embedded into the binary.
+func loadRegistry(ctx context.Context, cfg config.Root) (*events.Registry, error) {
+	if cfg.SchemaRegistry.Dir != "" {
+		return events.LoadRegistry(ctx, os.DirFS(cfg.SchemaRegistry.Dir))
+	}
+	fsys, err := fs.Sub(api.Protos, "proto")
+	if err != nil {
+		return nil, err
+	}
+	return events.LoadRegistry(ctx, fsys)
+}
+
+// producerOptions translates the per-topic config into producer options.
+func producerOptions(cfg config.Root, reg *events.Registry, topic, source string) ([]producer.Option, error) {
+	var opts []producer.Option
+	switch cfg.TopicFormat(topic) {
+	case config.FormatCloudEvents:
+		opts = append(opts, producer.WithCloudEvents(source))
+	case config.FormatProtobuf:
+		codec, err := reg.Codec(cfg.Topics[topic].Schema)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, producer.WithCodec(codec))
+	}
+	return opts, nil
+}
+
+// consumerOptions translates the per-topic config into consumer options.
+// The registry is always attached so JSON and protobuf can be read side by
+// side while producers migrate.
+func consumerOptions(cfg config.Root, reg *events.Registry, topic string) []consumer.Option {
+	opts := []consumer.Option{consumer.WithRegistry(reg)}
+	if cfg.TopicFormat(topic) == config.FormatCloudEvents {
+		opts = append(opts, consumer.WithCloudEvents())
+	}
+	return opts
+}
@@ -0,0 +1,100 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+
+	"dynamic-pricing/config"
+	"dynamic-pricing/internal/storage/pg"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Service names the embedded migration sets are keyed by.
+const (
+	ServiceCatalog = "catalog"
+	ServiceOrder   = "order"
+	ServicePricing = "pricing"
+)
+
+// migrateOnBoot applies pending migrations of service when the database is
+// configured with auto_migrate.
+func migrateOnBoot(ctx context.Context, db *pgxpool.Pool, dbCfg config.Postgres, service string) error {
+	if !dbCfg.AutoMigrate {
+		return nil
+	}
+	m, err := pg.NewMigrator(db, service)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		slog.Info("migrate: applied", "service", service, "versions", applied)
+	}
+	return nil
+}
+
+// RunMigrate executes a migrate subcommand against the database of service:
+//
+//	up        apply all pending migrations
+//	down [N]  revert the last N applied migrations (default 1)
+//	status    list migrations and when they were applied
+func RunMigrate(ctx context.Context, dbCfg config.Postgres, service string, args []string, out io.Writer) error {
+	db, err := pg.NewPool(ctx, dbCfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := pg.NewMigrator(db, service)
+	if err != nil {
+		return err
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, v := range applied {
+			fmt.Fprintf(out, "applied %04d\n", v)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("migrate down: bad step count %q", args[1])
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, v := range reverted {
+			fmt.Fprintf(out, "reverted %04d\n", v)
+		}
+		return err
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			at := "pending"
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, at)
+		}
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown command %q (want up, down [N] or status)", cmd)
+	}
+}
@@ -7,10 +7,15 @@ import (
 
 	"dynamic-pricing/config"
 	"dynamic-pricing/internal/api/order_api"
+	"dynamic-pricing/internal/catalogclient"
+	"dynamic-pricing/internal/consumer"
 	"dynamic-pricing/internal/httpserver"
 	"dynamic-pricing/internal/producer"
+	"dynamic-pricing/internal/quote"
 	"dynamic-pricing/internal/services/order"
 	"dynamic-pricing/internal/storage/pg"
+
+	"github.com/segmentio/kafka-go"
 )
 
 func RunOrder(ctx context.Context, cfg config.Root) error {
@@ -20,14 +25,59 @@ func RunOrder(ctx context.Context, cfg config.Root) error {
 	}
 	defer db.Close()
 
-	prod := producer.New(cfg.Order.Kafka.Brokers, cfg.Order.Kafka.Topic)
+	if err := migrateOnBoot(ctx, db, cfg.Order.DB, ServiceOrder); err != nil {
+		return err
+	}
+
+	k := cfg.Order.Kafka
+	topics := []string{k.Topic, k.CatalogTopic, k.PricingTopic}
+	if k.UsersTopic != "" {
+		topics = append(topics, k.UsersTopic)
+	}
+	if err := ensureTopics(ctx, cfg, k.Brokers, topics...); err != nil {
+		return err
+	}
+
+	reg, err := loadRegistry(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	prodOpts, err := producerOptions(cfg, reg, cfg.Order.Kafka.Topic, "dynamic-pricing/order")
+	if err != nil {
+		return err
+	}
+	prod := producer.New(cfg.Order.Kafka.Brokers, cfg.Order.Kafka.Topic, prodOpts...)
 	defer prod.Close()
 
+	opts := orderOptions(cfg)
+	if k.UsersTopic != "" {
+		usersOpts, err := producerOptions(cfg, reg, k.UsersTopic, "dynamic-pricing/order")
+		if err != nil {
+			return err
+		}
+		usersProd := producer.New(k.Brokers, k.UsersTopic, usersOpts...)
+		defer usersProd.Close()
+		opts = append(opts, order.WithUserEvents(usersProd))
+	}
+
 	repo := pg.NewOrderRepository(db)
-	svc := order.NewService(repo, prod)
-	h := order_api.NewHandler(svc)
+	svc := order.NewService(repo, prod, opts...)
+	if c := cfg.Order.Catalog; cfg.Order.Products != config.ProductsCatalog && c.URL != "" {
+		backfillProducts(ctx, svc, catalogclient.New(c.URL))
+	}
+
+	catalogCons := consumer.New(k.Brokers, k.CatalogTopic, k.GroupID+"-catalog", consumerOptions(cfg, reg, k.CatalogTopic)...)
+	defer catalogCons.Close()
+	go catalogCons.Run(ctx, orderCatalogHandler(svc))
+
+	pricingCons := consumer.New(k.Brokers, k.PricingTopic, k.GroupID+"-pricing", consumerOptions(cfg, reg, k.PricingTopic)...)
+	defer pricingCons.Close()
+	go pricingCons.Run(ctx, orderPricingHandler(svc))
+	go svc.RunSegmentSweeper(ctx, cfg.Order.Segments.SweepInterval())
 
-	srv := httpserver.New(cfg.Order.HTTPAddr, httpserver.CORS(h.Routes()))
+	h := order_api.NewHandler(svc, order_api.WithIdempotency(idempotency(ctx, cfg, pg.NewIdempotencyStore(db))))
+
+	srv := httpserver.New(cfg.Order.HTTPAddr, httpserver.WithMetrics(httpserver.CORS(h.Routes())))
 	go func() {
 		if err := srv.Start(); err != nil {
 			slog.Error("http", "err", err)
@@ -39,3 +89,72 @@ func RunOrder(ctx context.Context, cfg config.Root) error {
 	_ = srv.Shutdown(shCtx)
 	return nil
 }
+
+// orderCatalogHandler feeds catalog.events into the order service.
+func orderCatalogHandler(svc *order.Service) consumer.Handler {
+	return func(ctx context.Context, msg kafka.Message) error {
+		return svc.HandleCatalogEvent(ctx, msg.Value)
+	}
+}
+
+// backfillProducts fills the order service's copy of products from src
+// before catalog.events is read, so that products announced before the
+// service first read the topic can be ordered. A failure is only logged:
+// the copy still fills from events.
+func backfillProducts(ctx context.Context, svc *order.Service, src order.ProductSource) {
+	n, err := svc.BackfillProducts(ctx, src)
+	if err != nil {
+		slog.Warn("order: product backfill failed", "err", err, "products", n)
+		return
+	}
+	slog.Info("order: products backfilled", "products", n)
+}
+
+// orderPricingHandler feeds pricing.events into the order service.
+func orderPricingHandler(svc *order.Service) consumer.Handler {
+	return func(ctx context.Context, msg kafka.Message) error {
+		return svc.HandlePriceEvent(ctx, msg.Value)
+	}
+}
+
+// orderOptions makes the order service ask the catalog over HTTP when
+// order.products says so; otherwise it checks its copy from catalog.events.
+// Reservations are checked with the catalog whenever order.catalog.url is
+// set. Quote tokens are honoured when quotes.key is set. Users are segmented
+// by order.segments.
+func orderOptions(cfg config.Root) []order.Option {
+	opts := []order.Option{order.WithSegmentRules(segmentRules(cfg.Order.Segments))}
+	if s := quoteSigner(cfg); s != nil {
+		opts = append(opts, order.WithQuoteVerifier(s))
+	}
+	c := cfg.Order.Catalog
+	if c.URL == "" {
+		return opts
+	}
+	client := catalogclient.New(c.URL, catalogclient.WithTimeout(c.Timeout), catalogclient.WithBreaker(c.BreakerFailures, c.BreakerCooldown))
+	opts = append(opts, order.WithReservationLookup(client))
+	if cfg.Order.Products == config.ProductsCatalog {
+		opts = append(opts, order.WithProductLookup(client))
+	}
+	return opts
+}
+
+// segmentRules are the default segment rules with the fields set in c.
+func segmentRules(c config.Segments) order.SegmentRules {
+	r := order.DefaultSegmentRules()
+	if c.NewFor > 0 {
+		r.NewFor = c.NewFor
+	}
+	if c.LoyalOrders > 0 {
+		r.LoyalOrders = c.LoyalOrders
+	}
+	return r
+}
+
+// quoteSigner is the signer for quotes.key, or nil when quotes are off.
+func quoteSigner(cfg config.Root) *quote.Signer {
+	if cfg.Quotes.Key == "" {
+		return nil
+	}
+	return quote.NewSigner([]byte(cfg.Quotes.Key))
+}
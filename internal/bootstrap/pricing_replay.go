@@ -0,0 +1,289 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dynamic-pricing/config"
+	"dynamic-pricing/internal/consumer"
+	"dynamic-pricing/internal/events"
+	"dynamic-pricing/internal/kafkautil"
+	"dynamic-pricing/internal/producer"
+	pricing "dynamic-pricing/internal/services/pricing"
+	"dynamic-pricing/internal/storage/pg"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/segmentio/kafka-go"
+)
+
+// ReplayOptions configures RunPricingReplay.
+type ReplayOptions struct {
+	// Target is where catalog.events and orders.events are rewound to.
+	Target kafkautil.OffsetTarget
+	// ShadowTable, when set, receives the recomputed prices instead of the
+	// live prices table. A separate consumer group is used so the live
+	// service's offsets are left alone.
+	ShadowTable string
+}
+
+const replayProgressInterval = 2 * time.Second
+
+// RunPricingReplay rewinds the pricing consumer groups and feeds catalog and
+// order events through a fresh Engine in event-time order until the offsets
+// recorded at reset time are reached. Prices are not published while they
+// are replayed; in live mode the final ones are republished at the end, so
+// the order service's copy catches up without restarting pricing. In live
+// mode the pricing service must be stopped while this runs.
+func RunPricingReplay(ctx context.Context, cfg config.Root, opts ReplayOptions) error {
+	db, err := pg.NewPool(ctx, cfg.Pricing.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := migrateOnBoot(ctx, db, cfg.Pricing.DB, ServicePricing); err != nil {
+		return err
+	}
+
+	reg, err := loadRegistry(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	repo := pg.NewPriceRepository(db)
+	group := cfg.Pricing.Kafka.GroupID
+	if opts.ShadowTable != "" {
+		repo, err = pg.NewShadowPriceRepository(ctx, db, opts.ShadowTable)
+		if err != nil {
+			return err
+		}
+		group += "-replay"
+	}
+	eng := pricing.NewEngine(repo, discardBus{}, pricing.WithPolicyRepository(pg.NewPolicyRepository(db)), pricing.WithCurrency(cfg.Pricing.Currency))
+	if err := eng.LoadPolicies(ctx); err != nil {
+		return err
+	}
+	if err := eng.LoadRetired(ctx); err != nil {
+		return err
+	}
+
+	k := cfg.Pricing.Kafka
+	catalog, err := newReplayStream(ctx, cfg, reg, k.CatalogTopic, group+"-catalog", opts.Target)
+	if err != nil {
+		return err
+	}
+	defer catalog.close()
+	orders, err := newReplayStream(ctx, cfg, reg, k.OrdersTopic, group+"-orders", opts.Target)
+	if err != nil {
+		return err
+	}
+	defer orders.close()
+
+	slog.Info("replay: start", "catalog_messages", catalog.total, "order_messages", orders.total, "shadow_table", opts.ShadowTable)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go catalog.run(ctx)
+	go orders.run(ctx)
+
+	onCatalog, onOrder := pricingHandlers(eng)
+	handle := map[*replayStream]consumer.Handler{catalog: onCatalog, orders: onOrder}
+
+	progress := time.NewTicker(replayProgressInterval)
+	defer progress.Stop()
+	var failed int
+	for {
+		// Catalog events are applied first on equal timestamps so that an
+		// order never precedes the snapshot of its product.
+		s, it, ok, err := nextInTimeOrder(ctx, catalog, orders)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		msg := it.msg
+		if it.err == nil {
+			it.err = handle[s](ctx, msg)
+		}
+		if it.err != nil {
+			failed++
+			slog.Warn("replay: event failed", "topic", s.topic, "partition", msg.Partition, "offset", msg.Offset, "err", it.err)
+		}
+		if err := s.cons.Commit(ctx, msg); err != nil {
+			return fmt.Errorf("commit %s: %w", s.topic, err)
+		}
+		s.done++
+
+		select {
+		case <-progress.C:
+			slog.Info("replay: progress", "catalog", catalog.progress(), "orders", orders.progress(), "failed", failed)
+		default:
+		}
+	}
+	slog.Info("replay: done", "catalog", catalog.progress(), "orders", orders.progress(), "failed", failed)
+	if opts.ShadowTable != "" {
+		return nil
+	}
+	n, err := republishReplayed(ctx, cfg, reg, db, repo)
+	if err != nil {
+		return fmt.Errorf("republish prices: %w", err)
+	}
+	slog.Info("replay: prices republished", "count", n)
+	return nil
+}
+
+// republishReplayed publishes the prices a live replay left in repo to
+// pricing.events, with their segment prices.
+func republishReplayed(ctx context.Context, cfg config.Root, reg *events.Registry, db *pgxpool.Pool, repo pricing.PriceRepository) (int, error) {
+	prodOpts, err := producerOptions(cfg, reg, cfg.Pricing.Kafka.PricingTopic, "dynamic-pricing/pricing")
+	if err != nil {
+		return 0, err
+	}
+	bus := producer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.PricingTopic, prodOpts...)
+	defer bus.Close()
+	eng := pricing.NewEngine(repo, bus, pricing.WithSegmentRepository(pg.NewSegmentRepository(db)), pricing.WithCurrency(cfg.Pricing.Currency))
+	if err := eng.LoadSegments(ctx); err != nil {
+		return 0, err
+	}
+	return eng.RepublishPrices(ctx)
+}
+
+type replayStream struct {
+	topic string
+	cons  *consumer.Consumer
+	// end holds the exclusive end offset of each partition still being read.
+	end   map[int]int64
+	total int64
+	done  int64
+
+	items chan replayItem
+	errs  chan error
+	head  *replayItem
+	eof   bool
+}
+
+// replayItem is a fetched message; err is set when it could not be decoded.
+type replayItem struct {
+	msg kafka.Message
+	err error
+}
+
+func newReplayStream(ctx context.Context, cfg config.Root, reg *events.Registry, topic, group string, target kafkautil.OffsetTarget) (*replayStream, error) {
+	brokers := cfg.Pricing.Kafka.Brokers
+	ranges, err := kafkautil.ResetGroupOffsets(ctx, brokers, group, topic, target)
+	if err != nil {
+		return nil, err
+	}
+	s := &replayStream{
+		topic: topic,
+		cons:  consumer.New(brokers, topic, group, consumerOptions(cfg, reg, topic)...),
+		end:   make(map[int]int64),
+		items: make(chan replayItem),
+		errs:  make(chan error, 1),
+	}
+	for _, r := range ranges {
+		if r.End > r.Start {
+			s.end[r.Partition] = r.End
+			s.total += r.End - r.Start
+		}
+	}
+	return s, nil
+}
+
+func (s *replayStream) close() { _ = s.cons.Close() }
+
+func (s *replayStream) progress() string {
+	if s.total == 0 {
+		return "0/0"
+	}
+	return fmt.Sprintf("%d/%d (%.0f%%)", s.done, s.total, float64(s.done)*100/float64(s.total))
+}
+
+// run fetches messages until every partition reached its end offset.
+// Messages past the end belong to live traffic and are left uncommitted.
+func (s *replayStream) run(ctx context.Context) {
+	defer close(s.items)
+	for len(s.end) > 0 {
+		msg, err := s.cons.Fetch(ctx)
+		if err != nil && !errors.Is(err, consumer.ErrDecode) {
+			if ctx.Err() == nil {
+				s.errs <- fmt.Errorf("fetch %s: %w", s.topic, err)
+			}
+			return
+		}
+		end, ok := s.end[msg.Partition]
+		if !ok {
+			continue
+		}
+		if msg.Offset >= end {
+			delete(s.end, msg.Partition)
+			continue
+		}
+		if msg.Offset == end-1 {
+			delete(s.end, msg.Partition)
+		}
+		select {
+		case s.items <- replayItem{msg: msg, err: err}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// peek blocks until the stream has a head item or is exhausted.
+func (s *replayStream) peek(ctx context.Context) (*replayItem, error) {
+	if s.head != nil || s.eof {
+		return s.head, nil
+	}
+	select {
+	case it, ok := <-s.items:
+		if !ok {
+			s.eof = true
+			select {
+			case err := <-s.errs:
+				return nil, err
+			default:
+			}
+			return nil, nil
+		}
+		s.head = &it
+		return s.head, nil
+	case err := <-s.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// nextInTimeOrder pops the earlier head of the two streams. first wins ties.
+func nextInTimeOrder(ctx context.Context, first, second *replayStream) (*replayStream, replayItem, bool, error) {
+	a, err := first.peek(ctx)
+	if err != nil {
+		return nil, replayItem{}, false, err
+	}
+	b, err := second.peek(ctx)
+	if err != nil {
+		return nil, replayItem{}, false, err
+	}
+	var s *replayStream
+	switch {
+	case a == nil && b == nil:
+		return nil, replayItem{}, false, nil
+	case b == nil || (a != nil && !b.msg.Time.Before(a.msg.Time)):
+		s = first
+	default:
+		s = second
+	}
+	it := *s.head
+	s.head = nil
+	return s, it, true, nil
+}
+
+// discardBus drops price events: replayed prices describe the past and must
+// not reach subscribers of pricing.events.
+type discardBus struct{}
+
+func (discardBus) Send(context.Context, string, []byte) error { return nil }
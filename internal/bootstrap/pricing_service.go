@@ -10,9 +10,10 @@ import (
     "dynamic-pricing/internal/httpserver"
     "dynamic-pricing/internal/consumer"
     "dynamic-pricing/internal/producer"
-    "dynamic-pricing/internal/kafkautil"
     pricing "dynamic-pricing/internal/services/pricing"
     "dynamic-pricing/internal/storage/pg"
+
+    "github.com/segmentio/kafka-go"
 )
 
 // RunPricing starts consumers, pricing engine, and HTTP read API until ctx is done.
@@ -21,41 +22,44 @@ func RunPricing(ctx context.Context, cfg config.Root) error {
     if err != nil { return err }
     defer db.Close()
 
-    // Ensure the pricing topic exists to avoid UnknownTopic errors on publish.
-    if err := kafkautil.EnsureTopic(ctx, cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.PricingTopic, 1, 1); err != nil {
-        slog.Error("kafka ensure topic", "topic", cfg.Pricing.Kafka.PricingTopic, "err", err)
+    if err := migrateOnBoot(ctx, db, cfg.Pricing.DB, ServicePricing); err != nil { return err }
+
+    // Ensure the topics exist to avoid UnknownTopic errors on publish and subscribe.
+    k := cfg.Pricing.Kafka
+    if err := ensureTopics(ctx, cfg, k.Brokers, k.PricingTopic, k.CatalogTopic, k.OrdersTopic); err != nil {
+        return err
     }
 
-    bus := producer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.PricingTopic)
+    reg, err := loadRegistry(ctx, cfg)
+    if err != nil { return err }
+    prodOpts, err := producerOptions(cfg, reg, cfg.Pricing.Kafka.PricingTopic, "dynamic-pricing/pricing")
+    if err != nil { return err }
+    bus := producer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.PricingTopic, prodOpts...)
     defer bus.Close()
 
     repo := pg.NewPriceRepository(db)
-    eng := pricing.NewEngine(repo, bus)
+    eng := pricing.NewEngine(repo, bus, pricing.WithPolicyRepository(pg.NewPolicyRepository(db)), pricing.WithSegmentRepository(pg.NewSegmentRepository(db)), pricing.WithCurrency(cfg.Pricing.Currency), pricing.WithQuotes(quoteSigner(cfg), cfg.Quotes.TTL))
+    if err := eng.LoadPolicies(ctx); err != nil { return err }
+    if err := eng.LoadSegments(ctx); err != nil { return err }
+    if err := eng.LoadRetired(ctx); err != nil { return err }
+    // Services that copy prices from pricing.events may have started after
+    // some were published; announce them all again.
+    if _, err := eng.RepublishPrices(ctx); err != nil { return err }
 
-    catalogCons := consumer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.CatalogTopic, cfg.Pricing.Kafka.GroupID+"-catalog")
+    catalogCons := consumer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.CatalogTopic, cfg.Pricing.Kafka.GroupID+"-catalog",
+        consumerOptions(cfg, reg, cfg.Pricing.Kafka.CatalogTopic)...)
     defer catalogCons.Close()
 
-    ordersCons := consumer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.OrdersTopic, cfg.Pricing.Kafka.GroupID+"-orders")
+    ordersCons := consumer.New(cfg.Pricing.Kafka.Brokers, cfg.Pricing.Kafka.OrdersTopic, cfg.Pricing.Kafka.GroupID+"-orders",
+        consumerOptions(cfg, reg, cfg.Pricing.Kafka.OrdersTopic)...)
     defer ordersCons.Close()
 
-    go func() {
-        for {
-            msg, err := catalogCons.Read(ctx)
-            if err != nil { slog.Error("catalog-cons", "err", err); continue }
-            if err := eng.HandleCatalogEvent(msg.Value); err != nil { slog.Error("catalog-ev", "err", err) }
-        }
-    }()
-
-    go func() {
-        for {
-            msg, err := ordersCons.Read(ctx)
-            if err != nil { slog.Error("orders-cons", "err", err); continue }
-            if _, err := eng.HandleOrderEvent(ctx, msg.Value); err != nil { slog.Error("orders-ev", "err", err) }
-        }
-    }()
+    onCatalog, onOrder := pricingHandlers(eng)
+    go catalogCons.Run(ctx, onCatalog)
+    go ordersCons.Run(ctx, onOrder)
 
     h := pricing_api.NewHandler(repo, eng)
-    srv := httpserver.New(cfg.Pricing.HTTPAddr, httpserver.CORS(h.Routes()))
+    srv := httpserver.New(cfg.Pricing.HTTPAddr, httpserver.WithMetrics(httpserver.CORS(h.Routes())))
     go func() {
         if err := srv.Start(); err != nil { slog.Error("http", "err", err) }
     }()
@@ -66,3 +70,16 @@ func RunPricing(ctx context.Context, cfg config.Root) error {
     _ = srv.Shutdown(shCtx)
     return nil
 }
+
+// pricingHandlers adapts the engine to the consumer loop for catalog.events
+// and orders.events.
+func pricingHandlers(eng *pricing.Engine) (onCatalog, onOrder consumer.Handler) {
+    onCatalog = func(ctx context.Context, msg kafka.Message) error {
+        return eng.HandleCatalogEvent(msg.Value)
+    }
+    onOrder = func(ctx context.Context, msg kafka.Message) error {
+        _, err := eng.HandleOrderEvent(ctx, msg.Value)
+        return err
+    }
+    return onCatalog, onOrder
+}
@@ -0,0 +1,222 @@
+// Package catalogclient looks products up in the catalog service over HTTP,
+// for the order service when it is configured to check products
+// synchronously instead of from catalog.events.
+package catalogclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultTimeout         = 2 * time.Second
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// Client implements order.ProductLookup and order.ReservationLookup. Every
+// call is bounded by a timeout; after a run of failed calls the circuit
+// breaker opens and calls fail fast with order.ErrCatalogUnavailable until
+// the cooldown is over, when a single trial call decides whether it closes
+// again.
+type Client struct {
+	base    string
+	http    *http.Client
+	timeout time.Duration
+
+	mu       sync.Mutex
+	failures int
+	maxFails int
+	cooldown time.Duration
+	openedAt time.Time
+	trial    bool
+	now      func() time.Time
+}
+
+type Option func(*Client)
+
+// WithTimeout bounds each call to the catalog.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithBreaker opens the breaker after failures consecutive failed calls and
+// keeps it open for cooldown.
+func WithBreaker(failures int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		if failures > 0 {
+			c.maxFails = failures
+		}
+		if cooldown > 0 {
+			c.cooldown = cooldown
+		}
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		base:     strings.TrimRight(baseURL, "/"),
+		http:     http.DefaultClient,
+		timeout:  DefaultTimeout,
+		maxFails: DefaultBreakerFailures,
+		cooldown: DefaultBreakerCooldown,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetProduct fetches a product from GET /products/{id}. Unknown products
+// are reported with storage.ErrNotFound; a catalog that cannot be reached,
+// times out or fails, or a breaker that is open, with
+// order.ErrCatalogUnavailable.
+func (c *Client) GetProduct(ctx context.Context, id uuid.UUID) (models.CatalogProduct, error) {
+	var p models.Product
+	if err := c.call(ctx, "/products/"+id.String(), &p); err != nil {
+		return models.CatalogProduct{}, err
+	}
+	return models.NewCatalogProduct(p), nil
+}
+
+// ExportProducts calls fn for every product, archived ones included, as
+// streamed by GET /products:export. It is bounded by ctx alone and does not
+// go through the breaker.
+func (c *Client) ExportProducts(ctx context.Context, fn func(models.CatalogProduct) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/products:export?format=jsonl&include_archived=true", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", order.ErrCatalogUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: catalog answered %s", order.ErrCatalogUnavailable, resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var p models.Product
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(models.NewCatalogProduct(p)); err != nil {
+			return err
+		}
+	}
+}
+
+// GetReservation fetches a reservation from
+// GET /products/{id}/reservations/{rid}, reporting errors like GetProduct.
+func (c *Client) GetReservation(ctx context.Context, productID, id uuid.UUID) (models.Reservation, error) {
+	var res models.Reservation
+	err := c.call(ctx, "/products/"+productID.String()+"/reservations/"+id.String(), &res)
+	return res, err
+}
+
+// call decodes the answer to a GET of path into v, through the breaker.
+func (c *Client) call(ctx context.Context, path string, v any) error {
+	if !c.allow() {
+		return fmt.Errorf("%w: circuit open", order.ErrCatalogUnavailable)
+	}
+	err := c.get(ctx, path, v)
+	switch {
+	case err == nil, errors.Is(err, storage.ErrNotFound):
+		c.record(true)
+		return err
+	case ctx.Err() != nil:
+		// The caller gave up; that says nothing about the catalog.
+		c.abandon()
+		return ctx.Err()
+	default:
+		c.record(false)
+		return fmt.Errorf("%w: %w", order.ErrCatalogUnavailable, err)
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return storage.ErrNotFound
+	default:
+		return fmt.Errorf("catalog answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// allow reports whether a call may go out: always while the breaker is
+// closed, and once it has been open for the cooldown, for one trial call at
+// a time.
+func (c *Client) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures < c.maxFails {
+		return true
+	}
+	if c.trial || c.now().Sub(c.openedAt) < c.cooldown {
+		return false
+	}
+	c.trial = true
+	return true
+}
+
+func (c *Client) record(ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trial = false
+	if ok {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= c.maxFails {
+		c.openedAt = c.now()
+	}
+}
+
+// abandon ends a call without counting it either way.
+func (c *Client) abandon() {
+	c.mu.Lock()
+	c.trial = false
+	c.mu.Unlock()
+}
@@ -0,0 +1,120 @@
+package catalogclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_GetProduct(t *testing.T) {
+	known := models.Product{ID: uuid.New(), Stock: 5, Reserved: 2, Version: 3}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/"+known.ID.String() {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(known)
+	}))
+	defer srv.Close()
+	c := New(srv.URL + "/")
+	ctx := context.Background()
+
+	p, err := c.GetProduct(ctx, known.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.Available)
+	require.Equal(t, int64(3), p.Version)
+
+	_, err = c.GetProduct(ctx, uuid.New())
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestClient_GetReservation(t *testing.T) {
+	known := models.Reservation{ID: uuid.New(), ProductID: uuid.New(), Qty: 2, Status: models.ReservationActive}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/"+known.ProductID.String()+"/reservations/"+known.ID.String() {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(known)
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	res, err := c.GetReservation(ctx, known.ProductID, known.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Qty)
+	require.Equal(t, models.ReservationActive, res.Status)
+
+	_, err = c.GetReservation(ctx, uuid.New(), known.ID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestClient_ExportProducts(t *testing.T) {
+	archivedAt := time.Now().UTC()
+	ps := []models.Product{{ID: uuid.New(), Stock: 2}, {ID: uuid.New(), ArchivedAt: &archivedAt}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/products:export", r.URL.Path)
+		require.Equal(t, "true", r.URL.Query().Get("include_archived"))
+		for _, p := range ps {
+			_ = json.NewEncoder(w).Encode(p)
+		}
+	}))
+	defer srv.Close()
+
+	var got []models.CatalogProduct
+	err := New(srv.URL).ExportProducts(context.Background(), func(p models.CatalogProduct) error {
+		got = append(got, p)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, 2, got[0].Available)
+	require.Nil(t, got[0].ArchivedAt)
+	require.NotNil(t, got[1].ArchivedAt)
+}
+
+func TestClient_BreakerOpensAndRecovers(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			time.Sleep(50 * time.Millisecond)
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	clock := time.Now()
+	c := New(srv.URL, WithTimeout(10*time.Millisecond), WithBreaker(2, time.Minute))
+	c.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	// Two timeouts open the breaker; the third call does not reach the catalog.
+	for i := 0; i < 3; i++ {
+		_, err := c.GetProduct(ctx, uuid.New())
+		require.ErrorIs(t, err, order.ErrCatalogUnavailable)
+	}
+	require.EqualValues(t, 2, calls.Load())
+
+	// After the cooldown a trial call goes out and closes it again.
+	healthy.Store(true)
+	clock = clock.Add(time.Minute)
+	_, err := c.GetProduct(ctx, uuid.New())
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = c.GetProduct(ctx, uuid.New())
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.EqualValues(t, 4, calls.Load())
+}
@@ -0,0 +1,81 @@
+// Package cloudevents maps the services' JSON event envelope
+// ({"type","ts","payload"}) to CloudEvents binary content mode over Kafka
+// and back, so the domain code keeps producing and consuming envelopes.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"dynamic-pricing/internal/kafkautil"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	SpecVersion = "1.0"
+
+	HeaderSpecVersion = "ce_specversion"
+	HeaderID          = "ce_id"
+	HeaderType        = "ce_type"
+	HeaderSource      = "ce_source"
+	HeaderTime        = "ce_time"
+	HeaderContentType = "content-type"
+
+	ContentTypeJSON = "application/json"
+)
+
+var ErrNotCloudEvent = errors.New("cloudevents: message has no ce_ headers")
+
+type envelope struct {
+	Type    string          `json:"type"`
+	TS      time.Time       `json:"ts"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// ToBinary splits a JSON envelope into CloudEvents headers and the data value.
+func ToBinary(source string, value []byte) ([]kafka.Header, []byte, error) {
+	var ev envelope
+	if err := json.Unmarshal(value, &ev); err != nil {
+		return nil, nil, err
+	}
+	if ev.Type == "" {
+		return nil, nil, errors.New("cloudevents: envelope without type")
+	}
+	ts := ev.TS
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+	headers := []kafka.Header{
+		{Key: HeaderSpecVersion, Value: []byte(SpecVersion)},
+		{Key: HeaderID, Value: []byte(uuid.NewString())},
+		{Key: HeaderType, Value: []byte(ev.Type)},
+		{Key: HeaderSource, Value: []byte(source)},
+		{Key: HeaderTime, Value: []byte(ts.UTC().Format(time.RFC3339Nano))},
+		{Key: HeaderContentType, Value: []byte(ContentTypeJSON)},
+	}
+	return headers, []byte(ev.Payload), nil
+}
+
+// FromBinary rebuilds the JSON envelope from a binary-mode message.
+// It returns ErrNotCloudEvent when the message carries no ce_type header.
+func FromBinary(msg kafka.Message) ([]byte, error) {
+	typ, ok := kafkautil.Header(msg, HeaderType)
+	if !ok {
+		return nil, ErrNotCloudEvent
+	}
+	ev := envelope{Type: typ, Payload: msg.Value}
+	if raw, ok := kafkautil.Header(msg, HeaderTime); ok {
+		ts, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, err
+		}
+		ev.TS = ts.UTC()
+	}
+	if len(ev.Payload) == 0 {
+		ev.Payload = json.RawMessage("null")
+	}
+	return json.Marshal(ev)
+}
@@ -0,0 +1,52 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/kafkautil"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	in, err := json.Marshal(map[string]any{
+		"type":    "product_created",
+		"ts":      ts,
+		"payload": map[string]any{"id": "p1", "stock": 5},
+	})
+	require.NoError(t, err)
+
+	headers, data, err := ToBinary("dynamic-pricing/catalog", in)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"p1","stock":5}`, string(data))
+
+	msg := kafka.Message{Headers: headers, Value: data}
+	typ, _ := kafkautil.Header(msg, HeaderType)
+	require.Equal(t, "product_created", typ)
+	src, _ := kafkautil.Header(msg, HeaderSource)
+	require.Equal(t, "dynamic-pricing/catalog", src)
+	id, ok := kafkautil.Header(msg, HeaderID)
+	require.True(t, ok)
+	require.NotEmpty(t, id)
+
+	out, err := FromBinary(msg)
+	require.NoError(t, err)
+	var ev struct {
+		Type    string          `json:"type"`
+		TS      time.Time       `json:"ts"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(out, &ev))
+	require.Equal(t, "product_created", ev.Type)
+	require.True(t, ts.Equal(ev.TS))
+	require.JSONEq(t, `{"id":"p1","stock":5}`, string(ev.Payload))
+}
+
+func TestFromBinary_NotCloudEvent(t *testing.T) {
+	_, err := FromBinary(kafka.Message{Value: []byte(`{"type":"x"}`)})
+	require.ErrorIs(t, err, ErrNotCloudEvent)
+}
@@ -2,23 +2,187 @@ package consumer
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "strconv"
+    "time"
+
+    "dynamic-pricing/internal/cloudevents"
+    "dynamic-pricing/internal/events"
+    "dynamic-pricing/internal/kafkautil"
+    "dynamic-pricing/internal/metrics"
 
     "github.com/segmentio/kafka-go"
 )
 
-type Consumer struct { r *kafka.Reader }
+type Consumer struct {
+    r           *kafka.Reader
+    brokers     []string
+    topic       string
+    group       string
+    cloudEvents bool
+    registry    *events.Registry
+}
+
+type Option func(*Consumer)
+
+// ErrDecode wraps errors converting a fetched message to the JSON envelope.
+// The message is still returned so callers can skip past it.
+var ErrDecode = errors.New("consumer: decode")
+
+// WithCloudEvents makes Read convert CloudEvents binary-mode messages back
+// into the JSON envelope. Messages without ce_ headers are passed through.
+func WithCloudEvents() Option {
+    return func(c *Consumer) { c.cloudEvents = true }
+}
+
+// WithRegistry lets Read decode protobuf messages using the schema named in
+// their content-type header. JSON messages are passed through unchanged.
+func WithRegistry(reg *events.Registry) Option {
+    return func(c *Consumer) { c.registry = reg }
+}
 
-func New(brokers []string, topic string, groupID string) *Consumer {
-    return &Consumer{ r: kafka.NewReader(kafka.ReaderConfig{
-        Brokers: brokers,
-        Topic:   topic,
-        GroupID: groupID,
-    })}
+func New(brokers []string, topic string, groupID string, opts ...Option) *Consumer {
+    c := &Consumer{
+        r: kafka.NewReader(kafka.ReaderConfig{
+            Brokers: brokers,
+            Topic:   topic,
+            GroupID: groupID,
+        }),
+        brokers: brokers,
+        topic:   topic,
+        group:   groupID,
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c
 }
 
 func (c *Consumer) Close() error { return c.r.Close() }
 
+// Read returns the next message with Value normalized to the JSON envelope.
+// The message offset is committed before it is returned.
 func (c *Consumer) Read(ctx context.Context) (kafka.Message, error) {
-    return c.r.ReadMessage(ctx)
+    msg, err := c.r.ReadMessage(ctx)
+    if err != nil {
+        return msg, err
+    }
+    return c.decode(msg)
+}
+
+// Fetch is like Read but leaves committing to the caller, see Commit.
+func (c *Consumer) Fetch(ctx context.Context) (kafka.Message, error) {
+    msg, err := c.r.FetchMessage(ctx)
+    if err != nil {
+        return msg, err
+    }
+    return c.decode(msg)
+}
+
+// Commit commits the offsets of messages returned by Fetch.
+func (c *Consumer) Commit(ctx context.Context, msgs ...kafka.Message) error {
+    return c.r.CommitMessages(ctx, msgs...)
 }
 
+func (c *Consumer) decode(msg kafka.Message) (kafka.Message, error) {
+    if c.cloudEvents {
+        value, err := cloudevents.FromBinary(msg)
+        if err == nil {
+            msg.Value = value
+            return msg, nil
+        }
+        if !errors.Is(err, cloudevents.ErrNotCloudEvent) {
+            return msg, fmt.Errorf("%w: %w", ErrDecode, err)
+        }
+    }
+    contentType, _ := kafkautil.Header(msg, events.HeaderContentType)
+    codec, err := c.registry.CodecFor(contentType)
+    if err != nil {
+        return msg, fmt.Errorf("%w: %w", ErrDecode, err)
+    }
+    value, err := codec.Decode(msg.Value)
+    if err != nil {
+        return msg, fmt.Errorf("%w: %w", ErrDecode, err)
+    }
+    msg.Value = value
+    return msg, nil
+}
+
+// Handler processes one message whose Value is the JSON envelope.
+type Handler func(ctx context.Context, msg kafka.Message) error
+
+// statsInterval is how often reader stats are copied into metrics.
+const statsInterval = 5 * time.Second
+
+// Run reads messages and passes them to h until ctx is done or the reader
+// is closed. Read and handler errors are logged and counted; the loop keeps
+// going so one bad message does not stall the partition.
+func (c *Consumer) Run(ctx context.Context, h Handler) {
+    stop := make(chan struct{})
+    defer close(stop)
+    go c.collectStats(stop)
+
+    for {
+        msg, err := c.Read(ctx)
+        if err != nil {
+            if ctx.Err() != nil || errors.Is(err, io.EOF) {
+                return
+            }
+            stage := "read"
+            if errors.Is(err, ErrDecode) {
+                stage = "decode"
+            }
+            metrics.ConsumerErrors.WithLabelValues(c.topic, c.group, stage).Inc()
+            slog.Error("consumer: "+stage, "topic", c.topic, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+            continue
+        }
+        c.handle(ctx, h, msg)
+    }
+}
+
+// handle passes msg to h, timing and counting it, and logs h's error.
+func (c *Consumer) handle(ctx context.Context, h Handler, msg kafka.Message) {
+    start := time.Now()
+    err := h(ctx, msg)
+    metrics.ConsumerHandlerDuration.WithLabelValues(c.topic, c.group).Observe(time.Since(start).Seconds())
+    metrics.ConsumerMessages.WithLabelValues(c.topic, c.group).Inc()
+    if err != nil {
+        metrics.ConsumerErrors.WithLabelValues(c.topic, c.group, "handle").Inc()
+        slog.Error("consumer: handle", "topic", c.topic, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+    }
+}
+
+func (c *Consumer) collectStats(stop <-chan struct{}) {
+    t := time.NewTicker(statsInterval)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            // Stats() resets its counters on every call, so they are added as deltas.
+            st := c.r.Stats()
+            metrics.ConsumerRebalances.WithLabelValues(c.topic, c.group).Add(float64(st.Rebalances))
+            c.collectLag()
+        }
+    }
+}
+
+// collectLag sets the lag gauge of every partition of the topic. A group
+// reader's Stats() only describe partition 0, so the lag is asked from the
+// brokers instead.
+func (c *Consumer) collectLag() {
+    ctx, cancel := context.WithTimeout(context.Background(), statsInterval)
+    defer cancel()
+    lag, err := kafkautil.GroupLag(ctx, c.brokers, c.group, c.topic)
+    if err != nil {
+        slog.Warn("consumer: lag", "topic", c.topic, "group", c.group, "err", err)
+        return
+    }
+    for p, n := range lag {
+        metrics.ConsumerLag.WithLabelValues(c.topic, c.group, strconv.Itoa(p)).Set(float64(n))
+    }
+}
@@ -0,0 +1,45 @@
+package consumer
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "dynamic-pricing/internal/httpserver"
+    "dynamic-pricing/internal/metrics"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/segmentio/kafka-go"
+    "github.com/stretchr/testify/require"
+)
+
+func TestHandle_RecordsMetrics(t *testing.T) {
+    c := &Consumer{topic: "metrics-test.events", group: "metrics-test"}
+    ok := func(context.Context, kafka.Message) error { return nil }
+    failing := func(context.Context, kafka.Message) error { return errors.New("boom") }
+
+    c.handle(context.Background(), ok, kafka.Message{Value: []byte(`{}`)})
+    c.handle(context.Background(), failing, kafka.Message{Value: []byte(`{}`)})
+
+    require.Equal(t, 2.0, testutil.ToFloat64(metrics.ConsumerMessages.WithLabelValues(c.topic, c.group)))
+    require.Equal(t, 1.0, testutil.ToFloat64(metrics.ConsumerErrors.WithLabelValues(c.topic, c.group, "handle")))
+
+    // The same series are served on GET /metrics, the handler latency as a
+    // histogram.
+    srv := httptest.NewServer(httpserver.WithMetrics(http.NotFoundHandler()))
+    defer srv.Close()
+    resp, err := http.Get(srv.URL + "/metrics")
+    require.NoError(t, err)
+    defer resp.Body.Close()
+    require.Equal(t, http.StatusOK, resp.StatusCode)
+    b, err := io.ReadAll(resp.Body)
+    require.NoError(t, err)
+    body := string(b)
+    require.Contains(t, body, `kafka_consumer_messages_total{group="metrics-test",topic="metrics-test.events"} 2`)
+    require.Contains(t, body, `kafka_consumer_errors_total{group="metrics-test",stage="handle",topic="metrics-test.events"} 1`)
+    require.Contains(t, body, `kafka_consumer_handler_duration_seconds_count{group="metrics-test",topic="metrics-test.events"} 2`)
+    require.Contains(t, body, `kafka_consumer_handler_duration_seconds_bucket{group="metrics-test",topic="metrics-test.events",le="+Inf"} 2`)
+}
@@ -0,0 +1,41 @@
+// Package events holds the wire codecs for domain events. Services build
+// events as JSON envelopes ({"type","ts","payload"}); a Codec converts that
+// canonical form to and from the representation written to Kafka.
+package events
+
+import (
+	"mime"
+	"strings"
+)
+
+const (
+	HeaderContentType = "content-type"
+
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+type Codec interface {
+	// ContentType is written to the content-type Kafka header.
+	ContentType() string
+	// Encode converts a JSON envelope to the wire representation.
+	Encode(envelope []byte) ([]byte, error)
+	// Decode converts the wire representation back to a JSON envelope.
+	Decode(data []byte) ([]byte, error)
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string                    { return ContentTypeJSON }
+func (JSONCodec) Encode(envelope []byte) ([]byte, error) { return envelope, nil }
+func (JSONCodec) Decode(data []byte) ([]byte, error)     { return data, nil }
+
+// ParseContentType splits a content-type header into the media type and the
+// protobuf message name carried in its messageType parameter, if any.
+func ParseContentType(v string) (mediaType string, messageType string) {
+	mt, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return strings.TrimSpace(strings.ToLower(v)), ""
+	}
+	return mt, params["messagetype"]
+}
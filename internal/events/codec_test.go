@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"dynamic-pricing/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadEmbedded(t *testing.T) *Registry {
+	t.Helper()
+	fsys, err := fs.Sub(api.Protos, "proto")
+	require.NoError(t, err)
+	reg, err := LoadRegistry(context.Background(), fsys)
+	require.NoError(t, err)
+	return reg
+}
+
+func TestProtoCodec_RoundTrip(t *testing.T) {
+	reg := loadEmbedded(t)
+	codec, err := reg.Codec("dynamicpricing.events.v1.ProductEvent")
+	require.NoError(t, err)
+
+	in := `{"type":"product_archived","ts":"2024-01-02T03:04:05Z","payload":{"id":"8b0c6f4e-3d1a-4a53-9b61-1f1f3a0c1a11","name":"A","base_price":10.5,"stock":5,"archived_at":"2024-01-02T03:04:05Z","category_id":"5f6c1d2e-0a1b-4c3d-8e9f-0a1b2c3d4e5f","category_path":["5f6c1d2e-0a1b-4c3d-8e9f-0a1b2c3d4e5f"],"version":"3","backorders":true,"reserved":2,"available":3}}`
+	data, err := codec.Encode([]byte(in))
+	require.NoError(t, err)
+	require.Less(t, len(data), len(in))
+
+	// The decoder is resolved from the content type, as consumers do.
+	dec, err := reg.CodecFor(codec.ContentType())
+	require.NoError(t, err)
+	out, err := dec.Decode(data)
+	require.NoError(t, err)
+	require.JSONEq(t, in, string(out))
+}
+
+func TestProtoCodec_OrderLines(t *testing.T) {
+	reg := loadEmbedded(t)
+	codec, err := reg.Codec("dynamicpricing.events.v1.OrderEvent")
+	require.NoError(t, err)
+
+	in := `{"type":"order_placed","ts":"2024-01-02T03:04:05Z","payload":{"id":"8b0c6f4e-3d1a-4a53-9b61-1f1f3a0c1a11","user_id":"5f6c1d2e-0a1b-4c3d-8e9f-0a1b2c3d4e5f","product_id":"0d8f4c5e-9a1b-4c3d-8e9f-0a1b2c3d4e5f","qty":2,"status":"placed","reservation_id":"","unit_price":10.5,"currency":"USD","total":26,"previous_status":"","lines":[{"product_id":"0d8f4c5e-9a1b-4c3d-8e9f-0a1b2c3d4e5f","qty":2,"unit_price":10.5,"total":21},{"product_id":"1e9f4c5e-9a1b-4c3d-8e9f-0a1b2c3d4e5f","qty":1,"unit_price":5,"total":5}]}}`
+	data, err := codec.Encode([]byte(in))
+	require.NoError(t, err)
+	out, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.JSONEq(t, in, string(out))
+}
+
+func TestProtoCodec_UserEvent(t *testing.T) {
+	reg := loadEmbedded(t)
+	codec, err := reg.Codec("dynamicpricing.events.v1.UserEvent")
+	require.NoError(t, err)
+
+	in := `{"type":"user_segment_changed","ts":"2024-01-02T03:04:05Z","payload":{"id":"8b0c6f4e-3d1a-4a53-9b61-1f1f3a0c1a11","email":"a@ex.com","active":true,"segment":"loyal","order_count":7,"previous_segment":"regular"}}`
+	data, err := codec.Encode([]byte(in))
+	require.NoError(t, err)
+	out, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.JSONEq(t, in, string(out))
+}
+
+func TestRegistry_CodecFor(t *testing.T) {
+	reg := loadEmbedded(t)
+
+	c, err := reg.CodecFor("")
+	require.NoError(t, err)
+	require.IsType(t, JSONCodec{}, c)
+
+	_, err = reg.CodecFor("application/x-protobuf; messageType=nope.Missing")
+	require.Error(t, err)
+
+	_, err = reg.CodecFor("text/plain")
+	require.Error(t, err)
+}
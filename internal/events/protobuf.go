@@ -0,0 +1,41 @@
+package events
+
+import (
+	"mime"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoCodec encodes envelopes as the protobuf message described by desc.
+// Messages are built dynamically from the registry, so no generated code is
+// needed and the .proto files remain the single source of truth.
+type ProtoCodec struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func NewProtoCodec(desc protoreflect.MessageDescriptor) *ProtoCodec {
+	return &ProtoCodec{desc: desc}
+}
+
+func (c *ProtoCodec) ContentType() string {
+	return mime.FormatMediaType(ContentTypeProtobuf, map[string]string{"messageType": string(c.desc.FullName())})
+}
+
+func (c *ProtoCodec) Encode(envelope []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(envelope, msg); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+func (c *ProtoCodec) Decode(data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Registry is a local, file-based stand-in for a schema registry: it compiles
+// every .proto file found in a directory tree and resolves codecs by message
+// name or content type.
+type Registry struct {
+	messages map[protoreflect.FullName]protoreflect.MessageDescriptor
+}
+
+// LoadRegistry compiles all .proto files under fsys.
+func LoadRegistry(ctx context.Context, fsys fs.FS) (*Registry, error) {
+	var files []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".proto") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	comp := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: func(path string) (io.ReadCloser, error) { return fsys.Open(path) },
+		}),
+	}
+	compiled, err := comp.Compile(ctx, files...)
+	if err != nil {
+		return nil, fmt.Errorf("compile schemas: %w", err)
+	}
+	r := &Registry{messages: make(map[protoreflect.FullName]protoreflect.MessageDescriptor)}
+	for _, f := range compiled {
+		msgs := f.Messages()
+		for i := 0; i < msgs.Len(); i++ {
+			r.messages[msgs.Get(i).FullName()] = msgs.Get(i)
+		}
+	}
+	return r, nil
+}
+
+// Codec returns a protobuf codec for the named message.
+func (r *Registry) Codec(messageType string) (Codec, error) {
+	desc, ok := r.messages[protoreflect.FullName(messageType)]
+	if !ok {
+		return nil, fmt.Errorf("schema registry: unknown message %q", messageType)
+	}
+	return NewProtoCodec(desc), nil
+}
+
+// CodecFor resolves the codec for a content-type header value. An empty
+// value is treated as JSON, the format used before content types existed.
+func (r *Registry) CodecFor(contentType string) (Codec, error) {
+	mt, messageType := ParseContentType(contentType)
+	switch mt {
+	case "", ContentTypeJSON:
+		return JSONCodec{}, nil
+	case ContentTypeProtobuf:
+		if r == nil {
+			return nil, fmt.Errorf("schema registry: not configured for %q", contentType)
+		}
+		return r.Codec(messageType)
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
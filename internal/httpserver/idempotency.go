@@ -0,0 +1,195 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+)
+
+const (
+	// IdempotencyKeyHeader names the key a client sends to make retries of a
+	// request safe.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for a repeat.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey = 255
+)
+
+// replayedHeaders are the response headers stored with a response and sent
+// again with its replays.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "Last-Modified"}
+
+// IdempotencyStore keeps idempotency records.
+type IdempotencyStore interface {
+	// ClaimKey stores rec unless its key is held by a record that has not
+	// expired at rec.CreatedAt; then it returns that record and
+	// storage.ErrConflict.
+	ClaimKey(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, error)
+	// CompleteKey stores the response to the request holding key.
+	CompleteKey(ctx context.Context, key string, status int, header http.Header, body []byte) error
+	// ReleaseKey drops key so that the request can be retried.
+	ReleaseKey(ctx context.Context, key string) error
+	// PurgeKeys deletes the records expired at now and returns how many.
+	PurgeKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// Idempotency makes the requests it wraps safe to retry. A request with an
+// Idempotency-Key header is served once; repeats within ttl get the stored
+// response back, 409 while the first is still being served, or 422 if they
+// differ from it in body. Keys are scoped to the method and path, so the same
+// key sent to two endpoints names two requests; the services do not
+// authenticate clients, so clients are not told apart. Server errors and
+// panics are not stored, so a retry after one runs the request again.
+// Requests without the header pass through.
+func Idempotency(store IdempotencyStore, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "bad body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key = scopedKey(r, key)
+			fp := fingerprint(r, body)
+			now := time.Now().UTC()
+			rec, err := store.ClaimKey(r.Context(), models.IdempotencyRecord{
+				Key:         key,
+				Fingerprint: fp,
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			})
+			switch {
+			case errors.Is(err, storage.ErrConflict):
+				replay(w, rec, fp)
+				return
+			case err != nil:
+				slog.Error("idempotency: claim key", "key", key, "err", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+
+			// The client may be gone already; the outcome is stored anyway.
+			ctx := context.WithoutCancel(r.Context())
+			defer func() {
+				if p := recover(); p != nil {
+					if err := store.ReleaseKey(ctx, key); err != nil {
+						slog.Error("idempotency: release key after panic", "key", key, "err", err)
+					}
+					panic(p)
+				}
+			}()
+			rw := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			if rw.status >= http.StatusInternalServerError {
+				err = store.ReleaseKey(ctx, key)
+			} else {
+				err = store.CompleteKey(ctx, key, rw.status, storedHeader(rw.Header()), rw.body.Bytes())
+			}
+			if err != nil {
+				slog.Error("idempotency: store response", "key", key, "err", err)
+			}
+		})
+	}
+}
+
+// replay answers a repeat of the request rec was stored for.
+func replay(w http.ResponseWriter, rec models.IdempotencyRecord, fp string) {
+	switch {
+	case rec.Fingerprint != fp:
+		http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+	case rec.Pending():
+		http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+	default:
+		for name, values := range rec.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(rec.Status)
+		_, _ = w.Write(rec.Body)
+	}
+}
+
+// storedHeader is the part of h kept for replays.
+func storedHeader(h http.Header) http.Header {
+	out := make(http.Header)
+	for _, name := range replayedHeaders {
+		if vs := h.Values(name); len(vs) > 0 {
+			out[http.CanonicalHeaderKey(name)] = append([]string(nil), vs...)
+		}
+	}
+	return out
+}
+
+// RunIdempotencyPurger deletes expired idempotency records every interval
+// until ctx is done.
+func RunIdempotencyPurger(ctx context.Context, store IdempotencyStore, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := store.PurgeKeys(ctx, now.UTC())
+			if err != nil {
+				slog.Error("idempotency: purge keys", "err", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("idempotency: purged keys", "count", n)
+			}
+		}
+	}
+}
+
+// scopedKey is the stored key of a request sent with the Idempotency-Key
+// key.
+func scopedKey(r *http.Request, key string) string {
+	return r.Method + " " + r.URL.Path + "\n" + key
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,60 @@
+package httpserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/httpserver"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency_KeysAreScopedToMethodAndPath(t *testing.T) {
+	served := map[string]int{}
+	h := httpserver.Idempotency(memory.NewIdempotencyStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served[r.URL.Path]++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	do := func(path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set(httpserver.IdempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, do("/orders", `{"qty":1}`).Code)
+	require.Equal(t, http.StatusCreated, do("/products", `{"name":"A"}`).Code, "same key, another endpoint")
+	replayed := do("/orders", `{"qty":1}`)
+	require.Equal(t, http.StatusCreated, replayed.Code)
+	require.Equal(t, "true", replayed.Header().Get(httpserver.IdempotentReplayedHeader))
+	require.Equal(t, http.StatusUnprocessableEntity, do("/orders", `{"qty":2}`).Code)
+	require.Equal(t, map[string]int{"/orders": 1, "/products": 1}, served)
+}
+
+func TestIdempotency_PanicReleasesKey(t *testing.T) {
+	panics := true
+	h := httpserver.Idempotency(memory.NewIdempotencyStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{}`))
+		r.Header.Set(httpserver.IdempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	require.PanicsWithValue(t, "boom", func() { do() }, "the panic goes on to the server")
+	panics = false
+	w := do()
+	require.Equal(t, http.StatusCreated, w.Code, "the retry runs instead of waiting out the pending key")
+	require.Empty(t, w.Header().Get(httpserver.IdempotentReplayedHeader))
+}
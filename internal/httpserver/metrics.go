@@ -0,0 +1,16 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// WithMetrics serves Prometheus metrics on /metrics and passes every other
+// request to next.
+func WithMetrics(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", next)
+	return mux
+}
@@ -0,0 +1,13 @@
+package kafkautil
+
+import "github.com/segmentio/kafka-go"
+
+// Header returns the value of the first header with the given key.
+func Header(msg kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,187 @@
+package kafkautil
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// OffsetTarget selects where a consumer group is rewound to: the first offset
+// at or after At when it is set, otherwise Offset in every partition.
+type OffsetTarget struct {
+	At     time.Time
+	Offset int64
+}
+
+// PartitionRange is the span [Start, End) of offsets a rewound group will
+// read in one partition; End is the high watermark at reset time.
+type PartitionRange struct {
+	Partition int
+	Start     int64
+	End       int64
+}
+
+// ResetGroupOffsets commits target offsets for group on topic and returns the
+// ranges left to consume. The group must have no active members, otherwise
+// the broker rejects the commit.
+func ResetGroupOffsets(parent context.Context, brokers []string, group, topic string, target OffsetTarget) ([]PartitionRange, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers configured")
+	}
+	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
+	defer cancel()
+
+	client := &kafka.Client{Addr: kafka.TCP(brokers...)}
+	partitions, err := topicPartitions(ctx, client, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := listOffsets(ctx, client, topic, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	last, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	var atTime map[int]int64
+	if !target.At.IsZero() {
+		atTime, err = listOffsets(ctx, client, topic, partitions, func(p int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(p, target.At)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ranges := make([]PartitionRange, 0, len(partitions))
+	commits := make([]kafka.OffsetCommit, 0, len(partitions))
+	for _, p := range partitions {
+		start := target.Offset
+		if atTime != nil {
+			start = atTime[p]
+			// No message at or after the timestamp: nothing to replay.
+			if start < 0 {
+				start = last[p]
+			}
+		}
+		start = min(max(start, first[p]), last[p])
+		ranges = append(ranges, PartitionRange{Partition: p, Start: start, End: last[p]})
+		commits = append(commits, kafka.OffsetCommit{Partition: p, Offset: start})
+	}
+
+	resp, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("commit offsets for %s: %w", group, err)
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("commit offsets for %s partition %d: %w", group, p.Partition, p.Error)
+		}
+	}
+	return ranges, nil
+}
+
+// GroupLag returns, for every partition of topic, how many messages group
+// has yet to commit: the high watermark minus the committed offset, or minus
+// the first offset where the group has committed nothing.
+func GroupLag(ctx context.Context, brokers []string, group, topic string) (map[int]int64, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers configured")
+	}
+	client := &kafka.Client{Addr: kafka.TCP(brokers...)}
+	partitions, err := topicPartitions(ctx, client, topic)
+	if err != nil {
+		return nil, err
+	}
+	first, err := listOffsets(ctx, client, topic, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	last, err := listOffsets(ctx, client, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group, Topics: map[string][]int{topic: partitions}})
+	if err != nil {
+		return nil, fmt.Errorf("fetch offsets of %s: %w", group, err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("fetch offsets of %s: %w", group, resp.Error)
+	}
+	committed := make(map[int]int64, len(partitions))
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch offsets of %s partition %d: %w", group, p.Partition, p.Error)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+	lag := make(map[int]int64, len(partitions))
+	for _, p := range partitions {
+		from, ok := committed[p]
+		if !ok || from < 0 {
+			from = first[p]
+		}
+		lag[p] = max(last[p]-from, 0)
+	}
+	return lag, nil
+}
+
+func topicPartitions(ctx context.Context, client *kafka.Client, topic string) ([]int, error) {
+	md, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("metadata %s: %w", topic, err)
+	}
+	var partitions []int
+	for _, t := range md.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("metadata %s: %w", topic, t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return partitions, nil
+}
+
+func listOffsets(ctx context.Context, client *kafka.Client, topic string, partitions []int, req func(int) kafka.OffsetRequest) (map[int]int64, error) {
+	reqs := make([]kafka.OffsetRequest, 0, len(partitions))
+	for _, p := range partitions {
+		reqs = append(reqs, req(p))
+	}
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: reqs}})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets %s: %w", topic, err)
+	}
+	out := make(map[int]int64, len(partitions))
+	for _, po := range resp.Topics[topic] {
+		if po.Error != nil {
+			return nil, fmt.Errorf("list offsets %s partition %d: %w", topic, po.Partition, po.Error)
+		}
+		switch {
+		case po.FirstOffset >= 0 && po.LastOffset < 0 && len(po.Offsets) == 0:
+			out[po.Partition] = po.FirstOffset
+		case po.LastOffset >= 0 && po.FirstOffset < 0 && len(po.Offsets) == 0:
+			out[po.Partition] = po.LastOffset
+		default:
+			out[po.Partition] = -1
+			for off := range po.Offsets {
+				out[po.Partition] = off
+			}
+		}
+	}
+	return out, nil
+}
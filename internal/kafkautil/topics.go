@@ -2,64 +2,203 @@ package kafkautil
 
 import (
     "context"
+    "errors"
     "fmt"
     "log/slog"
-    "net"
-    "net/netip"
+    "strconv"
     "time"
 
     "github.com/segmentio/kafka-go"
 )
 
-// EnsureTopic creates the topic if it doesn't exist. Idempotent on existing topics.
-func EnsureTopic(parent context.Context, brokers []string, topic string, partitions int, replicationFactor int) error {
-    if topic == "" {
-        return fmt.Errorf("empty topic")
+// TopicSpec is the desired shape of a topic. Zero Partitions,
+// ReplicationFactor and Retention and empty CleanupPolicy leave the broker
+// defaults in place.
+type TopicSpec struct {
+    Name              string
+    Partitions        int
+    ReplicationFactor int
+    Retention         time.Duration
+    CleanupPolicy     string
+}
+
+const (
+    configRetentionMs   = "retention.ms"
+    configCleanupPolicy = "cleanup.policy"
+)
+
+func (s TopicSpec) configEntries() []kafka.ConfigEntry {
+    var entries []kafka.ConfigEntry
+    if s.Retention > 0 {
+        entries = append(entries, kafka.ConfigEntry{ConfigName: configRetentionMs, ConfigValue: strconv.FormatInt(s.Retention.Milliseconds(), 10)})
     }
+    if s.CleanupPolicy != "" {
+        entries = append(entries, kafka.ConfigEntry{ConfigName: configCleanupPolicy, ConfigValue: s.CleanupPolicy})
+    }
+    return entries
+}
+
+// TopicState is what the broker reports for an existing topic.
+type TopicState struct {
+    Partitions        int
+    ReplicationFactor int
+    Configs           map[string]string
+}
+
+// Drift describes one setting where an existing topic differs from its spec.
+type Drift struct {
+    Topic   string
+    Setting string
+    Want    string
+    Got     string
+}
+
+func (d Drift) String() string {
+    return fmt.Sprintf("%s: %s want %s, got %s", d.Topic, d.Setting, d.Want, d.Got)
+}
+
+// EnsureTopics creates missing topics from their specs and compares existing
+// ones against them. Differences are returned as drift, not corrected: partition
+// counts can only grow and replication changes need a reassignment plan.
+func EnsureTopics(parent context.Context, brokers []string, specs ...TopicSpec) ([]Drift, error) {
     if len(brokers) == 0 {
-        return fmt.Errorf("no kafka brokers configured")
+        return nil, fmt.Errorf("no kafka brokers configured")
+    }
+    for _, s := range specs {
+        if s.Name == "" {
+            return nil, fmt.Errorf("empty topic")
+        }
     }
 
     ctx, cancel := context.WithTimeout(parent, 10*time.Second)
     defer cancel()
 
-    // Dial any broker to find the controller.
-    dialer := &kafka.Dialer{Timeout: 5 * time.Second}
-    conn, err := dialer.DialContext(ctx, "tcp", brokers[0])
+    // A transport of our own: the shared one may serve metadata from a cache
+    // that predates the topics.
+    transport := &kafka.Transport{DialTimeout: 5 * time.Second}
+    defer transport.CloseIdleConnections()
+    client := &kafka.Client{Addr: kafka.TCP(brokers...), Transport: transport}
+
+    existing, err := describeTopics(ctx, client, specs)
     if err != nil {
-        return fmt.Errorf("dial broker: %w", err)
+        return nil, err
     }
-    defer conn.Close()
 
-    ctrl, err := conn.Controller()
+    var drift []Drift
+    var create []kafka.TopicConfig
+    for _, s := range specs {
+        if state, ok := existing[s.Name]; ok {
+            drift = append(drift, CompareTopic(s, state)...)
+            continue
+        }
+        create = append(create, kafka.TopicConfig{
+            Topic:             s.Name,
+            NumPartitions:     orBrokerDefault(s.Partitions),
+            ReplicationFactor: orBrokerDefault(s.ReplicationFactor),
+            ConfigEntries:     s.configEntries(),
+        })
+    }
+    if len(create) == 0 {
+        return drift, nil
+    }
+    resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: create})
     if err != nil {
-        return fmt.Errorf("get controller: %w", err)
+        return nil, fmt.Errorf("create topics: %w", err)
+    }
+    for _, t := range create {
+        // Another instance starting at the same time may have won the race.
+        if err := resp.Errors[t.Topic]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+            return nil, fmt.Errorf("create topic %s: %w", t.Topic, err)
+        }
+        slog.Info("kafka: created topic", "topic", t.Topic, "partitions", t.NumPartitions, "replication_factor", t.ReplicationFactor)
     }
+    return drift, nil
+}
 
-    // Build controller network address.
-    host := ctrl.Host
-    port := ctrl.Port
-    // Normalize IPv6 host if needed.
-    if ip, err := netip.ParseAddr(host); err == nil && ip.Is6() {
-        host = "[" + host + "]"
+// orBrokerDefault maps an unset partition count or replication factor to -1,
+// which makes the broker (Kafka 2.4 and later) use its own default.
+func orBrokerDefault(n int) int {
+    if n <= 0 {
+        return -1
     }
-    addr := net.JoinHostPort(host, fmt.Sprint(port))
+    return n
+}
 
-    ctrlConn, err := dialer.DialContext(ctx, "tcp", addr)
+// describeTopics returns the current state of the specs' topics that exist.
+// The metadata request does not ask for auto-creation, so absent topics come
+// back as UnknownTopicOrPartition instead of being created with the broker
+// defaults.
+func describeTopics(ctx context.Context, client *kafka.Client, specs []TopicSpec) (map[string]TopicState, error) {
+    names := make([]string, 0, len(specs))
+    for _, s := range specs {
+        names = append(names, s.Name)
+    }
+    meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
     if err != nil {
-        return fmt.Errorf("dial controller: %w", err)
+        return nil, fmt.Errorf("read metadata: %w", err)
+    }
+    states := make(map[string]TopicState)
+    for _, t := range meta.Topics {
+        switch {
+        case errors.Is(t.Error, kafka.UnknownTopicOrPartition):
+            continue
+        case t.Error != nil:
+            return nil, fmt.Errorf("read metadata of %s: %w", t.Name, t.Error)
+        }
+        st := TopicState{Partitions: len(t.Partitions)}
+        for _, p := range t.Partitions {
+            if len(p.Replicas) > st.ReplicationFactor {
+                st.ReplicationFactor = len(p.Replicas)
+            }
+        }
+        states[t.Name] = st
+    }
+    if len(states) == 0 {
+        return states, nil
     }
-    defer ctrlConn.Close()
 
-    // Create topic (idempotent; TopicAlreadyExists is ignored inside kafka-go).
-    if err := ctrlConn.CreateTopics(kafka.TopicConfig{
-        Topic:             topic,
-        NumPartitions:     partitions,
-        ReplicationFactor: replicationFactor,
-    }); err != nil {
-        return fmt.Errorf("create topic %s: %w", topic, err)
+    req := &kafka.DescribeConfigsRequest{}
+    for name := range states {
+        req.Resources = append(req.Resources, kafka.DescribeConfigRequestResource{
+            ResourceType: kafka.ResourceTypeTopic,
+            ResourceName: name,
+            ConfigNames:  []string{configRetentionMs, configCleanupPolicy},
+        })
     }
-    slog.Info("kafka: ensured topic", "topic", topic)
-    return nil
+    resp, err := client.DescribeConfigs(ctx, req)
+    if err != nil {
+        return nil, fmt.Errorf("describe topic configs: %w", err)
+    }
+    for _, res := range resp.Resources {
+        if res.Error != nil {
+            return nil, fmt.Errorf("describe topic %s: %w", res.ResourceName, res.Error)
+        }
+        st := states[res.ResourceName]
+        st.Configs = make(map[string]string, len(res.ConfigEntries))
+        for _, e := range res.ConfigEntries {
+            st.Configs[e.ConfigName] = e.ConfigValue
+        }
+        states[res.ResourceName] = st
+    }
+    return states, nil
 }
 
+// CompareTopic lists the settings where state differs from spec.
+func CompareTopic(spec TopicSpec, state TopicState) []Drift {
+    var drift []Drift
+    add := func(setting, want, got string) {
+        drift = append(drift, Drift{Topic: spec.Name, Setting: setting, Want: want, Got: got})
+    }
+    if spec.Partitions > 0 && state.Partitions != spec.Partitions {
+        add("partitions", strconv.Itoa(spec.Partitions), strconv.Itoa(state.Partitions))
+    }
+    if spec.ReplicationFactor > 0 && state.ReplicationFactor != spec.ReplicationFactor {
+        add("replication_factor", strconv.Itoa(spec.ReplicationFactor), strconv.Itoa(state.ReplicationFactor))
+    }
+    for _, e := range spec.configEntries() {
+        if got := state.Configs[e.ConfigName]; got != e.ConfigValue {
+            add(e.ConfigName, e.ConfigValue, got)
+        }
+    }
+    return drift
+}
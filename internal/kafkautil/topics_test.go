@@ -0,0 +1,27 @@
+package kafkautil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareTopic(t *testing.T) {
+	spec := TopicSpec{Name: "pricing.events", Partitions: 3, ReplicationFactor: 1, Retention: time.Hour, CleanupPolicy: "compact"}
+
+	same := TopicState{Partitions: 3, ReplicationFactor: 1, Configs: map[string]string{"retention.ms": "3600000", "cleanup.policy": "compact"}}
+	require.Empty(t, CompareTopic(spec, same))
+
+	drifted := TopicState{Partitions: 1, ReplicationFactor: 1, Configs: map[string]string{"retention.ms": "604800000", "cleanup.policy": "delete"}}
+	drift := CompareTopic(spec, drifted)
+	require.Len(t, drift, 3)
+	require.Equal(t, Drift{Topic: "pricing.events", Setting: "partitions", Want: "3", Got: "1"}, drift[0])
+	require.Equal(t, "retention.ms", drift[1].Setting)
+	require.Equal(t, "cleanup.policy", drift[2].Setting)
+}
+
+func TestCompareTopic_UnsetSettingsIgnored(t *testing.T) {
+	spec := TopicSpec{Name: "orders.events"}
+	require.Empty(t, CompareTopic(spec, TopicState{Partitions: 6, ReplicationFactor: 3}))
+}
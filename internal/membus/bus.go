@@ -0,0 +1,182 @@
+// Package membus is an in-process replacement for Kafka: publishers
+// implement services.EventBus and subscribers expose the same Run loop as
+// consumer.Consumer, so services can be wired in a single binary or test.
+package membus
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/consumer"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Bus fans every message sent to a topic out to all of its subscribers,
+// like independent consumer groups on a Kafka topic.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[string][]*Subscriber
+}
+
+func New() *Bus {
+	return &Bus{subs: make(map[string][]*Subscriber)}
+}
+
+// Publisher returns a services.EventBus that sends to topic.
+func (b *Bus) Publisher(topic string) *Publisher {
+	return &Publisher{bus: b, topic: topic}
+}
+
+// Subscribe registers a subscriber that receives messages sent to topic
+// from now on.
+func (b *Bus) Subscribe(topic string) *Subscriber {
+	s := &Subscriber{topic: topic, notify: make(chan struct{}, 1), closed: make(chan struct{})}
+	b.mu.Lock()
+	b.subs[topic] = append(b.subs[topic], s)
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Bus) publish(msg kafka.Message) {
+	b.mu.RLock()
+	subs := b.subs[msg.Topic]
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.push(msg)
+	}
+}
+
+type Publisher struct {
+	bus    *Bus
+	topic  string
+	mu     sync.Mutex
+	offset int64
+}
+
+// Send never blocks on subscribers; messages are queued per subscriber.
+func (p *Publisher) Send(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	offset := p.offset
+	p.offset++
+	p.mu.Unlock()
+	p.bus.publish(kafka.Message{
+		Topic:  p.topic,
+		Offset: offset,
+		Key:    []byte(key),
+		Value:  append([]byte(nil), value...),
+		Time:   time.Now(),
+	})
+	return nil
+}
+
+// Subscriber holds an unbounded, ordered queue of messages for one reader.
+type Subscriber struct {
+	topic  string
+	mu     sync.Mutex
+	queue  []kafka.Message
+	busy   bool
+	notify chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *Subscriber) push(msg kafka.Message) {
+	s.mu.Lock()
+	s.queue = append(s.queue, msg)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read blocks until a message is available, ctx is done or the subscriber
+// is closed.
+func (s *Subscriber) Read(ctx context.Context) (kafka.Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			msg := s.queue[0]
+			s.queue = s.queue[1:]
+			s.busy = true
+			s.mu.Unlock()
+			return msg, nil
+		}
+		s.busy = false
+		s.mu.Unlock()
+		select {
+		case <-s.notify:
+		case <-s.closed:
+			return kafka.Message{}, context.Canceled
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// Run passes messages to h until ctx is done or the subscriber is closed.
+// Handler errors are logged, as in consumer.Consumer.Run.
+func (s *Subscriber) Run(ctx context.Context, h consumer.Handler) {
+	for {
+		msg, err := s.Read(ctx)
+		if err != nil {
+			return
+		}
+		if err := h(ctx, msg); err != nil {
+			slog.Error("membus: handle", "topic", s.topic, "offset", msg.Offset, "err", err)
+		}
+	}
+}
+
+// Idle reports whether the queue is empty and the last message read has been
+// handled, i.e. the subscriber is blocked waiting for the next one.
+func (s *Subscriber) Idle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue) == 0 && !s.busy
+}
+
+func (s *Subscriber) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// WaitIdle polls until every subscriber is idle twice in a row, so callers
+// (mostly tests) can observe the effects of events that cascade across topics.
+func (b *Bus) WaitIdle(ctx context.Context) error {
+	t := time.NewTicker(5 * time.Millisecond)
+	defer t.Stop()
+	streak := 0
+	for streak < 2 {
+		if b.idle() {
+			streak++
+		} else {
+			streak = 0
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+func (b *Bus) idle() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			if !s.Idle() {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package membus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_FanOutInOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bus := New()
+	pub := bus.Publisher("catalog.events")
+
+	var mu sync.Mutex
+	got := map[int][]string{}
+	for i := 0; i < 2; i++ {
+		sub := bus.Subscribe("catalog.events")
+		defer sub.Close()
+		go sub.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[i] = append(got[i], string(msg.Value))
+			return nil
+		})
+	}
+	other := bus.Subscribe("orders.events")
+	defer other.Close()
+
+	for _, v := range []string{"a", "b", "c"} {
+		require.NoError(t, pub.Send(ctx, "k", []byte(v)))
+	}
+	require.NoError(t, bus.WaitIdle(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"a", "b", "c"}, got[0])
+	require.Equal(t, []string{"a", "b", "c"}, got[1])
+	require.True(t, other.Idle())
+}
+
+func TestBus_CascadingPublish(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bus := New()
+	orders := bus.Subscribe("orders.events")
+	defer orders.Close()
+	prices := bus.Subscribe("pricing.events")
+	defer prices.Close()
+
+	pricingPub := bus.Publisher("pricing.events")
+	go orders.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+		return pricingPub.Send(ctx, string(msg.Key), msg.Value)
+	})
+	var seen []string
+	var mu sync.Mutex
+	go prices.Run(ctx, func(ctx context.Context, msg kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, string(msg.Value))
+		return nil
+	})
+
+	require.NoError(t, bus.Publisher("orders.events").Send(ctx, "p1", []byte("x")))
+	require.NoError(t, bus.WaitIdle(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"x"}, seen)
+}
@@ -0,0 +1,57 @@
+// Package metrics defines the Prometheus metrics shared by the services.
+// Every service exposes them on GET /metrics of its HTTP server.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ConsumerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_consumer_messages_total",
+		Help: "Messages passed to the consumer handler.",
+	}, []string{"topic", "group"})
+
+	ConsumerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_consumer_errors_total",
+		Help: "Consumer errors by stage: read, decode or handle.",
+	}, []string{"topic", "group", "stage"})
+
+	ConsumerHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_consumer_handler_duration_seconds",
+		Help:    "Time spent in the consumer handler per message.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic", "group"})
+
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Messages per partition past the consumer group's committed offset.",
+	}, []string{"topic", "group", "partition"})
+
+	ConsumerRebalances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_consumer_rebalances_total",
+		Help: "Consumer group rebalances as reported by kafka.Reader.Stats().",
+	}, []string{"topic", "group"})
+
+	ProducerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_messages_total",
+		Help: "Messages written successfully.",
+	}, []string{"topic"})
+
+	ProducerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_errors_total",
+		Help: "Failed message writes, including encoding failures.",
+	}, []string{"topic"})
+
+	ProducerWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_producer_write_duration_seconds",
+		Help:    "Latency of synchronous Kafka writes.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	OrderStockShortfalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "catalog_order_stock_shortfalls_total",
+		Help: "Placed order lines the catalog could not take from stock, by reason: insufficient_stock, archived or not_found.",
+	}, []string{"reason"})
+)
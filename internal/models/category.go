@@ -0,0 +1,17 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Category is a node of the catalog's category tree. Root categories have
+// no parent.
+type Category struct {
+    ID        uuid.UUID  `json:"id"`
+    ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+    Name      string     `json:"name"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+}
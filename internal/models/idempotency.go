@@ -0,0 +1,26 @@
+package models
+
+import (
+    "net/http"
+    "time"
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it
+// has been served, the response to replay for repeats of it.
+type IdempotencyRecord struct {
+    Key string
+    // Fingerprint identifies the request (method, path and body); a repeat
+    // with a different fingerprint is a misuse of the key.
+    Fingerprint string
+    // Status is 0 while the first request is still being served.
+    Status int
+    // Header holds the response headers replayed with Body.
+    Header    http.Header
+    Body      []byte
+    CreatedAt time.Time
+    ExpiresAt time.Time
+}
+
+// Pending reports whether the first request with the key is still being
+// served.
+func (r IdempotencyRecord) Pending() bool { return r.Status == 0 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// MovementReason says why a product's stock changed.
+type MovementReason string
+
+const (
+    MovementRestock    MovementReason = "restock"
+    MovementSale       MovementReason = "sale"
+    MovementReturn     MovementReason = "return"
+    MovementAdjustment MovementReason = "adjustment"
+)
+
+// InventoryMovement is one entry of a product's stock ledger. IDs are
+// assigned in the order movements are recorded.
+type InventoryMovement struct {
+    ID        int64          `json:"id"`
+    ProductID uuid.UUID      `json:"product_id"`
+    Delta     int            `json:"delta"`
+    Reason    MovementReason `json:"reason"`
+    Note      string         `json:"note,omitempty"`
+    // OrderID links sales and returns to the order that caused them.
+    OrderID *uuid.UUID `json:"order_id,omitempty"`
+    // StockAfter is the product's stock once the movement was applied.
+    StockAfter int       `json:"stock_after"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// MovementQuery selects a page of a product's movements, newest first.
+// BeforeID, when set, is the ID of the last movement of the previous page.
+type MovementQuery struct {
+    ProductID uuid.UUID
+    BeforeID  int64
+    Limit     int
+}
@@ -7,18 +7,158 @@ import (
 )
 
 type User struct {
-    ID        uuid.UUID `json:"id"`
-    Email     string    `json:"email"`
-    CreatedAt time.Time `json:"created_at"`
+    ID    uuid.UUID `json:"id"`
+    Email string    `json:"email"`
+    // DeactivatedAt is set while the user is deactivated and cannot place
+    // orders.
+    DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+    // Segment is the customer segment the user is priced in, one of the
+    // Segment* constants.
+    Segment string `json:"segment"`
+    // OrderCount is computed from the user's orders, not counting canceled
+    // and refunded ones.
+    OrderCount int       `json:"order_count"`
+    CreatedAt  time.Time `json:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// Active reports whether the user may place orders.
+func (u User) Active() bool { return u.DeactivatedAt == nil }
+
+// UserQuery selects a page of users, oldest first by CreatedAt, then by ID.
+// After, when set, is the last user of the previous page.
+type UserQuery struct {
+    // Email, when set, keeps the user with that (normalized) email.
+    Email string
+    After *User
+    Limit int
+}
+
+// Order statuses. OrderPlaced, OrderPaid, OrderFulfilled and OrderDelivered
+// follow each other; OrderCanceled and OrderRefunded are where an order
+// ends when it does not go through.
+const (
+    OrderPlaced    = "placed"
+    OrderPaid      = "paid"
+    OrderFulfilled = "fulfilled"
+    OrderDelivered = "delivered"
+    OrderCanceled  = "canceled"
+    OrderRefunded  = "refunded"
+)
+
 type Order struct {
-    ID        uuid.UUID `json:"id"`
-    UserID    uuid.UUID `json:"user_id"`
+    ID     uuid.UUID `json:"id"`
+    UserID uuid.UUID `json:"user_id"`
+    // ProductID, Qty and UnitPrice are those of the first line, for clients
+    // of single-product orders.
     ProductID uuid.UUID `json:"product_id"`
     Qty       int       `json:"qty"`
     Status    string    `json:"status"`
-    CreatedAt time.Time `json:"created_at"`
-    UpdatedAt time.Time `json:"updated_at"`
+    // ReservationID is the catalog stock reservation the order redeems.
+    ReservationID *uuid.UUID `json:"reservation_id,omitempty"`
+    // UnitPrice and Currency are the price quoted when the order was
+    // placed; Total is the sum of the line totals.
+    UnitPrice float64     `json:"unit_price"`
+    Currency  string      `json:"currency"`
+    Total     float64     `json:"total"`
+    Lines     []OrderLine `json:"lines"`
+    CreatedAt time.Time   `json:"created_at"`
+    UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// OrderQuery selects a page of orders, newest first by CreatedAt, then by
+// ID. Unset filters match every order. After, when set, is the last order of
+// the previous page; only orders past it are returned.
+type OrderQuery struct {
+    UserID *uuid.UUID
+    // ProductID keeps orders with the product on any line.
+    ProductID *uuid.UUID
+    Status    string
+    // From and To bound CreatedAt, From inclusive and To exclusive.
+    From  *time.Time
+    To    *time.Time
+    After *Order
+    Limit int
+}
+
+// CatalogProduct is what the order service knows about a catalog product.
+type CatalogProduct struct {
+    ID uuid.UUID
+    // Available is the catalog stock not held by reservations.
+    Available  int
+    Backorders bool
+    // Version is the catalog version this copy was taken at.
+    Version    int64
+    ArchivedAt *time.Time
+    UpdatedAt  time.Time
+}
+
+// NewCatalogProduct is the order service's copy of p.
+func NewCatalogProduct(p Product) CatalogProduct {
+    return CatalogProduct{
+        ID:         p.ID,
+        Available:  p.Available(),
+        Backorders: p.Backorders,
+        Version:    p.Version,
+        ArchivedAt: p.ArchivedAt,
+        UpdatedAt:  p.UpdatedAt,
+    }
+}
+
+// QuotedPrice is a product's price as the order service last saw it.
+type QuotedPrice struct {
+    ProductID uuid.UUID
+    UnitPrice float64
+    Currency  string
+    // SegmentPrices are the prices of the customer segments pricing has a
+    // multiplier for.
+    SegmentPrices map[string]float64
+    // PricedAt is when pricing set the price.
+    PricedAt time.Time
+}
+
+// ForSegment is the unit price a user in segment pays.
+func (p QuotedPrice) ForSegment(segment string) float64 {
+    if sp, ok := p.SegmentPrices[segment]; ok {
+        return sp
+    }
+    return p.UnitPrice
+}
+
+// OrderStatusChange is one move of an order from one status to the next.
+// From is empty for the order's placement.
+type OrderStatusChange struct {
+    OrderID   uuid.UUID `json:"order_id"`
+    From      string    `json:"from,omitempty"`
+    To        string    `json:"to"`
+    ChangedAt time.Time `json:"changed_at"`
+}
+
+// OrderLine is qty of one product in an order or cart, at UnitPrice each.
+type OrderLine struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Qty       int       `json:"qty"`
+    UnitPrice float64   `json:"unit_price"`
+    Total     float64   `json:"total"`
 }
 
+// Cart statuses: a cart is open until it is checked out into an order.
+const (
+    CartOpen       = "open"
+    CartCheckedOut = "checked_out"
+)
+
+// Cart collects lines a user is going to order. Prices are the current ones
+// when the cart is read, not locked in until checkout.
+type Cart struct {
+    ID     uuid.UUID `json:"id"`
+    UserID uuid.UUID `json:"user_id"`
+    Status string    `json:"status"`
+    // OrderID is the order the cart was checked out into.
+    OrderID   *uuid.UUID  `json:"order_id,omitempty"`
+    Lines     []OrderLine `json:"lines"`
+    Currency  string      `json:"currency,omitempty"`
+    Total     float64     `json:"total"`
+    CreatedAt time.Time   `json:"created_at"`
+    UpdatedAt time.Time   `json:"updated_at"`
+}
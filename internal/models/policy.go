@@ -0,0 +1,41 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// PricingPolicy tunes how the pricing engine prices a category or a single
+// product. Nil fields are inherited from the parent category, and at the
+// root from the engine defaults.
+type PricingPolicy struct {
+    // Strategy is "dynamic" (demand and stock markups) or "fixed" (base price).
+    Strategy *string `json:"strategy,omitempty"`
+    // MinMultiplier and MaxMultiplier bound the price relative to the base price.
+    MinMultiplier *float64 `json:"min_multiplier,omitempty"`
+    MaxMultiplier *float64 `json:"max_multiplier,omitempty"`
+    // DemandStep is the markup per unit ordered in the demand window, capped at DemandCap.
+    DemandStep *float64 `json:"demand_step,omitempty"`
+    DemandCap  *float64 `json:"demand_cap,omitempty"`
+    // LowStockMarkup applies at or below LowStockThreshold units, OutOfStockMarkup on top of it at zero.
+    LowStockThreshold *int     `json:"low_stock_threshold,omitempty"`
+    LowStockMarkup    *float64 `json:"low_stock_markup,omitempty"`
+    OutOfStockMarkup  *float64 `json:"out_of_stock_markup,omitempty"`
+}
+
+// PolicyScope says what a ScopedPolicy is attached to.
+type PolicyScope string
+
+const (
+    PolicyScopeCategory PolicyScope = "category"
+    PolicyScopeProduct  PolicyScope = "product"
+)
+
+// ScopedPolicy is a pricing policy attached to a category or product ID.
+type ScopedPolicy struct {
+    Scope     PolicyScope   `json:"scope"`
+    ID        uuid.UUID     `json:"id"`
+    Policy    PricingPolicy `json:"policy"`
+    UpdatedAt time.Time     `json:"updated_at"`
+}
@@ -9,6 +9,14 @@ import (
 type Price struct {
     ProductID    uuid.UUID `json:"product_id"`
     CurrentPrice float64   `json:"current_price"`
+    // Currency is the ISO 4217 code of CurrentPrice.
+    Currency  string    `json:"currency,omitempty"`
+    // Segment is the customer segment CurrentPrice is for; empty for the
+    // price everyone else pays.
+    Segment   string    `json:"segment,omitempty"`
+    // SegmentPrices is CurrentPrice for each segment with a multiplier, as
+    // announced on pricing.events.
+    SegmentPrices map[string]float64 `json:"segment_prices,omitempty"`
     UpdatedAt    time.Time `json:"updated_at"`
 }
 
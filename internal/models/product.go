@@ -11,13 +11,62 @@ type Product struct {
     Name      string    `json:"name"`
     BasePrice float64   `json:"base_price"`
     Stock     int       `json:"stock"`
-    UpdatedAt time.Time `json:"updated_at"`
+    // CategoryID is the category the product is assigned to, if any.
+    CategoryID *uuid.UUID `json:"category_id,omitempty"`
+    UpdatedAt  time.Time  `json:"updated_at"`
+    // ArchivedAt is set once the product is withdrawn from sale.
+    ArchivedAt *time.Time `json:"archived_at,omitempty"`
+    // Version starts at 1 and grows with every change to the product.
+    Version int64 `json:"version"`
+    // Backorders lets stock adjustments take the stock below zero.
+    Backorders bool `json:"backorders"`
+    // Reserved is the part of Stock held by active reservations.
+    Reserved int `json:"reserved"`
 }
 
+// Available is the stock that can still be reserved or sold.
+func (p Product) Available() int { return p.Stock - p.Reserved }
+
 type ProductSnapshot struct {
     ID        uuid.UUID
     BasePrice float64
     Stock     int
-    UpdatedAt time.Time
+    // CategoryPath lists the product's category and its ancestors, root first.
+    CategoryPath []uuid.UUID
+    UpdatedAt    time.Time
+    // Version is the catalog version the snapshot was taken at.
+    Version int64
+    // Reserved is the part of Stock held by checkout reservations.
+    Reserved int
 }
 
+// Available is the stock not held by reservations.
+func (s ProductSnapshot) Available() int { return s.Stock - s.Reserved }
+
+
+// ProductSort is a column products can be listed by.
+type ProductSort string
+
+const (
+    SortByName      ProductSort = "name"
+    SortByPrice     ProductSort = "price"
+    SortByUpdatedAt ProductSort = "updated_at"
+)
+
+// ProductQuery selects a page of products ordered by Sort, then by ID.
+// After, when set, is the last product of the previous page; only products
+// strictly past it in that order are returned.
+type ProductQuery struct {
+    Search   string
+    MinPrice *float64
+    MaxPrice *float64
+    InStock  bool
+    // CategoryIDs, when not empty, keeps products assigned to one of them.
+    CategoryIDs []uuid.UUID
+    // IncludeArchived lists archived products too; they are hidden by default.
+    IncludeArchived bool
+    Sort     ProductSort
+    Desc     bool
+    After    *Product
+    Limit    int
+}
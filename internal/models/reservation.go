@@ -0,0 +1,31 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// ReservationStatus is where a stock reservation is in its life. Only active
+// reservations hold stock.
+type ReservationStatus string
+
+const (
+    ReservationActive    ReservationStatus = "active"
+    ReservationConfirmed ReservationStatus = "confirmed"
+    ReservationReleased  ReservationStatus = "released"
+    ReservationExpired   ReservationStatus = "expired"
+)
+
+// Reservation holds Qty of a product's stock for a checkout until ExpiresAt.
+type Reservation struct {
+    ID        uuid.UUID         `json:"id"`
+    ProductID uuid.UUID         `json:"product_id"`
+    Qty       int               `json:"qty"`
+    Status    ReservationStatus `json:"status"`
+    // OrderID is the order that confirmed the reservation.
+    OrderID   *uuid.UUID `json:"order_id,omitempty"`
+    ExpiresAt time.Time  `json:"expires_at"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+}
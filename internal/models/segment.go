@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Customer segments. The order service puts every user in one from their
+// signup date and orders; pricing can price each segment differently.
+const (
+    SegmentNew     = "new"
+    SegmentRegular = "regular"
+    SegmentLoyal   = "loyal"
+)
+
+// ValidSegment reports whether s is a known customer segment.
+func ValidSegment(s string) bool {
+    switch s {
+    case SegmentNew, SegmentRegular, SegmentLoyal:
+        return true
+    }
+    return false
+}
+
+// SegmentMultiplier scales the prices shown to a customer segment.
+type SegmentMultiplier struct {
+    Segment    string    `json:"segment"`
+    Multiplier float64   `json:"multiplier"`
+    UpdatedAt  time.Time `json:"updated_at"`
+}
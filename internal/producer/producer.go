@@ -4,24 +4,87 @@ import (
 	"context"
 	"time"
 
+	"dynamic-pricing/internal/cloudevents"
+	"dynamic-pricing/internal/events"
+	"dynamic-pricing/internal/metrics"
+
 	"github.com/segmentio/kafka-go"
 )
 
-type Producer struct{ w *kafka.Writer }
+type Producer struct {
+	w        *kafka.Writer
+	topic    string
+	codec    events.Codec
+	ceSource string
+}
+
+type Option func(*Producer)
+
+// WithCloudEvents makes Send publish events in CloudEvents binary mode,
+// using source as the ce_source attribute.
+func WithCloudEvents(source string) Option {
+	return func(p *Producer) { p.ceSource = source }
+}
 
-func New(brokers []string, topic string) *Producer {
-	return &Producer{w: &kafka.Writer{
-		Addr:         kafka.TCP(brokers...),
-		Topic:        topic,
-		Balancer:     &kafka.LeastBytes{},
-		RequiredAcks: kafka.RequireOne,
-		Async:        false,
-	}}
+// WithCodec sets the codec used to encode envelopes. Defaults to JSON.
+func WithCodec(c events.Codec) Option {
+	return func(p *Producer) { p.codec = c }
+}
+
+func New(brokers []string, topic string, opts ...Option) *Producer {
+	p := &Producer{
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireOne,
+			Async:        false,
+		},
+		topic: topic,
+		codec: events.JSONCodec{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *Producer) Close() error { return p.w.Close() }
 
 func (p *Producer) Send(ctx context.Context, key string, value []byte) error {
+	msg, err := p.message(key, value)
+	if err != nil {
+		metrics.ProducerErrors.WithLabelValues(p.topic).Inc()
+		return err
+	}
+	start := time.Now()
+	err = p.w.WriteMessages(ctx, msg)
+	metrics.ProducerWriteDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ProducerErrors.WithLabelValues(p.topic).Inc()
+		return err
+	}
+	metrics.ProducerMessages.WithLabelValues(p.topic).Inc()
+	return nil
+}
+
+// message encodes value into a Kafka message in the configured format.
+func (p *Producer) message(key string, value []byte) (kafka.Message, error) {
 	msg := kafka.Message{Key: []byte(key), Value: value, Time: time.Now()} //TODO: убрать key ->
-	return p.w.WriteMessages(ctx, msg)
+	if p.ceSource != "" {
+		headers, data, err := cloudevents.ToBinary(p.ceSource, value)
+		if err != nil {
+			return msg, err
+		}
+		msg.Headers = headers
+		msg.Value = data
+	} else {
+		data, err := p.codec.Encode(value)
+		if err != nil {
+			return msg, err
+		}
+		msg.Headers = []kafka.Header{{Key: events.HeaderContentType, Value: []byte(p.codec.ContentType())}}
+		msg.Value = data
+	}
+	return msg, nil
 }
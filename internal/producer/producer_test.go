@@ -0,0 +1,26 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend_RecordsFailedWrites(t *testing.T) {
+	const topic = "metrics-test.events"
+	// Nothing listens on port 1, so the write fails.
+	p := New([]string{"127.0.0.1:1"}, topic)
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	require.Error(t, p.Send(ctx, "k", []byte(`{"type":"x"}`)))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ProducerErrors.WithLabelValues(topic)))
+	require.Zero(t, testutil.ToFloat64(metrics.ProducerMessages.WithLabelValues(topic)))
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.ProducerWriteDuration, "kafka_producer_write_duration_seconds"))
+}
@@ -0,0 +1,54 @@
+package quote
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	q := Quote{
+		ProductID: uuid.New(),
+		Qty:       2,
+		UnitPrice: 10.5,
+		Currency:  "USD",
+		ExpiresAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	token, err := s.Sign(q)
+	require.NoError(t, err)
+
+	got, err := s.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, q, got)
+
+	require.False(t, got.Expired(q.ExpiresAt.Add(-time.Second)))
+	require.True(t, got.Expired(q.ExpiresAt))
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	token, err := s.Sign(Quote{ProductID: uuid.New(), Qty: 1, UnitPrice: 10})
+	require.NoError(t, err)
+	payload, sig, _ := strings.Cut(token, ".")
+
+	other, err := NewSigner([]byte("other")).Sign(Quote{ProductID: uuid.New(), Qty: 1, UnitPrice: 1})
+	require.NoError(t, err)
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	for name, tok := range map[string]string{
+		"empty":        "",
+		"no_signature": payload,
+		"bad_base64":   payload + ".!!",
+		"other_key":    other,
+		"swapped":      otherPayload + "." + sig,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := s.Verify(tok)
+			require.ErrorIs(t, err, ErrInvalid)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"dynamic-pricing/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCategory is returned for an empty category name or a parent that
+// would put a category inside its own subtree.
+var ErrInvalidCategory = errors.New("invalid category")
+
+// maxCategoryDepth bounds walks up the tree in case stored data has a cycle.
+const maxCategoryDepth = 64
+
+func (s *Service) CreateCategory(ctx context.Context, name string, parentID *uuid.UUID) (models.Category, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.Category{}, fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+	return s.repo.CreateCategory(ctx, models.Category{ID: uuid.New(), ParentID: parentID, Name: name})
+}
+
+func (s *Service) GetCategory(ctx context.Context, id uuid.UUID) (models.Category, error) {
+	return s.repo.GetCategory(ctx, id)
+}
+
+func (s *Service) ListCategories(ctx context.Context) ([]models.Category, error) {
+	return s.repo.ListCategories(ctx)
+}
+
+// UpdateCategory renames or moves a category. Moving it changes the category
+// path of every product below it, so those products are republished as
+// product_updated for pricing to pick up the new policies.
+func (s *Service) UpdateCategory(ctx context.Context, id uuid.UUID, name string, parentID *uuid.UUID) (models.Category, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.Category{}, fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+	prev, err := s.repo.GetCategory(ctx, id)
+	if err != nil {
+		return prev, err
+	}
+	moved := !sameParent(prev.ParentID, parentID)
+	if moved && parentID != nil {
+		path, err := s.categoryPath(ctx, parentID)
+		if err != nil {
+			return prev, err
+		}
+		for _, anc := range path {
+			if anc == id {
+				return prev, fmt.Errorf("%w: %s cannot be moved into its own subtree", ErrInvalidCategory, id)
+			}
+		}
+	}
+	c, err := s.repo.UpdateCategory(ctx, id, name, parentID)
+	if err != nil || !moved {
+		return c, err
+	}
+	return c, s.republishSubtree(ctx, id)
+}
+
+func (s *Service) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+	return s.repo.DeleteCategory(ctx, id)
+}
+
+// AssignCategory moves a product to categoryID, or out of any category when
+// categoryID is nil.
+func (s *Service) AssignCategory(ctx context.Context, id uuid.UUID, categoryID *uuid.UUID) (models.Product, error) {
+	p, err := s.repo.SetCategory(ctx, id, categoryID)
+	if err != nil {
+		return p, s.archivedErr(ctx, id, err)
+	}
+	return p, s.publish(ctx, "product_updated", p)
+}
+
+// ListByCategory lists products of the category and all its subcategories.
+func (s *Service) ListByCategory(ctx context.Context, categoryID uuid.UUID, q models.ProductQuery, cursor string) (Page, error) {
+	if _, err := s.repo.GetCategory(ctx, categoryID); err != nil {
+		return Page{}, err
+	}
+	ids, err := s.subtree(ctx, categoryID)
+	if err != nil {
+		return Page{}, err
+	}
+	q.CategoryIDs = ids
+	return s.List(ctx, q, cursor)
+}
+
+// categoryPath returns id and its ancestors, root first.
+func (s *Service) categoryPath(ctx context.Context, id *uuid.UUID) ([]uuid.UUID, error) {
+	var path []uuid.UUID
+	for next := id; next != nil; {
+		if len(path) == maxCategoryDepth {
+			return nil, fmt.Errorf("category %s: tree deeper than %d", *id, maxCategoryDepth)
+		}
+		c, err := s.repo.GetCategory(ctx, *next)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, c.ID)
+		next = c.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// subtree returns root and every category below it.
+func (s *Service) subtree(ctx context.Context, root uuid.UUID) ([]uuid.UUID, error) {
+	all, err := s.repo.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, c := range all {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+	out := []uuid.UUID{root}
+	seen := map[uuid.UUID]bool{root: true}
+	for i := 0; i < len(out); i++ {
+		for _, c := range children[out[i]] {
+			if !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *Service) republishSubtree(ctx context.Context, root uuid.UUID) error {
+	ids, err := s.subtree(ctx, root)
+	if err != nil {
+		return err
+	}
+	q := models.ProductQuery{CategoryIDs: ids, Sort: models.SortByName, Limit: MaxPageSize}
+	for {
+		ps, err := s.repo.List(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if err := s.publish(ctx, "product_updated", p); err != nil {
+				return err
+			}
+		}
+		if len(ps) < q.Limit {
+			return nil
+		}
+		q.After = &ps[len(ps)-1]
+	}
+}
+
+func sameParent(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
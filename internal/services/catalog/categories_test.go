@@ -0,0 +1,84 @@
+package catalog_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingBus struct{ events []catalog.Event }
+
+func (b *recordingBus) Send(_ context.Context, _ string, value []byte) error {
+	var e struct {
+		Type    string                 `json:"type"`
+		Payload catalog.ProductPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(value, &e); err != nil {
+		return err
+	}
+	b.events = append(b.events, catalog.Event{Type: e.Type, Payload: e.Payload})
+	return nil
+}
+
+func TestCategories_PathSubtreeAndMoves(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+
+	food, err := svc.CreateCategory(ctx, "Food", nil)
+	require.NoError(t, err)
+	fruit, err := svc.CreateCategory(ctx, "Fruit", &food.ID)
+	require.NoError(t, err)
+	other, err := svc.CreateCategory(ctx, "Other", nil)
+	require.NoError(t, err)
+
+	apple, err := svc.Create(ctx, "Apple", 1, 1, &fruit.ID)
+	require.NoError(t, err)
+	_, err = svc.Create(ctx, "Bread", 1, 1, &food.ID)
+	require.NoError(t, err)
+	_, err = svc.Create(ctx, "Nail", 1, 1, &other.ID)
+	require.NoError(t, err)
+
+	created := bus.events[0].Payload.(catalog.ProductPayload)
+	require.Equal(t, apple.ID.String(), created.ID)
+	require.Equal(t, []string{food.ID.String(), fruit.ID.String()}, created.CategoryPath)
+
+	page, err := svc.ListByCategory(ctx, food.ID, models.ProductQuery{}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	_, err = svc.ListByCategory(ctx, uuid.New(), models.ProductQuery{}, "")
+	require.Error(t, err)
+
+	// A category cannot move into its own subtree.
+	_, err = svc.UpdateCategory(ctx, food.ID, "Food", &fruit.ID)
+	require.ErrorIs(t, err, catalog.ErrInvalidCategory)
+	_, err = svc.UpdateCategory(ctx, food.ID, "Food", &food.ID)
+	require.ErrorIs(t, err, catalog.ErrInvalidCategory)
+	_, err = svc.CreateCategory(ctx, " ", nil)
+	require.ErrorIs(t, err, catalog.ErrInvalidCategory)
+
+	// Renaming publishes nothing; moving republishes the whole subtree.
+	bus.events = nil
+	_, err = svc.UpdateCategory(ctx, fruit.ID, "Fresh fruit", &food.ID)
+	require.NoError(t, err)
+	require.Empty(t, bus.events)
+	_, err = svc.UpdateCategory(ctx, food.ID, "Food", &other.ID)
+	require.NoError(t, err)
+	require.Len(t, bus.events, 2)
+	for _, e := range bus.events {
+		require.Equal(t, "product_updated", e.Type)
+		require.Equal(t, other.ID.String(), e.Payload.(catalog.ProductPayload).CategoryPath[0])
+	}
+
+	p, err := svc.AssignCategory(ctx, apple.ID, nil)
+	require.NoError(t, err)
+	require.Nil(t, p.CategoryID)
+	require.Empty(t, bus.events[len(bus.events)-1].Payload.(catalog.ProductPayload).CategoryPath)
+}
@@ -4,6 +4,8 @@ import (
     "dynamic-pricing/internal/models"
     "encoding/json"
     "time"
+
+    "github.com/google/uuid"
 )
 
 type Event struct {
@@ -13,22 +15,41 @@ type Event struct {
 }
 
 type ProductPayload struct {
-    ID        string  `json:"id"`
-    Name      string  `json:"name"`
-    BasePrice float64 `json:"base_price"`
-    Stock     int     `json:"stock"`
+    ID           string     `json:"id"`
+    Name         string     `json:"name"`
+    BasePrice    float64    `json:"base_price"`
+    Stock        int        `json:"stock"`
+    ArchivedAt   *time.Time `json:"archived_at,omitempty"`
+    CategoryID   string     `json:"category_id,omitempty"`
+    CategoryPath []string   `json:"category_path,omitempty"` // root first, ends with CategoryID
+    Version      int64      `json:"version"`
+    Backorders   bool       `json:"backorders,omitempty"`
+    Reserved     int        `json:"reserved"`
+    Available    int        `json:"available"` // stock not held by reservations
 }
 
-func NewProductEvent(eventType string, p models.Product) ([]byte, error) {
+func NewProductEvent(eventType string, p models.Product, categoryPath []uuid.UUID) ([]byte, error) {
+    payload := ProductPayload{
+        ID:         p.ID.String(),
+        Name:       p.Name,
+        BasePrice:  p.BasePrice,
+        Stock:      p.Stock,
+        ArchivedAt: p.ArchivedAt,
+        Version:    p.Version,
+        Backorders: p.Backorders,
+        Reserved:   p.Reserved,
+        Available:  p.Available(),
+    }
+    if p.CategoryID != nil {
+        payload.CategoryID = p.CategoryID.String()
+    }
+    for _, id := range categoryPath {
+        payload.CategoryPath = append(payload.CategoryPath, id.String())
+    }
     e := Event{
-        Type: eventType,
-        TS:   time.Now().UTC(),
-        Payload: ProductPayload{
-            ID:        p.ID.String(),
-            Name:      p.Name,
-            BasePrice: p.BasePrice,
-            Stock:     p.Stock,
-        },
+        Type:    eventType,
+        TS:      time.Now().UTC(),
+        Payload: payload,
     }
     return json.Marshal(e)
 }
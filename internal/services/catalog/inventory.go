@@ -0,0 +1,131 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInsufficientStock is returned when an adjustment or reservation
+	// would take the available stock of a product without backorders below
+	// zero.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrInvalidMovement is returned for an unknown reason, a zero delta or
+	// a delta whose sign contradicts the reason.
+	ErrInvalidMovement = errors.New("invalid stock movement")
+)
+
+// MovementPage is one page of a product's stock ledger, newest first.
+type MovementPage struct {
+	Items      []models.InventoryMovement `json:"items"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+}
+
+// AdjustStock changes a product's stock by delta atomically, whatever it is
+// at the moment, and records why in its ledger. Restocks and returns add,
+// sales take away, adjustments go either way.
+func (s *Service) AdjustStock(ctx context.Context, id uuid.UUID, delta int, reason models.MovementReason, note string) (models.Product, models.InventoryMovement, error) {
+	return s.adjust(ctx, models.InventoryMovement{ProductID: id, Delta: delta, Reason: reason, Note: note})
+}
+
+func (s *Service) adjust(ctx context.Context, m models.InventoryMovement) (models.Product, models.InventoryMovement, error) {
+	if err := validateMovement(m); err != nil {
+		return models.Product{}, m, err
+	}
+	p, m, err := s.repo.AdjustStock(ctx, m)
+	if err != nil {
+		return p, m, s.adjustErr(ctx, m.ProductID, err)
+	}
+	return p, m, s.publish(ctx, "product_stock_updated", p)
+}
+
+func validateMovement(m models.InventoryMovement) error {
+	if m.Delta == 0 {
+		return fmt.Errorf("%w: delta must not be zero", ErrInvalidMovement)
+	}
+	switch m.Reason {
+	case models.MovementRestock, models.MovementReturn:
+		if m.Delta < 0 {
+			return fmt.Errorf("%w: %s must add stock", ErrInvalidMovement, m.Reason)
+		}
+	case models.MovementSale:
+		if m.Delta > 0 {
+			return fmt.Errorf("%w: sale must take stock away", ErrInvalidMovement)
+		}
+	case models.MovementAdjustment:
+	default:
+		return fmt.Errorf("%w: unknown reason %q", ErrInvalidMovement, m.Reason)
+	}
+	return nil
+}
+
+// adjustErr tells apart the reasons the repository refused an adjustment.
+func (s *Service) adjustErr(ctx context.Context, id uuid.UUID, err error) error {
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	p, gerr := s.repo.Get(ctx, id)
+	switch {
+	case gerr != nil:
+		return err
+	case p.ArchivedAt != nil:
+		return ErrArchived
+	default:
+		return fmt.Errorf("%w: product %s has %d available", ErrInsufficientStock, id, p.Available())
+	}
+}
+
+// SetBackorders allows or forbids adjustments that take the stock below
+// zero.
+func (s *Service) SetBackorders(ctx context.Context, id uuid.UUID, enabled bool) (models.Product, error) {
+	p, err := s.repo.SetBackorders(ctx, id, enabled)
+	if err != nil {
+		return p, s.archivedErr(ctx, id, err)
+	}
+	return p, s.publish(ctx, "product_updated", p)
+}
+
+// Movements returns the page of a product's movements that follows cursor,
+// or the newest page when cursor is empty.
+func (s *Service) Movements(ctx context.Context, id uuid.UUID, cursor string, limit int) (MovementPage, error) {
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return MovementPage{}, err
+	}
+	q := models.MovementQuery{ProductID: id, Limit: limit}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = DefaultPageSize
+	case q.Limit > MaxPageSize:
+		q.Limit = MaxPageSize
+	}
+	if cursor != "" {
+		before, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before < 1 {
+			return MovementPage{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+		}
+		q.BeforeID = before
+	}
+
+	limit = q.Limit
+	q.Limit++
+	items, err := s.repo.ListMovements(ctx, q)
+	if err != nil {
+		return MovementPage{}, err
+	}
+	page := MovementPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = strconv.FormatInt(page.Items[limit-1].ID, 10)
+	}
+	if page.Items == nil {
+		page.Items = []models.InventoryMovement{}
+	}
+	return page, nil
+}
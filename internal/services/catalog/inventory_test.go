@@ -0,0 +1,85 @@
+package catalog_test
+
+import (
+	"context"
+	"testing"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdjustStock_GuardsAndLedger(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+	p, err := svc.Create(ctx, "A", 1, 2, nil)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		delta  int
+		reason models.MovementReason
+	}{
+		{0, models.MovementAdjustment},
+		{-1, models.MovementRestock},
+		{-1, models.MovementReturn},
+		{1, models.MovementSale},
+		{1, "theft"},
+	} {
+		_, _, err := svc.AdjustStock(ctx, p.ID, tc.delta, tc.reason, "")
+		require.ErrorIs(t, err, catalog.ErrInvalidMovement, "%+v", tc)
+	}
+
+	_, _, err = svc.AdjustStock(ctx, p.ID, -3, models.MovementSale, "")
+	require.ErrorIs(t, err, catalog.ErrInsufficientStock)
+	_, _, err = svc.AdjustStock(ctx, uuid.New(), -1, models.MovementSale, "")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	bus.events = nil
+	got, m, err := svc.AdjustStock(ctx, p.ID, 5, models.MovementRestock, "supplier X")
+	require.NoError(t, err)
+	require.Equal(t, 7, got.Stock)
+	require.Equal(t, 7, m.StockAfter)
+	require.Equal(t, "supplier X", m.Note)
+	require.Len(t, bus.events, 1)
+	require.Equal(t, "product_stock_updated", bus.events[0].Type)
+	require.Equal(t, 7, bus.events[0].Payload.(catalog.ProductPayload).Stock)
+
+	_, err = svc.SetBackorders(ctx, p.ID, true)
+	require.NoError(t, err)
+	got, _, err = svc.AdjustStock(ctx, p.ID, -9, models.MovementSale, "")
+	require.NoError(t, err)
+	require.Equal(t, -2, got.Stock)
+	_, _, err = svc.AdjustStock(ctx, p.ID, 1, models.MovementReturn, "")
+	require.NoError(t, err)
+
+	var reasons []models.MovementReason
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		page, err := svc.Movements(ctx, p.ID, cursor, 2)
+		require.NoError(t, err)
+		for _, m := range page.Items {
+			reasons = append(reasons, m.Reason)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	require.Equal(t, []models.MovementReason{models.MovementReturn, models.MovementSale, models.MovementRestock}, reasons)
+
+	_, err = svc.Movements(ctx, p.ID, "nope", 0)
+	require.ErrorIs(t, err, catalog.ErrInvalidQuery)
+	_, err = svc.Movements(ctx, uuid.New(), "", 0)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = svc.Archive(ctx, p.ID)
+	require.NoError(t, err)
+	_, _, err = svc.AdjustStock(ctx, p.ID, 1, models.MovementRestock, "")
+	require.ErrorIs(t, err, catalog.ErrArchived)
+}
@@ -0,0 +1,124 @@
+package catalog
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"dynamic-pricing/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ErrInvalidQuery is returned by List for bad filters or a cursor that does
+// not belong to the requested ordering.
+var ErrInvalidQuery = errors.New("invalid product query")
+
+// Page is one page of a product listing. NextCursor is empty on the last page.
+type Page struct {
+	Items      []models.Product `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// List returns the page of products matching q that follows cursor, or the
+// first page when cursor is empty.
+func (s *Service) List(ctx context.Context, q models.ProductQuery, cursor string) (Page, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return Page{}, err
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = DefaultPageSize
+	case q.Limit > MaxPageSize:
+		q.Limit = MaxPageSize
+	}
+	if cursor != "" {
+		after, err := decodeCursor(cursor, q.Sort, q.Desc)
+		if err != nil {
+			return Page{}, err
+		}
+		q.After = &after
+	}
+
+	// One extra row tells whether another page follows.
+	limit := q.Limit
+	q.Limit++
+	items, err := s.repo.List(ctx, q)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(page.Items[limit-1], q.Sort, q.Desc)
+	}
+	if page.Items == nil {
+		page.Items = []models.Product{}
+	}
+	return page, nil
+}
+
+// normalizeQuery defaults the sort and checks the filters of q.
+func normalizeQuery(q models.ProductQuery) (models.ProductQuery, error) {
+	if q.Sort == "" {
+		q.Sort = models.SortByName
+	}
+	switch q.Sort {
+	case models.SortByName, models.SortByPrice, models.SortByUpdatedAt:
+	default:
+		return q, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.Sort)
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return q, fmt.Errorf("%w: min_price is greater than max_price", ErrInvalidQuery)
+	}
+	return q, nil
+}
+
+// cursor is the keyset position after the last product of a page. Sort and
+// Desc pin it to the ordering it was issued for.
+type cursor struct {
+	Sort      models.ProductSort `json:"s"`
+	Desc      bool               `json:"d,omitempty"`
+	ID        uuid.UUID          `json:"id"`
+	Name      string             `json:"n,omitempty"`
+	BasePrice float64            `json:"p,omitempty"`
+	UpdatedAt time.Time          `json:"u,omitempty"`
+}
+
+func encodeCursor(p models.Product, sort models.ProductSort, desc bool) string {
+	c := cursor{Sort: sort, Desc: desc, ID: p.ID}
+	switch sort {
+	case models.SortByName:
+		c.Name = p.Name
+	case models.SortByPrice:
+		c.BasePrice = p.BasePrice
+	case models.SortByUpdatedAt:
+		c.UpdatedAt = p.UpdatedAt
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, sort models.ProductSort, desc bool) (models.Product, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return models.Product{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return models.Product{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != sort || c.Desc != desc {
+		return models.Product{}, fmt.Errorf("%w: cursor was issued for another ordering", ErrInvalidQuery)
+	}
+	return models.Product{ID: c.ID, Name: c.Name, BasePrice: c.BasePrice, UpdatedAt: c.UpdatedAt}, nil
+}
@@ -0,0 +1,65 @@
+package catalog_test
+
+import (
+	"context"
+	"testing"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nopBus struct{}
+
+func (nopBus) Send(context.Context, string, []byte) error { return nil }
+
+func TestList_PagesWithCursor(t *testing.T) {
+	ctx := context.Background()
+	svc := catalog.NewService(memory.NewCatalogRepository(), nopBus{})
+	for _, name := range []string{"e", "a", "d", "b", "c"} {
+		_, err := svc.Create(ctx, name, 1, 1, nil)
+		require.NoError(t, err)
+	}
+
+	q := models.ProductQuery{Sort: models.SortByName, Desc: true, Limit: 2}
+	var names []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		page, err := svc.List(ctx, q, cursor)
+		require.NoError(t, err)
+		for _, p := range page.Items {
+			names = append(names, p.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	require.Equal(t, []string{"e", "d", "c", "b", "a"}, names)
+}
+
+func TestList_InvalidQuery(t *testing.T) {
+	ctx := context.Background()
+	svc := catalog.NewService(memory.NewCatalogRepository(), nopBus{})
+	_, err := svc.Create(ctx, "a", 1, 1, nil)
+	require.NoError(t, err)
+	_, err = svc.Create(ctx, "b", 1, 1, nil)
+	require.NoError(t, err)
+
+	page, err := svc.List(ctx, models.ProductQuery{Limit: 1}, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, page.NextCursor)
+
+	_, err = svc.List(ctx, models.ProductQuery{Sort: models.SortByPrice, Limit: 1}, page.NextCursor)
+	require.ErrorIs(t, err, catalog.ErrInvalidQuery)
+	_, err = svc.List(ctx, models.ProductQuery{}, "not-a-cursor")
+	require.ErrorIs(t, err, catalog.ErrInvalidQuery)
+	_, err = svc.List(ctx, models.ProductQuery{Sort: "stock"}, "")
+	require.ErrorIs(t, err, catalog.ErrInvalidQuery)
+	min, max := 5.0, 1.0
+	_, err = svc.List(ctx, models.ProductQuery{MinPrice: &min, MaxPrice: &max}, "")
+	require.ErrorIs(t, err, catalog.ErrInvalidQuery)
+}
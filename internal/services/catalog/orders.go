@@ -0,0 +1,147 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"dynamic-pricing/internal/metrics"
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// orderLine is a line of an order in orders.events.
+type orderLine struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Qty       int       `json:"qty"`
+}
+
+// HandleOrderEvent keeps stock in step with orders.events: order_placed
+// sells the quantity of each order line and order_canceled returns what that
+// order sold, as does order_refunded for an order refunded while paid. An
+// order refunded after delivery is not restocked: the goods are with the
+// customer until they come back, and are then adjusted in by hand. An order
+// placed with a reservation is served from the reserved stock; once the
+// reservation is gone it is sold like any other. Each happens at most once
+// per order and product, so redelivered events are harmless.
+//
+// A line the stock cannot serve (not enough of it, archived or deleted
+// product) leaves the stock alone: the order stays placed and is oversold.
+// Such lines are logged and counted in catalog_order_stock_shortfalls_total
+// so they can be alerted on and settled by hand; pricing only sees the stock
+// actually sold.
+func (s *Service) HandleOrderEvent(ctx context.Context, b []byte) error {
+	var ev struct {
+		Type    string `json:"type"`
+		Payload struct {
+			ID        uuid.UUID `json:"id"`
+			ProductID uuid.UUID `json:"product_id"`
+			Qty       int       `json:"qty"`
+			// Lines is empty in events from before orders had lines.
+			Lines []orderLine `json:"lines"`
+			// ReservationID is empty for orders placed without one.
+			ReservationID string `json:"reservation_id"`
+			// PreviousStatus is the status an order_refunded order was in.
+			PreviousStatus string `json:"previous_status"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return err
+	}
+	orderID := ev.Payload.ID
+	var ms []models.InventoryMovement
+	switch ev.Type {
+	case "order_placed":
+		lines := ev.Payload.Lines
+		if len(lines) == 0 {
+			lines = []orderLine{{ev.Payload.ProductID, ev.Payload.Qty}}
+		}
+		for _, l := range lines {
+			if l.Qty <= 0 {
+				return fmt.Errorf("order %s: bad qty %d", orderID, l.Qty)
+			}
+			ms = append(ms, models.InventoryMovement{ProductID: l.ProductID, Delta: -l.Qty, Reason: models.MovementSale})
+		}
+	case "order_canceled", "order_refunded":
+		if ev.Type == "order_refunded" && ev.Payload.PreviousStatus != models.OrderPaid {
+			slog.Info("catalog: order refunded after fulfillment, stock not returned", "order_id", orderID, "from", ev.Payload.PreviousStatus)
+			return nil
+		}
+		sales, err := s.repo.OrderMovements(ctx, orderID, models.MovementSale)
+		if err != nil {
+			return err
+		}
+		if len(sales) == 0 {
+			slog.Info("catalog: canceled order sold nothing", "order_id", orderID)
+			return nil
+		}
+		for _, sale := range sales {
+			ms = append(ms, models.InventoryMovement{ProductID: sale.ProductID, Delta: -sale.Delta, Reason: models.MovementReturn})
+		}
+	default:
+		return nil
+	}
+
+	for _, m := range ms {
+		m.OrderID = &orderID
+		var (
+			p   models.Product
+			err error
+		)
+		if ev.Type == "order_placed" && ev.Payload.ReservationID != "" {
+			p, m, err = s.confirm(ctx, ev.Payload.ReservationID, m)
+		} else {
+			p, m, err = s.adjust(ctx, m)
+		}
+		switch {
+		case errors.Is(err, storage.ErrConflict):
+			slog.Info("catalog: order already applied to stock", "order_id", orderID, "product_id", m.ProductID, "reason", m.Reason)
+			continue
+		case errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrArchived), errors.Is(err, storage.ErrNotFound):
+			slog.Warn("catalog: order not applied to stock", "order_id", orderID, "product_id", m.ProductID, "reason", m.Reason, "err", err)
+			if m.Reason == models.MovementSale {
+				metrics.OrderStockShortfalls.WithLabelValues(shortfallReason(err)).Inc()
+			}
+			continue
+		case err != nil:
+			return err
+		}
+		slog.Info("catalog: stock follows order", "order_id", orderID, "product_id", p.ID, "delta", m.Delta, "stock", p.Stock)
+	}
+	return nil
+}
+
+// shortfallReason is the metric label for an order line the stock could not
+// serve with err.
+func shortfallReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInsufficientStock):
+		return "insufficient_stock"
+	case errors.Is(err, ErrArchived):
+		return "archived"
+	default:
+		return "not_found"
+	}
+}
+
+// confirm sells m from the reservation rid, or from the available stock when
+// the reservation is no longer active.
+func (s *Service) confirm(ctx context.Context, rid string, m models.InventoryMovement) (models.Product, models.InventoryMovement, error) {
+	id, err := uuid.Parse(rid)
+	if err != nil {
+		return models.Product{}, m, fmt.Errorf("order %s: bad reservation_id %q", *m.OrderID, rid)
+	}
+	p, _, sold, err := s.repo.ConfirmReservation(ctx, id, m)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.Info("catalog: order reservation not active, selling from stock", "order_id", *m.OrderID, "reservation_id", id)
+		return s.adjust(ctx, m)
+	}
+	if err != nil {
+		return p, m, err
+	}
+	return p, sold, s.publish(ctx, "product_stock_updated", p)
+}
@@ -0,0 +1,74 @@
+package catalog_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"dynamic-pricing/internal/metrics"
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func orderEvent(t *testing.T, typ string, orderID, productID uuid.UUID, qty int) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"type":    typ,
+		"payload": map[string]any{"id": orderID, "product_id": productID, "qty": qty},
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func TestHandleOrderEvent_IdempotentByOrder(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+	p, err := svc.Create(ctx, "A", 1, 5, nil)
+	require.NoError(t, err)
+	stock := func() int {
+		got, err := svc.Get(ctx, p.ID)
+		require.NoError(t, err)
+		return got.Stock
+	}
+
+	first, second := uuid.New(), uuid.New()
+	placed := orderEvent(t, "order_placed", first, p.ID, 2)
+	require.NoError(t, svc.HandleOrderEvent(ctx, placed))
+	require.NoError(t, svc.HandleOrderEvent(ctx, placed)) // redelivered
+	require.Equal(t, 3, stock())
+
+	// More than is left: counted, stock untouched, and its cancel returns
+	// nothing.
+	shortfalls := testutil.ToFloat64(metrics.OrderStockShortfalls.WithLabelValues("insufficient_stock"))
+	require.NoError(t, svc.HandleOrderEvent(ctx, orderEvent(t, "order_placed", second, p.ID, 4)))
+	require.Equal(t, 3, stock())
+	require.Equal(t, shortfalls+1, testutil.ToFloat64(metrics.OrderStockShortfalls.WithLabelValues("insufficient_stock")))
+	require.NoError(t, svc.HandleOrderEvent(ctx, orderEvent(t, "order_canceled", second, p.ID, 4)))
+	require.Equal(t, 3, stock())
+
+	canceled := orderEvent(t, "order_canceled", first, p.ID, 2)
+	require.NoError(t, svc.HandleOrderEvent(ctx, canceled))
+	require.NoError(t, svc.HandleOrderEvent(ctx, canceled))
+	require.Equal(t, 5, stock())
+
+	page, err := svc.Movements(ctx, p.ID, "", 0)
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	require.Equal(t, models.MovementReturn, page.Items[0].Reason)
+	require.Equal(t, first, *page.Items[0].OrderID)
+	require.Equal(t, -2, page.Items[1].Delta)
+
+	var stockEvents int
+	for _, e := range bus.events {
+		if e.Type == "product_stock_updated" {
+			stockEvents++
+		}
+	}
+	require.Equal(t, 2, stockEvents)
+}
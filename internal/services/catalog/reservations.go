@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultReservationTTL is how long a reservation holds stock when the
+	// caller does not say.
+	DefaultReservationTTL = 15 * time.Minute
+	// MaxReservationTTL caps how long a reservation may hold stock.
+	MaxReservationTTL = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidReservation is returned for a non-positive quantity or a TTL
+	// out of range.
+	ErrInvalidReservation = errors.New("invalid reservation")
+	// ErrReservationClosed is returned when releasing a reservation that
+	// was already confirmed, released or expired.
+	ErrReservationClosed = errors.New("reservation is not active")
+)
+
+// Reserve holds qty of a product's available stock for ttl, or for
+// DefaultReservationTTL when ttl is 0. Held stock is not available to other
+// reservations or sales until the reservation is confirmed by an order,
+// released or expires.
+func (s *Service) Reserve(ctx context.Context, productID uuid.UUID, qty int, ttl time.Duration) (models.Reservation, error) {
+	if ttl == 0 {
+		ttl = DefaultReservationTTL
+	}
+	switch {
+	case qty <= 0:
+		return models.Reservation{}, fmt.Errorf("%w: qty must be positive", ErrInvalidReservation)
+	case ttl < 0 || ttl > MaxReservationTTL:
+		return models.Reservation{}, fmt.Errorf("%w: ttl must be between 0 and %s", ErrInvalidReservation, MaxReservationTTL)
+	}
+	res := models.Reservation{
+		ID:        uuid.New(),
+		ProductID: productID,
+		Qty:       qty,
+		ExpiresAt: time.Now().UTC().Add(ttl).Truncate(time.Microsecond),
+	}
+	p, res, err := s.repo.Reserve(ctx, res)
+	if err != nil {
+		return res, s.adjustErr(ctx, productID, err)
+	}
+	return res, s.publish(ctx, "product_stock_updated", p)
+}
+
+// GetReservation returns a reservation of the product.
+func (s *Service) GetReservation(ctx context.Context, productID, id uuid.UUID) (models.Reservation, error) {
+	res, err := s.repo.GetReservation(ctx, id)
+	if err != nil {
+		return res, err
+	}
+	if res.ProductID != productID {
+		return models.Reservation{}, fmt.Errorf("%w: reservation %s of product %s", storage.ErrNotFound, id, productID)
+	}
+	return res, nil
+}
+
+// Release gives the stock of an active reservation back, as when a checkout
+// is abandoned.
+func (s *Service) Release(ctx context.Context, productID, id uuid.UUID) (models.Reservation, error) {
+	if _, err := s.GetReservation(ctx, productID, id); err != nil {
+		return models.Reservation{}, err
+	}
+	p, res, err := s.repo.ReleaseReservation(ctx, id, models.ReservationReleased)
+	if errors.Is(err, storage.ErrNotFound) {
+		return res, ErrReservationClosed
+	}
+	if err != nil {
+		return res, err
+	}
+	return res, s.publish(ctx, "product_stock_updated", p)
+}
+
+// ExpireReservations frees the stock of every reservation past its expiry
+// and announces the products it changed.
+func (s *Service) ExpireReservations(ctx context.Context) (int, error) {
+	ps, err := s.repo.ExpireReservations(ctx, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range ps {
+		if err := s.publish(ctx, "product_stock_updated", p); err != nil {
+			return 0, err
+		}
+	}
+	return len(ps), nil
+}
+
+// RunReservationSweeper expires reservations every interval until ctx is
+// done.
+func (s *Service) RunReservationSweeper(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := s.ExpireReservations(ctx)
+		if err != nil {
+			slog.Error("catalog: reservation sweep failed", "err", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("catalog: reservations expired", "products", n)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package catalog_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func reservedOrderEvent(t *testing.T, orderID, productID, reservationID uuid.UUID, qty int) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"type":    "order_placed",
+		"payload": map[string]any{"id": orderID, "product_id": productID, "qty": qty, "reservation_id": reservationID},
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func TestReservations_HoldConfirmRelease(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+	p, err := svc.Create(ctx, "A", 1, 5, nil)
+	require.NoError(t, err)
+	get := func() models.Product {
+		got, err := svc.Get(ctx, p.ID)
+		require.NoError(t, err)
+		return got
+	}
+
+	_, err = svc.Reserve(ctx, p.ID, 0, 0)
+	require.ErrorIs(t, err, catalog.ErrInvalidReservation)
+	_, err = svc.Reserve(ctx, p.ID, 1, catalog.MaxReservationTTL+time.Second)
+	require.ErrorIs(t, err, catalog.ErrInvalidReservation)
+
+	held, err := svc.Reserve(ctx, p.ID, 3, 0)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(catalog.DefaultReservationTTL), held.ExpiresAt, time.Minute)
+	last := bus.events[len(bus.events)-1]
+	require.Equal(t, "product_stock_updated", last.Type)
+	require.Equal(t, 3, last.Payload.(catalog.ProductPayload).Reserved)
+	require.Equal(t, 2, last.Payload.(catalog.ProductPayload).Available)
+
+	_, err = svc.Reserve(ctx, p.ID, 3, 0)
+	require.ErrorIs(t, err, catalog.ErrInsufficientStock)
+	_, _, err = svc.AdjustStock(ctx, p.ID, -3, models.MovementSale, "")
+	require.ErrorIs(t, err, catalog.ErrInsufficientStock)
+
+	// The order that redeems the reservation takes the held units.
+	orderID := uuid.New()
+	require.NoError(t, svc.HandleOrderEvent(ctx, reservedOrderEvent(t, orderID, p.ID, held.ID, 3)))
+	require.NoError(t, svc.HandleOrderEvent(ctx, reservedOrderEvent(t, orderID, p.ID, held.ID, 3))) // redelivered
+	got := get()
+	require.Equal(t, 2, got.Stock)
+	require.Equal(t, 0, got.Reserved)
+	res, err := svc.GetReservation(ctx, p.ID, held.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReservationConfirmed, res.Status)
+	require.Equal(t, &orderID, res.OrderID)
+	_, err = svc.Release(ctx, p.ID, held.ID)
+	require.ErrorIs(t, err, catalog.ErrReservationClosed)
+
+	abandoned, err := svc.Reserve(ctx, p.ID, 2, time.Minute)
+	require.NoError(t, err)
+	_, err = svc.GetReservation(ctx, uuid.New(), abandoned.ID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	res, err = svc.Release(ctx, p.ID, abandoned.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReservationReleased, res.Status)
+	require.Equal(t, 2, get().Available())
+}
+
+func TestReservations_Expire(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+	p, err := svc.Create(ctx, "A", 1, 2, nil)
+	require.NoError(t, err)
+
+	due, err := svc.Reserve(ctx, p.ID, 2, time.Nanosecond)
+	require.NoError(t, err)
+	n, err := svc.ExpireReservations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	last := bus.events[len(bus.events)-1]
+	require.Equal(t, "product_stock_updated", last.Type)
+	require.Equal(t, 2, last.Payload.(catalog.ProductPayload).Available)
+
+	// An order placed with an expired reservation is sold from stock.
+	require.NoError(t, svc.HandleOrderEvent(ctx, reservedOrderEvent(t, uuid.New(), p.ID, due.ID, 1)))
+	got, err := svc.Get(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, got.Stock)
+	res, err := svc.GetReservation(ctx, p.ID, due.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReservationExpired, res.Status)
+}
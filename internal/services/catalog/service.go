@@ -2,80 +2,186 @@ package catalog
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"dynamic-pricing/internal/models"
 	"dynamic-pricing/internal/services"
+	"dynamic-pricing/internal/storage"
 
 	"github.com/google/uuid"
 )
 
 type ProductRepository interface {
 	Create(ctx context.Context, p models.Product) (models.Product, error)
-	Update(ctx context.Context, id uuid.UUID, name string, basePrice float64) (models.Product, error)
-	UpdateStock(ctx context.Context, id uuid.UUID, stock int) (models.Product, error)
+	// CreateBatch stores all of ps or none of them.
+	CreateBatch(ctx context.Context, ps []models.Product) ([]models.Product, error)
+	// Update and UpdateStock change a live product at version, or at any
+	// version when version is 0, and report anything else as not found.
+	Update(ctx context.Context, id uuid.UUID, name string, basePrice float64, version int64) (models.Product, error)
+	UpdateStock(ctx context.Context, id uuid.UUID, stock int, version int64) (models.Product, error)
 	Get(ctx context.Context, id uuid.UUID) (models.Product, error)
+	// List returns up to q.Limit products matching q in q.Sort order.
+	List(ctx context.Context, q models.ProductQuery) ([]models.Product, error)
+	// Archive marks a live product archived; Delete removes a product and
+	// returns its last state.
+	Archive(ctx context.Context, id uuid.UUID) (models.Product, error)
+	Delete(ctx context.Context, id uuid.UUID) (models.Product, error)
+	// SetCategory assigns a live product to a category, or unassigns it
+	// when categoryID is nil.
+	SetCategory(ctx context.Context, id uuid.UUID, categoryID *uuid.UUID) (models.Product, error)
+	// AdjustStock applies m.Delta to a live product and records m, filling
+	// in ID, StockAfter and CreatedAt. Without backorders a decrease that
+	// takes the available stock below zero is refused and reported as not
+	// found; a second movement with the same OrderID and Reason fails with
+	// storage.ErrConflict. UpdateStock records its change as an adjustment
+	// too.
+	AdjustStock(ctx context.Context, m models.InventoryMovement) (models.Product, models.InventoryMovement, error)
+	// OrderMovements returns the movements an order caused for reason, one
+	// per product, oldest first.
+	OrderMovements(ctx context.Context, orderID uuid.UUID, reason models.MovementReason) ([]models.InventoryMovement, error)
+	// ListMovements returns up to q.Limit movements, newest first.
+	ListMovements(ctx context.Context, q models.MovementQuery) ([]models.InventoryMovement, error)
+	SetBackorders(ctx context.Context, id uuid.UUID, enabled bool) (models.Product, error)
+
+	// Reserve holds r.Qty of a live product's available stock, filling in
+	// Status, CreatedAt and UpdatedAt. Unless the product takes backorders,
+	// a reservation the available stock cannot cover is refused and reported
+	// as not found. Reservations do not change the product's version.
+	Reserve(ctx context.Context, r models.Reservation) (models.Product, models.Reservation, error)
+	GetReservation(ctx context.Context, id uuid.UUID) (models.Reservation, error)
+	// ReleaseReservation ends an active reservation with status, released
+	// or expired, and frees its stock. A reservation that is not active is
+	// reported as not found.
+	ReleaseReservation(ctx context.Context, id uuid.UUID, status models.ReservationStatus) (models.Product, models.Reservation, error)
+	// ConfirmReservation turns the active reservation id of m.ProductID into
+	// the sale m, which must carry an OrderID: the reserved stock is freed
+	// and m applied as AdjustStock would, counting the freed stock as
+	// available. A reservation that is not active, or a sale the stock cannot
+	// cover, is reported as not found.
+	ConfirmReservation(ctx context.Context, id uuid.UUID, m models.InventoryMovement) (models.Product, models.Reservation, models.InventoryMovement, error)
+	// ExpireReservations expires the active reservations due by now and
+	// returns the products whose stock they freed.
+	ExpireReservations(ctx context.Context, now time.Time) ([]models.Product, error)
+}
+
+type CategoryRepository interface {
+	CreateCategory(ctx context.Context, c models.Category) (models.Category, error)
+	GetCategory(ctx context.Context, id uuid.UUID) (models.Category, error)
+	UpdateCategory(ctx context.Context, id uuid.UUID, name string, parentID *uuid.UUID) (models.Category, error)
+	// DeleteCategory fails with storage.ErrConflict while the category has
+	// subcategories or products.
+	DeleteCategory(ctx context.Context, id uuid.UUID) error
+	ListCategories(ctx context.Context) ([]models.Category, error)
+}
+
+// Repository is the catalog's storage: products and their categories.
+type Repository interface {
+	ProductRepository
+	CategoryRepository
 }
 
+var (
+	// ErrArchived is returned when changing a product that has been archived.
+	ErrArchived = errors.New("product is archived")
+	// ErrVersionMismatch is returned when a product has changed since the
+	// version the caller read.
+	ErrVersionMismatch = errors.New("product version mismatch")
+)
+
 type Service struct {
-	repo ProductRepository
+	repo Repository
 	bus  services.EventBus
 }
 
-func NewService(repo ProductRepository, bus services.EventBus) *Service {
+func NewService(repo Repository, bus services.EventBus) *Service {
 	return &Service{repo: repo, bus: bus}
 }
 
-func (s *Service) Create(ctx context.Context, name string, basePrice float64, stock int) (models.Product, error) {
+// Create adds a product, optionally assigned to categoryID.
+func (s *Service) Create(ctx context.Context, name string, basePrice float64, stock int, categoryID *uuid.UUID) (models.Product, error) {
 	p := models.Product{
-		ID:        uuid.New(),
-		Name:      name,
-		BasePrice: basePrice,
-		Stock:     stock,
+		ID:         uuid.New(),
+		Name:       name,
+		BasePrice:  basePrice,
+		Stock:      stock,
+		CategoryID: categoryID,
 	}
 	p, err := s.repo.Create(ctx, p)
 	if err != nil {
 		return p, err
 	}
-	b, err := NewProductEvent("product_created", p)
+	return p, s.publish(ctx, "product_created", p)
+}
+
+// Update and UpdateStock apply only while the product is at version; 0
+// skips the check.
+func (s *Service) Update(ctx context.Context, id uuid.UUID, name string, basePrice float64, version int64) (models.Product, error) {
+	p, err := s.repo.Update(ctx, id, name, basePrice, version)
 	if err != nil {
-		return p, err
-	}
-	if err := s.bus.Send(ctx, p.ID.String(), b); err != nil {
-		return p, err
+		return p, s.archivedErr(ctx, id, err)
 	}
-	return p, nil
+	return p, s.publish(ctx, "product_updated", p)
 }
 
-func (s *Service) Update(ctx context.Context, id uuid.UUID, name string, basePrice float64) (models.Product, error) {
-	p, err := s.repo.Update(ctx, id, name, basePrice)
+func (s *Service) UpdateStock(ctx context.Context, id uuid.UUID, stock int, version int64) (models.Product, error) {
+	p, err := s.repo.UpdateStock(ctx, id, stock, version)
 	if err != nil {
-		return p, err
+		return p, s.archivedErr(ctx, id, err)
 	}
-	b, err := NewProductEvent("product_updated", p)
+	return p, s.publish(ctx, "product_stock_updated", p)
+}
+
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	return s.repo.Get(ctx, id)
+}
+
+// Archive withdraws a product from sale. It stays readable by ID but no
+// longer changes, is hidden from listings and gets no new prices.
+func (s *Service) Archive(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	p, err := s.repo.Archive(ctx, id)
 	if err != nil {
-		return p, err
+		return p, s.archivedErr(ctx, id, err)
 	}
-	if err := s.bus.Send(ctx, p.ID.String(), b); err != nil {
-		return p, err
-	}
-	return p, nil
+	return p, s.publish(ctx, "product_archived", p)
 }
 
-func (s *Service) UpdateStock(ctx context.Context, id uuid.UUID, stock int) (models.Product, error) {
-	p, err := s.repo.UpdateStock(ctx, id, stock)
+// Delete removes a product for good, archived or not.
+func (s *Service) Delete(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	p, err := s.repo.Delete(ctx, id)
 	if err != nil {
 		return p, err
 	}
-	b, err := NewProductEvent("product_stock_updated", p)
+	return p, s.publish(ctx, "product_deleted", p)
+}
+
+func (s *Service) publish(ctx context.Context, eventType string, p models.Product) error {
+	path, err := s.categoryPath(ctx, p.CategoryID)
 	if err != nil {
-		return p, err
+		return err
 	}
-	if err := s.bus.Send(ctx, p.ID.String(), b); err != nil {
-		return p, err
+	b, err := NewProductEvent(eventType, p, path)
+	if err != nil {
+		return err
 	}
-	return p, nil
+	return s.bus.Send(ctx, p.ID.String(), b)
 }
 
-func (s *Service) Get(ctx context.Context, id uuid.UUID) (models.Product, error) {
-	return s.repo.Get(ctx, id)
+// archivedErr turns the not-found error the repository reports for archived
+// products, or products at another version, into ErrArchived or
+// ErrVersionMismatch.
+func (s *Service) archivedErr(ctx context.Context, id uuid.UUID, err error) error {
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	p, gerr := s.repo.Get(ctx, id)
+	switch {
+	case gerr != nil:
+		return err
+	case p.ArchivedAt != nil:
+		return ErrArchived
+	default:
+		return fmt.Errorf("%w: product %s is at version %d", ErrVersionMismatch, id, p.Version)
+	}
 }
@@ -0,0 +1,319 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"dynamic-pricing/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Format is the wire format of a bulk import or export.
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+const (
+	// ImportBatchSize is how many valid rows are stored, and then announced
+	// as product_created, at a time.
+	ImportBatchSize = 500
+	// maxImportErrors caps the row errors kept in a report; the rest are
+	// only counted.
+	maxImportErrors = 100
+)
+
+// CSVColumns is the header of a CSV export. Imports need name, base_price
+// and stock, take category_id when present and ignore the other columns, so
+// an export can be imported as is.
+var CSVColumns = []string{"id", "name", "base_price", "stock", "category_id", "updated_at", "archived_at"}
+
+// ErrInvalidImport is returned when an import cannot be read at all: an
+// unknown format or a CSV header without the required columns.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportRowError reports a rejected row. Line is 1-based and counts the CSV
+// header.
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+func (r *ImportReport) reject(line int, err error) {
+	r.Failed++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportRowError{Line: line, Error: err.Error()})
+	}
+}
+
+// importRow is one product to import, as read from either format.
+type importRow struct {
+	Name       string     `json:"name"`
+	BasePrice  float64    `json:"base_price"`
+	Stock      int        `json:"stock"`
+	CategoryID *uuid.UUID `json:"category_id"`
+}
+
+// rowReader yields rows until io.EOF. A row it cannot parse comes back with
+// rowErr set; err is reserved for failures that end the import.
+type rowReader func() (line int, row importRow, rowErr error, err error)
+
+// Import reads products from r row by row. Invalid rows are skipped and
+// reported; valid ones are stored in batches of ImportBatchSize, each
+// followed by its product_created events. An error is returned only when
+// the import has to stop, together with the report so far.
+func (s *Service) Import(ctx context.Context, r io.Reader, format Format) (ImportReport, error) {
+	report := ImportReport{Errors: []ImportRowError{}}
+	var next rowReader
+	var err error
+	switch format {
+	case FormatCSV:
+		next, err = csvRows(r)
+	case FormatJSONL:
+		next = jsonlRows(r)
+	default:
+		err = fmt.Errorf("%w: unknown format %q", ErrInvalidImport, format)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	categories, err := s.repo.ListCategories(ctx)
+	if err != nil {
+		return report, err
+	}
+	known := make(map[uuid.UUID]bool, len(categories))
+	for _, c := range categories {
+		known[c.ID] = true
+	}
+
+	batch := make([]models.Product, 0, ImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ps, err := s.repo.CreateBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		// The batch is stored even if announcing it fails below.
+		report.Imported += len(ps)
+		for _, p := range ps {
+			if err := s.publish(ctx, "product_created", p); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		line, row, rowErr, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		if rowErr == nil {
+			rowErr = validateRow(row, known)
+		}
+		if rowErr != nil {
+			report.reject(line, rowErr)
+			continue
+		}
+		batch = append(batch, models.Product{
+			ID:         uuid.New(),
+			Name:       strings.TrimSpace(row.Name),
+			BasePrice:  row.BasePrice,
+			Stock:      row.Stock,
+			CategoryID: row.CategoryID,
+		})
+		if len(batch) == ImportBatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, flush()
+}
+
+func validateRow(row importRow, categories map[uuid.UUID]bool) error {
+	switch {
+	case strings.TrimSpace(row.Name) == "":
+		return errors.New("name is required")
+	case math.IsNaN(row.BasePrice) || math.IsInf(row.BasePrice, 0):
+		return errors.New("base_price must be a finite number")
+	case row.BasePrice <= 0:
+		return errors.New("base_price must be positive")
+	case row.Stock < 0:
+		return errors.New("stock must not be negative")
+	case row.CategoryID != nil && !categories[*row.CategoryID]:
+		return fmt.Errorf("category %s not found", *row.CategoryID)
+	}
+	return nil
+}
+
+func csvRows(r io.Reader) (rowReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty CSV", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{"name", "base_price", "stock"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%w: CSV header has no %s column", ErrInvalidImport, name)
+		}
+	}
+
+	return func() (int, importRow, error, error) {
+		rec, err := cr.Read()
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return pe.StartLine, importRow{}, pe.Err, nil
+		}
+		if err != nil {
+			return 0, importRow{}, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			i, ok := col[name]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		row := importRow{Name: field("name")}
+		if row.BasePrice, err = strconv.ParseFloat(field("base_price"), 64); err != nil {
+			return line, row, fmt.Errorf("bad base_price %q", field("base_price")), nil
+		}
+		if row.Stock, err = strconv.Atoi(field("stock")); err != nil {
+			return line, row, fmt.Errorf("bad stock %q", field("stock")), nil
+		}
+		if s := field("category_id"); s != "" {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return line, row, fmt.Errorf("bad category_id %q", s), nil
+			}
+			row.CategoryID = &id
+		}
+		return line, row, nil, nil
+	}, nil
+}
+
+// jsonlRows reads one JSON object per line; blank lines are skipped and
+// unknown fields ignored.
+func jsonlRows(r io.Reader) rowReader {
+	br := bufio.NewReader(r)
+	line := 0
+	return func() (int, importRow, error, error) {
+		for {
+			b, err := br.ReadBytes('\n')
+			if len(b) == 0 && err != nil {
+				return line, importRow{}, nil, err
+			}
+			if err != nil && err != io.EOF {
+				return line, importRow{}, nil, err
+			}
+			line++
+			b = bytes.TrimSpace(b)
+			if len(b) == 0 {
+				continue
+			}
+			var row importRow
+			if err := json.Unmarshal(b, &row); err != nil {
+				return line, row, fmt.Errorf("bad json: %w", err), nil
+			}
+			return line, row, nil, nil
+		}
+	}
+}
+
+// Export calls fn for every product matching q, in q's order. q.After and
+// q.Limit are ignored.
+func (s *Service) Export(ctx context.Context, q models.ProductQuery, fn func(models.Product) error) error {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return err
+	}
+	q.After = nil
+	q.Limit = MaxPageSize
+	for {
+		ps, err := s.repo.List(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, p := range ps {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(ps) < q.Limit {
+			return nil
+		}
+		q.After = &ps[len(ps)-1]
+	}
+}
+
+// ExportByCategory exports the products of the category and all its
+// subcategories.
+func (s *Service) ExportByCategory(ctx context.Context, categoryID uuid.UUID, q models.ProductQuery, fn func(models.Product) error) error {
+	if _, err := s.repo.GetCategory(ctx, categoryID); err != nil {
+		return err
+	}
+	ids, err := s.subtree(ctx, categoryID)
+	if err != nil {
+		return err
+	}
+	q.CategoryIDs = ids
+	return s.Export(ctx, q, fn)
+}
+
+// CSVRecord formats p as a row under CSVColumns.
+func CSVRecord(p models.Product) []string {
+	rec := []string{
+		p.ID.String(),
+		p.Name,
+		strconv.FormatFloat(p.BasePrice, 'f', -1, 64),
+		strconv.Itoa(p.Stock),
+		"",
+		p.UpdatedAt.Format(time.RFC3339Nano),
+		"",
+	}
+	if p.CategoryID != nil {
+		rec[4] = p.CategoryID.String()
+	}
+	if p.ArchivedAt != nil {
+		rec[6] = p.ArchivedAt.Format(time.RFC3339Nano)
+	}
+	return rec
+}
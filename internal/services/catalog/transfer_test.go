@@ -0,0 +1,107 @@
+package catalog_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport_CSVReportsBadRows(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+	fruit, err := svc.CreateCategory(ctx, "Fruit", nil)
+	require.NoError(t, err)
+
+	in := "Name,Base_Price,Stock,category_id,extra\n" +
+		"Apple,1.5,10," + fruit.ID.String() + ",x\n" +
+		",1,1,,\n" +
+		"Pear,abc,1,,\n" +
+		"Plum,2,-1,,\n" +
+		"Fig,2,1,8b0c6f4e-3d1a-4a53-9b61-1f1f3a0c1a11,\n" +
+		"\"Kiwi, gold\",3,0\n"
+	report, err := svc.Import(ctx, strings.NewReader(in), catalog.FormatCSV)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Imported)
+	require.Equal(t, 4, report.Failed)
+	var lines []int
+	for _, e := range report.Errors {
+		lines = append(lines, e.Line)
+	}
+	require.Equal(t, []int{3, 4, 5, 6}, lines)
+
+	require.Len(t, bus.events, 2)
+	require.Equal(t, "product_created", bus.events[0].Type)
+	apple := bus.events[0].Payload.(catalog.ProductPayload)
+	require.Equal(t, "Apple", apple.Name)
+	require.Equal(t, []string{fruit.ID.String()}, apple.CategoryPath)
+	require.Equal(t, "Kiwi, gold", bus.events[1].Payload.(catalog.ProductPayload).Name)
+
+	_, err = svc.Import(ctx, strings.NewReader("name,stock\nA,1\n"), catalog.FormatCSV)
+	require.ErrorIs(t, err, catalog.ErrInvalidImport)
+	_, err = svc.Import(ctx, strings.NewReader(""), "xml")
+	require.ErrorIs(t, err, catalog.ErrInvalidImport)
+}
+
+type failingBus struct{}
+
+func (failingBus) Send(context.Context, string, []byte) error { return errors.New("broker down") }
+
+func TestImport_NonFiniteAndUnannouncedRows(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+
+	in := "name,base_price,stock\nA,NaN,1\nB,Inf,1\nC,-inf,1\nD,2,1\n"
+	report, err := svc.Import(ctx, strings.NewReader(in), catalog.FormatCSV)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Imported)
+	require.Equal(t, 3, report.Failed)
+	require.Len(t, bus.events, 1)
+
+	// Stored rows count as imported even when announcing them fails.
+	repo := memory.NewCatalogRepository()
+	svc = catalog.NewService(repo, failingBus{})
+	report, err = svc.Import(ctx, strings.NewReader("name,base_price,stock\nA,1,1\nB,2,1\n"), catalog.FormatCSV)
+	require.Error(t, err)
+	require.Equal(t, 2, report.Imported)
+}
+
+func TestImport_JSONLInBatches(t *testing.T) {
+	ctx := context.Background()
+	bus := &recordingBus{}
+	svc := catalog.NewService(memory.NewCatalogRepository(), bus)
+
+	var b strings.Builder
+	n := catalog.ImportBatchSize + 7
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `{"name":"p%04d","base_price":%d,"stock":1}`+"\n", i, i+1)
+		if i == 3 {
+			b.WriteString("\n{not json}\n")
+		}
+	}
+	report, err := svc.Import(ctx, strings.NewReader(b.String()), catalog.FormatJSONL)
+	require.NoError(t, err)
+	require.Equal(t, n, report.Imported)
+	require.Equal(t, []catalog.ImportRowError{{Line: 6, Error: report.Errors[0].Error}}, report.Errors)
+	require.Len(t, bus.events, n)
+
+	// Export walks every page in order.
+	var names []string
+	err = svc.Export(ctx, models.ProductQuery{Sort: models.SortByName}, func(p models.Product) error {
+		names = append(names, p.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, names, n)
+	require.Equal(t, "p0000", names[0])
+	require.Equal(t, fmt.Sprintf("p%04d", n-1), names[n-1])
+}
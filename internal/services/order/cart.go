@@ -0,0 +1,114 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrCartCheckedOut is returned when changing or checking out a cart
+	// that was already checked out.
+	ErrCartCheckedOut = errors.New("cart is checked out")
+	// ErrCartChanged is returned when a cart changes while it is being
+	// checked out.
+	ErrCartChanged = errors.New("cart changed during checkout")
+)
+
+func (s *Service) CreateCart(ctx context.Context, userID uuid.UUID) (models.Cart, error) {
+	return s.repo.CreateCart(ctx, models.Cart{ID: uuid.New(), UserID: userID})
+}
+
+// GetCart returns a cart with its open lines priced at the current prices.
+func (s *Service) GetCart(ctx context.Context, id uuid.UUID) (models.Cart, error) {
+	c, err := s.repo.GetCart(ctx, id)
+	if err != nil {
+		return c, err
+	}
+	return s.priceCart(ctx, c)
+}
+
+// SetCartLine puts qty of a product in an open cart, replacing the qty
+// already there; 0 removes the product.
+func (s *Service) SetCartLine(ctx context.Context, cartID, productID uuid.UUID, qty int) (models.Cart, error) {
+	if qty < 0 {
+		return models.Cart{}, ErrInvalidQty
+	}
+	if qty > 0 {
+		if err := s.checkProduct(ctx, productID, qty, nil); err != nil {
+			return models.Cart{}, err
+		}
+	}
+	c, err := s.repo.SetCartLine(ctx, cartID, productID, qty)
+	if errors.Is(err, storage.ErrNotFound) {
+		if cur, gerr := s.repo.GetCart(ctx, cartID); gerr == nil && cur.Status != models.CartOpen {
+			return cur, ErrCartCheckedOut
+		}
+	}
+	if err != nil {
+		return c, err
+	}
+	return s.priceCart(ctx, c)
+}
+
+// Checkout places an order for the lines of an open cart at their current
+// prices and closes the cart, both or neither.
+func (s *Service) Checkout(ctx context.Context, cartID uuid.UUID) (models.Order, error) {
+	c, err := s.repo.GetCart(ctx, cartID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if c.Status != models.CartOpen {
+		return models.Order{}, ErrCartCheckedOut
+	}
+	o, err := s.newOrder(ctx, c.UserID, c.Lines, nil, "")
+	if err != nil {
+		return o, err
+	}
+	o, err = s.repo.CheckoutCart(ctx, c.ID, c.UpdatedAt, o)
+	if errors.Is(err, storage.ErrNotFound) {
+		cur, gerr := s.repo.GetCart(ctx, cartID)
+		switch {
+		case gerr == nil && cur.Status != models.CartOpen:
+			return o, ErrCartCheckedOut
+		case gerr == nil:
+			return o, ErrCartChanged
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+	return o, s.placed(ctx, o)
+}
+
+// priceCart fills in the line prices and total of an open cart.
+func (s *Service) priceCart(ctx context.Context, c models.Cart) (models.Cart, error) {
+	if c.Status != models.CartOpen {
+		return c, nil
+	}
+	u, err := s.repo.GetUser(ctx, c.UserID)
+	if err != nil {
+		return c, err
+	}
+	c.Total = 0
+	for i, l := range c.Lines {
+		p, err := s.price(ctx, l.ProductID, l.Qty, u.Segment, "")
+		if err != nil {
+			return c, fmt.Errorf("product %s: %w", l.ProductID, err)
+		}
+		if c.Currency == "" {
+			c.Currency = p.Currency
+		}
+		c.Lines[i].UnitPrice = p.UnitPrice
+		c.Lines[i].Total = orderTotal(p.UnitPrice, l.Qty)
+		c.Total += c.Lines[i].Total
+	}
+	c.Total = math.Round(c.Total*100) / 100
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceOrder_SeveralLines(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	svc := order.NewService(repo, &recordingBus{})
+	u := seedUser(t, svc)
+	a, b := seedProduct(t, repo, 5), seedProduct(t, repo, 5)
+	require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: b, UnitPrice: 2.5, Currency: "USD", PricedAt: time.Now().UTC()}))
+
+	o, err := svc.PlaceOrder(ctx, u.ID, []models.OrderLine{{ProductID: a, Qty: 2}, {ProductID: b, Qty: 3}}, nil, "")
+	require.NoError(t, err)
+	require.Len(t, o.Lines, 2)
+	require.Equal(t, 20.0, o.Lines[0].Total)
+	require.Equal(t, 7.5, o.Lines[1].Total)
+	require.Equal(t, 27.5, o.Total)
+	require.Equal(t, a, o.ProductID, "first line")
+	require.Equal(t, 2, o.Qty)
+
+	eur := seedProduct(t, repo, 5)
+	require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: eur, UnitPrice: 1, Currency: "EUR", PricedAt: time.Now().UTC()}))
+	_, err = svc.PlaceOrder(ctx, u.ID, []models.OrderLine{{ProductID: a, Qty: 1}, {ProductID: eur, Qty: 1}}, nil, "")
+	require.Error(t, err, "lines in two currencies")
+}
+
+func TestCart_Checkout(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	bus := &recordingBus{}
+	svc := order.NewService(repo, bus)
+	u := seedUser(t, svc)
+	a, b := seedProduct(t, repo, 5), seedProduct(t, repo, 5)
+
+	c, err := svc.CreateCart(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.CartOpen, c.Status)
+	_, err = svc.SetCartLine(ctx, c.ID, a, -1)
+	require.ErrorIs(t, err, order.ErrInvalidQty)
+	_, err = svc.SetCartLine(ctx, c.ID, a, 6)
+	require.ErrorIs(t, err, order.ErrOutOfStock)
+	_, err = svc.SetCartLine(ctx, c.ID, a, 1)
+	require.NoError(t, err)
+	_, err = svc.SetCartLine(ctx, c.ID, b, 4)
+	require.NoError(t, err)
+	c, err = svc.SetCartLine(ctx, c.ID, a, 2)
+	require.NoError(t, err)
+	require.Len(t, c.Lines, 2)
+	require.Equal(t, 60.0, c.Total)
+	require.Equal(t, "USD", c.Currency)
+
+	c, err = svc.SetCartLine(ctx, c.ID, b, 0)
+	require.NoError(t, err)
+	require.Len(t, c.Lines, 1, "0 removes the line")
+
+	o, err := svc.Checkout(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, 20.0, o.Total)
+	require.Contains(t, bus.types, "order_placed")
+	c, err = svc.GetCart(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.CartCheckedOut, c.Status)
+	require.Equal(t, o.ID, *c.OrderID)
+
+	_, err = svc.Checkout(ctx, c.ID)
+	require.ErrorIs(t, err, order.ErrCartCheckedOut)
+	_, err = svc.SetCartLine(ctx, c.ID, a, 1)
+	require.ErrorIs(t, err, order.ErrCartCheckedOut)
+
+	empty, err := svc.CreateCart(ctx, u.ID)
+	require.NoError(t, err)
+	_, err = svc.Checkout(ctx, empty.ID)
+	require.ErrorIs(t, err, order.ErrInvalidLines)
+}
+
+// changingRepo changes every cart right before it is checked out, like a
+// concurrent request would.
+type changingRepo struct {
+	*memory.OrderRepository
+	productID uuid.UUID
+}
+
+func (r changingRepo) CheckoutCart(ctx context.Context, cartID uuid.UUID, updatedAt time.Time, o models.Order) (models.Order, error) {
+	if _, err := r.SetCartLine(ctx, cartID, r.productID, 3); err != nil {
+		return models.Order{}, err
+	}
+	return r.OrderRepository.CheckoutCart(ctx, cartID, updatedAt, o)
+}
+
+func TestCart_CheckoutOfChangedCart(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.NewOrderRepository()
+	pid := seedProduct(t, mem, 5)
+	svc := order.NewService(changingRepo{mem, pid}, &recordingBus{})
+	u := seedUser(t, svc)
+
+	c, err := svc.CreateCart(ctx, u.ID)
+	require.NoError(t, err)
+	_, err = svc.SetCartLine(ctx, c.ID, pid, 1)
+	require.NoError(t, err)
+
+	_, err = svc.Checkout(ctx, c.ID)
+	require.ErrorIs(t, err, order.ErrCartChanged)
+	c, err = svc.GetCart(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.CartOpen, c.Status, "nothing was checked out")
+	require.Equal(t, 3, c.Lines[0].Qty)
+}
@@ -18,20 +18,117 @@ type OrderPayload struct {
     ProductID string `json:"product_id"`
     Qty       int    `json:"qty"`
     Status    string `json:"status"`
+    // ReservationID is the catalog stock reservation the order redeems.
+    ReservationID string `json:"reservation_id,omitempty"`
+    UnitPrice     float64 `json:"unit_price"`
+    Currency      string  `json:"currency"`
+    Total         float64 `json:"total"`
+    // PreviousStatus is set on order_status_changed.
+    PreviousStatus string `json:"previous_status,omitempty"`
+    Lines          []LinePayload `json:"lines"`
+}
+
+type LinePayload struct {
+    ProductID string  `json:"product_id"`
+    Qty       int     `json:"qty"`
+    UnitPrice float64 `json:"unit_price"`
+    Total     float64 `json:"total"`
 }
 
 func NewOrderEvent(eventType string, o models.Order) ([]byte, error) {
+    return newEvent(eventType, orderPayload(o))
+}
+
+// NewStatusChangedEvent announces that o moved from status from to its
+// current status.
+func NewStatusChangedEvent(o models.Order, from string) ([]byte, error) {
+    payload := orderPayload(o)
+    payload.PreviousStatus = from
+    return newEvent("order_status_changed", payload)
+}
+
+// NewRefundedEvent builds order_refunded for an order refunded from status
+// from.
+func NewRefundedEvent(o models.Order, from string) ([]byte, error) {
+    payload := orderPayload(o)
+    payload.PreviousStatus = from
+    return newEvent("order_refunded", payload)
+}
+
+func orderPayload(o models.Order) OrderPayload {
+    payload := OrderPayload{
+        ID:        o.ID.String(),
+        UserID:    o.UserID.String(),
+        ProductID: o.ProductID.String(),
+        Qty:       o.Qty,
+        Status:    o.Status,
+        UnitPrice: o.UnitPrice,
+        Currency:  o.Currency,
+        Total:     o.Total,
+    }
+    if o.ReservationID != nil {
+        payload.ReservationID = o.ReservationID.String()
+    }
+    for _, l := range o.Lines {
+        payload.Lines = append(payload.Lines, LinePayload{
+            ProductID: l.ProductID.String(),
+            Qty:       l.Qty,
+            UnitPrice: l.UnitPrice,
+            Total:     l.Total,
+        })
+    }
+    return payload
+}
+
+func newEvent(eventType string, payload OrderPayload) ([]byte, error) {
     e := Event{
-        Type: eventType,
-        TS:   time.Now().UTC(),
-        Payload: OrderPayload{
-            ID:        o.ID.String(),
-            UserID:    o.UserID.String(),
-            ProductID: o.ProductID.String(),
-            Qty:       o.Qty,
-            Status:    o.Status,
-        },
+        Type:    eventType,
+        TS:      time.Now().UTC(),
+        Payload: payload,
     }
     return json.Marshal(e)
 }
 
+
+// UserPayload is the payload of user_* events.
+type UserPayload struct {
+    ID         string `json:"id"`
+    Email      string `json:"email"`
+    Active     bool   `json:"active"`
+    Segment    string `json:"segment"`
+    OrderCount int    `json:"order_count"`
+    // PreviousSegment is set on user_segment_changed.
+    PreviousSegment string `json:"previous_segment,omitempty"`
+}
+
+// NewUserEvent encodes a user_* event (user_created, user_updated,
+// user_deactivated, user_reactivated) for u.
+func NewUserEvent(eventType string, u models.User) ([]byte, error) {
+    return newUserEvent(eventType, userPayload(u))
+}
+
+// NewSegmentChangedEvent announces that u moved from segment from to its
+// current segment.
+func NewSegmentChangedEvent(u models.User, from string) ([]byte, error) {
+    payload := userPayload(u)
+    payload.PreviousSegment = from
+    return newUserEvent("user_segment_changed", payload)
+}
+
+func userPayload(u models.User) UserPayload {
+    return UserPayload{
+        ID:         u.ID.String(),
+        Email:      u.Email,
+        Active:     u.Active(),
+        Segment:    u.Segment,
+        OrderCount: u.OrderCount,
+    }
+}
+
+func newUserEvent(eventType string, payload UserPayload) ([]byte, error) {
+    return json.Marshal(Event{
+        Type:    eventType,
+        TS:      time.Now().UTC(),
+        Payload: payload,
+    })
+}
@@ -0,0 +1,111 @@
+package order
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"dynamic-pricing/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ErrInvalidQuery is returned by ListOrders and ListUsers for bad filters
+// or a malformed cursor.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Page is one page of an order listing. NextCursor is empty on the last page.
+type Page struct {
+	Items      []models.Order `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ListOrders returns the page of orders matching q that follows cursor, or
+// the newest page when cursor is empty.
+func (s *Service) ListOrders(ctx context.Context, q models.OrderQuery, cursor string) (Page, error) {
+	if q.Status != "" && !knownStatus(q.Status) {
+		return Page{}, fmt.Errorf("%w: unknown status %q", ErrInvalidQuery, q.Status)
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return Page{}, fmt.Errorf("%w: from is not before to", ErrInvalidQuery)
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = DefaultPageSize
+	case q.Limit > MaxPageSize:
+		q.Limit = MaxPageSize
+	}
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		q.After = &models.Order{ID: c.ID, CreatedAt: c.CreatedAt}
+	}
+
+	// One extra row tells whether another page follows.
+	limit := q.Limit
+	q.Limit++
+	items, err := s.repo.ListOrders(ctx, q)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.Items == nil {
+		page.Items = []models.Order{}
+	}
+	return page, nil
+}
+
+// UserOrders returns a page of a user's orders, newest first. Unknown
+// users are reported with storage.ErrNotFound.
+func (s *Service) UserOrders(ctx context.Context, userID uuid.UUID, cursor string, limit int) (Page, error) {
+	if _, err := s.repo.GetUser(ctx, userID); err != nil {
+		return Page{}, err
+	}
+	return s.ListOrders(ctx, models.OrderQuery{UserID: &userID, Limit: limit}, cursor)
+}
+
+func knownStatus(status string) bool {
+	switch status {
+	case models.OrderPlaced, models.OrderPaid, models.OrderFulfilled, models.OrderDelivered, models.OrderCanceled, models.OrderRefunded:
+		return true
+	}
+	return false
+}
+
+// cursor is the keyset position after the last order or user of a page.
+type cursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	b, _ := json.Marshal(cursor{CreatedAt: createdAt, ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
@@ -0,0 +1,78 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListOrders_FiltersAndPages(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	svc := order.NewService(repo, &recordingBus{})
+	alice, bob := seedUser(t, svc), seedUser(t, svc)
+	a, b := seedProduct(t, repo, 100), seedProduct(t, repo, 100)
+
+	var placed []models.Order
+	for i := 0; i < 5; i++ {
+		o, err := svc.PlaceOrder(ctx, alice.ID, line(a, 1), nil, "")
+		require.NoError(t, err)
+		placed = append(placed, o)
+	}
+	_, err := svc.PlaceOrder(ctx, bob.ID, []models.OrderLine{{ProductID: b, Qty: 1}, {ProductID: a, Qty: 1}}, nil, "")
+	require.NoError(t, err)
+	_, err = svc.CancelOrder(ctx, placed[0].ID)
+	require.NoError(t, err)
+
+	// Alice's orders, two at a time, newest first.
+	var got []uuid.UUID
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		page, err := svc.UserOrders(ctx, alice.ID, cursor, 2)
+		require.NoError(t, err)
+		for _, o := range page.Items {
+			got = append(got, o.ID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	require.Len(t, got, 5)
+	require.Equal(t, placed[4].ID, got[0])
+	require.Equal(t, placed[0].ID, got[4])
+
+	page, err := svc.ListOrders(ctx, models.OrderQuery{ProductID: &b}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1, "product on any line")
+	require.Equal(t, bob.ID, page.Items[0].UserID)
+
+	page, err = svc.ListOrders(ctx, models.OrderQuery{Status: models.OrderCanceled}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, placed[0].ID, page.Items[0].ID)
+
+	future := time.Now().Add(time.Hour)
+	page, err = svc.ListOrders(ctx, models.OrderQuery{From: &future}, "")
+	require.NoError(t, err)
+	require.NotNil(t, page.Items)
+	require.Empty(t, page.Items)
+
+	_, err = svc.ListOrders(ctx, models.OrderQuery{Status: "lost"}, "")
+	require.ErrorIs(t, err, order.ErrInvalidQuery)
+	past := time.Now().Add(-time.Hour)
+	_, err = svc.ListOrders(ctx, models.OrderQuery{From: &future, To: &past}, "")
+	require.ErrorIs(t, err, order.ErrInvalidQuery)
+	_, err = svc.ListOrders(ctx, models.OrderQuery{}, "not a cursor!")
+	require.ErrorIs(t, err, order.ErrInvalidQuery)
+	_, err = svc.UserOrders(ctx, uuid.New(), "", 0)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
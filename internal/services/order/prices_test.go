@@ -0,0 +1,91 @@
+package order_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func priceEvent(t *testing.T, productID uuid.UUID, price float64, currency string, ts time.Time) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"type":    "price_updated",
+		"ts":      ts,
+		"payload": map[string]any{"product_id": productID, "current_price": price, "currency": currency},
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func TestHandlePriceEvent_PricesOrders(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	svc := order.NewService(repo, &recordingBus{})
+	u := seedUser(t, svc)
+	pid := uuid.New()
+	require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: pid, Available: 10, Version: 1}))
+
+	_, err := svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.ErrorIs(t, err, order.ErrNoPrice)
+
+	now := time.Now().UTC()
+	require.NoError(t, svc.HandlePriceEvent(ctx, priceEvent(t, pid, 12.5, "", now)))
+	// A late older price does not replace the newer one.
+	require.NoError(t, svc.HandlePriceEvent(ctx, priceEvent(t, pid, 99, "EUR", now.Add(-time.Minute))))
+
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 3), nil, "")
+	require.NoError(t, err)
+	require.Equal(t, 12.5, o.UnitPrice)
+	require.Equal(t, "USD", o.Currency, "default currency")
+	require.Equal(t, 37.5, o.Total)
+	require.Len(t, o.Lines, 1)
+	require.Equal(t, 37.5, o.Lines[0].Total)
+}
+
+func TestPlaceOrder_VerifiesQuotes(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	signer := quote.NewSigner([]byte("secret"))
+	svc := order.NewService(repo, &recordingBus{}, order.WithQuoteVerifier(signer))
+	u := seedUser(t, svc)
+	pid := seedProduct(t, repo, 10)
+
+	sign := func(q quote.Quote) string {
+		token, err := signer.Sign(q)
+		require.NoError(t, err)
+		return token
+	}
+	q := quote.Quote{ProductID: pid, Qty: 2, UnitPrice: 7, Currency: "USD", ExpiresAt: time.Now().Add(time.Minute)}
+	token := sign(q)
+
+	_, err := svc.PlaceOrder(ctx, u.ID, line(pid, 3), nil, token)
+	require.ErrorIs(t, err, order.ErrInvalidQuote, "another qty")
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 2), nil, token+"x")
+	require.ErrorIs(t, err, order.ErrInvalidQuote, "tampered")
+	forged, err := quote.NewSigner([]byte("other")).Sign(q)
+	require.NoError(t, err)
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 2), nil, forged)
+	require.ErrorIs(t, err, order.ErrInvalidQuote, "signed with another key")
+
+	expired := q
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 2), nil, sign(expired))
+	require.ErrorIs(t, err, order.ErrQuoteExpired)
+
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 2), nil, token)
+	require.NoError(t, err)
+	require.Equal(t, 7.0, o.UnitPrice, "the quoted price, not the current one")
+	require.Equal(t, 14.0, o.Total)
+
+	_, err = order.NewService(repo, &recordingBus{}).PlaceOrder(ctx, u.ID, line(pid, 2), nil, token)
+	require.ErrorIs(t, err, order.ErrInvalidQuote, "quotes are not accepted")
+}
@@ -0,0 +1,149 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// SegmentRules decide which customer segment a user is in.
+type SegmentRules struct {
+	// NewFor is how long after signing up a user is new.
+	NewFor time.Duration
+	// LoyalOrders is how many orders, not counting canceled and refunded
+	// ones, make a user loyal.
+	LoyalOrders int
+}
+
+// DefaultSegmentRules keep users new for 30 days and make them loyal at 5
+// orders.
+func DefaultSegmentRules() SegmentRules {
+	return SegmentRules{NewFor: 30 * 24 * time.Hour, LoyalOrders: 5}
+}
+
+// Segment returns the segment of u at now: loyal from LoyalOrders orders
+// on, otherwise new for NewFor after signing up, otherwise regular.
+func (r SegmentRules) Segment(u models.User, now time.Time) string {
+	switch {
+	case r.LoyalOrders > 0 && u.OrderCount >= r.LoyalOrders:
+		return models.SegmentLoyal
+	case now.Before(u.CreatedAt.Add(r.NewFor)):
+		return models.SegmentNew
+	default:
+		return models.SegmentRegular
+	}
+}
+
+// WithSegmentRules puts users into segments by r instead of
+// DefaultSegmentRules.
+func WithSegmentRules(r SegmentRules) Option {
+	return func(s *Service) { s.segments = r }
+}
+
+// RefreshSegment moves a user to the segment the rules give it now and
+// publishes user_segment_changed when that is a different one.
+func (s *Service) RefreshSegment(ctx context.Context, id uuid.UUID) (models.User, error) {
+	u, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return u, err
+	}
+	return s.resegment(ctx, u)
+}
+
+// SweepSegments refreshes the segment of every user, catching the moves
+// that come with time rather than with orders, and returns how many users
+// moved.
+func (s *Service) SweepSegments(ctx context.Context) (int, error) {
+	moved := 0
+	q := models.UserQuery{Limit: MaxPageSize}
+	for {
+		users, err := s.repo.ListUsers(ctx, q)
+		if err != nil {
+			return moved, err
+		}
+		for _, u := range users {
+			before := u.Segment
+			if u, err = s.resegment(ctx, u); err != nil {
+				return moved, err
+			}
+			if u.Segment != before {
+				moved++
+			}
+		}
+		if len(users) < q.Limit {
+			return moved, nil
+		}
+		q.After = &users[len(users)-1]
+	}
+}
+
+// RunSegmentSweeper sweeps the segments of all users every interval until
+// ctx is done.
+func (s *Service) RunSegmentSweeper(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := s.SweepSegments(ctx)
+		if err != nil {
+			slog.Error("order: segment sweep failed", "err", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("order: user segments changed", "users", n)
+		}
+	}
+}
+
+// maxSegmentRetries bounds how often resegment starts over after losing a
+// race with another move of the same user.
+const maxSegmentRetries = 3
+
+// resegment moves u, as just read, to the segment the rules give it now.
+func (s *Service) resegment(ctx context.Context, u models.User) (models.User, error) {
+	for i := 0; ; i++ {
+		from, to := u.Segment, s.segments.Segment(u, time.Now().UTC())
+		if to == from {
+			return u, nil
+		}
+		moved, err := s.repo.SetUserSegment(ctx, u.ID, from, to)
+		if errors.Is(err, storage.ErrNotFound) && i < maxSegmentRetries {
+			// Moved by someone else since we read it; decide again on
+			// what is stored now.
+			if u, err = s.repo.GetUser(ctx, u.ID); err != nil {
+				return u, err
+			}
+			continue
+		}
+		if err != nil {
+			return u, err
+		}
+		if s.users == nil {
+			return moved, nil
+		}
+		b, err := NewSegmentChangedEvent(moved, from)
+		if err != nil {
+			return moved, err
+		}
+		return moved, s.users.Send(ctx, moved.ID.String(), b)
+	}
+}
+
+// segmentAfterOrder refreshes the segment of a user whose orders just
+// changed. Failures are logged rather than returned: the order stands and
+// the segment sweeper catches up.
+func (s *Service) segmentAfterOrder(ctx context.Context, userID uuid.UUID) {
+	if _, err := s.RefreshSegment(ctx, userID); err != nil {
+		slog.Error("order: refresh user segment", "user_id", userID, "err", err)
+	}
+}
@@ -0,0 +1,104 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentRules(t *testing.T) {
+	r := order.SegmentRules{NewFor: time.Hour, LoyalOrders: 3}
+	now := time.Now()
+	require.Equal(t, models.SegmentNew, r.Segment(models.User{CreatedAt: now.Add(-time.Minute)}, now))
+	require.Equal(t, models.SegmentRegular, r.Segment(models.User{CreatedAt: now.Add(-2 * time.Hour), OrderCount: 2}, now))
+	require.Equal(t, models.SegmentLoyal, r.Segment(models.User{CreatedAt: now, OrderCount: 3}, now), "loyal wins over new")
+	require.Equal(t, models.SegmentRegular, order.SegmentRules{}.Segment(models.User{CreatedAt: now, OrderCount: 9}, now), "no loyalty without LoyalOrders")
+}
+
+func TestSegments_FollowOrdersAndTime(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	users := &recordingBus{}
+	svc := order.NewService(repo, &recordingBus{}, order.WithUserEvents(users), order.WithSegmentRules(order.SegmentRules{NewFor: time.Hour, LoyalOrders: 2}))
+	pid := seedProduct(t, repo, 10)
+	u := seedUser(t, svc)
+	require.Equal(t, models.SegmentNew, u.Segment)
+
+	var last models.Order
+	for i := 0; i < 2; i++ {
+		var err error
+		last, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+		require.NoError(t, err)
+	}
+	u, err := svc.GetUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.SegmentLoyal, u.Segment)
+	require.Equal(t, 2, u.OrderCount)
+
+	_, err = svc.CancelOrder(ctx, last.ID)
+	require.NoError(t, err)
+	u, err = svc.GetUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.SegmentNew, u.Segment, "canceled orders do not count")
+	require.Equal(t, []string{"user_created", "user_segment_changed", "user_segment_changed"}, users.types)
+
+	// Time moves new users on; the sweep catches them.
+	other := seedUser(t, svc)
+	later := order.NewService(repo, &recordingBus{}, order.WithSegmentRules(order.SegmentRules{LoyalOrders: 2}))
+	moved, err := later.SweepSegments(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, moved)
+	other, err = svc.GetUser(ctx, other.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.SegmentRegular, other.Segment)
+	moved, err = later.SweepSegments(ctx)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+}
+
+func TestSegmentPrices(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	signer := quote.NewSigner([]byte("secret"))
+	svc := order.NewService(repo, &recordingBus{}, order.WithQuoteVerifier(signer))
+	u := seedUser(t, svc)
+	pid := seedProduct(t, repo, 10)
+	require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{
+		ProductID:     pid,
+		UnitPrice:     10,
+		Currency:      "USD",
+		SegmentPrices: map[string]float64{models.SegmentNew: 8},
+		PricedAt:      time.Now().UTC(),
+	}))
+
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 2), nil, "")
+	require.NoError(t, err)
+	require.Equal(t, 8.0, o.UnitPrice, "new users pay the new segment's price")
+	require.Equal(t, 16.0, o.Total)
+
+	c, err := svc.CreateCart(ctx, u.ID)
+	require.NoError(t, err)
+	c, err = svc.SetCartLine(ctx, c.ID, pid, 1)
+	require.NoError(t, err)
+	require.Equal(t, 8.0, c.Total)
+
+	sign := func(segment string) string {
+		token, err := signer.Sign(quote.Quote{ProductID: pid, Qty: 1, UnitPrice: 7, Currency: "USD", Segment: segment, ExpiresAt: time.Now().Add(time.Minute)})
+		require.NoError(t, err)
+		return token
+	}
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, sign(models.SegmentLoyal))
+	require.ErrorIs(t, err, order.ErrInvalidQuote, "quoted for another segment")
+	for _, segment := range []string{models.SegmentNew, ""} {
+		o, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, sign(segment))
+		require.NoError(t, err, segment)
+		require.Equal(t, 7.0, o.UnitPrice)
+	}
+}
@@ -2,63 +2,435 @@ package order
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
 	"dynamic-pricing/internal/services"
+	"dynamic-pricing/internal/storage"
 
 	"github.com/google/uuid"
 )
 
 type OrderRepository interface {
+	// CreateUser stores a new active user in models.SegmentNew. A taken
+	// email is reported with storage.ErrConflict. Users come back with
+	// their OrderCount filled in.
 	CreateUser(ctx context.Context, email string) (models.User, error)
-	CreateOrder(ctx context.Context, userID uuid.UUID, productID uuid.UUID, qty int) (models.Order, error)
-	CancelOrder(ctx context.Context, id uuid.UUID) (models.Order, error)
+	GetUser(ctx context.Context, id uuid.UUID) (models.User, error)
+	// ListUsers returns the users matching q, oldest first.
+	ListUsers(ctx context.Context, q models.UserQuery) ([]models.User, error)
+	// UpdateUser stores the email and DeactivatedAt of u, setting
+	// UpdatedAt. A taken email is reported with storage.ErrConflict.
+	UpdateUser(ctx context.Context, u models.User) (models.User, error)
+	// SetUserSegment moves a user from segment from to segment to. Users
+	// that are missing or no longer in from are reported with
+	// storage.ErrNotFound.
+	SetUserSegment(ctx context.Context, id uuid.UUID, from, to string) (models.User, error)
+	// CreateOrder stores o as placed with its lines, filling in Status,
+	// CreatedAt and UpdatedAt.
+	CreateOrder(ctx context.Context, o models.Order) (models.Order, error)
+	// TransitionOrder moves an order from status from to status to,
+	// recording the move in its history. Orders that are missing or no
+	// longer in from are reported with storage.ErrNotFound.
+	TransitionOrder(ctx context.Context, id uuid.UUID, from, to string) (models.Order, error)
+	// OrderHistory lists the status changes of an order, oldest first.
+	OrderHistory(ctx context.Context, id uuid.UUID) ([]models.OrderStatusChange, error)
 	GetOrder(ctx context.Context, id uuid.UUID) (models.Order, error)
+	// ListOrders returns the orders matching q with their lines, newest
+	// first.
+	ListOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error)
+	// SaveProduct, RetireProduct and GetProduct keep the service's copy of
+	// catalog products. SaveProduct ignores versions older than the stored
+	// one; RetireProduct marks a product archived or deleted for good.
+	SaveProduct(ctx context.Context, p models.CatalogProduct) error
+	RetireProduct(ctx context.Context, id uuid.UUID, at time.Time) error
+	ProductLookup
+	// SavePrice and GetPrice keep the latest price of each product, by
+	// PricedAt.
+	SavePrice(ctx context.Context, p models.QuotedPrice) error
+	GetPrice(ctx context.Context, productID uuid.UUID) (models.QuotedPrice, error)
+	CartRepository
 }
 
+// CartRepository stores carts. Lines come back unpriced, in the order they
+// were added.
+type CartRepository interface {
+	// CreateCart stores c as an empty open cart.
+	CreateCart(ctx context.Context, c models.Cart) (models.Cart, error)
+	GetCart(ctx context.Context, id uuid.UUID) (models.Cart, error)
+	// SetCartLine sets the qty of a product in an open cart, removing the
+	// line when qty is 0. Missing and checked-out carts are reported with
+	// storage.ErrNotFound.
+	SetCartLine(ctx context.Context, cartID, productID uuid.UUID, qty int) (models.Cart, error)
+	// CheckoutCart creates o like CreateOrder and closes the cart, both or
+	// neither. A cart that is not open or has changed since updatedAt is
+	// reported with storage.ErrNotFound.
+	CheckoutCart(ctx context.Context, cartID uuid.UUID, updatedAt time.Time, o models.Order) (models.Order, error)
+}
+
+// ProductLookup finds what the service needs to know about a catalog
+// product. Unknown products are reported with storage.ErrNotFound.
+type ProductLookup interface {
+	GetProduct(ctx context.Context, id uuid.UUID) (models.CatalogProduct, error)
+}
+
+// ProductSource lists catalog products for BackfillProducts.
+type ProductSource interface {
+	// ExportProducts calls fn for every catalog product, archived ones
+	// included.
+	ExportProducts(ctx context.Context, fn func(models.CatalogProduct) error) error
+}
+
+// ReservationLookup finds a catalog reservation of a product. Unknown
+// reservations, and reservations of another product, are reported with
+// storage.ErrNotFound.
+type ReservationLookup interface {
+	GetReservation(ctx context.Context, productID, id uuid.UUID) (models.Reservation, error)
+}
+
+var (
+	// ErrProductArchived is returned when ordering an archived or deleted product.
+	ErrProductArchived = errors.New("product is archived")
+	// ErrUnknownProduct is returned when ordering a product the catalog
+	// does not have.
+	ErrUnknownProduct = errors.New("unknown product")
+	// ErrOutOfStock is returned when ordering more than the catalog has
+	// available.
+	ErrOutOfStock = errors.New("product is out of stock")
+	// ErrInvalidQty is returned for order lines of less than one item.
+	ErrInvalidQty = errors.New("qty must be positive")
+	// ErrInvalidLines is returned for orders without lines, with a product
+	// on two lines, or with a reservation or quote and several lines.
+	ErrInvalidLines = errors.New("invalid order lines")
+	// ErrCatalogUnavailable is returned when products cannot be checked
+	// because the catalog does not answer.
+	ErrCatalogUnavailable = errors.New("catalog is unavailable")
+	// ErrNoPrice is returned when ordering a product pricing has not
+	// priced yet.
+	ErrNoPrice = errors.New("product has no price yet")
+	// ErrInvalidQuote is returned for quote tokens that are forged, or
+	// quote another product or quantity than the order.
+	ErrInvalidQuote = errors.New("invalid quote")
+	// ErrQuoteExpired is returned for quote tokens past their expiry.
+	ErrQuoteExpired = errors.New("quote has expired")
+)
+
 type Service struct {
-	repo OrderRepository
-	bus  services.EventBus
+	repo     OrderRepository
+	bus      services.EventBus
+	products ProductLookup
+	// reservations checks the reservations orders redeem; without it they
+	// hold no stock for the order.
+	reservations ReservationLookup
+	quotes       *quote.Signer
+	// users carries user_* events; without it they are not published.
+	users    services.EventBus
+	segments SegmentRules
 }
 
-func NewService(repo OrderRepository, bus services.EventBus) *Service {
-	return &Service{repo: repo, bus: bus}
+type Option func(*Service)
+
+// WithProductLookup makes the service check products with l instead of its
+// copy built from catalog.events.
+func WithProductLookup(l ProductLookup) Option {
+	return func(s *Service) { s.products = l }
 }
 
-func (s *Service) CreateUser(ctx context.Context, email string) (models.User, error) {
-	return s.repo.CreateUser(ctx, email)
+// WithReservationLookup makes the service count the stock held by an
+// order's reservation, as found by l, as available to the order.
+func WithReservationLookup(l ReservationLookup) Option {
+	return func(s *Service) { s.reservations = l }
+}
+
+// WithQuoteVerifier makes the service honour quote tokens signed by v.
+// Without it orders carrying a token are refused.
+func WithQuoteVerifier(v *quote.Signer) Option {
+	return func(s *Service) { s.quotes = v }
+}
+
+// WithUserEvents publishes user_* events to bus.
+func WithUserEvents(bus services.EventBus) Option {
+	return func(s *Service) { s.users = bus }
+}
+
+func NewService(repo OrderRepository, bus services.EventBus, opts ...Option) *Service {
+	s := &Service{repo: repo, bus: bus, products: repo, segments: DefaultSegmentRules()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *Service) PlaceOrder(ctx context.Context, userID uuid.UUID, productID uuid.UUID, qty int) (models.Order, error) {
-	o, err := s.repo.CreateOrder(ctx, userID, productID, qty)
+// PlaceOrder takes an order for lines, each a product and qty, at their
+// current prices, or at the price of quoteToken when one is given.
+// reservationID, when set, is the catalog reservation holding the stock; the
+// catalog redeems it once it sees the order. Reservations and quotes are for
+// single-line orders.
+func (s *Service) PlaceOrder(ctx context.Context, userID uuid.UUID, lines []models.OrderLine, reservationID *uuid.UUID, quoteToken string) (models.Order, error) {
+	o, err := s.newOrder(ctx, userID, lines, reservationID, quoteToken)
 	if err != nil {
 		return o, err
 	}
+	if o, err = s.repo.CreateOrder(ctx, o); err != nil {
+		return o, err
+	}
+	return o, s.placed(ctx, o)
+}
+
+// newOrder checks lines and prices them into an order ready to be stored.
+func (s *Service) newOrder(ctx context.Context, userID uuid.UUID, lines []models.OrderLine, reservationID *uuid.UUID, quoteToken string) (models.Order, error) {
+	if len(lines) == 0 {
+		return models.Order{}, fmt.Errorf("%w: no lines", ErrInvalidLines)
+	}
+	if len(lines) > 1 && (reservationID != nil || quoteToken != "") {
+		return models.Order{}, fmt.Errorf("%w: reservations and quotes are for single-line orders", ErrInvalidLines)
+	}
+	u, err := s.checkUser(ctx, userID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	o := models.Order{ID: uuid.New(), UserID: userID, ReservationID: reservationID}
+	seen := make(map[uuid.UUID]bool, len(lines))
+	for _, l := range lines {
+		if l.Qty < 1 {
+			return models.Order{}, ErrInvalidQty
+		}
+		if seen[l.ProductID] {
+			return models.Order{}, fmt.Errorf("%w: product %s on two lines", ErrInvalidLines, l.ProductID)
+		}
+		seen[l.ProductID] = true
+		if err := s.checkProduct(ctx, l.ProductID, l.Qty, reservationID); err != nil {
+			return models.Order{}, err
+		}
+		price, err := s.price(ctx, l.ProductID, l.Qty, u.Segment, quoteToken)
+		if err != nil {
+			return models.Order{}, err
+		}
+		if o.Currency == "" {
+			o.Currency = price.Currency
+		} else if o.Currency != price.Currency {
+			return models.Order{}, fmt.Errorf("product %s is priced in %s, not %s", l.ProductID, price.Currency, o.Currency)
+		}
+		o.Lines = append(o.Lines, models.OrderLine{
+			ProductID: l.ProductID,
+			Qty:       l.Qty,
+			UnitPrice: price.UnitPrice,
+			Total:     orderTotal(price.UnitPrice, l.Qty),
+		})
+		o.Total += orderTotal(price.UnitPrice, l.Qty)
+	}
+	o.Total = math.Round(o.Total*100) / 100
+	o.ProductID, o.Qty, o.UnitPrice = o.Lines[0].ProductID, o.Lines[0].Qty, o.Lines[0].UnitPrice
+	return o, nil
+}
+
+// placed announces a stored order with order_placed and refreshes the
+// segment of its user.
+func (s *Service) placed(ctx context.Context, o models.Order) error {
 	b, err := NewOrderEvent("order_placed", o)
 	if err != nil {
-		return o, err
+		return err
 	}
 	if err := s.bus.Send(ctx, o.ID.String(), b); err != nil {
-		return o, err
+		return err
 	}
-	return o, nil
+	s.segmentAfterOrder(ctx, o.UserID)
+	return nil
 }
 
-func (s *Service) CancelOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
-	o, err := s.repo.CancelOrder(ctx, id)
+// price is the price a user in segment places a line at: the quoted one if
+// quoteToken is set, otherwise the segment's latest from pricing.events.
+// Quotes made for a segment are only good for its users; quotes without one
+// are at the plain price and good for anyone.
+func (s *Service) price(ctx context.Context, productID uuid.UUID, qty int, segment, quoteToken string) (models.QuotedPrice, error) {
+	if quoteToken == "" {
+		p, err := s.repo.GetPrice(ctx, productID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return p, ErrNoPrice
+		}
+		if err != nil {
+			return p, err
+		}
+		p.UnitPrice = p.ForSegment(segment)
+		return p, nil
+	}
+	if s.quotes == nil {
+		return models.QuotedPrice{}, fmt.Errorf("%w: quotes are not accepted", ErrInvalidQuote)
+	}
+	q, err := s.quotes.Verify(quoteToken)
 	if err != nil {
-		return o, err
+		return models.QuotedPrice{}, fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	if q.ProductID != productID || q.Qty != qty {
+		return models.QuotedPrice{}, fmt.Errorf("%w: quoted %d of %s", ErrInvalidQuote, q.Qty, q.ProductID)
+	}
+	if q.Segment != "" && q.Segment != segment {
+		return models.QuotedPrice{}, fmt.Errorf("%w: quoted for segment %q, not %q", ErrInvalidQuote, q.Segment, segment)
+	}
+	if q.Expired(time.Now()) {
+		return models.QuotedPrice{}, ErrQuoteExpired
+	}
+	return models.QuotedPrice{ProductID: productID, UnitPrice: q.UnitPrice, Currency: q.Currency}, nil
+}
+
+// orderTotal is unit times qty, rounded to cents like prices are.
+func orderTotal(unit float64, qty int) float64 {
+	return math.Round(unit*float64(qty)*100) / 100
+}
+
+// checkProduct refuses orders for products that are unknown, archived or do
+// not have qty available, counting what reservationID holds for the order.
+func (s *Service) checkProduct(ctx context.Context, id uuid.UUID, qty int, reservationID *uuid.UUID) error {
+	p, err := s.products.GetProduct(ctx, id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return fmt.Errorf("%w: %s", ErrUnknownProduct, id)
+	case err != nil:
+		return err
+	case p.ArchivedAt != nil:
+		return ErrProductArchived
+	case p.Backorders:
+		return nil
 	}
-	b, err := NewOrderEvent("order_canceled", o)
+	held, err := s.reserved(ctx, id, reservationID)
 	if err != nil {
-		return o, err
+		return err
 	}
-	if err := s.bus.Send(ctx, o.ID.String(), b); err != nil {
-		return o, err
+	if p.Available+held < qty {
+		return fmt.Errorf("%w: %d available", ErrOutOfStock, p.Available+held)
 	}
-	return o, nil
+	return nil
+}
+
+// reserved is the stock reservationID holds for product id: its qty while it
+// is active, and nothing when it is unknown, of another product, used up or
+// expired, or cannot be checked for want of a ReservationLookup. The
+// catalog sells orders with such reservations from the available stock.
+func (s *Service) reserved(ctx context.Context, id uuid.UUID, reservationID *uuid.UUID) (int, error) {
+	if reservationID == nil || s.reservations == nil {
+		return 0, nil
+	}
+	res, err := s.reservations.GetReservation(ctx, id, *reservationID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	case res.Status != models.ReservationActive || !res.ExpiresAt.After(time.Now()):
+		return 0, nil
+	}
+	return res.Qty, nil
 }
 
 func (s *Service) GetOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
 	return s.repo.GetOrder(ctx, id)
 }
+
+// HandleCatalogEvent applies a catalog.events message to the service's view
+// of products.
+func (s *Service) HandleCatalogEvent(ctx context.Context, b []byte) error {
+	var ev struct {
+		Type    string    `json:"type"`
+		TS      time.Time `json:"ts"`
+		Payload struct {
+			ID         uuid.UUID  `json:"id"`
+			Stock      int        `json:"stock"`
+			Reserved   int        `json:"reserved"`
+			Backorders bool       `json:"backorders"`
+			ArchivedAt *time.Time `json:"archived_at"`
+			// Version is a number, or a string when decoded from protobuf.
+			Version json.Number `json:"version"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(ev.Type, "product_") {
+		return nil
+	}
+	if ev.Type != "product_archived" && ev.Type != "product_deleted" && ev.Payload.ArchivedAt == nil {
+		p := models.CatalogProduct{
+			ID:         ev.Payload.ID,
+			Available:  ev.Payload.Stock - ev.Payload.Reserved,
+			Backorders: ev.Payload.Backorders,
+		}
+		if ev.Payload.Version != "" {
+			v, err := ev.Payload.Version.Int64()
+			if err != nil {
+				return fmt.Errorf("product %s: bad version %q", p.ID, ev.Payload.Version)
+			}
+			p.Version = v
+		}
+		return s.repo.SaveProduct(ctx, p)
+	}
+	at := ev.TS.UTC()
+	if ev.Payload.ArchivedAt != nil {
+		at = ev.Payload.ArchivedAt.UTC()
+	}
+	return s.repo.RetireProduct(ctx, ev.Payload.ID, at)
+}
+
+// BackfillProducts copies every product of src into the service's view of
+// products, for those announced on catalog.events before the service read
+// it. Like catalog events, it does not replace newer copies. It returns how
+// many products it copied.
+func (s *Service) BackfillProducts(ctx context.Context, src ProductSource) (int, error) {
+	n := 0
+	err := src.ExportProducts(ctx, func(p models.CatalogProduct) error {
+		var err error
+		if p.ArchivedAt != nil {
+			err = s.repo.RetireProduct(ctx, p.ID, p.ArchivedAt.UTC())
+		} else {
+			err = s.repo.SaveProduct(ctx, p)
+		}
+		if err == nil {
+			n++
+		}
+		return err
+	})
+	return n, err
+}
+
+// defaultCurrency is assumed for price events published before prices
+// carried a currency.
+const defaultCurrency = "USD"
+
+// HandlePriceEvent keeps the prices orders are placed at in step with
+// pricing.events.
+func (s *Service) HandlePriceEvent(ctx context.Context, b []byte) error {
+	var ev struct {
+		Type    string    `json:"type"`
+		TS      time.Time `json:"ts"`
+		Payload struct {
+			ProductID     uuid.UUID          `json:"product_id"`
+			CurrentPrice  float64            `json:"current_price"`
+			Currency      string             `json:"currency"`
+			SegmentPrices map[string]float64 `json:"segment_prices"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return err
+	}
+	if ev.Type != "price_updated" {
+		return nil
+	}
+	p := models.QuotedPrice{
+		ProductID: ev.Payload.ProductID,
+		UnitPrice: ev.Payload.CurrentPrice,
+		Currency:  ev.Payload.Currency,
+		PricedAt:  ev.TS.UTC(),
+	}
+	if len(ev.Payload.SegmentPrices) > 0 {
+		p.SegmentPrices = ev.Payload.SegmentPrices
+	}
+	if p.Currency == "" {
+		p.Currency = defaultCurrency
+	}
+	return s.repo.SavePrice(ctx, p)
+}
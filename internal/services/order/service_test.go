@@ -0,0 +1,186 @@
+package order_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingBus keeps the types of the events sent.
+type recordingBus struct{ types []string }
+
+func (b *recordingBus) Send(_ context.Context, _ string, value []byte) error {
+	var e struct{ Type string }
+	if err := json.Unmarshal(value, &e); err != nil {
+		return err
+	}
+	b.types = append(b.types, e.Type)
+	return nil
+}
+
+// seedProduct stores a catalog product with available stock and a price
+// of 10.
+func seedProduct(t *testing.T, repo *memory.OrderRepository, available int) uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: id, Available: available, Version: 1}))
+	require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: id, UnitPrice: 10, Currency: "USD", PricedAt: time.Now().UTC()}))
+	return id
+}
+
+func seedUser(t *testing.T, svc *order.Service) models.User {
+	t.Helper()
+	u, err := svc.CreateUser(context.Background(), uuid.NewString()+"@ex.com")
+	require.NoError(t, err)
+	return u
+}
+
+func line(productID uuid.UUID, qty int) []models.OrderLine {
+	return []models.OrderLine{{ProductID: productID, Qty: qty}}
+}
+
+// reservations finds reservations by ID, like the catalog does.
+type reservations map[uuid.UUID]models.Reservation
+
+func (r reservations) GetReservation(_ context.Context, productID, id uuid.UUID) (models.Reservation, error) {
+	res, ok := r[id]
+	if !ok || res.ProductID != productID {
+		return res, storage.ErrNotFound
+	}
+	return res, nil
+}
+
+func TestPlaceOrder_ChecksLinesAndProducts(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	bus := &recordingBus{}
+	svc := order.NewService(repo, bus)
+	u := seedUser(t, svc)
+	pid := seedProduct(t, repo, 3)
+
+	_, err := svc.PlaceOrder(ctx, u.ID, nil, nil, "")
+	require.ErrorIs(t, err, order.ErrInvalidLines)
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 0), nil, "")
+	require.ErrorIs(t, err, order.ErrInvalidQty)
+	_, err = svc.PlaceOrder(ctx, u.ID, append(line(pid, 1), line(pid, 1)...), nil, "")
+	require.ErrorIs(t, err, order.ErrInvalidLines, "product on two lines")
+	_, err = svc.PlaceOrder(ctx, uuid.New(), line(pid, 1), nil, "")
+	require.ErrorIs(t, err, storage.ErrNotFound, "unknown user")
+
+	_, err = svc.PlaceOrder(ctx, u.ID, line(uuid.New(), 1), nil, "")
+	require.ErrorIs(t, err, order.ErrUnknownProduct)
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 4), nil, "")
+	require.ErrorIs(t, err, order.ErrOutOfStock)
+
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 3), nil, "")
+	require.NoError(t, err)
+	require.Equal(t, models.OrderPlaced, o.Status)
+	require.Equal(t, 30.0, o.Total)
+	require.Contains(t, bus.types, "order_placed")
+
+	back := seedProduct(t, repo, 0)
+	require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: back, Backorders: true, Version: 2}))
+	_, err = svc.PlaceOrder(ctx, u.ID, line(back, 5), nil, "")
+	require.NoError(t, err, "backorders take any qty")
+
+	require.NoError(t, repo.RetireProduct(ctx, pid, time.Now().UTC()))
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.ErrorIs(t, err, order.ErrProductArchived)
+}
+
+func TestPlaceOrder_CountsActiveReservations(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	pid := seedProduct(t, repo, 1)
+	active, expired, other := uuid.New(), uuid.New(), uuid.New()
+	res := reservations{
+		active:  {ID: active, ProductID: pid, Qty: 3, Status: models.ReservationActive, ExpiresAt: time.Now().Add(time.Hour)},
+		expired: {ID: expired, ProductID: pid, Qty: 3, Status: models.ReservationActive, ExpiresAt: time.Now().Add(-time.Second)},
+		other:   {ID: other, ProductID: uuid.New(), Qty: 3, Status: models.ReservationActive, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	svc := order.NewService(repo, &recordingBus{}, order.WithReservationLookup(res))
+	u := seedUser(t, svc)
+
+	for _, id := range []uuid.UUID{expired, other, uuid.New()} {
+		_, err := svc.PlaceOrder(ctx, u.ID, line(pid, 4), &id, "")
+		require.ErrorIs(t, err, order.ErrOutOfStock, "reservation %s holds nothing", id)
+	}
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 4), &active, "")
+	require.NoError(t, err, "available plus the reserved qty")
+	require.Equal(t, active, *o.ReservationID)
+
+	_, err = svc.PlaceOrder(ctx, u.ID, append(line(pid, 1), line(seedProduct(t, repo, 1), 1)...), &active, "")
+	require.ErrorIs(t, err, order.ErrInvalidLines, "reservations are for single-line orders")
+
+	// Without a lookup reservations hold nothing for the order.
+	plain := order.NewService(repo, &recordingBus{})
+	_, err = plain.PlaceOrder(ctx, u.ID, line(pid, 4), &active, "")
+	require.ErrorIs(t, err, order.ErrOutOfStock)
+}
+
+// products is a ProductSource over a fixed list.
+type products []models.CatalogProduct
+
+func (ps products) ExportProducts(_ context.Context, fn func(models.CatalogProduct) error) error {
+	for _, p := range ps {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHandleCatalogEvent_AndBackfill(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	svc := order.NewService(repo, &recordingBus{})
+
+	pid := uuid.New()
+	ev := func(typ string, stock, reserved int, version int64) []byte {
+		b, err := json.Marshal(map[string]any{
+			"type":    typ,
+			"ts":      time.Now().UTC(),
+			"payload": map[string]any{"id": pid, "stock": stock, "reserved": reserved, "version": version},
+		})
+		require.NoError(t, err)
+		return b
+	}
+	require.NoError(t, svc.HandleCatalogEvent(ctx, ev("product_created", 5, 2, 2)))
+	p, err := repo.GetProduct(ctx, pid)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.Available)
+
+	// A backfilled copy older than the event's is ignored, newer ones
+	// replace it and archived ones retire the product.
+	archived := time.Now().UTC()
+	n, err := svc.BackfillProducts(ctx, products{
+		{ID: pid, Available: 9, Version: 1},
+		{ID: uuid.New(), Available: 1, Version: 1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	p, err = repo.GetProduct(ctx, pid)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.Available)
+
+	_, err = svc.BackfillProducts(ctx, products{{ID: pid, Version: 3, ArchivedAt: &archived}})
+	require.NoError(t, err)
+	p, err = repo.GetProduct(ctx, pid)
+	require.NoError(t, err)
+	require.NotNil(t, p.ArchivedAt)
+
+	require.NoError(t, svc.HandleCatalogEvent(ctx, ev("product_updated", 5, 0, 4)))
+	p, err = repo.GetProduct(ctx, pid)
+	require.NoError(t, err)
+	require.NotNil(t, p.ArchivedAt, "retired for good")
+}
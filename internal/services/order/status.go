@@ -0,0 +1,113 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidTransition is returned when an order cannot move to the
+// requested status from the one it is in.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses each status can move to. An order is
+// canceled before it is paid and refunded after.
+var transitions = map[string][]string{
+	models.OrderPlaced:    {models.OrderPaid, models.OrderCanceled},
+	models.OrderPaid:      {models.OrderFulfilled, models.OrderRefunded},
+	models.OrderFulfilled: {models.OrderDelivered},
+	models.OrderDelivered: {models.OrderRefunded},
+}
+
+// CanTransition reports whether an order in status from may move to to.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) PayOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	return s.Transition(ctx, id, models.OrderPaid)
+}
+
+func (s *Service) FulfillOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	return s.Transition(ctx, id, models.OrderFulfilled)
+}
+
+func (s *Service) DeliverOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	return s.Transition(ctx, id, models.OrderDelivered)
+}
+
+func (s *Service) CancelOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	return s.Transition(ctx, id, models.OrderCanceled)
+}
+
+func (s *Service) RefundOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	return s.Transition(ctx, id, models.OrderRefunded)
+}
+
+// Transition moves an order to status to and publishes
+// order_status_changed. Canceling also publishes order_canceled, which
+// returns the stock to the catalog, and refunding publishes order_refunded,
+// on which the catalog returns the stock of orders refunded before they were
+// fulfilled. Canceled and refunded orders no longer count toward the segment
+// of their user, which is refreshed.
+func (s *Service) Transition(ctx context.Context, id uuid.UUID, to string) (models.Order, error) {
+	cur, err := s.repo.GetOrder(ctx, id)
+	if err != nil {
+		return cur, err
+	}
+	if !CanTransition(cur.Status, to) {
+		return cur, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, cur.Status, to)
+	}
+	o, err := s.repo.TransitionOrder(ctx, id, cur.Status, to)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Moved by someone else since we read it.
+		if now, gerr := s.repo.GetOrder(ctx, id); gerr == nil {
+			return now, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, now.Status, to)
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+
+	b, err := NewStatusChangedEvent(o, cur.Status)
+	if err != nil {
+		return o, err
+	}
+	if err := s.bus.Send(ctx, o.ID.String(), b); err != nil {
+		return o, err
+	}
+	switch to {
+	case models.OrderCanceled:
+		b, err = NewOrderEvent("order_canceled", o)
+	case models.OrderRefunded:
+		b, err = NewRefundedEvent(o, cur.Status)
+	default:
+		return o, nil
+	}
+	if err != nil {
+		return o, err
+	}
+	if err := s.bus.Send(ctx, o.ID.String(), b); err != nil {
+		return o, err
+	}
+	s.segmentAfterOrder(ctx, o.UserID)
+	return o, nil
+}
+
+// OrderHistory lists the statuses an order has been through, oldest first.
+func (s *Service) OrderHistory(ctx context.Context, id uuid.UUID) ([]models.OrderStatusChange, error) {
+	if _, err := s.repo.GetOrder(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.OrderHistory(ctx, id)
+}
@@ -0,0 +1,62 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	require.True(t, order.CanTransition(models.OrderPlaced, models.OrderPaid))
+	require.True(t, order.CanTransition(models.OrderPlaced, models.OrderCanceled))
+	require.True(t, order.CanTransition(models.OrderPaid, models.OrderRefunded))
+	require.True(t, order.CanTransition(models.OrderDelivered, models.OrderRefunded))
+	require.False(t, order.CanTransition(models.OrderPaid, models.OrderCanceled), "paid orders are refunded")
+	require.False(t, order.CanTransition(models.OrderPlaced, models.OrderFulfilled))
+	require.False(t, order.CanTransition(models.OrderCanceled, models.OrderPlaced))
+	require.False(t, order.CanTransition(models.OrderRefunded, models.OrderRefunded))
+}
+
+func TestTransition_EventsAndHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	bus := &recordingBus{}
+	svc := order.NewService(repo, bus)
+	u := seedUser(t, svc)
+	pid := seedProduct(t, repo, 10)
+
+	o, err := svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.NoError(t, err)
+	_, err = svc.FulfillOrder(ctx, o.ID)
+	require.ErrorIs(t, err, order.ErrInvalidTransition)
+
+	bus.types = nil
+	o, err = svc.PayOrder(ctx, o.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.OrderPaid, o.Status)
+	_, err = svc.CancelOrder(ctx, o.ID)
+	require.ErrorIs(t, err, order.ErrInvalidTransition)
+	o, err = svc.RefundOrder(ctx, o.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"order_status_changed", "order_status_changed", "order_refunded"}, bus.types)
+
+	history, err := svc.OrderHistory(ctx, o.ID)
+	require.NoError(t, err)
+	var moves []string
+	for _, h := range history {
+		moves = append(moves, h.From+">"+h.To)
+	}
+	require.Equal(t, []string{">placed", "placed>paid", "paid>refunded"}, moves)
+
+	c, err := svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.NoError(t, err)
+	bus.types = nil
+	_, err = svc.CancelOrder(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"order_status_changed", "order_canceled"}, bus.types)
+}
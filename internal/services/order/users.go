@@ -0,0 +1,191 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidEmail is returned for emails that are not a bare address.
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrEmailTaken is returned when another user has the email.
+	ErrEmailTaken = errors.New("email is taken")
+	// ErrUserDeactivated is returned when a deactivated user places an
+	// order.
+	ErrUserDeactivated = errors.New("user is deactivated")
+)
+
+// UserPage is one page of a user listing. NextCursor is empty on the last
+// page.
+type UserPage struct {
+	Items      []models.User `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// UserPatch lists the changes to a user; nil fields stay as they are.
+type UserPatch struct {
+	Email  *string
+	Active *bool
+}
+
+// CreateUser stores a user under the normalized email and publishes
+// user_created. Users start out new unless the segment rules say
+// otherwise.
+func (s *Service) CreateUser(ctx context.Context, email string) (models.User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return models.User{}, err
+	}
+	u, err := s.repo.CreateUser(ctx, email)
+	if errors.Is(err, storage.ErrConflict) {
+		return u, fmt.Errorf("%w: %s", ErrEmailTaken, email)
+	}
+	if err != nil {
+		return u, err
+	}
+	if err := s.publishUser(ctx, "user_created", u); err != nil {
+		return u, err
+	}
+	return s.resegment(ctx, u)
+}
+
+func (s *Service) GetUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+	return s.repo.GetUser(ctx, id)
+}
+
+// ListUsers returns the page of users that follows cursor, oldest first,
+// keeping only the user with email when it is set.
+func (s *Service) ListUsers(ctx context.Context, email, cursor string, limit int) (UserPage, error) {
+	q := models.UserQuery{Limit: limit}
+	if email != "" {
+		var err error
+		if q.Email, err = NormalizeEmail(email); err != nil {
+			return UserPage{}, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = DefaultPageSize
+	case q.Limit > MaxPageSize:
+		q.Limit = MaxPageSize
+	}
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return UserPage{}, err
+		}
+		q.After = &models.User{ID: c.ID, CreatedAt: c.CreatedAt}
+	}
+
+	// One extra row tells whether another page follows.
+	limit = q.Limit
+	q.Limit++
+	items, err := s.repo.ListUsers(ctx, q)
+	if err != nil {
+		return UserPage{}, err
+	}
+	page := UserPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.Items == nil {
+		page.Items = []models.User{}
+	}
+	return page, nil
+}
+
+// UpdateUser applies p to a user. A new email publishes user_updated;
+// deactivation and reactivation publish user_deactivated and
+// user_reactivated. Deactivated users keep their orders but place no new
+// ones.
+func (s *Service) UpdateUser(ctx context.Context, id uuid.UUID, p UserPatch) (models.User, error) {
+	u, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return u, err
+	}
+	var events []string
+	if p.Email != nil {
+		email, err := NormalizeEmail(*p.Email)
+		if err != nil {
+			return u, err
+		}
+		if email != u.Email {
+			u.Email = email
+			events = append(events, "user_updated")
+		}
+	}
+	if p.Active != nil && *p.Active != u.Active() {
+		if *p.Active {
+			u.DeactivatedAt = nil
+			events = append(events, "user_reactivated")
+		} else {
+			at := time.Now().UTC()
+			u.DeactivatedAt = &at
+			events = append(events, "user_deactivated")
+		}
+	}
+	if len(events) == 0 {
+		return u, nil
+	}
+	email := u.Email
+	u, err = s.repo.UpdateUser(ctx, u)
+	if errors.Is(err, storage.ErrConflict) {
+		return u, fmt.Errorf("%w: %s", ErrEmailTaken, email)
+	}
+	if err != nil {
+		return u, err
+	}
+	for _, t := range events {
+		if err := s.publishUser(ctx, t, u); err != nil {
+			return u, err
+		}
+	}
+	return u, nil
+}
+
+// NormalizeEmail trims and lower-cases email, which must be a bare address
+// such as a@ex.com.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+	}
+	return email, nil
+}
+
+// checkUser refuses orders from unknown and deactivated users and returns
+// the user otherwise.
+func (s *Service) checkUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+	u, err := s.repo.GetUser(ctx, id)
+	if err != nil {
+		return u, err
+	}
+	if !u.Active() {
+		return u, fmt.Errorf("%w: %s", ErrUserDeactivated, id)
+	}
+	return u, nil
+}
+
+// publishUser announces u on the users topic, when the service has one.
+func (s *Service) publishUser(ctx context.Context, eventType string, u models.User) error {
+	if s.users == nil {
+		return nil
+	}
+	b, err := NewUserEvent(eventType, u)
+	if err != nil {
+		return err
+	}
+	return s.users.Send(ctx, u.ID.String(), b)
+}
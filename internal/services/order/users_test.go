@@ -0,0 +1,79 @@
+package order_test
+
+import (
+	"context"
+	"testing"
+
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"a@ex.com":        "a@ex.com",
+		"  A.B@Ex.COM \n": "a.b@ex.com",
+	} {
+		got, err := order.NormalizeEmail(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got)
+	}
+	for _, in := range []string{"", "a", "a@", "A <a@ex.com>", "a@ex.com, b@ex.com"} {
+		_, err := order.NormalizeEmail(in)
+		require.ErrorIs(t, err, order.ErrInvalidEmail, in)
+	}
+}
+
+func TestUsers_CreateUpdateDeactivate(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewOrderRepository()
+	users := &recordingBus{}
+	svc := order.NewService(repo, &recordingBus{}, order.WithUserEvents(users))
+	pid := seedProduct(t, repo, 10)
+
+	u, err := svc.CreateUser(ctx, " Ann@Ex.com")
+	require.NoError(t, err)
+	require.Equal(t, "ann@ex.com", u.Email)
+	_, err = svc.CreateUser(ctx, "ANN@ex.com")
+	require.ErrorIs(t, err, order.ErrEmailTaken, "emails are compared normalized")
+	_, err = svc.CreateUser(ctx, "ann")
+	require.ErrorIs(t, err, order.ErrInvalidEmail)
+
+	page, err := svc.ListUsers(ctx, "ANN@EX.COM", "", 0)
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, u.ID, page.Items[0].ID)
+
+	bob, err := svc.CreateUser(ctx, "bob@ex.com")
+	require.NoError(t, err)
+	email := "Ann@ex.com"
+	_, err = svc.UpdateUser(ctx, bob.ID, order.UserPatch{Email: &email})
+	require.ErrorIs(t, err, order.ErrEmailTaken)
+
+	users.types = nil
+	email = "Anne@ex.com"
+	off := false
+	u, err = svc.UpdateUser(ctx, u.ID, order.UserPatch{Email: &email, Active: &off})
+	require.NoError(t, err)
+	require.Equal(t, "anne@ex.com", u.Email)
+	require.False(t, u.Active())
+	require.Equal(t, []string{"user_updated", "user_deactivated"}, users.types)
+
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.ErrorIs(t, err, order.ErrUserDeactivated)
+
+	// Patches that change nothing publish nothing.
+	users.types = nil
+	_, err = svc.UpdateUser(ctx, u.ID, order.UserPatch{Email: &email, Active: &off})
+	require.NoError(t, err)
+	require.Empty(t, users.types)
+
+	on := true
+	u, err = svc.UpdateUser(ctx, u.ID, order.UserPatch{Active: &on})
+	require.NoError(t, err)
+	require.True(t, u.Active())
+	require.Equal(t, []string{"user_reactivated"}, users.types)
+	_, err = svc.PlaceOrder(ctx, u.ID, line(pid, 1), nil, "")
+	require.NoError(t, err)
+}
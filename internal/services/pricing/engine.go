@@ -4,12 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
-	"math"
 	"sync"
 	"time"
 
 	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
 	"dynamic-pricing/internal/services"
 
 	"github.com/google/uuid"
@@ -17,28 +18,92 @@ import (
 
 type PriceRepository interface {
 	UpsertPrice(ctx context.Context, productID uuid.UUID, currentPrice float64) (models.Price, error)
+	// GetPrice reports retired products with storage.ErrNotFound.
 	GetPrice(ctx context.Context, productID uuid.UUID) (models.Price, error)
+	// ListPrices returns the prices of the products that are not retired.
+	ListPrices(ctx context.Context) ([]models.Price, error)
+	// RetirePrice marks a product archived or deleted in the catalog, so
+	// that the mark survives restarts.
+	RetirePrice(ctx context.Context, productID uuid.UUID, at time.Time) error
+	// RetiredProducts lists the products marked by RetirePrice.
+	RetiredProducts(ctx context.Context) ([]uuid.UUID, error)
+}
+
+// PolicyRepository stores the category and product pricing policies.
+type PolicyRepository interface {
+	ListPolicies(ctx context.Context) ([]models.ScopedPolicy, error)
+	UpsertPolicy(ctx context.Context, p models.ScopedPolicy) (models.ScopedPolicy, error)
+	DeletePolicy(ctx context.Context, scope models.PolicyScope, id uuid.UUID) error
 }
 
 var ErrUnknownProduct = errors.New("unknown product")
 
+// ErrArchivedProduct is returned for products archived or deleted in the
+// catalog; they get no new prices.
+var ErrArchivedProduct = errors.New("product is archived")
+
 type Engine struct {
 	repo     PriceRepository
 	bus      services.EventBus
 	mu       sync.RWMutex
 	products map[uuid.UUID]models.ProductSnapshot
 	demandTS map[uuid.UUID][]time.Time
+	retired  map[uuid.UUID]struct{} // archived or deleted in the catalog
 	window   time.Duration
+	currency string
+
+	// signer signs quotes good for quoteTTL; without it quotes are off.
+	signer   *quote.Signer
+	quoteTTL time.Duration
+
+	policyRepo PolicyRepository
+	policies   map[models.PolicyScope]map[uuid.UUID]models.ScopedPolicy
+
+	segmentRepo SegmentRepository
+	segments    map[string]models.SegmentMultiplier
 }
 
-func NewEngine(repo PriceRepository, bus services.EventBus) *Engine {
-	return &Engine{
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithPolicyRepository persists pricing policies in r. Without it policies
+// live only in memory.
+func WithPolicyRepository(r PolicyRepository) Option {
+	return func(e *Engine) { e.policyRepo = r }
+}
+
+// DefaultCurrency is the currency of prices unless WithCurrency says
+// otherwise.
+const DefaultCurrency = "USD"
+
+// WithCurrency sets the ISO 4217 code prices are quoted in.
+func WithCurrency(code string) Option {
+	return func(e *Engine) {
+		if code != "" {
+			e.currency = code
+		}
+	}
+}
+
+func NewEngine(repo PriceRepository, bus services.EventBus, opts ...Option) *Engine {
+	e := &Engine{
 		repo:     repo,
 		bus:      bus,
 		products: make(map[uuid.UUID]models.ProductSnapshot),
 		demandTS: make(map[uuid.UUID][]time.Time),
+		retired:  make(map[uuid.UUID]struct{}),
 		window:   2 * time.Minute,
+		currency: DefaultCurrency,
+		policies: map[models.PolicyScope]map[uuid.UUID]models.ScopedPolicy{
+			models.PolicyScopeCategory: {},
+			models.PolicyScopeProduct:  {},
+		},
+		segments: make(map[string]models.SegmentMultiplier),
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 func (e *Engine) HandleCatalogEvent(b []byte) error {
@@ -54,30 +119,108 @@ func (e *Engine) HandleCatalogEvent(b []byte) error {
 		ID        uuid.UUID `json:"id"`
 		BasePrice float64   `json:"base_price"`
 		Stock     int       `json:"stock"`
+		// Reserved is held by checkouts; prices follow the stock left.
+		Reserved   int        `json:"reserved"`
+		ArchivedAt *time.Time `json:"archived_at"`
+		// CategoryPath selects the category policies, root first.
+		CategoryPath []uuid.UUID `json:"category_path"`
+		// Version is a number, or a string when decoded from protobuf.
+		Version json.Number `json:"version"`
 	}
 	if err := json.Unmarshal(ev.Payload, &p); err != nil {
 		return err
 	}
+	var version int64
+	if p.Version != "" {
+		v, err := p.Version.Int64()
+		if err != nil {
+			return fmt.Errorf("product %s: bad version %q", p.ID, p.Version)
+		}
+		version = v
+	}
 	e.mu.Lock()
+	if ev.Type == "product_archived" || ev.Type == "product_deleted" || p.ArchivedAt != nil {
+		delete(e.products, p.ID)
+		delete(e.demandTS, p.ID)
+		e.retired[p.ID] = struct{}{}
+		e.mu.Unlock()
+		slog.Info("pricing: product retired", "product_id", p.ID, "event", ev.Type)
+		at := ev.TS.UTC()
+		if at.IsZero() {
+			at = time.Now().UTC()
+		}
+		return e.repo.RetirePrice(context.Background(), p.ID, at)
+	}
+	if _, ok := e.retired[p.ID]; ok {
+		e.mu.Unlock()
+		slog.Warn("pricing: snapshot for retired product ignored", "product_id", p.ID, "event", ev.Type)
+		return nil
+	}
+	// Events are keyed by product, but replays and redeliveries can still
+	// bring an older snapshot after a newer one. Equal versions pass: moving
+	// a category republishes products without changing them.
+	if prev, ok := e.products[p.ID]; ok && version < prev.Version {
+		e.mu.Unlock()
+		slog.Warn("pricing: stale snapshot dropped", "product_id", p.ID, "version", version, "have", prev.Version)
+		return nil
+	}
 	e.products[p.ID] = models.ProductSnapshot{
-		ID:        p.ID,
-		BasePrice: p.BasePrice,
-		Stock:     p.Stock,
-		UpdatedAt: ev.TS,
+		ID:           p.ID,
+		BasePrice:    p.BasePrice,
+		Stock:        p.Stock,
+		Reserved:     p.Reserved,
+		CategoryPath: p.CategoryPath,
+		UpdatedAt:    ev.TS,
+		Version:      version,
 	}
 	snap := e.products[p.ID]
+	rules := e.rulesLocked(snap)
+	// Stock now follows orders, so a snapshot often lands right after the
+	// order that changed it; keep the demand the window still holds.
+	at := ev.TS.UTC()
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	demand := len(e.recentDemand(p.ID, at))
 	e.mu.Unlock()
-	slog.Info("pricing: catalog snapshot", "product_id", p.ID, "base_price", p.BasePrice, "stock", p.Stock)
+	slog.Info("pricing: catalog snapshot", "product_id", p.ID, "base_price", p.BasePrice, "stock", p.Stock, "reserved", p.Reserved)
 
-	price := computePrice(snap.BasePrice, snap.Stock, 0)
-	stored, err := e.repo.UpsertPrice(context.Background(), p.ID, price)
-	if err == nil {
-		slog.Info("pricing: initial price", "product_id", stored.ProductID, "price", stored.CurrentPrice)
+	price := rules.Price(snap.BasePrice, snap.Available(), demand)
+	ctx := context.Background()
+	stored, err := e.repo.UpsertPrice(ctx, p.ID, price)
+	if err != nil {
+		return err
 	}
+	slog.Info("pricing: snapshot price", "product_id", stored.ProductID, "price", stored.CurrentPrice)
+	_, err = e.publish(ctx, stored)
 	return err
 }
 
-func (e *Engine) HandleOrderEvent(ctx context.Context, b []byte) (*models.Price, error) {
+// Currency is the ISO 4217 code prices are quoted in.
+func (e *Engine) Currency() string { return e.currency }
+
+// publish announces a stored price on pricing.events and returns it with
+// its currency and segment prices.
+func (e *Engine) publish(ctx context.Context, p models.Price) (models.Price, error) {
+	p.Currency = e.currency
+	p.SegmentPrices = e.segmentPrices(p.CurrentPrice)
+	msg, err := NewPriceEvent(p)
+	if err != nil {
+		return p, err
+	}
+	return p, e.bus.Send(ctx, p.ProductID.String(), msg)
+}
+
+// orderLine is a line of an order in orders.events.
+type orderLine struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Qty       int       `json:"qty"`
+}
+
+// HandleOrderEvent counts the lines of a placed order as demand for their
+// products and returns the new prices. Lines of unknown or archived products
+// are skipped and reported in the error.
+func (e *Engine) HandleOrderEvent(ctx context.Context, b []byte) ([]models.Price, error) {
 	var ev struct {
 		Type    string          `json:"type"`
 		TS      time.Time       `json:"ts"`
@@ -86,61 +229,89 @@ func (e *Engine) HandleOrderEvent(ctx context.Context, b []byte) (*models.Price,
 	if err := json.Unmarshal(b, &ev); err != nil {
 		return nil, err
 	}
+	// Only placements are demand; cancellations and later status changes
+	// are not.
+	if ev.Type != "order_placed" {
+		return nil, nil
+	}
 	var o struct {
 		ProductID uuid.UUID `json:"product_id"`
 		Qty       int       `json:"qty"`
+		// Lines is empty in events from before orders had lines.
+		Lines []orderLine `json:"lines"`
 	}
 	if err := json.Unmarshal(ev.Payload, &o); err != nil {
 		return nil, err
 	}
+	if len(o.Lines) == 0 {
+		o.Lines = []orderLine{{o.ProductID, o.Qty}}
+	}
+
+	// Demand is measured in event time so that replayed history yields the
+	// same prices as live processing did.
+	at := ev.TS.UTC()
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	var (
+		prices []models.Price
+		errs   []error
+	)
+	for _, l := range o.Lines {
+		p, err := e.addDemand(ctx, l.ProductID, l.Qty, at)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		prices = append(prices, p)
+	}
+	return prices, errors.Join(errs...)
+}
 
+// addDemand records qty units ordered at at and reprices the product.
+func (e *Engine) addDemand(ctx context.Context, productID uuid.UUID, qty int, at time.Time) (models.Price, error) {
 	e.mu.Lock()
-	snap, ok := e.products[o.ProductID]
-	if !ok {
+	if _, retired := e.retired[productID]; retired {
 		e.mu.Unlock()
-		slog.Warn("pricing: order for unknown product (no snapshot)", "product_id", o.ProductID)
-		return nil, ErrUnknownProduct
+		return models.Price{}, ErrArchivedProduct
 	}
-	ts := e.demandTS[o.ProductID]
-	cutoff := time.Now().UTC().Add(-e.window)
-	var kept []time.Time
-	for _, t := range ts {
-		if t.After(cutoff) {
-			kept = append(kept, t)
-		}
+	snap, ok := e.products[productID]
+	if !ok {
+		e.mu.Unlock()
+		slog.Warn("pricing: order for unknown product (no snapshot)", "product_id", productID)
+		return models.Price{}, ErrUnknownProduct
 	}
-	for i := 0; i < max(1, o.Qty); i++ {
-		kept = append(kept, time.Now().UTC())
+	kept := e.recentDemand(productID, at)
+	for i := 0; i < max(1, qty); i++ {
+		kept = append(kept, at)
 	}
-	e.demandTS[o.ProductID] = kept
+	e.demandTS[productID] = kept
+	rules := e.rulesLocked(snap)
 	e.mu.Unlock()
 
 	demand := len(kept)
-	price := computePrice(snap.BasePrice, snap.Stock, demand)
-	stored, err := e.repo.UpsertPrice(ctx, o.ProductID, price)
-	if err != nil {
-		return nil, err
-	}
-	msg, err := NewPriceEvent(stored)
+	price := rules.Price(snap.BasePrice, snap.Available(), demand)
+	stored, err := e.repo.UpsertPrice(ctx, productID, price)
 	if err != nil {
-		return nil, err
-	}
-	if err := e.bus.Send(ctx, o.ProductID.String(), msg); err != nil {
-		return nil, err
+		return models.Price{}, err
 	}
-	return &stored, nil
+	return e.publish(ctx, stored)
 }
 
 func (e *Engine) ComputeAndPersistCurrentPrice(ctx context.Context, productID uuid.UUID) (*models.Price, error) {
 	e.mu.RLock()
 	snap, ok := e.products[productID]
-	ts := e.demandTS[productID]
+	demand := len(e.recentDemand(productID, time.Now().UTC()))
+	_, retired := e.retired[productID]
+	rules := e.rulesLocked(snap)
 	e.mu.RUnlock()
+	if retired {
+		return nil, ErrArchivedProduct
+	}
 	if !ok {
 		return nil, ErrUnknownProduct
 	}
-	demand := len(ts)
-	price := computePrice(snap.BasePrice, snap.Stock, demand)
+	price := rules.Price(snap.BasePrice, snap.Available(), demand)
 	stored, err := e.repo.UpsertPrice(ctx, productID, price)
 	if err != nil {
 		return nil, err
@@ -148,17 +319,65 @@ func (e *Engine) ComputeAndPersistCurrentPrice(ctx context.Context, productID uu
 	return &stored, nil
 }
 
-func computePrice(base float64, stock int, demand int) float64 {
-	m := 1.0
-	m += math.Min(0.30, float64(demand)*0.02)
-	if stock <= 5 {
-		m += 0.20
+// recentDemand returns the demand timestamps of a product that are still
+// inside the window at at. The caller holds e.mu.
+func (e *Engine) recentDemand(productID uuid.UUID, at time.Time) []time.Time {
+	cutoff := at.Add(-e.window)
+	var kept []time.Time
+	for _, t := range e.demandTS[productID] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// LoadRetired marks the products retired in the price repository, so that
+// they keep answering ErrArchivedProduct after a restart.
+func (e *Engine) LoadRetired(ctx context.Context) error {
+	ids, err := e.repo.RetiredProducts(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range ids {
+		delete(e.products, id)
+		delete(e.demandTS, id)
+		e.retired[id] = struct{}{}
+	}
+	slog.Info("pricing: retired products loaded", "count", len(ids))
+	return nil
+}
+
+// RepublishPrices announces every stored price again on pricing.events, so
+// that services keeping a copy of prices pick up the ones published before
+// they read the topic. It returns how many prices it published.
+func (e *Engine) RepublishPrices(ctx context.Context) (int, error) {
+	ps, err := e.repo.ListPrices(ctx)
+	if err != nil {
+		return 0, err
 	}
-	if stock <= 0 {
-		m += 0.50
+	for i, p := range ps {
+		if _, err := e.publish(ctx, p); err != nil {
+			return i, err
+		}
 	}
-	v := base * m
-	return math.Round(v*100) / 100
+	slog.Info("pricing: prices republished", "count", len(ps))
+	return len(ps), nil
+}
+
+// Retired reports whether the product was archived or deleted in the catalog.
+func (e *Engine) Retired(productID uuid.UUID) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.retired[productID]
+	return ok
+}
+
+// computePrice prices with the default rules.
+func computePrice(base float64, stock int, demand int) float64 {
+	return DefaultRules().Price(base, stock, demand)
 }
 
 func max(a, b int) int {
@@ -7,6 +7,8 @@ import (
     "time"
 
     "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/storage"
+    "dynamic-pricing/internal/storage/memory"
     pmocks "dynamic-pricing/internal/services/pricing/mocks"
     smocks "dynamic-pricing/internal/services/mocks"
 
@@ -31,10 +33,18 @@ func TestHandleCatalogEvent_InitialPriceUpsert(t *testing.T) {
 
     pid := uuid.New()
 
-    // Expect initial upsert with computed price 120.0 (base=100, stock=5)
+    // Expect initial upsert with computed price 120.0 (base=100, stock=5),
+    // announced so that orders can quote it
     repo.EXPECT().
         UpsertPrice(mock.Anything, pid, 120.0).
         Return(models.Price{ProductID: pid, CurrentPrice: 120.0, UpdatedAt: time.Now().UTC()}, nil)
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.MatchedBy(func(b []byte) bool {
+            var ev struct{ Payload PricePayload }
+            return json.Unmarshal(b, &ev) == nil && ev.Payload.CurrentPrice == 120.0 && ev.Payload.Currency == DefaultCurrency
+        })).
+        Return(nil).
+        Once()
 
     ev := struct {
         Type    string    `json:"type"`
@@ -72,14 +82,201 @@ func TestHandleOrderEvent_UnknownProduct(t *testing.T) {
         },
     }
     _, err := eng.HandleOrderEvent(context.Background(), mustJSON(t, orderEv))
-    require.Error(t, err)
-    require.Equal(t, ErrUnknownProduct, err)
+    require.ErrorIs(t, err, ErrUnknownProduct)
 
     // Ensure no side-effects
     repo.AssertNotCalled(t, "UpsertPrice", mock.Anything, mock.Anything, mock.Anything)
     bus.AssertNotCalled(t, "Send", mock.Anything, mock.Anything, mock.Anything)
 }
 
+func TestHandleOrderEvent_OnlyPlacementsAreDemand(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    for _, typ := range []string{"order_canceled", "order_status_changed"} {
+        ev := mustJSON(t, map[string]any{
+            "type":    typ,
+            "ts":      time.Now().UTC(),
+            "payload": map[string]any{"product_id": uuid.New(), "qty": 1},
+        })
+        ps, err := eng.HandleOrderEvent(context.Background(), ev)
+        require.NoError(t, err)
+        require.Empty(t, ps)
+    }
+}
+
+func TestHandleCatalogEvent_ArchivedProductGetsNoPrices(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    pid := uuid.New()
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, pid, 120.0).
+        Return(models.Price{ProductID: pid, CurrentPrice: 120.0}, nil).
+        Once()
+    // Only the first snapshot is priced and announced.
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Once()
+    repo.EXPECT().
+        RetirePrice(mock.Anything, pid, mock.Anything).
+        Return(nil).
+        Once()
+
+    catalogEv := func(typ string) []byte {
+        return mustJSON(t, map[string]any{
+            "type":    typ,
+            "ts":      time.Now().UTC(),
+            "payload": map[string]any{"id": pid, "name": "A", "base_price": 100.0, "stock": 5},
+        })
+    }
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv("product_created")))
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv("product_archived")))
+    // A late snapshot must not bring the product back.
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv("product_stock_updated")))
+
+    eng.mu.RLock()
+    _, cached := eng.products[pid]
+    eng.mu.RUnlock()
+    require.False(t, cached)
+
+    orderEv := mustJSON(t, map[string]any{
+        "type":    "order_placed",
+        "ts":      time.Now().UTC(),
+        "payload": map[string]any{"product_id": pid, "qty": 1},
+    })
+    _, err := eng.HandleOrderEvent(context.Background(), orderEv)
+    require.ErrorIs(t, err, ErrArchivedProduct)
+    _, err = eng.ComputeAndPersistCurrentPrice(context.Background(), pid)
+    require.ErrorIs(t, err, ErrArchivedProduct)
+}
+
+func TestEngine_RetiredSurvivesRestart(t *testing.T) {
+    ctx := context.Background()
+    prices := memory.NewPriceRepository()
+    eng := NewEngine(prices, &recordingBus{})
+
+    pid := uuid.New()
+    ev := func(typ string) []byte {
+        return mustJSON(t, map[string]any{
+            "type":    typ,
+            "ts":      time.Now().UTC(),
+            "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 5},
+        })
+    }
+    require.NoError(t, eng.HandleCatalogEvent(ev("product_created")))
+    require.NoError(t, eng.HandleCatalogEvent(ev("product_archived")))
+
+    restarted := NewEngine(prices, &recordingBus{})
+    require.NoError(t, restarted.LoadRetired(ctx))
+    _, err := restarted.CurrentPrice(ctx, pid)
+    require.ErrorIs(t, err, ErrArchivedProduct)
+    require.NoError(t, restarted.HandleCatalogEvent(ev("product_stock_updated")))
+    _, err = prices.GetPrice(ctx, pid)
+    require.ErrorIs(t, err, storage.ErrNotFound, "a late snapshot is not priced")
+}
+
+func TestEngine_RepublishPrices(t *testing.T) {
+    ctx := context.Background()
+    prices := memory.NewPriceRepository()
+    live, retired := uuid.New(), uuid.New()
+    _, err := prices.UpsertPrice(ctx, live, 10)
+    require.NoError(t, err)
+    _, err = prices.UpsertPrice(ctx, retired, 20)
+    require.NoError(t, err)
+    require.NoError(t, prices.RetirePrice(ctx, retired, time.Now().UTC()))
+
+    bus := &recordingBus{}
+    n, err := NewEngine(prices, bus).RepublishPrices(ctx)
+    require.NoError(t, err)
+    require.Equal(t, 1, n)
+    require.Equal(t, 1, bus.sent)
+}
+
+func TestComputeAndPersistCurrentPrice_ExpiredDemandIgnored(t *testing.T) {
+    ctx := context.Background()
+    prices := memory.NewPriceRepository()
+    eng := NewEngine(prices, &recordingBus{})
+
+    pid := uuid.New()
+    require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, map[string]any{
+        "type":    "product_created",
+        "ts":      time.Now().UTC(),
+        "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 50},
+    })))
+    plain, err := prices.GetPrice(ctx, pid)
+    require.NoError(t, err)
+
+    old := time.Now().UTC().Add(-2 * eng.window)
+    eng.mu.Lock()
+    eng.demandTS[pid] = []time.Time{old, old, old}
+    eng.mu.Unlock()
+    p, err := eng.ComputeAndPersistCurrentPrice(ctx, pid)
+    require.NoError(t, err)
+    require.Equal(t, plain.CurrentPrice, p.CurrentPrice)
+}
+
+func TestHandleCatalogEvent_StaleSnapshotDropped(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    pid := uuid.New()
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, pid, mock.Anything).
+        Return(models.Price{ProductID: pid}, nil).
+        Twice()
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Twice()
+
+    catalogEv := func(version any, stock int) []byte {
+        return mustJSON(t, map[string]any{
+            "type":    "product_stock_updated",
+            "ts":      time.Now().UTC(),
+            "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": stock, "version": version},
+        })
+    }
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv(3, 10)))
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv(2, 0)))
+    // Protobuf-decoded events carry the version as a string.
+    require.NoError(t, eng.HandleCatalogEvent(catalogEv("4", 7)))
+    require.Error(t, eng.HandleCatalogEvent(catalogEv("x", 7)))
+
+    eng.mu.RLock()
+    snap := eng.products[pid]
+    eng.mu.RUnlock()
+    require.Equal(t, int64(4), snap.Version)
+    require.Equal(t, 7, snap.Stock)
+}
+
+func TestHandleCatalogEvent_ReservedStockIsNotAvailable(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    // 10 on hand but 8 held by checkouts: priced as low stock.
+    pid := uuid.New()
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, pid, 120.0).
+        Return(models.Price{ProductID: pid, CurrentPrice: 120.0}, nil).
+        Once()
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Once()
+
+    require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, map[string]any{
+        "type":    "product_stock_updated",
+        "ts":      time.Now().UTC(),
+        "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 10, "reserved": 8, "version": 1},
+    })))
+}
+
 func TestHandleOrderEvent_PriceUpdatedAndEventSent(t *testing.T) {
     repo := pmocks.NewPriceRepository(t)
     bus := smocks.NewEventBus(t)
@@ -91,6 +288,10 @@ func TestHandleOrderEvent_PriceUpdatedAndEventSent(t *testing.T) {
     repo.EXPECT().
         UpsertPrice(mock.Anything, pid, 100.0).
         Return(models.Price{ProductID: pid, CurrentPrice: 100.0, UpdatedAt: time.Now().UTC()}, nil)
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Once()
 
     cat := struct {
         Type    string    `json:"type"`
@@ -128,10 +329,104 @@ func TestHandleOrderEvent_PriceUpdatedAndEventSent(t *testing.T) {
             "qty":        1,
         },
     }
-    p, err := eng.HandleOrderEvent(context.Background(), mustJSON(t, ord))
+    ps, err := eng.HandleOrderEvent(context.Background(), mustJSON(t, ord))
     require.NoError(t, err)
-    require.NotNil(t, p)
-    require.InDelta(t, 102.0, p.CurrentPrice, 0.0001)
+    require.Len(t, ps, 1)
+    require.InDelta(t, 102.0, ps[0].CurrentPrice, 0.0001)
+}
+
+func TestHandleOrderEvent_DemandPerLine(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    a, b, unknown := uuid.New(), uuid.New(), uuid.New()
+    bus.EXPECT().
+        Send(mock.Anything, mock.Anything, mock.Anything).
+        Return(nil).
+        Times(4)
+    for _, pid := range []uuid.UUID{a, b} {
+        repo.EXPECT().
+            UpsertPrice(mock.Anything, pid, 100.0).
+            Return(models.Price{ProductID: pid, CurrentPrice: 100.0}, nil).
+            Once()
+        require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, map[string]any{
+            "type":    "product_created",
+            "ts":      time.Now().UTC(),
+            "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 10},
+        })))
+    }
+
+    // Each line is demand for its own product; an unknown one does not
+    // stop the others.
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, a, 102.0).
+        Return(models.Price{ProductID: a, CurrentPrice: 102.0}, nil).
+        Once()
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, b, 106.0).
+        Return(models.Price{ProductID: b, CurrentPrice: 106.0}, nil).
+        Once()
+    ps, err := eng.HandleOrderEvent(context.Background(), mustJSON(t, map[string]any{
+        "type": "order_placed",
+        "ts":   time.Now().UTC(),
+        "payload": map[string]any{
+            "product_id": a,
+            "qty":        1,
+            "lines": []map[string]any{
+                {"product_id": a, "qty": 1},
+                {"product_id": unknown, "qty": 1},
+                {"product_id": b, "qty": 3},
+            },
+        },
+    }))
+    require.ErrorIs(t, err, ErrUnknownProduct)
+    require.Len(t, ps, 2)
+    require.Equal(t, b, ps[1].ProductID)
+}
+
+func TestHandleOrderEvent_DemandWindowUsesEventTime(t *testing.T) {
+    repo := pmocks.NewPriceRepository(t)
+    bus := smocks.NewEventBus(t)
+    eng := NewEngine(repo, bus)
+
+    pid := uuid.New()
+    start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, pid, 100.0).
+        Return(models.Price{ProductID: pid, CurrentPrice: 100.0}, nil)
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Once()
+    cat := map[string]any{
+        "type":    "product_created",
+        "ts":      start,
+        "payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 10},
+    }
+    require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, cat)))
+
+    // Replayed orders 10 minutes apart never share the 2 minute window,
+    // however fast they are processed.
+    repo.EXPECT().
+        UpsertPrice(mock.Anything, pid, 102.0).
+        Return(models.Price{ProductID: pid, CurrentPrice: 102.0}, nil).
+        Times(2)
+    bus.EXPECT().
+        Send(mock.Anything, pid.String(), mock.Anything).
+        Return(nil).
+        Times(2)
+    for i := 0; i < 2; i++ {
+        ord := map[string]any{
+            "type":    "order_placed",
+            "ts":      start.Add(time.Duration(i) * 10 * time.Minute),
+            "payload": map[string]any{"product_id": pid, "qty": 1},
+        }
+        ps, err := eng.HandleOrderEvent(context.Background(), mustJSON(t, ord))
+        require.NoError(t, err)
+        require.InDelta(t, 102.0, ps[0].CurrentPrice, 0.0001)
+    }
 }
 
 func TestComputePrice_Table(t *testing.T) {
@@ -15,15 +15,27 @@ type Event struct {
 type PricePayload struct {
     ProductID    string  `json:"product_id"`
     CurrentPrice float64 `json:"current_price"`
+    Currency     string  `json:"currency,omitempty"`
+    // SegmentPrices is what customers of each segment with a multiplier
+    // pay; the other segments pay CurrentPrice.
+    SegmentPrices map[string]float64 `json:"segment_prices,omitempty"`
 }
 
 func NewPriceEvent(p models.Price) ([]byte, error) {
+    // ts is when the price was set, so that a price announced again is
+    // not taken for a newer one.
+    ts := p.UpdatedAt
+    if ts.IsZero() {
+        ts = time.Now().UTC()
+    }
     e := Event{
         Type: "price_updated",
-        TS:   time.Now().UTC(),
+        TS:   ts,
         Payload: PricePayload{
             ProductID:    p.ProductID.String(),
             CurrentPrice: p.CurrentPrice,
+            Currency:     p.Currency,
+            SegmentPrices: p.SegmentPrices,
         },
     }
     return json.Marshal(e)
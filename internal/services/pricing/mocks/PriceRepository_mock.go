@@ -8,6 +8,8 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	uuid "github.com/google/uuid"
 )
 
@@ -81,6 +83,170 @@ func (_c *PriceRepository_GetPrice_Call) RunAndReturn(run func(context.Context,
 	return _c
 }
 
+// ListPrices provides a mock function with given fields: ctx
+func (_m *PriceRepository) ListPrices(ctx context.Context) ([]models.Price, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListPrices")
+	}
+
+	var r0 []models.Price
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.Price, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []models.Price); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Price)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PriceRepository_ListPrices_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ListPrices'
+type PriceRepository_ListPrices_Call struct {
+	*mock.Call
+}
+
+// ListPrices is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *PriceRepository_Expecter) ListPrices(ctx interface{}) *PriceRepository_ListPrices_Call {
+	return &PriceRepository_ListPrices_Call{Call: _e.mock.On("ListPrices", ctx)}
+}
+
+func (_c *PriceRepository_ListPrices_Call) Run(run func(ctx context.Context)) *PriceRepository_ListPrices_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *PriceRepository_ListPrices_Call) Return(_a0 []models.Price, _a1 error) *PriceRepository_ListPrices_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *PriceRepository_ListPrices_Call) RunAndReturn(run func(context.Context) ([]models.Price, error)) *PriceRepository_ListPrices_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RetirePrice provides a mock function with given fields: ctx, productID, at
+func (_m *PriceRepository) RetirePrice(ctx context.Context, productID uuid.UUID, at time.Time) error {
+	ret := _m.Called(ctx, productID, at)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RetirePrice")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, time.Time) error); ok {
+		r0 = rf(ctx, productID, at)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PriceRepository_RetirePrice_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetirePrice'
+type PriceRepository_RetirePrice_Call struct {
+	*mock.Call
+}
+
+// RetirePrice is a helper method to define mock.On call
+//   - ctx context.Context
+//   - productID uuid.UUID
+//   - at time.Time
+func (_e *PriceRepository_Expecter) RetirePrice(ctx interface{}, productID interface{}, at interface{}) *PriceRepository_RetirePrice_Call {
+	return &PriceRepository_RetirePrice_Call{Call: _e.mock.On("RetirePrice", ctx, productID, at)}
+}
+
+func (_c *PriceRepository_RetirePrice_Call) Run(run func(ctx context.Context, productID uuid.UUID, at time.Time)) *PriceRepository_RetirePrice_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uuid.UUID), args[2].(time.Time))
+	})
+	return _c
+}
+
+func (_c *PriceRepository_RetirePrice_Call) Return(_a0 error) *PriceRepository_RetirePrice_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *PriceRepository_RetirePrice_Call) RunAndReturn(run func(context.Context, uuid.UUID, time.Time) error) *PriceRepository_RetirePrice_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RetiredProducts provides a mock function with given fields: ctx
+func (_m *PriceRepository) RetiredProducts(ctx context.Context) ([]uuid.UUID, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RetiredProducts")
+	}
+
+	var r0 []uuid.UUID
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]uuid.UUID, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []uuid.UUID); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]uuid.UUID)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PriceRepository_RetiredProducts_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RetiredProducts'
+type PriceRepository_RetiredProducts_Call struct {
+	*mock.Call
+}
+
+// RetiredProducts is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *PriceRepository_Expecter) RetiredProducts(ctx interface{}) *PriceRepository_RetiredProducts_Call {
+	return &PriceRepository_RetiredProducts_Call{Call: _e.mock.On("RetiredProducts", ctx)}
+}
+
+func (_c *PriceRepository_RetiredProducts_Call) Run(run func(ctx context.Context)) *PriceRepository_RetiredProducts_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *PriceRepository_RetiredProducts_Call) Return(_a0 []uuid.UUID, _a1 error) *PriceRepository_RetiredProducts_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *PriceRepository_RetiredProducts_Call) RunAndReturn(run func(context.Context) ([]uuid.UUID, error)) *PriceRepository_RetiredProducts_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // UpsertPrice provides a mock function with given fields: ctx, productID, currentPrice
 func (_m *PriceRepository) UpsertPrice(ctx context.Context, productID uuid.UUID, currentPrice float64) (models.Price, error) {
 	ret := _m.Called(ctx, productID, currentPrice)
@@ -0,0 +1,171 @@
+package pricing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// LoadPolicies replaces the cached policies with those in the policy
+// repository. It is a no-op without one.
+func (e *Engine) LoadPolicies(ctx context.Context) error {
+	if e.policyRepo == nil {
+		return nil
+	}
+	ps, err := e.policyRepo.ListPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for scope := range e.policies {
+		e.policies[scope] = make(map[uuid.UUID]models.ScopedPolicy)
+	}
+	for _, p := range ps {
+		if _, ok := e.policies[p.Scope]; !ok {
+			slog.Warn("pricing: policy with unknown scope ignored", "scope", p.Scope, "id", p.ID)
+			continue
+		}
+		e.policies[p.Scope][p.ID] = p
+	}
+	slog.Info("pricing: policies loaded", "count", len(ps))
+	return nil
+}
+
+// Policies lists the policies of every scope, categories first.
+func (e *Engine) Policies() []models.ScopedPolicy {
+	e.mu.RLock()
+	var out []models.ScopedPolicy
+	for _, byID := range e.policies {
+		for _, p := range byID {
+			out = append(out, p)
+		}
+	}
+	e.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope < out[j].Scope
+		}
+		return bytes.Compare(out[i].ID[:], out[j].ID[:]) < 0
+	})
+	return out
+}
+
+// Policy returns the policy attached to id in scope.
+func (e *Engine) Policy(scope models.PolicyScope, id uuid.UUID) (models.ScopedPolicy, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	p, ok := e.policies[scope][id]
+	if !ok {
+		return p, fmt.Errorf("%w: %s policy %s", storage.ErrNotFound, scope, id)
+	}
+	return p, nil
+}
+
+// SetPolicy stores a policy and reprices the known products it applies to.
+func (e *Engine) SetPolicy(ctx context.Context, p models.ScopedPolicy) (models.ScopedPolicy, error) {
+	if p.Scope != models.PolicyScopeCategory && p.Scope != models.PolicyScopeProduct {
+		return p, fmt.Errorf("%w: unknown scope %q", ErrInvalidPolicy, p.Scope)
+	}
+	if err := ValidatePolicy(p.Policy); err != nil {
+		return p, err
+	}
+	p.UpdatedAt = time.Now().UTC()
+	if e.policyRepo != nil {
+		var err error
+		if p, err = e.policyRepo.UpsertPolicy(ctx, p); err != nil {
+			return p, err
+		}
+	}
+	e.mu.Lock()
+	e.policies[p.Scope][p.ID] = p
+	e.mu.Unlock()
+	return p, e.repriceAffected(ctx, p.Scope, p.ID)
+}
+
+// DeletePolicy removes a policy; affected products fall back to the
+// enclosing policies and are repriced.
+func (e *Engine) DeletePolicy(ctx context.Context, scope models.PolicyScope, id uuid.UUID) error {
+	if _, err := e.Policy(scope, id); err != nil {
+		return err
+	}
+	if e.policyRepo != nil {
+		if err := e.policyRepo.DeletePolicy(ctx, scope, id); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	delete(e.policies[scope], id)
+	e.mu.Unlock()
+	return e.repriceAffected(ctx, scope, id)
+}
+
+// EffectiveRules returns the rules the engine applies to a known product.
+func (e *Engine) EffectiveRules(productID uuid.UUID) (Rules, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if _, ok := e.retired[productID]; ok {
+		return Rules{}, ErrArchivedProduct
+	}
+	snap, ok := e.products[productID]
+	if !ok {
+		return Rules{}, ErrUnknownProduct
+	}
+	return e.rulesLocked(snap), nil
+}
+
+// rulesLocked resolves the defaults, then the category policies from the
+// root down, then the product's own policy. The caller holds e.mu.
+func (e *Engine) rulesLocked(snap models.ProductSnapshot) Rules {
+	r := DefaultRules()
+	for _, id := range snap.CategoryPath {
+		if p, ok := e.policies[models.PolicyScopeCategory][id]; ok {
+			r = r.Apply(p.Policy)
+		}
+	}
+	if p, ok := e.policies[models.PolicyScopeProduct][snap.ID]; ok {
+		r = r.Apply(p.Policy)
+	}
+	return r
+}
+
+// repriceAffected recomputes and publishes the price of every known product
+// a policy change may affect.
+func (e *Engine) repriceAffected(ctx context.Context, scope models.PolicyScope, id uuid.UUID) error {
+	e.mu.RLock()
+	var ids []uuid.UUID
+	for pid, snap := range e.products {
+		if (scope == models.PolicyScopeProduct && pid == id) ||
+			(scope == models.PolicyScopeCategory && slices.Contains(snap.CategoryPath, id)) {
+			ids = append(ids, pid)
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, pid := range ids {
+		stored, err := e.ComputeAndPersistCurrentPrice(ctx, pid)
+		if errors.Is(err, ErrArchivedProduct) || errors.Is(err, ErrUnknownProduct) {
+			continue // retired since the snapshot was taken
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := e.publish(ctx, *stored); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		slog.Info("pricing: repriced after policy change", "scope", scope, "id", id, "products", len(ids))
+	}
+	return nil
+}
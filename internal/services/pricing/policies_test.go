@@ -0,0 +1,110 @@
+package pricing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingBus struct{ sent int }
+
+func (b *recordingBus) Send(context.Context, string, []byte) error {
+	b.sent++
+	return nil
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestRules_ApplyAndBounds(t *testing.T) {
+	r := DefaultRules().Apply(models.PricingPolicy{DemandStep: ptr(0.05), MaxMultiplier: ptr(1.1)})
+	require.Equal(t, StrategyDynamic, r.Strategy)
+	require.Equal(t, 0.30, r.DemandCap)
+	require.InDelta(t, 105.0, r.Price(100, 10, 1), 0.0001)
+	require.InDelta(t, 110.0, r.Price(100, 0, 0), 0.0001, "capped by max_multiplier")
+
+	fixed := r.Apply(models.PricingPolicy{Strategy: ptr(StrategyFixed), MinMultiplier: ptr(1.05)})
+	require.InDelta(t, 105.0, fixed.Price(100, 0, 50), 0.0001, "fixed price raised to min_multiplier")
+}
+
+func TestValidatePolicy(t *testing.T) {
+	require.NoError(t, ValidatePolicy(models.PricingPolicy{}))
+	require.ErrorIs(t, ValidatePolicy(models.PricingPolicy{Strategy: ptr("surge")}), ErrInvalidPolicy)
+	require.ErrorIs(t, ValidatePolicy(models.PricingPolicy{DemandStep: ptr(-0.1)}), ErrInvalidPolicy)
+	require.ErrorIs(t, ValidatePolicy(models.PricingPolicy{LowStockThreshold: ptr(-1)}), ErrInvalidPolicy)
+	require.ErrorIs(t, ValidatePolicy(models.PricingPolicy{MinMultiplier: ptr(2.0), MaxMultiplier: ptr(1.5)}), ErrInvalidPolicy)
+}
+
+func TestEngine_CategoryPoliciesWithProductOverride(t *testing.T) {
+	ctx := context.Background()
+	prices := memory.NewPriceRepository()
+	policies := memory.NewPolicyRepository()
+	bus := &recordingBus{}
+	root, child := uuid.New(), uuid.New()
+
+	// Loaded at start: the root category disables the low-stock markup.
+	_, err := policies.UpsertPolicy(ctx, models.ScopedPolicy{
+		Scope:  models.PolicyScopeCategory,
+		ID:     root,
+		Policy: models.PricingPolicy{LowStockMarkup: ptr(0.0)},
+	})
+	require.NoError(t, err)
+	eng := NewEngine(prices, bus, WithPolicyRepository(policies))
+	require.NoError(t, eng.LoadPolicies(ctx))
+
+	pid := uuid.New()
+	ev := map[string]any{
+		"type": "product_created",
+		"ts":   time.Now().UTC(),
+		"payload": map[string]any{
+			"id": pid, "base_price": 100.0, "stock": 3,
+			"category_id": child, "category_path": []uuid.UUID{root, child},
+		},
+	}
+	require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, ev)))
+	p, err := prices.GetPrice(ctx, pid)
+	require.NoError(t, err)
+	require.InDelta(t, 100.0, p.CurrentPrice, 0.0001)
+	require.Equal(t, 1, bus.sent)
+
+	// A child category policy adds on top and reprices right away.
+	_, err = eng.SetPolicy(ctx, models.ScopedPolicy{
+		Scope:  models.PolicyScopeCategory,
+		ID:     child,
+		Policy: models.PricingPolicy{MinMultiplier: ptr(1.25)},
+	})
+	require.NoError(t, err)
+	p, err = prices.GetPrice(ctx, pid)
+	require.NoError(t, err)
+	require.InDelta(t, 125.0, p.CurrentPrice, 0.0001)
+	require.Equal(t, 2, bus.sent)
+
+	// The product's own policy wins over its categories.
+	_, err = eng.SetPolicy(ctx, models.ScopedPolicy{
+		Scope:  models.PolicyScopeProduct,
+		ID:     pid,
+		Policy: models.PricingPolicy{Strategy: ptr(StrategyFixed), MinMultiplier: ptr(0.0)},
+	})
+	require.NoError(t, err)
+	rules, err := eng.EffectiveRules(pid)
+	require.NoError(t, err)
+	require.Equal(t, StrategyFixed, rules.Strategy)
+	require.Equal(t, 0.0, rules.LowStockMarkup)
+	p, err = prices.GetPrice(ctx, pid)
+	require.NoError(t, err)
+	require.InDelta(t, 100.0, p.CurrentPrice, 0.0001)
+
+	stored, err := policies.ListPolicies(ctx)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+
+	require.NoError(t, eng.DeletePolicy(ctx, models.PolicyScopeProduct, pid))
+	p, err = prices.GetPrice(ctx, pid)
+	require.NoError(t, err)
+	require.InDelta(t, 125.0, p.CurrentPrice, 0.0001)
+}
@@ -0,0 +1,92 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrQuotesDisabled is returned by Quote when no quote key is
+	// configured.
+	ErrQuotesDisabled = errors.New("quotes are disabled")
+	// ErrInvalidQty is returned for quotes of less than one item.
+	ErrInvalidQty = errors.New("qty must be positive")
+)
+
+// DefaultQuoteTTL is how long quotes are good for unless WithQuotes says
+// otherwise.
+const DefaultQuoteTTL = 5 * time.Minute
+
+// WithQuotes lets the engine give out quotes signed by s and good for ttl.
+func WithQuotes(s *quote.Signer, ttl time.Duration) Option {
+	return func(e *Engine) {
+		e.signer = s
+		e.quoteTTL = ttl
+		if e.quoteTTL <= 0 {
+			e.quoteTTL = DefaultQuoteTTL
+		}
+	}
+}
+
+// Quote fixes the current price of qty of a product for customers of
+// segment, or the plain price when segment is empty, and returns it with
+// its signed token.
+func (e *Engine) Quote(ctx context.Context, productID uuid.UUID, qty int, segment string) (quote.Quote, string, error) {
+	if e.signer == nil {
+		return quote.Quote{}, "", ErrQuotesDisabled
+	}
+	if qty < 1 {
+		return quote.Quote{}, "", ErrInvalidQty
+	}
+	var p models.Price
+	var err error
+	if segment == "" {
+		p, err = e.CurrentPrice(ctx, productID)
+	} else {
+		p, err = e.SegmentPrice(ctx, productID, segment)
+	}
+	if err != nil {
+		return quote.Quote{}, "", err
+	}
+	q := quote.Quote{
+		ProductID: productID,
+		Qty:       qty,
+		UnitPrice: p.CurrentPrice,
+		Currency:  p.Currency,
+		Segment:   segment,
+		ExpiresAt: time.Now().UTC().Add(e.quoteTTL).Truncate(time.Second),
+	}
+	token, err := e.signer.Sign(q)
+	if err != nil {
+		return quote.Quote{}, "", err
+	}
+	return q, token, nil
+}
+
+// CurrentPrice returns the stored price of a product, computing it first
+// when there is none yet.
+func (e *Engine) CurrentPrice(ctx context.Context, productID uuid.UUID) (models.Price, error) {
+	if e.Retired(productID) {
+		return models.Price{}, ErrArchivedProduct
+	}
+	p, err := e.repo.GetPrice(ctx, productID)
+	if errors.Is(err, storage.ErrNotFound) {
+		var computed *models.Price
+		computed, err = e.ComputeAndPersistCurrentPrice(ctx, productID)
+		if computed != nil {
+			p = *computed
+		}
+	}
+	if err != nil {
+		return models.Price{}, err
+	}
+	p.Currency = e.currency
+	return p, nil
+}
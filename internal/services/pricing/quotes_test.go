@@ -0,0 +1,63 @@
+package pricing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/quote"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	ctx := context.Background()
+	signer := quote.NewSigner([]byte("secret"))
+	eng := NewEngine(memory.NewPriceRepository(), &recordingBus{}, WithQuotes(signer, time.Minute))
+
+	pid := uuid.New()
+	require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, map[string]any{
+		"type":    "product_created",
+		"ts":      time.Now().UTC(),
+		"payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 10},
+	})))
+
+	_, err := eng.SetSegmentMultiplier(ctx, models.SegmentLoyal, 0.9)
+	require.NoError(t, err)
+	q, token, err := eng.Quote(ctx, pid, 3, models.SegmentRegular)
+	require.NoError(t, err)
+	require.Equal(t, 3, q.Qty)
+	require.InDelta(t, 100.0, q.UnitPrice, 0.0001)
+	require.Equal(t, models.SegmentRegular, q.Segment)
+	require.Equal(t, DefaultCurrency, q.Currency)
+	require.WithinDuration(t, time.Now().Add(time.Minute), q.ExpiresAt, 2*time.Second)
+
+	got, err := signer.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, q, got)
+
+	loyal, _, err := eng.Quote(ctx, pid, 3, models.SegmentLoyal)
+	require.NoError(t, err)
+	require.InDelta(t, 90.0, loyal.UnitPrice, 0.0001, "quoted at the segment price")
+
+	plain, plainToken, err := eng.Quote(ctx, pid, 3, "")
+	require.NoError(t, err)
+	require.InDelta(t, 100.0, plain.UnitPrice, 0.0001, "no segment, plain price")
+	require.Empty(t, plain.Segment)
+	got, err = signer.Verify(plainToken)
+	require.NoError(t, err)
+	require.Equal(t, plain, got)
+
+	_, _, err = eng.Quote(ctx, pid, 0, models.SegmentNew)
+	require.ErrorIs(t, err, ErrInvalidQty)
+	_, _, err = eng.Quote(ctx, pid, 1, "vip")
+	require.ErrorIs(t, err, ErrUnknownSegment)
+	_, _, err = eng.Quote(ctx, uuid.New(), 1, models.SegmentNew)
+	require.ErrorIs(t, err, ErrUnknownProduct)
+
+	_, _, err = NewEngine(memory.NewPriceRepository(), &recordingBus{}).Quote(ctx, pid, 1, models.SegmentNew)
+	require.ErrorIs(t, err, ErrQuotesDisabled)
+}
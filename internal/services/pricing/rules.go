@@ -0,0 +1,121 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"dynamic-pricing/internal/models"
+)
+
+const (
+	StrategyDynamic = "dynamic"
+	StrategyFixed   = "fixed"
+)
+
+// ErrInvalidPolicy is returned for policies with unknown strategies or
+// negative or inverted values.
+var ErrInvalidPolicy = errors.New("invalid pricing policy")
+
+// Rules is a fully resolved pricing policy. A zero MinMultiplier or
+// MaxMultiplier means unbounded.
+type Rules struct {
+	Strategy          string  `json:"strategy"`
+	MinMultiplier     float64 `json:"min_multiplier"`
+	MaxMultiplier     float64 `json:"max_multiplier"`
+	DemandStep        float64 `json:"demand_step"`
+	DemandCap         float64 `json:"demand_cap"`
+	LowStockThreshold int     `json:"low_stock_threshold"`
+	LowStockMarkup    float64 `json:"low_stock_markup"`
+	OutOfStockMarkup  float64 `json:"out_of_stock_markup"`
+}
+
+// DefaultRules is what the engine applies when no policy says otherwise.
+func DefaultRules() Rules {
+	return Rules{
+		Strategy:          StrategyDynamic,
+		DemandStep:        0.02,
+		DemandCap:         0.30,
+		LowStockThreshold: 5,
+		LowStockMarkup:    0.20,
+		OutOfStockMarkup:  0.50,
+	}
+}
+
+// Apply overrides r with the fields set in p.
+func (r Rules) Apply(p models.PricingPolicy) Rules {
+	if p.Strategy != nil {
+		r.Strategy = *p.Strategy
+	}
+	if p.MinMultiplier != nil {
+		r.MinMultiplier = *p.MinMultiplier
+	}
+	if p.MaxMultiplier != nil {
+		r.MaxMultiplier = *p.MaxMultiplier
+	}
+	if p.DemandStep != nil {
+		r.DemandStep = *p.DemandStep
+	}
+	if p.DemandCap != nil {
+		r.DemandCap = *p.DemandCap
+	}
+	if p.LowStockThreshold != nil {
+		r.LowStockThreshold = *p.LowStockThreshold
+	}
+	if p.LowStockMarkup != nil {
+		r.LowStockMarkup = *p.LowStockMarkup
+	}
+	if p.OutOfStockMarkup != nil {
+		r.OutOfStockMarkup = *p.OutOfStockMarkup
+	}
+	return r
+}
+
+// Price computes the price for base price, stock and units ordered in the
+// demand window, rounded to cents.
+func (r Rules) Price(base float64, stock int, demand int) float64 {
+	m := 1.0
+	if r.Strategy != StrategyFixed {
+		m += math.Min(r.DemandCap, float64(demand)*r.DemandStep)
+		if stock <= r.LowStockThreshold {
+			m += r.LowStockMarkup
+		}
+		if stock <= 0 {
+			m += r.OutOfStockMarkup
+		}
+	}
+	if r.MinMultiplier > 0 && m < r.MinMultiplier {
+		m = r.MinMultiplier
+	}
+	if r.MaxMultiplier > 0 && m > r.MaxMultiplier {
+		m = r.MaxMultiplier
+	}
+	v := base * m
+	return math.Round(v*100) / 100
+}
+
+// ValidatePolicy checks the fields set in p.
+func ValidatePolicy(p models.PricingPolicy) error {
+	if p.Strategy != nil && *p.Strategy != StrategyDynamic && *p.Strategy != StrategyFixed {
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidPolicy, *p.Strategy)
+	}
+	for name, v := range map[string]*float64{
+		"min_multiplier":      p.MinMultiplier,
+		"max_multiplier":      p.MaxMultiplier,
+		"demand_step":         p.DemandStep,
+		"demand_cap":          p.DemandCap,
+		"low_stock_markup":    p.LowStockMarkup,
+		"out_of_stock_markup": p.OutOfStockMarkup,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%w: %s must not be negative", ErrInvalidPolicy, name)
+		}
+	}
+	if p.LowStockThreshold != nil && *p.LowStockThreshold < 0 {
+		return fmt.Errorf("%w: low_stock_threshold must not be negative", ErrInvalidPolicy)
+	}
+	if p.MinMultiplier != nil && p.MaxMultiplier != nil && *p.MaxMultiplier > 0 && *p.MinMultiplier > *p.MaxMultiplier {
+		return fmt.Errorf("%w: min_multiplier is greater than max_multiplier", ErrInvalidPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// SegmentRepository stores the price multipliers of customer segments.
+type SegmentRepository interface {
+	ListSegmentMultipliers(ctx context.Context) ([]models.SegmentMultiplier, error)
+	UpsertSegmentMultiplier(ctx context.Context, m models.SegmentMultiplier) (models.SegmentMultiplier, error)
+	DeleteSegmentMultiplier(ctx context.Context, segment string) error
+}
+
+var (
+	// ErrUnknownSegment is returned for segments other than those in
+	// models.ValidSegment.
+	ErrUnknownSegment = errors.New("unknown customer segment")
+	// ErrInvalidMultiplier is returned for segment multipliers that are not
+	// positive.
+	ErrInvalidMultiplier = errors.New("multiplier must be positive")
+)
+
+// WithSegmentRepository persists segment multipliers in r. Without it they
+// live only in memory.
+func WithSegmentRepository(r SegmentRepository) Option {
+	return func(e *Engine) { e.segmentRepo = r }
+}
+
+// LoadSegments replaces the cached segment multipliers with those in the
+// segment repository. It is a no-op without one.
+func (e *Engine) LoadSegments(ctx context.Context) error {
+	if e.segmentRepo == nil {
+		return nil
+	}
+	ms, err := e.segmentRepo.ListSegmentMultipliers(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.segments = make(map[string]models.SegmentMultiplier, len(ms))
+	for _, m := range ms {
+		if !models.ValidSegment(m.Segment) {
+			slog.Warn("pricing: multiplier of unknown segment ignored", "segment", m.Segment)
+			continue
+		}
+		e.segments[m.Segment] = m
+	}
+	slog.Info("pricing: segment multipliers loaded", "count", len(e.segments))
+	return nil
+}
+
+// SegmentMultipliers lists the configured segment multipliers by segment.
+// Segments without one pay the plain price.
+func (e *Engine) SegmentMultipliers() []models.SegmentMultiplier {
+	e.mu.RLock()
+	out := make([]models.SegmentMultiplier, 0, len(e.segments))
+	for _, m := range e.segments {
+		out = append(out, m)
+	}
+	e.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Segment < out[j].Segment })
+	return out
+}
+
+// SetSegmentMultiplier makes the prices of segment multiplier times the
+// plain price, and republishes all prices with their new segment prices.
+func (e *Engine) SetSegmentMultiplier(ctx context.Context, segment string, multiplier float64) (models.SegmentMultiplier, error) {
+	m := models.SegmentMultiplier{Segment: segment, Multiplier: multiplier, UpdatedAt: time.Now().UTC()}
+	if !models.ValidSegment(segment) {
+		return m, fmt.Errorf("%w: %q", ErrUnknownSegment, segment)
+	}
+	if !(multiplier > 0) || math.IsInf(multiplier, 0) {
+		return m, ErrInvalidMultiplier
+	}
+	if e.segmentRepo != nil {
+		var err error
+		if m, err = e.segmentRepo.UpsertSegmentMultiplier(ctx, m); err != nil {
+			return m, err
+		}
+	}
+	e.mu.Lock()
+	e.segments[segment] = m
+	e.mu.Unlock()
+	_, err := e.RepublishPrices(ctx)
+	return m, err
+}
+
+// DeleteSegmentMultiplier makes a segment pay the plain price again, and
+// republishes all prices without its segment price.
+func (e *Engine) DeleteSegmentMultiplier(ctx context.Context, segment string) error {
+	if !models.ValidSegment(segment) {
+		return fmt.Errorf("%w: %q", ErrUnknownSegment, segment)
+	}
+	e.mu.RLock()
+	_, ok := e.segments[segment]
+	e.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: multiplier of segment %s", storage.ErrNotFound, segment)
+	}
+	if e.segmentRepo != nil {
+		if err := e.segmentRepo.DeleteSegmentMultiplier(ctx, segment); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	delete(e.segments, segment)
+	e.mu.Unlock()
+	_, err := e.RepublishPrices(ctx)
+	return err
+}
+
+// SegmentPrice returns the current price of a product for a customer
+// segment: the plain price times the segment's multiplier, rounded to cents.
+func (e *Engine) SegmentPrice(ctx context.Context, productID uuid.UUID, segment string) (models.Price, error) {
+	if !models.ValidSegment(segment) {
+		return models.Price{}, fmt.Errorf("%w: %q", ErrUnknownSegment, segment)
+	}
+	p, err := e.CurrentPrice(ctx, productID)
+	if err != nil {
+		return p, err
+	}
+	e.mu.RLock()
+	m, ok := e.segments[segment]
+	e.mu.RUnlock()
+	if ok {
+		p.CurrentPrice = applyMultiplier(p.CurrentPrice, m.Multiplier)
+	}
+	p.Segment = segment
+	return p, nil
+}
+
+// segmentPrices is price for each segment with a multiplier, or nil when
+// there are none.
+func (e *Engine) segmentPrices(price float64) map[string]float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.segments) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(e.segments))
+	for segment, m := range e.segments {
+		out[segment] = applyMultiplier(price, m.Multiplier)
+	}
+	return out
+}
+
+// applyMultiplier is price times multiplier, rounded to cents.
+func applyMultiplier(price, multiplier float64) float64 {
+	return math.Round(price*multiplier*100) / 100
+}
@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+	"dynamic-pricing/internal/storage/memory"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// lastBus keeps the last message sent.
+type lastBus struct {
+	sent int
+	last []byte
+}
+
+func (b *lastBus) Send(_ context.Context, _ string, msg []byte) error {
+	b.sent++
+	b.last = msg
+	return nil
+}
+
+func TestEngine_PublishesSegmentPrices(t *testing.T) {
+	ctx := context.Background()
+	bus := &lastBus{}
+	eng := NewEngine(memory.NewPriceRepository(), bus)
+	pid := uuid.New()
+	require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, map[string]any{
+		"type":    "product_created",
+		"ts":      time.Now().UTC(),
+		"payload": map[string]any{"id": pid, "base_price": 100.0, "stock": 100},
+	})))
+	require.Equal(t, 1, bus.sent)
+
+	// A new multiplier republishes the price with the segment's price.
+	_, err := eng.SetSegmentMultiplier(ctx, models.SegmentLoyal, 0.9)
+	require.NoError(t, err)
+	require.Equal(t, 2, bus.sent)
+	var ev struct {
+		Payload PricePayload `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(bus.last, &ev))
+	require.Equal(t, 100.0, ev.Payload.CurrentPrice)
+	require.Equal(t, map[string]float64{models.SegmentLoyal: 90}, ev.Payload.SegmentPrices)
+
+	require.NoError(t, eng.DeleteSegmentMultiplier(ctx, models.SegmentLoyal))
+	require.Equal(t, 3, bus.sent)
+	ev.Payload = PricePayload{}
+	require.NoError(t, json.Unmarshal(bus.last, &ev))
+	require.Empty(t, ev.Payload.SegmentPrices)
+}
+
+func TestEngine_SegmentPrice(t *testing.T) {
+	ctx := context.Background()
+	prices := memory.NewPriceRepository()
+	segments := memory.NewSegmentRepository()
+	_, err := segments.UpsertSegmentMultiplier(ctx, models.SegmentMultiplier{Segment: models.SegmentLoyal, Multiplier: 0.9, UpdatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+	eng := NewEngine(prices, &recordingBus{}, WithSegmentRepository(segments))
+	require.NoError(t, eng.LoadSegments(ctx))
+
+	pid := uuid.New()
+	ev := map[string]any{
+		"type":    "product_created",
+		"ts":      time.Now().UTC(),
+		"payload": map[string]any{"id": pid, "base_price": 99.99, "stock": 100},
+	}
+	require.NoError(t, eng.HandleCatalogEvent(mustJSON(t, ev)))
+
+	p, err := eng.SegmentPrice(ctx, pid, models.SegmentLoyal)
+	require.NoError(t, err)
+	require.Equal(t, 89.99, p.CurrentPrice, "rounded to cents")
+	require.Equal(t, models.SegmentLoyal, p.Segment)
+
+	p, err = eng.SegmentPrice(ctx, pid, models.SegmentRegular)
+	require.NoError(t, err)
+	require.Equal(t, 99.99, p.CurrentPrice, "no multiplier, plain price")
+
+	_, err = eng.SegmentPrice(ctx, pid, "vip")
+	require.ErrorIs(t, err, ErrUnknownSegment)
+
+	_, err = eng.SetSegmentMultiplier(ctx, models.SegmentNew, 0)
+	require.ErrorIs(t, err, ErrInvalidMultiplier)
+	_, err = eng.SetSegmentMultiplier(ctx, models.SegmentNew, 0.5)
+	require.NoError(t, err)
+	p, err = eng.SegmentPrice(ctx, pid, models.SegmentNew)
+	require.NoError(t, err)
+	require.Equal(t, 50.0, p.CurrentPrice)
+
+	require.NoError(t, eng.DeleteSegmentMultiplier(ctx, models.SegmentLoyal))
+	require.ErrorIs(t, eng.DeleteSegmentMultiplier(ctx, models.SegmentLoyal), storage.ErrNotFound)
+	ms, err := segments.ListSegmentMultipliers(ctx)
+	require.NoError(t, err)
+	require.Len(t, ms, 1, "changes are persisted")
+	require.Equal(t, models.SegmentNew, ms[0].Segment)
+}
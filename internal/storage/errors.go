@@ -0,0 +1,12 @@
+// Package storage holds the error values shared by all repository
+// implementations (see pg and memory), so callers don't depend on a driver.
+package storage
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the requested row, or a row it references, does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write violates a uniqueness constraint.
+	ErrConflict = errors.New("already exists")
+)
@@ -0,0 +1,486 @@
+// Package memory provides thread-safe in-memory repositories with the same
+// behaviour and storage errors as the Postgres ones in package pg. They back
+// the all-in-one binary and tests that should not need a database.
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type CatalogRepository struct {
+	mu         sync.RWMutex
+	products   map[uuid.UUID]models.Product
+	categories map[uuid.UUID]models.Category
+	// movements holds each product's stock ledger, oldest first.
+	movements  map[uuid.UUID][]models.InventoryMovement
+	movementID int64
+	// byOrder indexes the movements caused by orders.
+	byOrder      map[orderMovement]models.InventoryMovement
+	reservations map[uuid.UUID]models.Reservation
+}
+
+type orderMovement struct {
+	orderID   uuid.UUID
+	productID uuid.UUID
+	reason    models.MovementReason
+}
+
+func NewCatalogRepository() *CatalogRepository {
+	return &CatalogRepository{
+		products:     make(map[uuid.UUID]models.Product),
+		categories:   make(map[uuid.UUID]models.Category),
+		movements:    make(map[uuid.UUID][]models.InventoryMovement),
+		byOrder:      make(map[orderMovement]models.InventoryMovement),
+		reservations: make(map[uuid.UUID]models.Reservation),
+	}
+}
+
+func (r *CatalogRepository) Create(ctx context.Context, p models.Product) (models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.products[p.ID]; ok {
+		return p, fmt.Errorf("%w: product %s", storage.ErrConflict, p.ID)
+	}
+	if err := r.checkCategory(p.CategoryID); err != nil {
+		return p, err
+	}
+	p.UpdatedAt = now()
+	p.Version = 1
+	r.products[p.ID] = p
+	return p, nil
+}
+
+func (r *CatalogRepository) CreateBatch(ctx context.Context, ps []models.Product) ([]models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[uuid.UUID]bool, len(ps))
+	for _, p := range ps {
+		if _, ok := r.products[p.ID]; ok || seen[p.ID] {
+			return ps, fmt.Errorf("%w: product %s", storage.ErrConflict, p.ID)
+		}
+		seen[p.ID] = true
+		if err := r.checkCategory(p.CategoryID); err != nil {
+			return ps, err
+		}
+	}
+	ts := now()
+	for i := range ps {
+		ps[i].UpdatedAt = ts
+		ps[i].Version = 1
+		r.products[ps[i].ID] = ps[i]
+	}
+	return ps, nil
+}
+
+func (r *CatalogRepository) Update(ctx context.Context, id uuid.UUID, name string, basePrice float64, version int64) (models.Product, error) {
+	return r.update(id, version, func(p *models.Product) {
+		p.Name = name
+		p.BasePrice = basePrice
+	})
+}
+
+func (r *CatalogRepository) UpdateStock(ctx context.Context, id uuid.UUID, stock int, version int64) (models.Product, error) {
+	return r.update(id, version, func(p *models.Product) {
+		if delta := stock - p.Stock; delta != 0 {
+			r.movementID++
+			r.movements[id] = append(r.movements[id], models.InventoryMovement{
+				ID: r.movementID, ProductID: id, Delta: delta, Reason: models.MovementAdjustment,
+				StockAfter: stock, CreatedAt: p.UpdatedAt,
+			})
+		}
+		p.Stock = stock
+	})
+}
+
+func (r *CatalogRepository) AdjustStock(ctx context.Context, m models.InventoryMovement) (models.Product, models.InventoryMovement, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[m.ProductID]
+	if !ok || p.ArchivedAt != nil || !covers(p, 0, m.Delta) {
+		return models.Product{}, m, fmt.Errorf("%w: product %s", storage.ErrNotFound, m.ProductID)
+	}
+	if err := r.checkOrderMovement(m); err != nil {
+		return models.Product{}, m, err
+	}
+	m = r.applyMovement(&p, m)
+	return p, m, nil
+}
+
+// covers reports whether p can take delta, counting freed reserved units
+// as available again.
+func covers(p models.Product, freed, delta int) bool {
+	return p.Backorders || delta >= 0 || p.Available()+freed+delta >= 0
+}
+
+// checkOrderMovement reports a second movement of an order for the same
+// reason the way the unique index does in Postgres. The caller holds r.mu.
+func (r *CatalogRepository) checkOrderMovement(m models.InventoryMovement) error {
+	if m.OrderID == nil {
+		return nil
+	}
+	if _, ok := r.byOrder[orderMovement{*m.OrderID, m.ProductID, m.Reason}]; ok {
+		return fmt.Errorf("%w: %s of order %s for product %s", storage.ErrConflict, m.Reason, *m.OrderID, m.ProductID)
+	}
+	return nil
+}
+
+// applyMovement adds m to p, stores both and returns m as recorded. The
+// caller holds r.mu.
+func (r *CatalogRepository) applyMovement(p *models.Product, m models.InventoryMovement) models.InventoryMovement {
+	r.movementID++
+	m.ID = r.movementID
+	m.CreatedAt = now()
+	p.Stock += m.Delta
+	p.UpdatedAt = m.CreatedAt
+	p.Version++
+	m.StockAfter = p.Stock
+	r.products[p.ID] = *p
+	r.movements[p.ID] = append(r.movements[p.ID], m)
+	if m.OrderID != nil {
+		r.byOrder[orderMovement{*m.OrderID, m.ProductID, m.Reason}] = m
+	}
+	return m
+}
+
+func (r *CatalogRepository) Reserve(ctx context.Context, res models.Reservation) (models.Product, models.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[res.ProductID]
+	if !ok || p.ArchivedAt != nil || !covers(p, 0, -res.Qty) {
+		return models.Product{}, res, fmt.Errorf("%w: product %s", storage.ErrNotFound, res.ProductID)
+	}
+	if _, ok := r.reservations[res.ID]; ok {
+		return models.Product{}, res, fmt.Errorf("%w: reservation %s", storage.ErrConflict, res.ID)
+	}
+	res.Status = models.ReservationActive
+	res.CreatedAt = now()
+	res.UpdatedAt = res.CreatedAt
+	p.Reserved += res.Qty
+	r.products[p.ID] = p
+	r.reservations[res.ID] = res
+	return p, res, nil
+}
+
+func (r *CatalogRepository) GetReservation(ctx context.Context, id uuid.UUID) (models.Reservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res, ok := r.reservations[id]
+	if !ok {
+		return res, fmt.Errorf("%w: reservation %s", storage.ErrNotFound, id)
+	}
+	return res, nil
+}
+
+func (r *CatalogRepository) ReleaseReservation(ctx context.Context, id uuid.UUID, status models.ReservationStatus) (models.Product, models.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.reservations[id]
+	if !ok || res.Status != models.ReservationActive {
+		return models.Product{}, res, fmt.Errorf("%w: active reservation %s", storage.ErrNotFound, id)
+	}
+	p := r.release(res, status, now())
+	return p, r.reservations[id], nil
+}
+
+// release ends an active reservation and frees its stock. The caller holds
+// r.mu.
+func (r *CatalogRepository) release(res models.Reservation, status models.ReservationStatus, at time.Time) models.Product {
+	res.Status = status
+	res.UpdatedAt = at
+	r.reservations[res.ID] = res
+	p := r.products[res.ProductID]
+	p.Reserved -= res.Qty
+	r.products[p.ID] = p
+	return p
+}
+
+func (r *CatalogRepository) ConfirmReservation(ctx context.Context, id uuid.UUID, m models.InventoryMovement) (models.Product, models.Reservation, models.InventoryMovement, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.reservations[id]
+	if !ok || res.Status != models.ReservationActive || res.ProductID != m.ProductID {
+		return models.Product{}, res, m, fmt.Errorf("%w: active reservation %s of product %s", storage.ErrNotFound, id, m.ProductID)
+	}
+	p, ok := r.products[m.ProductID]
+	if !ok || p.ArchivedAt != nil || !covers(p, res.Qty, m.Delta) {
+		return models.Product{}, res, m, fmt.Errorf("%w: product %s", storage.ErrNotFound, m.ProductID)
+	}
+	if err := r.checkOrderMovement(m); err != nil {
+		return models.Product{}, res, m, err
+	}
+	p = r.release(res, models.ReservationConfirmed, now())
+	m = r.applyMovement(&p, m)
+	res = r.reservations[id]
+	res.OrderID = m.OrderID
+	r.reservations[id] = res
+	return p, res, m, nil
+}
+
+func (r *CatalogRepository) ExpireReservations(ctx context.Context, at time.Time) ([]models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	freed := make(map[uuid.UUID]bool)
+	for _, res := range r.reservations {
+		if res.Status == models.ReservationActive && !res.ExpiresAt.After(at) {
+			r.release(res, models.ReservationExpired, now())
+			freed[res.ProductID] = true
+		}
+	}
+	out := make([]models.Product, 0, len(freed))
+	for id := range freed {
+		out = append(out, r.products[id])
+	}
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i].ID[:], out[j].ID[:]) < 0 })
+	return out, nil
+}
+
+func (r *CatalogRepository) OrderMovements(ctx context.Context, orderID uuid.UUID, reason models.MovementReason) ([]models.InventoryMovement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []models.InventoryMovement
+	for k, m := range r.byOrder {
+		if k.orderID == orderID && k.reason == reason {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (r *CatalogRepository) ListMovements(ctx context.Context, q models.MovementQuery) ([]models.InventoryMovement, error) {
+	r.mu.RLock()
+	out := slices.Clone(r.movements[q.ProductID])
+	r.mu.RUnlock()
+	slices.Reverse(out)
+	if q.BeforeID > 0 {
+		out = slices.DeleteFunc(out, func(m models.InventoryMovement) bool { return m.ID >= q.BeforeID })
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (r *CatalogRepository) SetBackorders(ctx context.Context, id uuid.UUID, enabled bool) (models.Product, error) {
+	return r.update(id, 0, func(p *models.Product) { p.Backorders = enabled })
+}
+
+func (r *CatalogRepository) SetCategory(ctx context.Context, id uuid.UUID, categoryID *uuid.UUID) (models.Product, error) {
+	r.mu.RLock()
+	err := r.checkCategory(categoryID)
+	r.mu.RUnlock()
+	if err != nil {
+		return models.Product{}, err
+	}
+	return r.update(id, 0, func(p *models.Product) { p.CategoryID = categoryID })
+}
+
+func (r *CatalogRepository) Get(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.products[id]
+	if !ok {
+		return models.Product{}, fmt.Errorf("%w: product %s", storage.ErrNotFound, id)
+	}
+	return p, nil
+}
+
+func (r *CatalogRepository) Archive(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	return r.update(id, 0, func(p *models.Product) {
+		at := p.UpdatedAt
+		p.ArchivedAt = &at
+	})
+}
+
+func (r *CatalogRepository) Delete(ctx context.Context, id uuid.UUID) (models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok {
+		return models.Product{}, fmt.Errorf("%w: product %s", storage.ErrNotFound, id)
+	}
+	delete(r.products, id)
+	for _, m := range r.movements[id] {
+		if m.OrderID != nil {
+			delete(r.byOrder, orderMovement{*m.OrderID, m.ProductID, m.Reason})
+		}
+	}
+	delete(r.movements, id)
+	for rid, res := range r.reservations {
+		if res.ProductID == id {
+			delete(r.reservations, rid)
+		}
+	}
+	return p, nil
+}
+
+func (r *CatalogRepository) List(ctx context.Context, q models.ProductQuery) ([]models.Product, error) {
+	r.mu.RLock()
+	out := make([]models.Product, 0, len(r.products))
+	search := strings.ToLower(q.Search)
+	for _, p := range r.products {
+		switch {
+		case search != "" && !strings.Contains(strings.ToLower(p.Name), search):
+		case q.MinPrice != nil && p.BasePrice < *q.MinPrice:
+		case q.MaxPrice != nil && p.BasePrice > *q.MaxPrice:
+		case q.InStock && p.Available() <= 0:
+		case !q.IncludeArchived && p.ArchivedAt != nil:
+		case len(q.CategoryIDs) > 0 && (p.CategoryID == nil || !slices.Contains(q.CategoryIDs, *p.CategoryID)):
+		case q.After != nil && compareProducts(*q.After, p, q.Sort, q.Desc) >= 0:
+		default:
+			out = append(out, p)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return compareProducts(out[i], out[j], q.Sort, q.Desc) < 0 })
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+// compareProducts orders a and b by the sort column, then by ID, matching
+// the Postgres ordering (byte-wise names, "C" collation).
+func compareProducts(a, b models.Product, by models.ProductSort, desc bool) int {
+	var c int
+	switch by {
+	case models.SortByPrice:
+		c = cmp.Compare(a.BasePrice, b.BasePrice)
+	case models.SortByUpdatedAt:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	default:
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if c == 0 {
+		c = bytes.Compare(a.ID[:], b.ID[:])
+	}
+	if desc {
+		c = -c
+	}
+	return c
+}
+
+// update applies a change to a live product at version, or at any version
+// when version is 0, and bumps its version.
+func (r *CatalogRepository) update(id uuid.UUID, version int64, apply func(*models.Product)) (models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok || p.ArchivedAt != nil || (version != 0 && p.Version != version) {
+		return models.Product{}, fmt.Errorf("%w: product %s", storage.ErrNotFound, id)
+	}
+	p.UpdatedAt = now()
+	p.Version++
+	apply(&p)
+	r.products[id] = p
+	return p, nil
+}
+
+func (r *CatalogRepository) CreateCategory(ctx context.Context, c models.Category) (models.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.categories[c.ID]; ok {
+		return c, fmt.Errorf("%w: category %s", storage.ErrConflict, c.ID)
+	}
+	if err := r.checkCategory(c.ParentID); err != nil {
+		return c, err
+	}
+	c.CreatedAt = now()
+	c.UpdatedAt = c.CreatedAt
+	r.categories[c.ID] = c
+	return c, nil
+}
+
+func (r *CatalogRepository) GetCategory(ctx context.Context, id uuid.UUID) (models.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.categories[id]
+	if !ok {
+		return models.Category{}, fmt.Errorf("%w: category %s", storage.ErrNotFound, id)
+	}
+	return c, nil
+}
+
+func (r *CatalogRepository) UpdateCategory(ctx context.Context, id uuid.UUID, name string, parentID *uuid.UUID) (models.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.categories[id]
+	if !ok {
+		return models.Category{}, fmt.Errorf("%w: category %s", storage.ErrNotFound, id)
+	}
+	if err := r.checkCategory(parentID); err != nil {
+		return models.Category{}, err
+	}
+	c.Name = name
+	c.ParentID = parentID
+	c.UpdatedAt = now()
+	r.categories[id] = c
+	return c, nil
+}
+
+func (r *CatalogRepository) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.categories[id]; !ok {
+		return fmt.Errorf("%w: category %s", storage.ErrNotFound, id)
+	}
+	for _, c := range r.categories {
+		if c.ParentID != nil && *c.ParentID == id {
+			return fmt.Errorf("%w: category %s has subcategories or products", storage.ErrConflict, id)
+		}
+	}
+	for _, p := range r.products {
+		if p.CategoryID != nil && *p.CategoryID == id {
+			return fmt.Errorf("%w: category %s has subcategories or products", storage.ErrConflict, id)
+		}
+	}
+	delete(r.categories, id)
+	return nil
+}
+
+func (r *CatalogRepository) ListCategories(ctx context.Context) ([]models.Category, error) {
+	r.mu.RLock()
+	out := make([]models.Category, 0, len(r.categories))
+	for _, c := range r.categories {
+		out = append(out, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return bytes.Compare(out[i].ID[:], out[j].ID[:]) < 0
+	})
+	return out, nil
+}
+
+// checkCategory reports a missing category the way the foreign key does in
+// Postgres. The caller holds r.mu.
+func (r *CatalogRepository) checkCategory(id *uuid.UUID) error {
+	if id == nil {
+		return nil
+	}
+	if _, ok := r.categories[*id]; !ok {
+		return fmt.Errorf("%w: category %s", storage.ErrNotFound, *id)
+	}
+	return nil
+}
+
+// now matches the microsecond precision of Postgres timestamptz.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"testing"
+
+	"dynamic-pricing/internal/httpserver"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/services/pricing"
+	"dynamic-pricing/internal/storage/storagetest"
+)
+
+func TestCatalogRepository(t *testing.T) {
+	storagetest.Catalog(t, func(t *testing.T) catalog.Repository { return NewCatalogRepository() })
+}
+
+func TestOrderRepository(t *testing.T) {
+	storagetest.Order(t, func(t *testing.T) order.OrderRepository { return NewOrderRepository() })
+}
+
+func TestPriceRepository(t *testing.T) {
+	storagetest.Price(t, func(t *testing.T) pricing.PriceRepository { return NewPriceRepository() })
+}
+
+func TestPolicyRepository(t *testing.T) {
+	storagetest.Policy(t, func(t *testing.T) pricing.PolicyRepository { return NewPolicyRepository() })
+}
+
+func TestSegmentRepository(t *testing.T) {
+	storagetest.Segment(t, func(t *testing.T) pricing.SegmentRepository { return NewSegmentRepository() })
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	storagetest.Idempotency(t, func(t *testing.T) httpserver.IdempotencyStore { return NewIdempotencyStore() })
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+)
+
+// IdempotencyStore keeps idempotency records in memory.
+type IdempotencyStore struct {
+	mu   sync.Mutex
+	recs map[string]models.IdempotencyRecord
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{recs: make(map[string]models.IdempotencyRecord)}
+}
+
+func (s *IdempotencyStore) ClaimKey(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.recs[rec.Key]; ok && cur.ExpiresAt.After(rec.CreatedAt) {
+		return cur, fmt.Errorf("%w: idempotency key %q", storage.ErrConflict, rec.Key)
+	}
+	rec.Status, rec.Header, rec.Body = 0, nil, nil
+	s.recs[rec.Key] = rec
+	return rec, nil
+}
+
+func (s *IdempotencyStore) CompleteKey(ctx context.Context, key string, status int, header http.Header, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.recs[key]
+	if !ok {
+		return fmt.Errorf("%w: idempotency key %q", storage.ErrNotFound, key)
+	}
+	rec.Status, rec.Header, rec.Body = status, header.Clone(), append([]byte(nil), body...)
+	s.recs[key] = rec
+	return nil
+}
+
+func (s *IdempotencyStore) ReleaseKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recs, key)
+	return nil
+}
+
+func (s *IdempotencyStore) PurgeKeys(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k, rec := range s.recs {
+		if !rec.ExpiresAt.After(now) {
+			delete(s.recs, k)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,373 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type OrderRepository struct {
+	mu       sync.RWMutex
+	users    map[uuid.UUID]models.User
+	byEmail  map[string]uuid.UUID
+	orders   map[uuid.UUID]models.Order
+	products map[uuid.UUID]models.CatalogProduct
+	prices   map[uuid.UUID]models.QuotedPrice
+	history  map[uuid.UUID][]models.OrderStatusChange
+	carts    map[uuid.UUID]models.Cart
+}
+
+func NewOrderRepository() *OrderRepository {
+	return &OrderRepository{
+		users:    make(map[uuid.UUID]models.User),
+		byEmail:  make(map[string]uuid.UUID),
+		orders:   make(map[uuid.UUID]models.Order),
+		products: make(map[uuid.UUID]models.CatalogProduct),
+		prices:   make(map[uuid.UUID]models.QuotedPrice),
+		history:  make(map[uuid.UUID][]models.OrderStatusChange),
+		carts:    make(map[uuid.UUID]models.Cart),
+	}
+}
+
+func (r *OrderRepository) CreateUser(ctx context.Context, email string) (models.User, error) {
+	u := models.User{ID: uuid.New(), Email: email, Segment: models.SegmentNew, CreatedAt: now()}
+	u.UpdatedAt = u.CreatedAt
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byEmail[email]; ok {
+		return u, fmt.Errorf("%w: email %s", storage.ErrConflict, email)
+	}
+	r.users[u.ID] = u
+	r.byEmail[email] = u.ID
+	return u, nil
+}
+
+func (r *OrderRepository) GetUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	u, ok := r.users[id]
+	if !ok {
+		return u, fmt.Errorf("%w: user %s", storage.ErrNotFound, id)
+	}
+	u.OrderCount = r.orderCounts()[id]
+	return u, nil
+}
+
+// ListUsers returns the users matching q, oldest first.
+func (r *OrderRepository) ListUsers(ctx context.Context, q models.UserQuery) ([]models.User, error) {
+	r.mu.RLock()
+	counts := r.orderCounts()
+	var out []models.User
+	for _, u := range r.users {
+		if q.Email != "" && u.Email != q.Email {
+			continue
+		}
+		if q.After != nil && compareUsers(u, *q.After) <= 0 {
+			continue
+		}
+		u.OrderCount = counts[u.ID]
+		out = append(out, u)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(out, compareUsers)
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+// compareUsers orders by CreatedAt, then by ID.
+func compareUsers(a, b models.User) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func (r *OrderRepository) UpdateUser(ctx context.Context, u models.User) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.users[u.ID]
+	if !ok {
+		return u, fmt.Errorf("%w: user %s", storage.ErrNotFound, u.ID)
+	}
+	if id, ok := r.byEmail[u.Email]; ok && id != u.ID {
+		return u, fmt.Errorf("%w: email %s", storage.ErrConflict, u.Email)
+	}
+	delete(r.byEmail, cur.Email)
+	cur.Email, cur.DeactivatedAt, cur.UpdatedAt = u.Email, u.DeactivatedAt, now()
+	r.users[u.ID] = cur
+	r.byEmail[cur.Email] = cur.ID
+	cur.OrderCount = r.orderCounts()[cur.ID]
+	return cur, nil
+}
+
+// SetUserSegment moves a user from segment from to segment to.
+func (r *OrderRepository) SetUserSegment(ctx context.Context, id uuid.UUID, from, to string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok || u.Segment != from {
+		return u, fmt.Errorf("%w: user %s in segment %s", storage.ErrNotFound, id, from)
+	}
+	u.Segment, u.UpdatedAt = to, now()
+	r.users[id] = u
+	u.OrderCount = r.orderCounts()[id]
+	return u, nil
+}
+
+// orderCounts counts the orders of each user, skipping canceled and
+// refunded ones. The caller holds r.mu.
+func (r *OrderRepository) orderCounts() map[uuid.UUID]int {
+	counts := make(map[uuid.UUID]int)
+	for _, o := range r.orders {
+		if o.Status != models.OrderCanceled && o.Status != models.OrderRefunded {
+			counts[o.UserID]++
+		}
+	}
+	return counts
+}
+
+func (r *OrderRepository) CreateOrder(ctx context.Context, o models.Order) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.createOrder(o)
+}
+
+// createOrder stores o as placed. The caller holds r.mu.
+func (r *OrderRepository) createOrder(o models.Order) (models.Order, error) {
+	o.Status = models.OrderPlaced
+	o.CreatedAt = now()
+	o.UpdatedAt = o.CreatedAt
+	o.Lines = append([]models.OrderLine(nil), o.Lines...)
+	if _, ok := r.users[o.UserID]; !ok {
+		return o, fmt.Errorf("%w: user %s", storage.ErrNotFound, o.UserID)
+	}
+	if _, ok := r.orders[o.ID]; ok {
+		return o, fmt.Errorf("%w: order %s", storage.ErrConflict, o.ID)
+	}
+	r.orders[o.ID] = o
+	r.history[o.ID] = []models.OrderStatusChange{{OrderID: o.ID, To: o.Status, ChangedAt: o.CreatedAt}}
+	return o, nil
+}
+
+func (r *OrderRepository) TransitionOrder(ctx context.Context, id uuid.UUID, from, to string) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.orders[id]
+	if !ok || o.Status != from {
+		return models.Order{}, fmt.Errorf("%w: order %s in status %s", storage.ErrNotFound, id, from)
+	}
+	o.Status = to
+	o.UpdatedAt = now()
+	r.orders[id] = o
+	r.history[id] = append(r.history[id], models.OrderStatusChange{OrderID: id, From: from, To: to, ChangedAt: o.UpdatedAt})
+	return o, nil
+}
+
+func (r *OrderRepository) OrderHistory(ctx context.Context, id uuid.UUID) ([]models.OrderStatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]models.OrderStatusChange(nil), r.history[id]...), nil
+}
+
+func (r *OrderRepository) SaveProduct(ctx context.Context, p models.CatalogProduct) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.products[p.ID]
+	if ok && prev.Version > p.Version {
+		return nil
+	}
+	p.ArchivedAt = prev.ArchivedAt
+	p.UpdatedAt = now()
+	r.products[p.ID] = p
+	return nil
+}
+
+func (r *OrderRepository) RetireProduct(ctx context.Context, id uuid.UUID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok {
+		p.ID = id
+	}
+	if p.ArchivedAt == nil || at.Before(*p.ArchivedAt) {
+		p.ArchivedAt = &at
+	}
+	p.UpdatedAt = now()
+	r.products[id] = p
+	return nil
+}
+
+func (r *OrderRepository) GetProduct(ctx context.Context, id uuid.UUID) (models.CatalogProduct, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.products[id]
+	if !ok {
+		return p, fmt.Errorf("%w: product %s", storage.ErrNotFound, id)
+	}
+	return p, nil
+}
+
+func (r *OrderRepository) SavePrice(ctx context.Context, p models.QuotedPrice) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.prices[p.ProductID]; ok && prev.PricedAt.After(p.PricedAt) {
+		return nil
+	}
+	if p.SegmentPrices != nil {
+		sp := make(map[string]float64, len(p.SegmentPrices))
+		for k, v := range p.SegmentPrices {
+			sp[k] = v
+		}
+		p.SegmentPrices = sp
+	}
+	r.prices[p.ProductID] = p
+	return nil
+}
+
+func (r *OrderRepository) GetPrice(ctx context.Context, productID uuid.UUID) (models.QuotedPrice, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.prices[productID]
+	if !ok {
+		return p, fmt.Errorf("%w: price of product %s", storage.ErrNotFound, productID)
+	}
+	return p, nil
+}
+
+func (r *OrderRepository) GetOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	o, ok := r.orders[id]
+	if !ok {
+		return models.Order{}, fmt.Errorf("%w: order %s", storage.ErrNotFound, id)
+	}
+	o.Lines = append([]models.OrderLine(nil), o.Lines...)
+	return o, nil
+}
+
+// ListOrders returns the orders matching q, newest first.
+func (r *OrderRepository) ListOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error) {
+	r.mu.RLock()
+	var out []models.Order
+	for _, o := range r.orders {
+		if orderMatches(o, q) {
+			o.Lines = append([]models.OrderLine(nil), o.Lines...)
+			out = append(out, o)
+		}
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(out, func(a, b models.Order) int { return -compareOrders(a, b) })
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func orderMatches(o models.Order, q models.OrderQuery) bool {
+	switch {
+	case q.UserID != nil && o.UserID != *q.UserID,
+		q.Status != "" && o.Status != q.Status,
+		q.From != nil && o.CreatedAt.Before(*q.From),
+		q.To != nil && !o.CreatedAt.Before(*q.To),
+		q.After != nil && compareOrders(o, *q.After) >= 0:
+		return false
+	}
+	if q.ProductID != nil {
+		return o.ProductID == *q.ProductID || slices.ContainsFunc(o.Lines, func(l models.OrderLine) bool { return l.ProductID == *q.ProductID })
+	}
+	return true
+}
+
+// compareOrders orders by CreatedAt, then by ID.
+func compareOrders(a, b models.Order) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func (r *OrderRepository) CreateCart(ctx context.Context, c models.Cart) (models.Cart, error) {
+	c.Status = models.CartOpen
+	c.Lines = nil
+	c.CreatedAt = now()
+	c.UpdatedAt = c.CreatedAt
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[c.UserID]; !ok {
+		return c, fmt.Errorf("%w: user %s", storage.ErrNotFound, c.UserID)
+	}
+	if _, ok := r.carts[c.ID]; ok {
+		return c, fmt.Errorf("%w: cart %s", storage.ErrConflict, c.ID)
+	}
+	r.carts[c.ID] = c
+	return c, nil
+}
+
+func (r *OrderRepository) GetCart(ctx context.Context, id uuid.UUID) (models.Cart, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cart(id)
+}
+
+// cart returns a copy of a cart. The caller holds r.mu.
+func (r *OrderRepository) cart(id uuid.UUID) (models.Cart, error) {
+	c, ok := r.carts[id]
+	if !ok {
+		return c, fmt.Errorf("%w: cart %s", storage.ErrNotFound, id)
+	}
+	c.Lines = append([]models.OrderLine(nil), c.Lines...)
+	return c, nil
+}
+
+func (r *OrderRepository) SetCartLine(ctx context.Context, cartID, productID uuid.UUID, qty int) (models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.cart(cartID)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != models.CartOpen {
+		return models.Cart{}, fmt.Errorf("%w: open cart %s", storage.ErrNotFound, cartID)
+	}
+	i := slices.IndexFunc(c.Lines, func(l models.OrderLine) bool { return l.ProductID == productID })
+	switch {
+	case qty == 0 && i >= 0:
+		c.Lines = slices.Delete(c.Lines, i, i+1)
+	case qty == 0:
+	case i >= 0:
+		c.Lines[i].Qty = qty
+	default:
+		c.Lines = append(c.Lines, models.OrderLine{ProductID: productID, Qty: qty})
+	}
+	c.UpdatedAt = now()
+	r.carts[cartID] = c
+	return r.cart(cartID)
+}
+
+func (r *OrderRepository) CheckoutCart(ctx context.Context, cartID uuid.UUID, updatedAt time.Time, o models.Order) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.carts[cartID]
+	if !ok || c.Status != models.CartOpen || !c.UpdatedAt.Equal(updatedAt) {
+		return models.Order{}, fmt.Errorf("%w: open cart %s as of %s", storage.ErrNotFound, cartID, updatedAt)
+	}
+	o, err := r.createOrder(o)
+	if err != nil {
+		return o, err
+	}
+	c.Status = models.CartCheckedOut
+	c.OrderID = &o.ID
+	c.UpdatedAt = o.CreatedAt
+	r.carts[cartID] = c
+	return o, nil
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type policyKey struct {
+	scope models.PolicyScope
+	id    uuid.UUID
+}
+
+type PolicyRepository struct {
+	mu       sync.RWMutex
+	policies map[policyKey]models.ScopedPolicy
+}
+
+func NewPolicyRepository() *PolicyRepository {
+	return &PolicyRepository{policies: make(map[policyKey]models.ScopedPolicy)}
+}
+
+func (r *PolicyRepository) ListPolicies(ctx context.Context) ([]models.ScopedPolicy, error) {
+	r.mu.RLock()
+	out := make([]models.ScopedPolicy, 0, len(r.policies))
+	for _, p := range r.policies {
+		out = append(out, p)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope < out[j].Scope
+		}
+		return bytes.Compare(out[i].ID[:], out[j].ID[:]) < 0
+	})
+	return out, nil
+}
+
+func (r *PolicyRepository) UpsertPolicy(ctx context.Context, p models.ScopedPolicy) (models.ScopedPolicy, error) {
+	r.mu.Lock()
+	r.policies[policyKey{p.Scope, p.ID}] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+func (r *PolicyRepository) DeletePolicy(ctx context.Context, scope models.PolicyScope, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := policyKey{scope, id}
+	if _, ok := r.policies[k]; !ok {
+		return fmt.Errorf("%w: %s policy %s", storage.ErrNotFound, scope, id)
+	}
+	delete(r.policies, k)
+	return nil
+}
@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type PriceRepository struct {
+	mu      sync.RWMutex
+	prices  map[uuid.UUID]models.Price
+	retired map[uuid.UUID]time.Time
+}
+
+func NewPriceRepository() *PriceRepository {
+	return &PriceRepository{prices: make(map[uuid.UUID]models.Price), retired: make(map[uuid.UUID]time.Time)}
+}
+
+func (r *PriceRepository) UpsertPrice(ctx context.Context, productID uuid.UUID, currentPrice float64) (models.Price, error) {
+	p := models.Price{ProductID: productID, CurrentPrice: currentPrice, UpdatedAt: now()}
+	r.mu.Lock()
+	r.prices[productID] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+func (r *PriceRepository) GetPrice(ctx context.Context, productID uuid.UUID) (models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.prices[productID]
+	if _, retired := r.retired[productID]; !ok || retired {
+		return models.Price{}, fmt.Errorf("%w: price %s", storage.ErrNotFound, productID)
+	}
+	return p, nil
+}
+
+func (r *PriceRepository) ListPrices(ctx context.Context) ([]models.Price, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]models.Price, 0, len(r.prices))
+	for id, p := range r.prices {
+		if _, retired := r.retired[id]; !retired {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (r *PriceRepository) RetirePrice(ctx context.Context, productID uuid.UUID, at time.Time) error {
+	r.mu.Lock()
+	r.retired[productID] = at
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *PriceRepository) RetiredProducts(ctx context.Context) ([]uuid.UUID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]uuid.UUID, 0, len(r.retired))
+	for id := range r.retired {
+		out = append(out, id)
+	}
+	return out, nil
+}
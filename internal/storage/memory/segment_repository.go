@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/storage"
+)
+
+type SegmentRepository struct {
+	mu          sync.RWMutex
+	multipliers map[string]models.SegmentMultiplier
+}
+
+func NewSegmentRepository() *SegmentRepository {
+	return &SegmentRepository{multipliers: make(map[string]models.SegmentMultiplier)}
+}
+
+func (r *SegmentRepository) ListSegmentMultipliers(ctx context.Context) ([]models.SegmentMultiplier, error) {
+	r.mu.RLock()
+	out := make([]models.SegmentMultiplier, 0, len(r.multipliers))
+	for _, m := range r.multipliers {
+		out = append(out, m)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Segment < out[j].Segment })
+	return out, nil
+}
+
+func (r *SegmentRepository) UpsertSegmentMultiplier(ctx context.Context, m models.SegmentMultiplier) (models.SegmentMultiplier, error) {
+	r.mu.Lock()
+	r.multipliers[m.Segment] = m
+	r.mu.Unlock()
+	return m, nil
+}
+
+func (r *SegmentRepository) DeleteSegmentMultiplier(ctx context.Context, segment string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.multipliers[segment]; !ok {
+		return fmt.Errorf("%w: multiplier of segment %s", storage.ErrNotFound, segment)
+	}
+	delete(r.multipliers, segment)
+	return nil
+}
@@ -2,12 +2,14 @@ package pg
 
 import (
     "context"
-    "errors"
+    "fmt"
+    "strings"
     "time"
 
     "dynamic-pricing/internal/models"
 
     "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,39 +23,383 @@ func NewCatalogRepository(db *pgxpool.Pool) *CatalogRepository {
 
 func (r *CatalogRepository) Create(ctx context.Context, p models.Product) (models.Product, error) {
     p.UpdatedAt = time.Now().UTC()
-    _, err := r.db.Exec(ctx, `insert into products(id, name, base_price, stock, updated_at) values($1,$2,$3,$4,$5)`, p.ID, p.Name, p.BasePrice, p.Stock, p.UpdatedAt)
+    p.Version = 1
+    _, err := r.db.Exec(ctx, `insert into products(id, name, base_price, stock, category_id, updated_at, backorders) values($1,$2,$3,$4,$5,$6,$7)`, p.ID, p.Name, p.BasePrice, p.Stock, p.CategoryID, p.UpdatedAt, p.Backorders)
+    return p, mapErr(err)
+}
+
+// CreateBatch copies ps in with a single COPY, so either all rows land or none.
+func (r *CatalogRepository) CreateBatch(ctx context.Context, ps []models.Product) ([]models.Product, error) {
+    now := time.Now().UTC()
+    rows := make([][]any, len(ps))
+    for i := range ps {
+        ps[i].UpdatedAt = now
+        ps[i].Version = 1
+        p := ps[i]
+        rows[i] = []any{p.ID, p.Name, p.BasePrice, p.Stock, p.CategoryID, p.UpdatedAt, p.Backorders}
+    }
+    _, err := r.db.CopyFrom(ctx, pgx.Identifier{"products"},
+        []string{"id", "name", "base_price", "stock", "category_id", "updated_at", "backorders"}, pgx.CopyFromRows(rows))
+    return ps, mapErr(err)
+}
+
+// productColumns is the select list scanProduct expects.
+const productColumns = `id, name, base_price, stock, category_id, updated_at, archived_at, version, backorders, reserved`
+
+func scanProduct(row pgx.Row) (models.Product, error) {
+    var p models.Product
+    err := row.Scan(&p.ID, &p.Name, &p.BasePrice, &p.Stock, &p.CategoryID, &p.UpdatedAt, &p.ArchivedAt, &p.Version, &p.Backorders, &p.Reserved)
+    return p, mapErr(err)
+}
+
+// Update and UpdateStock leave archived products alone and, unless version
+// is 0, products at another version; both are reported as not found. Every
+// change bumps the version.
+func (r *CatalogRepository) Update(ctx context.Context, id uuid.UUID, name string, basePrice float64, version int64) (models.Product, error) {
+    row := r.db.QueryRow(ctx, `update products set name=$2, base_price=$3, updated_at=$4, version=version+1 where id=$1 and archived_at is null and ($5 = 0 or version = $5) returning `+productColumns, id, name, basePrice, time.Now().UTC(), version)
+    return scanProduct(row)
+}
+
+// UpdateStock also records the change as an adjustment movement.
+func (r *CatalogRepository) UpdateStock(ctx context.Context, id uuid.UUID, stock int, version int64) (models.Product, error) {
+    var p models.Product
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var old int
+        if err := tx.QueryRow(ctx, `select stock from products where id=$1 for update`, id).Scan(&old); err != nil {
+            return mapErr(err)
+        }
+        now := time.Now().UTC().Truncate(time.Microsecond)
+        row := tx.QueryRow(ctx, `update products set stock=$2, updated_at=$3, version=version+1 where id=$1 and archived_at is null and ($4 = 0 or version = $4) returning `+productColumns, id, stock, now, version)
+        var err error
+        if p, err = scanProduct(row); err != nil || p.Stock == old {
+            return err
+        }
+        _, err = insertMovement(ctx, tx, models.InventoryMovement{
+            ProductID: id, Delta: p.Stock - old, Reason: models.MovementAdjustment,
+            StockAfter: p.Stock, CreatedAt: now,
+        })
+        return err
+    })
     return p, err
 }
 
-func (r *CatalogRepository) Update(ctx context.Context, id uuid.UUID, name string, basePrice float64) (models.Product, error) {
+// AdjustStock adds m.Delta to a live product's stock and records m in the
+// same transaction. Unless the product takes backorders, a decrease that
+// would leave the available stock negative is not applied; like a missing or
+// archived product it is reported as not found.
+func (r *CatalogRepository) AdjustStock(ctx context.Context, m models.InventoryMovement) (models.Product, models.InventoryMovement, error) {
     var p models.Product
-    p.UpdatedAt = time.Now().UTC()
-    row := r.db.QueryRow(ctx, `update products set name=$2, base_price=$3, updated_at=$4 where id=$1 returning id, name, base_price, stock, updated_at`, id, name, basePrice, p.UpdatedAt)
-    if err := row.Scan(&p.ID, &p.Name, &p.BasePrice, &p.Stock, &p.UpdatedAt); err != nil {
-        return p, err
+    m.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        row := tx.QueryRow(ctx, `update products set stock=stock+$2, updated_at=$3, version=version+1 where id=$1 and archived_at is null and (backorders or $2 >= 0 or stock-reserved+$2 >= 0) returning `+productColumns, m.ProductID, m.Delta, m.CreatedAt)
+        var err error
+        if p, err = scanProduct(row); err != nil {
+            return err
+        }
+        m.StockAfter = p.Stock
+        m, err = insertMovement(ctx, tx, m)
+        return err
+    })
+    return p, m, err
+}
+
+// insertMovement records m and returns it with its ID.
+func insertMovement(ctx context.Context, tx pgx.Tx, m models.InventoryMovement) (models.InventoryMovement, error) {
+    err := tx.QueryRow(ctx, `insert into inventory_movements(product_id, delta, reason, note, order_id, stock_after, created_at) values($1,$2,$3,$4,$5,$6,$7) returning id`,
+        m.ProductID, m.Delta, m.Reason, m.Note, m.OrderID, m.StockAfter, m.CreatedAt).Scan(&m.ID)
+    return m, mapErr(err)
+}
+
+// ListMovements returns up to q.Limit movements of a product, newest first.
+func (r *CatalogRepository) ListMovements(ctx context.Context, q models.MovementQuery) ([]models.InventoryMovement, error) {
+    sql := `select ` + movementColumns + ` from inventory_movements where product_id=$1`
+    args := []any{q.ProductID}
+    if q.BeforeID > 0 {
+        args = append(args, q.BeforeID)
+        sql += ` and id < $2`
     }
-    return p, nil
+    sql += ` order by id desc`
+    if q.Limit > 0 {
+        args = append(args, q.Limit)
+        sql += fmt.Sprintf(` limit $%d`, len(args))
+    }
+
+    rows, err := r.db.Query(ctx, sql, args...)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.InventoryMovement
+    for rows.Next() {
+        m, err := scanMovement(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, m)
+    }
+    return out, rows.Err()
+}
+
+// OrderMovements returns the movements an order caused for reason, one per
+// product, oldest first.
+func (r *CatalogRepository) OrderMovements(ctx context.Context, orderID uuid.UUID, reason models.MovementReason) ([]models.InventoryMovement, error) {
+    rows, err := r.db.Query(ctx, `select `+movementColumns+` from inventory_movements where order_id=$1 and reason=$2 order by id`, orderID, reason)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.InventoryMovement
+    for rows.Next() {
+        m, err := scanMovement(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, m)
+    }
+    return out, rows.Err()
+}
+
+// movementColumns is the select list scanMovement expects.
+const movementColumns = `id, product_id, delta, reason, note, order_id, stock_after, created_at`
+
+func scanMovement(row pgx.Row) (models.InventoryMovement, error) {
+    var m models.InventoryMovement
+    err := row.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Reason, &m.Note, &m.OrderID, &m.StockAfter, &m.CreatedAt)
+    return m, mapErr(err)
 }
 
-func (r *CatalogRepository) UpdateStock(ctx context.Context, id uuid.UUID, stock int) (models.Product, error) {
+// Reserve adds res.Qty to a live product's reserved stock when its available
+// stock covers it, or it takes backorders, and records res in the same
+// transaction.
+func (r *CatalogRepository) Reserve(ctx context.Context, res models.Reservation) (models.Product, models.Reservation, error) {
     var p models.Product
-    p.UpdatedAt = time.Now().UTC()
-    row := r.db.QueryRow(ctx, `update products set stock=$2, updated_at=$3 where id=$1 returning id, name, base_price, stock, updated_at`, id, stock, p.UpdatedAt)
-    if err := row.Scan(&p.ID, &p.Name, &p.BasePrice, &p.Stock, &p.UpdatedAt); err != nil {
-        return p, err
+    res.Status = models.ReservationActive
+    res.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    res.UpdatedAt = res.CreatedAt
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        row := tx.QueryRow(ctx, `update products set reserved=reserved+$2 where id=$1 and archived_at is null and (backorders or stock-reserved >= $2) returning `+productColumns, res.ProductID, res.Qty)
+        var err error
+        if p, err = scanProduct(row); err != nil {
+            return err
+        }
+        _, err = tx.Exec(ctx, `insert into stock_reservations(id, product_id, qty, status, order_id, expires_at, created_at, updated_at) values($1,$2,$3,$4,$5,$6,$7,$8)`,
+            res.ID, res.ProductID, res.Qty, res.Status, res.OrderID, res.ExpiresAt, res.CreatedAt, res.UpdatedAt)
+        return mapErr(err)
+    })
+    return p, res, err
+}
+
+// reservationColumns is the select list scanReservation expects.
+const reservationColumns = `id, product_id, qty, status, order_id, expires_at, created_at, updated_at`
+
+func scanReservation(row pgx.Row) (models.Reservation, error) {
+    var res models.Reservation
+    err := row.Scan(&res.ID, &res.ProductID, &res.Qty, &res.Status, &res.OrderID, &res.ExpiresAt, &res.CreatedAt, &res.UpdatedAt)
+    return res, mapErr(err)
+}
+
+func (r *CatalogRepository) GetReservation(ctx context.Context, id uuid.UUID) (models.Reservation, error) {
+    row := r.db.QueryRow(ctx, `select `+reservationColumns+` from stock_reservations where id=$1`, id)
+    return scanReservation(row)
+}
+
+// ReleaseReservation ends an active reservation with status and gives its
+// quantity back to the product's available stock.
+func (r *CatalogRepository) ReleaseReservation(ctx context.Context, id uuid.UUID, status models.ReservationStatus) (models.Product, models.Reservation, error) {
+    var (
+        p   models.Product
+        res models.Reservation
+    )
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var err error
+        row := tx.QueryRow(ctx, `update stock_reservations set status=$2, updated_at=$3 where id=$1 and status='active' returning `+reservationColumns, id, status, time.Now().UTC())
+        if res, err = scanReservation(row); err != nil {
+            return err
+        }
+        row = tx.QueryRow(ctx, `update products set reserved=reserved-$2 where id=$1 returning `+productColumns, res.ProductID, res.Qty)
+        p, err = scanProduct(row)
+        return err
+    })
+    return p, res, err
+}
+
+// ConfirmReservation frees an active reservation of m.ProductID and applies
+// the sale m in one transaction, so the reserved units go to the order that
+// reserved them.
+func (r *CatalogRepository) ConfirmReservation(ctx context.Context, id uuid.UUID, m models.InventoryMovement) (models.Product, models.Reservation, models.InventoryMovement, error) {
+    var (
+        p   models.Product
+        res models.Reservation
+    )
+    m.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var err error
+        row := tx.QueryRow(ctx, `update stock_reservations set status='confirmed', order_id=$3, updated_at=$4 where id=$1 and product_id=$2 and status='active' returning `+reservationColumns, id, m.ProductID, m.OrderID, m.CreatedAt)
+        if res, err = scanReservation(row); err != nil {
+            return err
+        }
+        row = tx.QueryRow(ctx, `update products set stock=stock+$2, reserved=reserved-$3, updated_at=$4, version=version+1 where id=$1 and archived_at is null and (backorders or $2 >= 0 or stock-reserved+$3+$2 >= 0) returning `+productColumns, m.ProductID, m.Delta, res.Qty, m.CreatedAt)
+        if p, err = scanProduct(row); err != nil {
+            return err
+        }
+        m.StockAfter = p.Stock
+        m, err = insertMovement(ctx, tx, m)
+        return err
+    })
+    return p, res, m, err
+}
+
+// ExpireReservations expires the active reservations due by now, frees
+// their stock and returns the products affected.
+func (r *CatalogRepository) ExpireReservations(ctx context.Context, now time.Time) ([]models.Product, error) {
+    rows, err := r.db.Query(ctx, `with expired as (
+  update stock_reservations set status='expired', updated_at=$2
+  where status='active' and expires_at <= $1
+  returning product_id, qty
+), freed as (
+  select product_id, sum(qty)::int as qty from expired group by product_id
+)
+update products p set reserved=p.reserved-freed.qty from freed where p.id=freed.product_id
+returning `+qualify("p", productColumns), now, time.Now().UTC())
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.Product
+    for rows.Next() {
+        p, err := scanProduct(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, p)
     }
-    return p, nil
+    return out, mapErr(rows.Err())
+}
+
+// qualify prefixes every column of a select list with alias.
+func qualify(alias, columns string) string {
+    cols := strings.Split(columns, ", ")
+    for i, c := range cols {
+        cols[i] = alias + "." + c
+    }
+    return strings.Join(cols, ", ")
+}
+
+// SetBackorders allows or forbids stock adjustments below zero for a live
+// product.
+func (r *CatalogRepository) SetBackorders(ctx context.Context, id uuid.UUID, enabled bool) (models.Product, error) {
+    row := r.db.QueryRow(ctx, `update products set backorders=$2, updated_at=$3, version=version+1 where id=$1 and archived_at is null returning `+productColumns, id, enabled, time.Now().UTC())
+    return scanProduct(row)
+}
+
+// SetCategory assigns a live product to categoryID, or unassigns it when
+// categoryID is nil.
+func (r *CatalogRepository) SetCategory(ctx context.Context, id uuid.UUID, categoryID *uuid.UUID) (models.Product, error) {
+    row := r.db.QueryRow(ctx, `update products set category_id=$2, updated_at=$3, version=version+1 where id=$1 and archived_at is null returning `+productColumns, id, categoryID, time.Now().UTC())
+    return scanProduct(row)
 }
 
 func (r *CatalogRepository) Get(ctx context.Context, id uuid.UUID) (models.Product, error) {
-    var p models.Product
-    row := r.db.QueryRow(ctx, `select id, name, base_price, stock, updated_at from products where id=$1`, id)
-    if err := row.Scan(&p.ID, &p.Name, &p.BasePrice, &p.Stock, &p.UpdatedAt); err != nil {
-        return p, err
+    row := r.db.QueryRow(ctx, `select `+productColumns+` from products where id=$1`, id)
+    return scanProduct(row)
+}
+
+// Archive sets archived_at on a product that is not archived yet.
+func (r *CatalogRepository) Archive(ctx context.Context, id uuid.UUID) (models.Product, error) {
+    now := time.Now().UTC()
+    row := r.db.QueryRow(ctx, `update products set archived_at=$2, updated_at=$2, version=version+1 where id=$1 and archived_at is null returning `+productColumns, id, now)
+    return scanProduct(row)
+}
+
+// Delete removes the product and returns its last state.
+func (r *CatalogRepository) Delete(ctx context.Context, id uuid.UUID) (models.Product, error) {
+    row := r.db.QueryRow(ctx, `delete from products where id=$1 returning `+productColumns, id)
+    return scanProduct(row)
+}
+
+// productSortColumns maps a sort key to its column. Names compare byte-wise
+// so that keyset pages are stable regardless of the database locale.
+var productSortColumns = map[models.ProductSort]string{
+    models.SortByName:      `name collate "C"`,
+    models.SortByPrice:     "base_price",
+    models.SortByUpdatedAt: "updated_at",
+}
+
+func (r *CatalogRepository) List(ctx context.Context, q models.ProductQuery) ([]models.Product, error) {
+    col, ok := productSortColumns[q.Sort]
+    if !ok {
+        return nil, fmt.Errorf("unknown product sort %q", q.Sort)
+    }
+    var (
+        where []string
+        args  []any
+    )
+    arg := func(v any) string {
+        args = append(args, v)
+        return fmt.Sprintf("$%d", len(args))
+    }
+    if q.Search != "" {
+        // Served by the trigram index on name.
+        where = append(where, "name ilike "+arg("%"+escapeLike(q.Search)+"%"))
+    }
+    if q.MinPrice != nil {
+        where = append(where, "base_price >= "+arg(*q.MinPrice))
+    }
+    if q.MaxPrice != nil {
+        where = append(where, "base_price <= "+arg(*q.MaxPrice))
+    }
+    if q.InStock {
+        where = append(where, "stock - reserved > 0")
+    }
+    if len(q.CategoryIDs) > 0 {
+        where = append(where, "category_id = any("+arg(q.CategoryIDs)+")")
     }
-    if p.ID == uuid.Nil {
-        return p, errors.New("not found")
+    if !q.IncludeArchived {
+        where = append(where, "archived_at is null")
     }
-    return p, nil
+    dir, op := "asc", ">"
+    if q.Desc {
+        dir, op = "desc", "<"
+    }
+    if q.After != nil {
+        var v any
+        switch q.Sort {
+        case models.SortByName:
+            v = q.After.Name
+        case models.SortByPrice:
+            v = q.After.BasePrice
+        case models.SortByUpdatedAt:
+            v = q.After.UpdatedAt
+        }
+        where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", col, op, arg(v), arg(q.After.ID)))
+    }
+
+    sql := `select ` + productColumns + ` from products`
+    if len(where) > 0 {
+        sql += " where " + strings.Join(where, " and ")
+    }
+    sql += fmt.Sprintf(" order by %s %s, id %s", col, dir, dir)
+    if q.Limit > 0 {
+        sql += " limit " + arg(q.Limit)
+    }
+
+    rows, err := r.db.Query(ctx, sql, args...)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.Product
+    for rows.Next() {
+        p, err := scanProduct(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, p)
+    }
+    return out, mapErr(rows.Err())
 }
 
+// escapeLike quotes the LIKE wildcards in s.
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
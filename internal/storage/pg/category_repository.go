@@ -0,0 +1,73 @@
+package pg
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+const categoryColumns = `id, parent_id, name, created_at, updated_at`
+
+func scanCategory(row pgx.Row) (models.Category, error) {
+    var c models.Category
+    err := row.Scan(&c.ID, &c.ParentID, &c.Name, &c.CreatedAt, &c.UpdatedAt)
+    return c, mapErr(err)
+}
+
+func (r *CatalogRepository) CreateCategory(ctx context.Context, c models.Category) (models.Category, error) {
+    c.CreatedAt = time.Now().UTC()
+    c.UpdatedAt = c.CreatedAt
+    _, err := r.db.Exec(ctx, `insert into categories(id, parent_id, name, created_at, updated_at) values($1,$2,$3,$4,$5)`, c.ID, c.ParentID, c.Name, c.CreatedAt, c.UpdatedAt)
+    return c, mapErr(err)
+}
+
+func (r *CatalogRepository) GetCategory(ctx context.Context, id uuid.UUID) (models.Category, error) {
+    return scanCategory(r.db.QueryRow(ctx, `select `+categoryColumns+` from categories where id=$1`, id))
+}
+
+func (r *CatalogRepository) UpdateCategory(ctx context.Context, id uuid.UUID, name string, parentID *uuid.UUID) (models.Category, error) {
+    row := r.db.QueryRow(ctx, `update categories set name=$2, parent_id=$3, updated_at=$4 where id=$1 returning `+categoryColumns, id, name, parentID, time.Now().UTC())
+    return scanCategory(row)
+}
+
+// DeleteCategory removes a category that has no subcategories and no
+// products; otherwise it fails with storage.ErrConflict.
+func (r *CatalogRepository) DeleteCategory(ctx context.Context, id uuid.UUID) error {
+    tag, err := r.db.Exec(ctx, `delete from categories where id=$1`, id)
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+        return fmt.Errorf("%w: category %s has subcategories or products", storage.ErrConflict, id)
+    }
+    if err != nil {
+        return mapErr(err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%w: category %s", storage.ErrNotFound, id)
+    }
+    return nil
+}
+
+func (r *CatalogRepository) ListCategories(ctx context.Context) ([]models.Category, error) {
+    rows, err := r.db.Query(ctx, `select `+categoryColumns+` from categories order by name collate "C", id`)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.Category
+    for rows.Next() {
+        c, err := scanCategory(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, c)
+    }
+    return out, mapErr(rows.Err())
+}
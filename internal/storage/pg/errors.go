@@ -0,0 +1,32 @@
+package pg
+
+import (
+    "errors"
+    "fmt"
+
+    "dynamic-pricing/internal/storage"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// mapErr wraps driver errors with the storage error they correspond to; the
+// original error stays in the chain for logging and errors.Is checks.
+func mapErr(err error) error {
+    if err == nil {
+        return nil
+    }
+    if errors.Is(err, pgx.ErrNoRows) {
+        return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+    }
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        switch pgErr.Code {
+        case "23505": // unique_violation
+            return fmt.Errorf("%w: %w", storage.ErrConflict, err)
+        case "23503": // foreign_key_violation
+            return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+        }
+    }
+    return err
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IdempotencyStore keeps idempotency records in idempotency_keys. Catalog
+// and order each have the table in their own database.
+type IdempotencyStore struct {
+    db *pgxpool.Pool
+}
+
+func NewIdempotencyStore(db *pgxpool.Pool) *IdempotencyStore { return &IdempotencyStore{db: db} }
+
+// ClaimKey inserts rec, taking over the key only from an expired record, and
+// reads the record holding the key when that fails.
+func (s *IdempotencyStore) ClaimKey(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, error) {
+    tag, err := s.db.Exec(ctx, `insert into idempotency_keys(key, fingerprint, status, headers, body, created_at, expires_at)
+        values($1,$2,0,'{}',null,$3,$4)
+        on conflict (key) do update set fingerprint=excluded.fingerprint, status=0, headers='{}', body=null,
+            created_at=excluded.created_at, expires_at=excluded.expires_at
+        where idempotency_keys.expires_at <= excluded.created_at`,
+        rec.Key, rec.Fingerprint, rec.CreatedAt, rec.ExpiresAt)
+    if err != nil {
+        return rec, mapErr(err)
+    }
+    if tag.RowsAffected() == 1 {
+        return rec, nil
+    }
+    var cur models.IdempotencyRecord
+    err = s.db.QueryRow(ctx, `select key, fingerprint, status, headers, body, created_at, expires_at
+        from idempotency_keys where key=$1`, rec.Key).
+        Scan(&cur.Key, &cur.Fingerprint, &cur.Status, &cur.Header, &cur.Body, &cur.CreatedAt, &cur.ExpiresAt)
+    if err != nil {
+        return cur, mapErr(err)
+    }
+    return cur, fmt.Errorf("%w: idempotency key %q", storage.ErrConflict, rec.Key)
+}
+
+func (s *IdempotencyStore) CompleteKey(ctx context.Context, key string, status int, header http.Header, body []byte) error {
+    if header == nil {
+        header = http.Header{}
+    }
+    tag, err := s.db.Exec(ctx, `update idempotency_keys set status=$2, headers=$3, body=$4 where key=$1`,
+        key, status, header, body)
+    if err != nil {
+        return mapErr(err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%w: idempotency key %q", storage.ErrNotFound, key)
+    }
+    return nil
+}
+
+func (s *IdempotencyStore) ReleaseKey(ctx context.Context, key string) error {
+    _, err := s.db.Exec(ctx, `delete from idempotency_keys where key=$1`, key)
+    return mapErr(err)
+}
+
+func (s *IdempotencyStore) PurgeKeys(ctx context.Context, now time.Time) (int, error) {
+    tag, err := s.db.Exec(ctx, `delete from idempotency_keys where expires_at <= $1`, now)
+    if err != nil {
+        return 0, mapErr(err)
+    }
+    return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,220 @@
+package pg
+
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "dynamic-pricing/internal/storage/pg/migrations"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration is one versioned schema change with its up and down scripts.
+type Migration struct {
+    Version int
+    Name    string
+    Up      string
+    Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+    Migration
+    AppliedAt *time.Time
+}
+
+// Migrator applies the embedded migrations of one service to its database
+// and records them in schema_migrations. Rows are keyed by service as well
+// as version, so services sharing one database keep separate histories.
+type Migrator struct {
+    db         *pgxpool.Pool
+    service    string
+    migrations []Migration
+}
+
+// NewMigrator loads the migrations embedded for service ("catalog", "order"
+// or "pricing").
+func NewMigrator(db *pgxpool.Pool, service string) (*Migrator, error) {
+    ms, err := LoadMigrations(migrations.FS, service)
+    if err != nil {
+        return nil, err
+    }
+    return &Migrator{db: db, service: service, migrations: ms}, nil
+}
+
+// LoadMigrations reads <dir>/<version>_<name>.{up,down}.sql from fsys, sorted by version.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+    entries, err := fs.ReadDir(fsys, dir)
+    if err != nil {
+        return nil, err
+    }
+    byVersion := make(map[int]*Migration)
+    for _, e := range entries {
+        name := e.Name()
+        var direction string
+        switch {
+        case strings.HasSuffix(name, ".up.sql"):
+            direction = "up"
+        case strings.HasSuffix(name, ".down.sql"):
+            direction = "down"
+        default:
+            continue
+        }
+        base := strings.TrimSuffix(name, "."+direction+".sql")
+        v, label, ok := strings.Cut(base, "_")
+        if !ok {
+            return nil, fmt.Errorf("migration %s: want <version>_<name>", name)
+        }
+        version, err := strconv.Atoi(v)
+        if err != nil {
+            return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+        }
+        b, err := fs.ReadFile(fsys, path.Join(dir, name))
+        if err != nil {
+            return nil, err
+        }
+        m, ok := byVersion[version]
+        if !ok {
+            m = &Migration{Version: version, Name: label}
+            byVersion[version] = m
+        }
+        if m.Name != label {
+            return nil, fmt.Errorf("migration %d: name mismatch %q vs %q", version, m.Name, label)
+        }
+        if direction == "up" {
+            m.Up = string(b)
+        } else {
+            m.Down = string(b)
+        }
+    }
+    out := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" {
+            return nil, fmt.Errorf("migration %d_%s: missing up script", m.Version, m.Name)
+        }
+        out = append(out, *m)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+    return out, nil
+}
+
+// Up applies all pending migrations in order and returns their versions.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+    var done []int
+    err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int]time.Time) error {
+        for _, mig := range m.migrations {
+            if _, ok := applied[mig.Version]; ok {
+                continue
+            }
+            err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+                if _, err := tx.Exec(ctx, mig.Up); err != nil {
+                    return err
+                }
+                _, err := tx.Exec(ctx, `insert into schema_migrations(service, version, name, applied_at) values($1,$2,$3,$4)`, m.service, mig.Version, mig.Name, time.Now().UTC())
+                return err
+            })
+            if err != nil {
+                return fmt.Errorf("migration %04d_%s up: %w", mig.Version, mig.Name, err)
+            }
+            done = append(done, mig.Version)
+        }
+        return nil
+    })
+    return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int, error) {
+    var done []int
+    err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int]time.Time) error {
+        for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+            mig := m.migrations[i]
+            if _, ok := applied[mig.Version]; !ok {
+                continue
+            }
+            if mig.Down == "" {
+                return fmt.Errorf("migration %04d_%s: no down script", mig.Version, mig.Name)
+            }
+            err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+                if _, err := tx.Exec(ctx, mig.Down); err != nil {
+                    return err
+                }
+                _, err := tx.Exec(ctx, `delete from schema_migrations where service=$1 and version=$2`, m.service, mig.Version)
+                return err
+            })
+            if err != nil {
+                return fmt.Errorf("migration %04d_%s down: %w", mig.Version, mig.Name, err)
+            }
+            done = append(done, mig.Version)
+        }
+        return nil
+    })
+    return done, err
+}
+
+// Status lists every known migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+    var out []MigrationStatus
+    err := m.locked(ctx, func(_ *pgxpool.Conn, applied map[int]time.Time) error {
+        for _, mig := range m.migrations {
+            st := MigrationStatus{Migration: mig}
+            if at, ok := applied[mig.Version]; ok {
+                st.AppliedAt = &at
+            }
+            out = append(out, st)
+        }
+        return nil
+    })
+    return out, err
+}
+
+// locked runs fn on a dedicated connection holding an advisory lock, so
+// replicas booting together don't apply the same migration twice.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn, applied map[int]time.Time) error) error {
+    conn, err := m.db.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Release()
+
+    if _, err := conn.Exec(ctx, `select pg_advisory_lock(hashtext('schema_migrations'))`); err != nil {
+        return err
+    }
+    defer conn.Exec(context.Background(), `select pg_advisory_unlock(hashtext('schema_migrations'))`)
+
+    if _, err := conn.Exec(ctx, `create table if not exists schema_migrations (
+  service text not null,
+  version bigint not null,
+  name text not null,
+  applied_at timestamptz not null,
+  primary key (service, version)
+)`); err != nil {
+        return err
+    }
+    rows, err := conn.Query(ctx, `select version, applied_at from schema_migrations where service=$1`, m.service)
+    if err != nil {
+        return err
+    }
+    applied := make(map[int]time.Time)
+    for rows.Next() {
+        var v int
+        var at time.Time
+        if err := rows.Scan(&v, &at); err != nil {
+            rows.Close()
+            return err
+        }
+        applied[v] = at
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    return fn(conn, applied)
+}
@@ -0,0 +1,91 @@
+package pg
+
+import (
+    "context"
+    "testing"
+    "testing/fstest"
+
+    "dynamic-pricing/internal/storage/pg/migrations"
+)
+
+func TestLoadMigrations_SortsAndPairs(t *testing.T) {
+    fsys := fstest.MapFS{
+        "svc/0002_add_index.up.sql":   {Data: []byte("create index i on t(x);")},
+        "svc/0002_add_index.down.sql": {Data: []byte("drop index i;")},
+        "svc/0001_init.up.sql":        {Data: []byte("create table t(x int);")},
+        "svc/README":                  {Data: []byte("ignored")},
+    }
+    ms, err := LoadMigrations(fsys, "svc")
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if len(ms) != 2 || ms[0].Version != 1 || ms[1].Version != 2 {
+        t.Fatalf("want versions [1 2], got %+v", ms)
+    }
+    if ms[0].Name != "init" || ms[0].Down != "" {
+        t.Fatalf("unexpected first migration: %+v", ms[0])
+    }
+    if ms[1].Name != "add_index" || ms[1].Down != "drop index i;" {
+        t.Fatalf("unexpected second migration: %+v", ms[1])
+    }
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+    cases := map[string]fstest.MapFS{
+        "missing up":    {"svc/0001_init.down.sql": {Data: []byte("x")}},
+        "bad version":   {"svc/one_init.up.sql": {Data: []byte("x")}},
+        "no name":       {"svc/0001.up.sql": {Data: []byte("x")}},
+        "name mismatch": {"svc/0001_a.up.sql": {Data: []byte("x")}, "svc/0001_b.down.sql": {Data: []byte("x")}},
+    }
+    for name, fsys := range cases {
+        if _, err := LoadMigrations(fsys, "svc"); err == nil {
+            t.Errorf("%s: expected error", name)
+        }
+    }
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+    for _, service := range []string{"catalog", "order", "pricing"} {
+        ms, err := LoadMigrations(migrations.FS, service)
+        if err != nil {
+            t.Fatalf("%s: %v", service, err)
+        }
+        if len(ms) == 0 {
+            t.Fatalf("%s: no migrations", service)
+        }
+        for _, m := range ms {
+            if m.Down == "" {
+                t.Errorf("%s: %04d_%s has no down script", service, m.Version, m.Name)
+            }
+        }
+    }
+}
+
+func TestMigrator_DownUp(t *testing.T) {
+    db := testPool(t)
+    ctx := context.Background()
+    m, err := NewMigrator(db, "catalog")
+    if err != nil {
+        t.Fatalf("migrator: %v", err)
+    }
+    reverted, err := m.Down(ctx, len(m.migrations))
+    if err != nil || len(reverted) != len(m.migrations) {
+        t.Fatalf("down: %v %v", reverted, err)
+    }
+    st, err := m.Status(ctx)
+    if err != nil {
+        t.Fatalf("status: %v", err)
+    }
+    for _, s := range st {
+        if s.AppliedAt != nil {
+            t.Fatalf("%04d still applied after down", s.Version)
+        }
+    }
+    applied, err := m.Up(ctx)
+    if err != nil || len(applied) != len(m.migrations) {
+        t.Fatalf("up: %v %v", applied, err)
+    }
+    if again, err := m.Up(ctx); err != nil || len(again) != 0 {
+        t.Fatalf("second up: %v %v", again, err)
+    }
+}
@@ -0,0 +1,4 @@
+drop index if exists products_updated_at_id_idx;
+drop index if exists products_base_price_id_idx;
+drop index if exists products_name_id_idx;
+drop index if exists products_name_trgm_idx;
@@ -0,0 +1,6 @@
+create extension if not exists pg_trgm;
+
+create index if not exists products_name_trgm_idx on products using gin (name gin_trgm_ops);
+create index if not exists products_name_id_idx on products ((name collate "C"), id);
+create index if not exists products_base_price_id_idx on products (base_price, id);
+create index if not exists products_updated_at_id_idx on products (updated_at, id);
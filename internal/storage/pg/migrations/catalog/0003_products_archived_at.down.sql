@@ -0,0 +1 @@
+alter table products drop column if exists archived_at;
@@ -0,0 +1 @@
+alter table products add column if not exists archived_at timestamptz;
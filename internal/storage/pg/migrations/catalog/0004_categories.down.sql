@@ -0,0 +1,2 @@
+alter table products drop column if exists category_id;
+drop table if exists categories;
@@ -0,0 +1,11 @@
+create table if not exists categories (
+  id uuid primary key,
+  parent_id uuid references categories(id),
+  name text not null,
+  created_at timestamptz not null,
+  updated_at timestamptz not null
+);
+create index if not exists categories_parent_id_idx on categories (parent_id);
+
+alter table products add column if not exists category_id uuid references categories(id);
+create index if not exists products_category_id_idx on products (category_id);
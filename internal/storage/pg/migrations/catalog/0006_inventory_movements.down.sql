@@ -0,0 +1,2 @@
+drop table if exists inventory_movements;
+alter table products drop column if exists backorders;
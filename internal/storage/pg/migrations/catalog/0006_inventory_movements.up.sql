@@ -0,0 +1,14 @@
+alter table products add column if not exists backorders boolean not null default false;
+
+create table if not exists inventory_movements (
+  id bigint generated always as identity primary key,
+  product_id uuid not null references products(id) on delete cascade,
+  delta int not null,
+  reason text not null check (reason in ('restock', 'sale', 'return', 'adjustment')),
+  note text not null default '',
+  stock_after int not null,
+  created_at timestamptz not null
+);
+
+create index if not exists inventory_movements_product_idx
+  on inventory_movements (product_id, id desc);
@@ -0,0 +1,2 @@
+drop index if exists inventory_movements_order_reason_key;
+alter table inventory_movements drop column if exists order_id;
@@ -0,0 +1,5 @@
+alter table inventory_movements add column if not exists order_id uuid;
+
+-- An order sells and returns its stock at most once each.
+create unique index if not exists inventory_movements_order_reason_key
+  on inventory_movements (order_id, reason) where order_id is not null;
@@ -0,0 +1,2 @@
+drop table if exists stock_reservations;
+alter table products drop column if exists reserved;
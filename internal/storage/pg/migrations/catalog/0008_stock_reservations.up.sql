@@ -0,0 +1,16 @@
+alter table products add column if not exists reserved int not null default 0;
+
+create table if not exists stock_reservations (
+  id uuid primary key,
+  product_id uuid not null references products(id) on delete cascade,
+  qty int not null check (qty > 0),
+  status text not null check (status in ('active', 'confirmed', 'released', 'expired')),
+  order_id uuid,
+  expires_at timestamptz not null,
+  created_at timestamptz not null,
+  updated_at timestamptz not null
+);
+
+-- The sweeper looks for active reservations past their expiry.
+create index if not exists stock_reservations_active_expiry_idx
+  on stock_reservations (expires_at) where status = 'active';
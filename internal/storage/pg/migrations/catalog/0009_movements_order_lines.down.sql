@@ -0,0 +1,3 @@
+drop index if exists inventory_movements_order_product_reason_key;
+create unique index if not exists inventory_movements_order_reason_key
+  on inventory_movements (order_id, reason) where order_id is not null;
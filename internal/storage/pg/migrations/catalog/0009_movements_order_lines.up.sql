@@ -0,0 +1,5 @@
+-- Orders have several lines: an order sells and returns each product's stock
+-- at most once each.
+drop index if exists inventory_movements_order_reason_key;
+create unique index if not exists inventory_movements_order_product_reason_key
+  on inventory_movements (order_id, product_id, reason) where order_id is not null;
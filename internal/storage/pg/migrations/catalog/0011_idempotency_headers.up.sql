@@ -0,0 +1,6 @@
+-- Replays repeat the response headers that matter to clients (ETag,
+-- Location, ...), not only the content type.
+alter table idempotency_keys add column if not exists headers jsonb not null default '{}';
+update idempotency_keys set headers = jsonb_build_object('Content-Type', jsonb_build_array(content_type))
+  where content_type <> '';
+alter table idempotency_keys drop column if exists content_type;
@@ -0,0 +1,9 @@
+// Package migrations embeds the versioned SQL migrations of every service.
+// Files are named <version>_<name>.up.sql / .down.sql, one directory per
+// service database.
+package migrations
+
+import "embed"
+
+//go:embed catalog order pricing
+var FS embed.FS
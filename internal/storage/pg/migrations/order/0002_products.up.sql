@@ -0,0 +1,6 @@
+-- Catalog products as seen by the order service, fed from catalog.events.
+create table if not exists products (
+  id uuid primary key,
+  archived_at timestamptz,
+  updated_at timestamptz not null
+);
@@ -0,0 +1 @@
+alter table orders add column if not exists reservation_id uuid;
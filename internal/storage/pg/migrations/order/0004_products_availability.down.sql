@@ -0,0 +1,3 @@
+alter table products drop column if exists version;
+alter table products drop column if exists backorders;
+alter table products drop column if exists available;
@@ -0,0 +1,4 @@
+-- The order service checks orders against its copy of catalog products.
+alter table products add column if not exists available int not null default 0;
+alter table products add column if not exists backorders boolean not null default false;
+alter table products add column if not exists version bigint not null default 0;
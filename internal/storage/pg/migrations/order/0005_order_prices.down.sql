@@ -0,0 +1,4 @@
+alter table orders drop column if exists total;
+alter table orders drop column if exists currency;
+alter table orders drop column if exists unit_price;
+drop table if exists prices;
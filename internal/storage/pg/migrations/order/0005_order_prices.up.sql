@@ -0,0 +1,12 @@
+-- Prices as seen by the order service, fed from pricing.events.
+create table if not exists prices (
+  product_id uuid primary key,
+  unit_price double precision not null,
+  currency text not null,
+  priced_at timestamptz not null
+);
+
+-- What the customer was quoted when the order was placed.
+alter table orders add column if not exists unit_price double precision not null default 0;
+alter table orders add column if not exists currency text not null default '';
+alter table orders add column if not exists total double precision not null default 0;
@@ -0,0 +1,20 @@
+-- Orders move through placed -> paid -> fulfilled -> delivered, or end up
+-- canceled or refunded.
+alter table orders add constraint orders_status_check
+  check (status in ('placed', 'paid', 'fulfilled', 'delivered', 'canceled', 'refunded'));
+
+-- Every status an order has been in; from_status is null for placement.
+create table if not exists order_status_history (
+  id bigserial primary key,
+  order_id uuid not null references orders(id) on delete cascade,
+  from_status text,
+  to_status text not null,
+  changed_at timestamptz not null
+);
+
+create index if not exists order_status_history_order_idx on order_status_history(order_id, id);
+
+insert into order_status_history(order_id, from_status, to_status, changed_at)
+select id, null, 'placed', created_at from orders;
+insert into order_status_history(order_id, from_status, to_status, changed_at)
+select id, 'placed', status, updated_at from orders where status <> 'placed';
@@ -0,0 +1,3 @@
+drop table if exists cart_lines;
+drop table if exists carts;
+drop table if exists order_lines;
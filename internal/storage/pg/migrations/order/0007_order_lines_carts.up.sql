@@ -0,0 +1,31 @@
+-- Orders have one or more lines; orders.product_id and qty keep the first.
+create table if not exists order_lines (
+  order_id uuid not null references orders(id) on delete cascade,
+  line_no integer not null,
+  product_id uuid not null,
+  qty integer not null check (qty > 0),
+  unit_price double precision not null,
+  total double precision not null,
+  primary key (order_id, line_no)
+);
+
+insert into order_lines(order_id, line_no, product_id, qty, unit_price, total)
+select id, 1, product_id, qty, unit_price, total from orders
+on conflict do nothing;
+
+create table if not exists carts (
+  id uuid primary key,
+  user_id uuid not null references users(id),
+  status text not null check (status in ('open', 'checked_out')),
+  order_id uuid references orders(id),
+  created_at timestamptz not null,
+  updated_at timestamptz not null
+);
+
+create table if not exists cart_lines (
+  cart_id uuid not null references carts(id) on delete cascade,
+  product_id uuid not null,
+  qty integer not null check (qty > 0),
+  added_at timestamptz not null,
+  primary key (cart_id, product_id)
+);
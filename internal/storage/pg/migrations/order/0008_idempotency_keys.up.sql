@@ -0,0 +1,13 @@
+-- Requests made with an Idempotency-Key and the responses replayed for
+-- repeats of them; status is 0 while the first request is being served.
+create table if not exists idempotency_keys (
+  key text primary key,
+  fingerprint text not null,
+  status integer not null default 0,
+  content_type text not null default '',
+  body bytea,
+  created_at timestamptz not null,
+  expires_at timestamptz not null
+);
+
+create index if not exists idempotency_keys_expires_at_idx on idempotency_keys(expires_at);
@@ -0,0 +1,3 @@
+drop index if exists order_lines_product_id_idx;
+drop index if exists orders_product_id_created_at_idx;
+drop index if exists orders_user_id_created_at_idx;
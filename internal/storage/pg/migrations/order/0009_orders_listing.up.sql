@@ -0,0 +1,4 @@
+-- Listing orders by user or product, newest first.
+create index if not exists orders_user_id_created_at_idx on orders(user_id, created_at);
+create index if not exists orders_product_id_created_at_idx on orders(product_id, created_at);
+create index if not exists order_lines_product_id_idx on order_lines(product_id);
@@ -0,0 +1,2 @@
+alter table users drop column if exists deactivated_at;
+alter table users drop column if exists updated_at;
@@ -0,0 +1,5 @@
+-- Users can be deactivated; deactivated users place no orders.
+alter table users add column if not exists updated_at timestamptz;
+update users set updated_at = created_at where updated_at is null;
+alter table users alter column updated_at set not null;
+alter table users add column if not exists deactivated_at timestamptz;
@@ -0,0 +1,3 @@
+-- Users are put into customer segments (new, regular, loyal) that pricing
+-- can price differently.
+alter table users add column if not exists segment text not null default 'new';
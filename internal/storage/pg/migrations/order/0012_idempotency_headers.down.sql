@@ -0,0 +1,3 @@
+alter table idempotency_keys add column if not exists content_type text not null default '';
+update idempotency_keys set content_type = coalesce(headers->'Content-Type'->>0, '');
+alter table idempotency_keys drop column if exists headers;
@@ -0,0 +1,2 @@
+-- Normalized emails are kept.
+drop index if exists users_email_lower_idx;
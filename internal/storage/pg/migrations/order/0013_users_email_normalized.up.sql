@@ -0,0 +1,18 @@
+-- Emails are stored trimmed and in lower case. Users created before that are
+-- normalized here; if two of them end up with the same email the migration
+-- stops and names it, so the users can be merged by hand first.
+do $$
+declare
+  taken text;
+begin
+  select string_agg(email, ', ') into taken from (
+    select lower(btrim(email)) as email from users group by 1 having count(*) > 1
+  ) dup;
+  if taken is not null then
+    raise exception 'users share an email once normalized: %', taken;
+  end if;
+end $$;
+
+update users set email = lower(btrim(email)) where email <> lower(btrim(email));
+
+create unique index if not exists users_email_lower_idx on users (lower(email));
@@ -0,0 +1 @@
+alter table prices drop column if exists segment_prices;
@@ -0,0 +1,2 @@
+-- Prices per customer segment, for segments pricing has a multiplier for.
+alter table prices add column if not exists segment_prices jsonb not null default '{}';
@@ -0,0 +1,7 @@
+create table if not exists pricing_policies (
+  scope text not null,
+  id uuid not null,
+  policy jsonb not null,
+  updated_at timestamptz not null,
+  primary key (scope, id)
+);
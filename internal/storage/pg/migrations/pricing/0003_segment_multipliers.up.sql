@@ -0,0 +1,7 @@
+-- Prices for a customer segment are the plain price times its multiplier;
+-- segments without a row pay the plain price.
+create table if not exists segment_multipliers (
+  segment text primary key,
+  multiplier double precision not null check (multiplier > 0),
+  updated_at timestamptz not null
+);
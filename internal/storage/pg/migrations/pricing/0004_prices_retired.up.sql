@@ -0,0 +1,3 @@
+-- Products archived or deleted in the catalog keep a flagged row, so that
+-- pricing still refuses them after a restart.
+alter table prices add column if not exists retired_at timestamptz;
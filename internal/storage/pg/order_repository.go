@@ -2,11 +2,14 @@ package pg
 
 import (
     "context"
+    "fmt"
+    "strings"
     "time"
 
     "dynamic-pricing/internal/models"
 
     "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -17,37 +20,400 @@ type OrderRepository struct {
 func NewOrderRepository(db *pgxpool.Pool) *OrderRepository { return &OrderRepository{db: db} }
 
 func (r *OrderRepository) CreateUser(ctx context.Context, email string) (models.User, error) {
-    u := models.User{ID: uuid.New(), Email: email, CreatedAt: time.Now().UTC()}
-    _, err := r.db.Exec(ctx, `insert into users(id, email, created_at) values($1,$2,$3)`, u.ID, u.Email, u.CreatedAt)
-    return u, err
-}
-
-func (r *OrderRepository) CreateOrder(ctx context.Context, userID uuid.UUID, productID uuid.UUID, qty int) (models.Order, error) {
-    o := models.Order{
-        ID:        uuid.New(),
-        UserID:    userID,
-        ProductID: productID,
-        Qty:       qty,
-        Status:    "placed",
-        CreatedAt: time.Now().UTC(),
-        UpdatedAt: time.Now().UTC(),
-    }
-    _, err := r.db.Exec(ctx, `insert into orders(id, user_id, product_id, qty, status, created_at, updated_at) values($1,$2,$3,$4,$5,$6,$7)`, o.ID, o.UserID, o.ProductID, o.Qty, o.Status, o.CreatedAt, o.UpdatedAt)
+    u := models.User{ID: uuid.New(), Email: email, Segment: models.SegmentNew, CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+    u.UpdatedAt = u.CreatedAt
+    _, err := r.db.Exec(ctx, `insert into users(id, email, segment, created_at, updated_at) values($1,$2,$3,$4,$5)`, u.ID, u.Email, u.Segment, u.CreatedAt, u.UpdatedAt)
+    return u, mapErr(err)
+}
+
+// userColumns is the select list scanUser expects. The order count skips
+// canceled and refunded orders and is served by the index on
+// orders(user_id, created_at).
+const userColumns = `id, email, deactivated_at, segment,
+    (select count(*) from orders o where o.user_id = users.id and o.status not in ('canceled', 'refunded')),
+    created_at, updated_at`
+
+func scanUser(row pgx.Row) (models.User, error) {
+    var u models.User
+    err := row.Scan(&u.ID, &u.Email, &u.DeactivatedAt, &u.Segment, &u.OrderCount, &u.CreatedAt, &u.UpdatedAt)
+    return u, mapErr(err)
+}
+
+func (r *OrderRepository) GetUser(ctx context.Context, id uuid.UUID) (models.User, error) {
+    return scanUser(r.db.QueryRow(ctx, `select `+userColumns+` from users where id=$1`, id))
+}
+
+// ListUsers returns the users matching q, oldest first.
+func (r *OrderRepository) ListUsers(ctx context.Context, q models.UserQuery) ([]models.User, error) {
+    var (
+        where []string
+        args  []any
+    )
+    arg := func(v any) string {
+        args = append(args, v)
+        return fmt.Sprintf("$%d", len(args))
+    }
+    if q.Email != "" {
+        where = append(where, "email = "+arg(q.Email))
+    }
+    if q.After != nil {
+        where = append(where, fmt.Sprintf("(created_at, id) > (%s, %s)", arg(q.After.CreatedAt), arg(q.After.ID)))
+    }
+    sql := `select ` + userColumns + ` from users`
+    if len(where) > 0 {
+        sql += " where " + strings.Join(where, " and ")
+    }
+    sql += " order by created_at, id"
+    if q.Limit > 0 {
+        sql += " limit " + arg(q.Limit)
+    }
+
+    rows, err := r.db.Query(ctx, sql, args...)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.User
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, u)
+    }
+    return out, mapErr(rows.Err())
+}
+
+// UpdateUser stores the email and deactivation of u. A taken email violates
+// the unique constraint on users.email and comes back as storage.ErrConflict.
+func (r *OrderRepository) UpdateUser(ctx context.Context, u models.User) (models.User, error) {
+    return scanUser(r.db.QueryRow(ctx, `update users set email=$2, deactivated_at=$3, updated_at=$4 where id=$1 returning `+userColumns,
+        u.ID, u.Email, u.DeactivatedAt, time.Now().UTC()))
+}
+
+// SetUserSegment moves a user between segments. A user that is missing or
+// has left segment from in the meantime matches no row and comes back as
+// storage.ErrNotFound.
+func (r *OrderRepository) SetUserSegment(ctx context.Context, id uuid.UUID, from, to string) (models.User, error) {
+    return scanUser(r.db.QueryRow(ctx, `update users set segment=$3, updated_at=$4 where id=$1 and segment=$2 returning `+userColumns,
+        id, from, to, time.Now().UTC()))
+}
+
+// CreateOrder stores o as placed with its lines and starts its status
+// history.
+func (r *OrderRepository) CreateOrder(ctx context.Context, o models.Order) (models.Order, error) {
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var err error
+        o, err = insertOrder(ctx, tx, o)
+        return err
+    })
+    return o, mapErr(err)
+}
+
+func insertOrder(ctx context.Context, tx pgx.Tx, o models.Order) (models.Order, error) {
+    o.Status = models.OrderPlaced
+    o.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    o.UpdatedAt = o.CreatedAt
+    _, err := tx.Exec(ctx, `insert into orders(id, user_id, product_id, qty, status, reservation_id, unit_price, currency, total, created_at, updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
+        o.ID, o.UserID, o.ProductID, o.Qty, o.Status, o.ReservationID, o.UnitPrice, o.Currency, o.Total, o.CreatedAt, o.UpdatedAt)
+    if err != nil {
+        return o, err
+    }
+    for i, l := range o.Lines {
+        _, err = tx.Exec(ctx, `insert into order_lines(order_id, line_no, product_id, qty, unit_price, total) values($1,$2,$3,$4,$5,$6)`,
+            o.ID, i+1, l.ProductID, l.Qty, l.UnitPrice, l.Total)
+        if err != nil {
+            return o, err
+        }
+    }
+    _, err = tx.Exec(ctx, `insert into order_status_history(order_id, from_status, to_status, changed_at) values($1,null,$2,$3)`, o.ID, o.Status, o.CreatedAt)
     return o, err
 }
 
-func (r *OrderRepository) CancelOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+// querier is what reads need from a pool or a transaction.
+type querier interface {
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// orderLines returns the lines of an order in order.
+func orderLines(ctx context.Context, q querier, orderID uuid.UUID) ([]models.OrderLine, error) {
+    rows, err := q.Query(ctx, `select product_id, qty, unit_price, total from order_lines where order_id=$1 order by line_no`, orderID)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.OrderLine
+    for rows.Next() {
+        var l models.OrderLine
+        if err := rows.Scan(&l.ProductID, &l.Qty, &l.UnitPrice, &l.Total); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, l)
+    }
+    return out, mapErr(rows.Err())
+}
+
+// orderColumns is the select list scanOrder expects.
+const orderColumns = `id, user_id, product_id, qty, status, reservation_id, unit_price, currency, total, created_at, updated_at`
+
+func scanOrder(row pgx.Row) (models.Order, error) {
     var o models.Order
-    o.UpdatedAt = time.Now().UTC()
-    row := r.db.QueryRow(ctx, `update orders set status='canceled', updated_at=$2 where id=$1 returning id, user_id, product_id, qty, status, created_at, updated_at`, id, o.UpdatedAt)
-    err := row.Scan(&o.ID, &o.UserID, &o.ProductID, &o.Qty, &o.Status, &o.CreatedAt, &o.UpdatedAt)
-    return o, err
+    err := row.Scan(&o.ID, &o.UserID, &o.ProductID, &o.Qty, &o.Status, &o.ReservationID, &o.UnitPrice, &o.Currency, &o.Total, &o.CreatedAt, &o.UpdatedAt)
+    return o, mapErr(err)
 }
 
-func (r *OrderRepository) GetOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+// TransitionOrder moves an order from status from to status to and records
+// the move. Orders that are missing or no longer in from are reported as
+// storage.ErrNotFound.
+func (r *OrderRepository) TransitionOrder(ctx context.Context, id uuid.UUID, from, to string) (models.Order, error) {
     var o models.Order
-    row := r.db.QueryRow(ctx, `select id, user_id, product_id, qty, status, created_at, updated_at from orders where id=$1`, id)
-    err := row.Scan(&o.ID, &o.UserID, &o.ProductID, &o.Qty, &o.Status, &o.CreatedAt, &o.UpdatedAt)
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var err error
+        o, err = scanOrder(tx.QueryRow(ctx, `update orders set status=$3, updated_at=$4 where id=$1 and status=$2 returning `+orderColumns, id, from, to, time.Now().UTC()))
+        if err != nil {
+            return err
+        }
+        _, err = tx.Exec(ctx, `insert into order_status_history(order_id, from_status, to_status, changed_at) values($1,$2,$3,$4)`, o.ID, from, to, o.UpdatedAt)
+        if err != nil {
+            return err
+        }
+        o.Lines, err = orderLines(ctx, tx, o.ID)
+        return err
+    })
+    return o, mapErr(err)
+}
+
+// OrderHistory lists the status changes of an order, oldest first.
+func (r *OrderRepository) OrderHistory(ctx context.Context, id uuid.UUID) ([]models.OrderStatusChange, error) {
+    rows, err := r.db.Query(ctx, `select order_id, coalesce(from_status, ''), to_status, changed_at from order_status_history where order_id=$1 order by id`, id)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.OrderStatusChange
+    for rows.Next() {
+        var c models.OrderStatusChange
+        if err := rows.Scan(&c.OrderID, &c.From, &c.To, &c.ChangedAt); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, c)
+    }
+    return out, mapErr(rows.Err())
+}
+
+// SaveProduct stores the order service's copy of a catalog product unless a
+// newer version is already there. Archival is kept.
+func (r *OrderRepository) SaveProduct(ctx context.Context, p models.CatalogProduct) error {
+    _, err := r.db.Exec(ctx, `insert into products(id, available, backorders, version, updated_at) values($1,$2,$3,$4,$5)
+on conflict (id) do update set available=excluded.available, backorders=excluded.backorders, version=excluded.version, updated_at=excluded.updated_at
+where products.version <= excluded.version`, p.ID, p.Available, p.Backorders, p.Version, time.Now().UTC())
+    return mapErr(err)
+}
+
+// RetireProduct records that a catalog product was archived or deleted.
+// The earliest time wins when the event is seen more than once.
+func (r *OrderRepository) RetireProduct(ctx context.Context, id uuid.UUID, at time.Time) error {
+    _, err := r.db.Exec(ctx, `insert into products(id, archived_at, updated_at) values($1,$2,$3)
+on conflict (id) do update set archived_at=least(coalesce(products.archived_at, excluded.archived_at), excluded.archived_at), updated_at=excluded.updated_at`, id, at, time.Now().UTC())
+    return mapErr(err)
+}
+
+func (r *OrderRepository) GetProduct(ctx context.Context, id uuid.UUID) (models.CatalogProduct, error) {
+    var p models.CatalogProduct
+    err := r.db.QueryRow(ctx, `select id, available, backorders, version, archived_at, updated_at from products where id=$1`, id).
+        Scan(&p.ID, &p.Available, &p.Backorders, &p.Version, &p.ArchivedAt, &p.UpdatedAt)
+    return p, mapErr(err)
+}
+
+// SavePrice stores a product's price unless a later one is already there.
+func (r *OrderRepository) SavePrice(ctx context.Context, p models.QuotedPrice) error {
+    segmentPrices := p.SegmentPrices
+    if segmentPrices == nil {
+        segmentPrices = map[string]float64{}
+    }
+    _, err := r.db.Exec(ctx, `insert into prices(product_id, unit_price, currency, segment_prices, priced_at) values($1,$2,$3,$4,$5)
+on conflict (product_id) do update set unit_price=excluded.unit_price, currency=excluded.currency, segment_prices=excluded.segment_prices, priced_at=excluded.priced_at
+where prices.priced_at <= excluded.priced_at`, p.ProductID, p.UnitPrice, p.Currency, segmentPrices, p.PricedAt)
+    return mapErr(err)
+}
+
+func (r *OrderRepository) GetPrice(ctx context.Context, productID uuid.UUID) (models.QuotedPrice, error) {
+    var p models.QuotedPrice
+    err := r.db.QueryRow(ctx, `select product_id, unit_price, currency, segment_prices, priced_at from prices where product_id=$1`, productID).
+        Scan(&p.ProductID, &p.UnitPrice, &p.Currency, &p.SegmentPrices, &p.PricedAt)
+    if len(p.SegmentPrices) == 0 {
+        p.SegmentPrices = nil
+    }
+    return p, mapErr(err)
+}
+
+func (r *OrderRepository) GetOrder(ctx context.Context, id uuid.UUID) (models.Order, error) {
+    o, err := scanOrder(r.db.QueryRow(ctx, `select `+orderColumns+` from orders where id=$1`, id))
+    if err != nil {
+        return o, err
+    }
+    o.Lines, err = orderLines(ctx, r.db, id)
     return o, err
 }
 
+// ListOrders returns the orders matching q, newest first. Filters are
+// served by the indexes on orders(user_id, created_at),
+// orders(product_id, created_at) and order_lines(product_id).
+func (r *OrderRepository) ListOrders(ctx context.Context, q models.OrderQuery) ([]models.Order, error) {
+    var (
+        where []string
+        args  []any
+    )
+    arg := func(v any) string {
+        args = append(args, v)
+        return fmt.Sprintf("$%d", len(args))
+    }
+    if q.UserID != nil {
+        where = append(where, "user_id = "+arg(*q.UserID))
+    }
+    if q.ProductID != nil {
+        p := arg(*q.ProductID)
+        where = append(where, "(product_id = "+p+" or id in (select order_id from order_lines where product_id = "+p+"))")
+    }
+    if q.Status != "" {
+        where = append(where, "status = "+arg(q.Status))
+    }
+    if q.From != nil {
+        where = append(where, "created_at >= "+arg(*q.From))
+    }
+    if q.To != nil {
+        where = append(where, "created_at < "+arg(*q.To))
+    }
+    if q.After != nil {
+        where = append(where, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(q.After.CreatedAt), arg(q.After.ID)))
+    }
+
+    sql := `select ` + orderColumns + ` from orders`
+    if len(where) > 0 {
+        sql += " where " + strings.Join(where, " and ")
+    }
+    sql += " order by created_at desc, id desc"
+    if q.Limit > 0 {
+        sql += " limit " + arg(q.Limit)
+    }
+
+    rows, err := r.db.Query(ctx, sql, args...)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var (
+        out []models.Order
+        ids []uuid.UUID
+    )
+    for rows.Next() {
+        o, err := scanOrder(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, o)
+        ids = append(ids, o.ID)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, mapErr(err)
+    }
+    if len(out) == 0 {
+        return out, nil
+    }
+
+    lines, err := r.db.Query(ctx, `select order_id, product_id, qty, unit_price, total from order_lines where order_id = any($1) order by order_id, line_no`, ids)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer lines.Close()
+    byOrder := make(map[uuid.UUID][]models.OrderLine, len(out))
+    for lines.Next() {
+        var (
+            id uuid.UUID
+            l  models.OrderLine
+        )
+        if err := lines.Scan(&id, &l.ProductID, &l.Qty, &l.UnitPrice, &l.Total); err != nil {
+            return nil, mapErr(err)
+        }
+        byOrder[id] = append(byOrder[id], l)
+    }
+    for i := range out {
+        out[i].Lines = byOrder[out[i].ID]
+    }
+    return out, mapErr(lines.Err())
+}
+
+// CreateCart stores c as an empty open cart.
+func (r *OrderRepository) CreateCart(ctx context.Context, c models.Cart) (models.Cart, error) {
+    c.Status = models.CartOpen
+    c.Lines = nil
+    c.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+    c.UpdatedAt = c.CreatedAt
+    _, err := r.db.Exec(ctx, `insert into carts(id, user_id, status, created_at, updated_at) values($1,$2,$3,$4,$5)`, c.ID, c.UserID, c.Status, c.CreatedAt, c.UpdatedAt)
+    return c, mapErr(err)
+}
+
+// GetCart returns a cart with its lines, unpriced, in the order they were
+// added.
+func (r *OrderRepository) GetCart(ctx context.Context, id uuid.UUID) (models.Cart, error) {
+    var c models.Cart
+    err := r.db.QueryRow(ctx, `select id, user_id, status, order_id, created_at, updated_at from carts where id=$1`, id).
+        Scan(&c.ID, &c.UserID, &c.Status, &c.OrderID, &c.CreatedAt, &c.UpdatedAt)
+    if err != nil {
+        return c, mapErr(err)
+    }
+    rows, err := r.db.Query(ctx, `select product_id, qty from cart_lines where cart_id=$1 order by added_at, product_id`, id)
+    if err != nil {
+        return c, mapErr(err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var l models.OrderLine
+        if err := rows.Scan(&l.ProductID, &l.Qty); err != nil {
+            return c, mapErr(err)
+        }
+        c.Lines = append(c.Lines, l)
+    }
+    return c, mapErr(rows.Err())
+}
+
+// SetCartLine sets the qty of a product in an open cart, removing the line
+// when qty is 0. Missing and checked-out carts are reported as not found.
+func (r *OrderRepository) SetCartLine(ctx context.Context, cartID, productID uuid.UUID, qty int) (models.Cart, error) {
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        now := time.Now().UTC().Truncate(time.Microsecond)
+        var id uuid.UUID
+        err := tx.QueryRow(ctx, `update carts set updated_at=$2 where id=$1 and status='open' returning id`, cartID, now).Scan(&id)
+        if err != nil {
+            return err
+        }
+        if qty == 0 {
+            _, err = tx.Exec(ctx, `delete from cart_lines where cart_id=$1 and product_id=$2`, cartID, productID)
+            return err
+        }
+        _, err = tx.Exec(ctx, `insert into cart_lines(cart_id, product_id, qty, added_at) values($1,$2,$3,$4)
+on conflict (cart_id, product_id) do update set qty=excluded.qty`, cartID, productID, qty, now)
+        return err
+    })
+    if err != nil {
+        return models.Cart{}, mapErr(err)
+    }
+    return r.GetCart(ctx, cartID)
+}
+
+// CheckoutCart places o and closes the cart in one transaction, provided
+// the cart is still open and unchanged since updatedAt; otherwise it is
+// reported as not found.
+func (r *OrderRepository) CheckoutCart(ctx context.Context, cartID uuid.UUID, updatedAt time.Time, o models.Order) (models.Order, error) {
+    err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+        var err error
+        if o, err = insertOrder(ctx, tx, o); err != nil {
+            return err
+        }
+        var id uuid.UUID
+        return tx.QueryRow(ctx, `update carts set status='checked_out', order_id=$3, updated_at=$4 where id=$1 and status='open' and updated_at=$2 returning id`,
+            cartID, updatedAt, o.ID, o.CreatedAt).Scan(&id)
+    })
+    return o, mapErr(err)
+}
+
@@ -0,0 +1,53 @@
+package pg
+
+import (
+    "context"
+    "fmt"
+
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PolicyRepository stores category and product pricing policies as JSON.
+type PolicyRepository struct {
+    db *pgxpool.Pool
+}
+
+func NewPolicyRepository(db *pgxpool.Pool) *PolicyRepository { return &PolicyRepository{db: db} }
+
+func (r *PolicyRepository) ListPolicies(ctx context.Context) ([]models.ScopedPolicy, error) {
+    rows, err := r.db.Query(ctx, `select scope, id, policy, updated_at from pricing_policies order by scope, id`)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.ScopedPolicy
+    for rows.Next() {
+        var p models.ScopedPolicy
+        if err := rows.Scan(&p.Scope, &p.ID, &p.Policy, &p.UpdatedAt); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, p)
+    }
+    return out, mapErr(rows.Err())
+}
+
+func (r *PolicyRepository) UpsertPolicy(ctx context.Context, p models.ScopedPolicy) (models.ScopedPolicy, error) {
+    _, err := r.db.Exec(ctx, `insert into pricing_policies(scope, id, policy, updated_at) values($1,$2,$3,$4)
+        on conflict (scope, id) do update set policy=excluded.policy, updated_at=excluded.updated_at`, p.Scope, p.ID, p.Policy, p.UpdatedAt)
+    return p, mapErr(err)
+}
+
+func (r *PolicyRepository) DeletePolicy(ctx context.Context, scope models.PolicyScope, id uuid.UUID) error {
+    tag, err := r.db.Exec(ctx, `delete from pricing_policies where scope=$1 and id=$2`, scope, id)
+    if err != nil {
+        return mapErr(err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%w: %s policy %s", storage.ErrNotFound, scope, id)
+    }
+    return nil
+}
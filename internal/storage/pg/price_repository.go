@@ -2,29 +2,89 @@ package pg
 
 import (
     "context"
+    "fmt"
     "time"
 
     "dynamic-pricing/internal/models"
 
     "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgxpool"
 )
 
-type PriceRepository struct{ db *pgxpool.Pool }
+type PriceRepository struct {
+    db    *pgxpool.Pool
+    table string
+}
+
+func NewPriceRepository(db *pgxpool.Pool) *PriceRepository { return &PriceRepository{db: db, table: "prices"} }
 
-func NewPriceRepository(db *pgxpool.Pool) *PriceRepository { return &PriceRepository{db: db} }
+// NewShadowPriceRepository creates table with the layout of prices if needed and
+// returns a repository writing to it, used to recompute prices side by side.
+func NewShadowPriceRepository(ctx context.Context, db *pgxpool.Pool, table string) (*PriceRepository, error) {
+    t := pgx.Identifier{table}.Sanitize()
+    if _, err := db.Exec(ctx, fmt.Sprintf(`create table if not exists %s (like prices including all)`, t)); err != nil {
+        return nil, err
+    }
+    // Shadow tables created before prices had retired_at lack it.
+    if _, err := db.Exec(ctx, fmt.Sprintf(`alter table %s add column if not exists retired_at timestamptz`, t)); err != nil {
+        return nil, err
+    }
+    return &PriceRepository{db: db, table: t}, nil
+}
 
 func (r *PriceRepository) UpsertPrice(ctx context.Context, productID uuid.UUID, currentPrice float64) (models.Price, error) {
     p := models.Price{ProductID: productID, CurrentPrice: currentPrice, UpdatedAt: time.Now().UTC()}
-    _, err := r.db.Exec(ctx, `insert into prices(product_id, current_price, updated_at) values($1,$2,$3)
+    _, err := r.db.Exec(ctx, `insert into `+r.table+`(product_id, current_price, updated_at) values($1,$2,$3)
         on conflict (product_id) do update set current_price=excluded.current_price, updated_at=excluded.updated_at`, p.ProductID, p.CurrentPrice, p.UpdatedAt)
-    return p, err
+    return p, mapErr(err)
 }
 
 func (r *PriceRepository) GetPrice(ctx context.Context, productID uuid.UUID) (models.Price, error) {
     var p models.Price
-    row := r.db.QueryRow(ctx, `select product_id, current_price, updated_at from prices where product_id=$1`, productID)
+    row := r.db.QueryRow(ctx, `select product_id, current_price, updated_at from `+r.table+` where product_id=$1 and retired_at is null`, productID)
     err := row.Scan(&p.ProductID, &p.CurrentPrice, &p.UpdatedAt)
-    return p, err
+    return p, mapErr(err)
+}
+
+func (r *PriceRepository) ListPrices(ctx context.Context) ([]models.Price, error) {
+    rows, err := r.db.Query(ctx, `select product_id, current_price, updated_at from `+r.table+` where retired_at is null`)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.Price
+    for rows.Next() {
+        var p models.Price
+        if err := rows.Scan(&p.ProductID, &p.CurrentPrice, &p.UpdatedAt); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, p)
+    }
+    return out, mapErr(rows.Err())
+}
+
+// RetirePrice flags the price row of a product as retired, creating one with
+// a zero price for products that were never priced.
+func (r *PriceRepository) RetirePrice(ctx context.Context, productID uuid.UUID, at time.Time) error {
+    _, err := r.db.Exec(ctx, `insert into `+r.table+`(product_id, current_price, updated_at, retired_at) values($1,0,$2,$2)
+        on conflict (product_id) do update set retired_at=excluded.retired_at`, productID, at)
+    return mapErr(err)
 }
 
+func (r *PriceRepository) RetiredProducts(ctx context.Context) ([]uuid.UUID, error) {
+    rows, err := r.db.Query(ctx, `select product_id from `+r.table+` where retired_at is not null`)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []uuid.UUID
+    for rows.Next() {
+        var id uuid.UUID
+        if err := rows.Scan(&id); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, id)
+    }
+    return out, mapErr(rows.Err())
+}
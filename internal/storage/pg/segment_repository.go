@@ -0,0 +1,52 @@
+package pg
+
+import (
+    "context"
+    "fmt"
+
+    "dynamic-pricing/internal/models"
+    "dynamic-pricing/internal/storage"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SegmentRepository stores the price multipliers of customer segments.
+type SegmentRepository struct {
+    db *pgxpool.Pool
+}
+
+func NewSegmentRepository(db *pgxpool.Pool) *SegmentRepository { return &SegmentRepository{db: db} }
+
+func (r *SegmentRepository) ListSegmentMultipliers(ctx context.Context) ([]models.SegmentMultiplier, error) {
+    rows, err := r.db.Query(ctx, `select segment, multiplier, updated_at from segment_multipliers order by segment`)
+    if err != nil {
+        return nil, mapErr(err)
+    }
+    defer rows.Close()
+    var out []models.SegmentMultiplier
+    for rows.Next() {
+        var m models.SegmentMultiplier
+        if err := rows.Scan(&m.Segment, &m.Multiplier, &m.UpdatedAt); err != nil {
+            return nil, mapErr(err)
+        }
+        out = append(out, m)
+    }
+    return out, mapErr(rows.Err())
+}
+
+func (r *SegmentRepository) UpsertSegmentMultiplier(ctx context.Context, m models.SegmentMultiplier) (models.SegmentMultiplier, error) {
+    _, err := r.db.Exec(ctx, `insert into segment_multipliers(segment, multiplier, updated_at) values($1,$2,$3)
+        on conflict (segment) do update set multiplier=excluded.multiplier, updated_at=excluded.updated_at`, m.Segment, m.Multiplier, m.UpdatedAt)
+    return m, mapErr(err)
+}
+
+func (r *SegmentRepository) DeleteSegmentMultiplier(ctx context.Context, segment string) error {
+    tag, err := r.db.Exec(ctx, `delete from segment_multipliers where segment=$1`, segment)
+    if err != nil {
+        return mapErr(err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%w: multiplier of segment %s", storage.ErrNotFound, segment)
+    }
+    return nil
+}
@@ -0,0 +1,1047 @@
+// Package storagetest is the contract suite every repository implementation
+// must pass. Tests in pg and memory run it against their own constructors.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dynamic-pricing/internal/httpserver"
+	"dynamic-pricing/internal/models"
+	"dynamic-pricing/internal/services/catalog"
+	"dynamic-pricing/internal/services/order"
+	"dynamic-pricing/internal/services/pricing"
+	"dynamic-pricing/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// Catalog runs the catalog.Repository contract. newRepo must return an
+// empty repository.
+func Catalog(t *testing.T, newRepo func(t *testing.T) catalog.Repository) {
+	ctx := context.Background()
+
+	t.Run("create_get", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5})
+		require.NoError(t, err)
+		require.False(t, p.UpdatedAt.IsZero())
+
+		got, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, p.ID, got.ID)
+		require.Equal(t, "A", got.Name)
+		require.Equal(t, 10.0, got.BasePrice)
+		require.Equal(t, 5, got.Stock)
+		require.WithinDuration(t, p.UpdatedAt, got.UpdatedAt, time.Millisecond)
+	})
+
+	t.Run("create_duplicate_id", func(t *testing.T) {
+		repo := newRepo(t)
+		p := models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5}
+		_, err := repo.Create(ctx, p)
+		require.NoError(t, err)
+		_, err = repo.Create(ctx, p)
+		require.ErrorIs(t, err, storage.ErrConflict)
+	})
+
+	t.Run("update_keeps_stock", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5})
+		require.NoError(t, err)
+		u, err := repo.Update(ctx, p.ID, "B", 12.5, 0)
+		require.NoError(t, err)
+		require.Equal(t, "B", u.Name)
+		require.Equal(t, 12.5, u.BasePrice)
+		require.Equal(t, 5, u.Stock)
+		require.False(t, u.UpdatedAt.Before(p.UpdatedAt.Truncate(time.Millisecond)))
+	})
+
+	t.Run("update_stock_keeps_name", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5})
+		require.NoError(t, err)
+		u, err := repo.UpdateStock(ctx, p.ID, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, "A", u.Name)
+		require.Equal(t, 0, u.Stock)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		repo := newRepo(t)
+		id := uuid.New()
+		_, err := repo.Get(ctx, id)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.Update(ctx, id, "B", 1, 0)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.UpdateStock(ctx, id, 1, 0)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), p.Version)
+		u, err := repo.Update(ctx, p.ID, "B", 11, 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), u.Version)
+
+		// A writer still holding version 1 loses.
+		_, err = repo.UpdateStock(ctx, p.ID, 1, 1)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		u, err = repo.UpdateStock(ctx, p.ID, 1, 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), u.Version)
+		u, err = repo.UpdateStock(ctx, p.ID, 2, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), u.Version)
+
+		u, err = repo.SetCategory(ctx, p.ID, nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), u.Version)
+		u, err = repo.Archive(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(6), u.Version)
+		got, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(6), got.Version)
+	})
+
+	t.Run("stock_adjustments", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 1, Stock: 10})
+		require.NoError(t, err)
+		move := func(delta int, reason models.MovementReason) models.InventoryMovement {
+			return models.InventoryMovement{ProductID: p.ID, Delta: delta, Reason: reason}
+		}
+
+		// Concurrent sales never oversell: exactly ten of them fit.
+		var wg sync.WaitGroup
+		var sold, refused atomic.Int32
+		for i := 0; i < 15; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := repo.AdjustStock(ctx, move(-1, models.MovementSale))
+				switch {
+				case err == nil:
+					sold.Add(1)
+				case errors.Is(err, storage.ErrNotFound):
+					refused.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(10), sold.Load())
+		require.Equal(t, int32(5), refused.Load())
+		got, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, 0, got.Stock)
+		require.Equal(t, int64(11), got.Version)
+
+		_, err = repo.SetBackorders(ctx, p.ID, true)
+		require.NoError(t, err)
+		u, m, err := repo.AdjustStock(ctx, move(-2, models.MovementSale))
+		require.NoError(t, err)
+		require.Equal(t, -2, u.Stock)
+		require.Equal(t, -2, m.StockAfter)
+		require.False(t, m.CreatedAt.IsZero())
+
+		// Absolute updates land in the ledger as adjustments.
+		_, err = repo.UpdateStock(ctx, p.ID, 5, 0)
+		require.NoError(t, err)
+		ms, err := repo.ListMovements(ctx, models.MovementQuery{ProductID: p.ID, Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, ms, 3)
+		require.Equal(t, models.MovementAdjustment, ms[0].Reason)
+		require.Equal(t, 7, ms[0].Delta)
+		require.Equal(t, 5, ms[0].StockAfter)
+		require.Equal(t, m.ID, ms[1].ID)
+
+		rest, err := repo.ListMovements(ctx, models.MovementQuery{ProductID: p.ID, BeforeID: ms[2].ID, Limit: 100})
+		require.NoError(t, err)
+		require.Len(t, rest, 9)
+		for _, m := range rest {
+			require.Equal(t, models.MovementSale, m.Reason)
+		}
+
+		_, _, err = repo.AdjustStock(ctx, models.InventoryMovement{ProductID: uuid.New(), Delta: 1, Reason: models.MovementRestock})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.Delete(ctx, p.ID)
+		require.NoError(t, err)
+		ms, err = repo.ListMovements(ctx, models.MovementQuery{ProductID: p.ID, Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, ms)
+	})
+
+	t.Run("order_movements", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 1, Stock: 5})
+		require.NoError(t, err)
+		orderID := uuid.New()
+		sale := models.InventoryMovement{ProductID: p.ID, Delta: -2, Reason: models.MovementSale, OrderID: &orderID}
+		_, m, err := repo.AdjustStock(ctx, sale)
+		require.NoError(t, err)
+
+		// The same order cannot sell twice; the failed attempt changes nothing.
+		_, _, err = repo.AdjustStock(ctx, sale)
+		require.ErrorIs(t, err, storage.ErrConflict)
+		got, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, 3, got.Stock)
+
+		// Another product of the same order is a movement of its own.
+		q, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "B", BasePrice: 1, Stock: 5})
+		require.NoError(t, err)
+		_, m2, err := repo.AdjustStock(ctx, models.InventoryMovement{ProductID: q.ID, Delta: -1, Reason: models.MovementSale, OrderID: &orderID})
+		require.NoError(t, err)
+
+		found, err := repo.OrderMovements(ctx, orderID, models.MovementSale)
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+		require.Equal(t, m.ID, found[0].ID)
+		require.Equal(t, orderID, *found[0].OrderID)
+		require.Equal(t, m2.ID, found[1].ID)
+		found, err = repo.OrderMovements(ctx, orderID, models.MovementReturn)
+		require.NoError(t, err)
+		require.Empty(t, found)
+		_, _, err = repo.AdjustStock(ctx, models.InventoryMovement{ProductID: p.ID, Delta: 2, Reason: models.MovementReturn, OrderID: &orderID})
+		require.NoError(t, err)
+	})
+
+	t.Run("reservations", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 1, Stock: 5})
+		require.NoError(t, err)
+		reserve := func(qty int, expires time.Time) (models.Product, models.Reservation, error) {
+			return repo.Reserve(ctx, models.Reservation{ID: uuid.New(), ProductID: p.ID, Qty: qty, ExpiresAt: expires})
+		}
+		later := time.Now().UTC().Add(time.Hour).Truncate(time.Microsecond)
+
+		got, held, err := reserve(3, later)
+		require.NoError(t, err)
+		require.Equal(t, models.ReservationActive, held.Status)
+		require.Equal(t, 3, got.Reserved)
+		require.Equal(t, 2, got.Available())
+		require.Equal(t, p.Version, got.Version)
+
+		// Reserved stock is not available to reservations or sales.
+		_, _, err = reserve(3, later)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, _, err = repo.AdjustStock(ctx, models.InventoryMovement{ProductID: p.ID, Delta: -3, Reason: models.MovementSale})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		ps, err := repo.List(ctx, models.ProductQuery{Sort: models.SortByName, InStock: true})
+		require.NoError(t, err)
+		require.Len(t, ps, 1)
+
+		// Confirming sells the reserved units to the order, once.
+		orderID := uuid.New()
+		sale := models.InventoryMovement{ProductID: p.ID, Delta: -3, Reason: models.MovementSale, OrderID: &orderID}
+		got, res, m, err := repo.ConfirmReservation(ctx, held.ID, sale)
+		require.NoError(t, err)
+		require.Equal(t, models.ReservationConfirmed, res.Status)
+		require.Equal(t, &orderID, res.OrderID)
+		require.Equal(t, 2, got.Stock)
+		require.Equal(t, 0, got.Reserved)
+		require.Equal(t, 2, m.StockAfter)
+		_, _, _, err = repo.ConfirmReservation(ctx, held.ID, sale)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, _, err = repo.ReleaseReservation(ctx, held.ID, models.ReservationReleased)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		// Released and expired reservations give their stock back.
+		_, kept, err := reserve(1, later)
+		require.NoError(t, err)
+		got, res, err = repo.ReleaseReservation(ctx, kept.ID, models.ReservationReleased)
+		require.NoError(t, err)
+		require.Equal(t, models.ReservationReleased, res.Status)
+		require.Equal(t, 0, got.Reserved)
+
+		_, due, err := reserve(1, time.Now().UTC().Add(-time.Second))
+		require.NoError(t, err)
+		_, _, err = reserve(1, later)
+		require.NoError(t, err)
+		expired, err := repo.ExpireReservations(ctx, time.Now().UTC())
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+		require.Equal(t, 1, expired[0].Reserved)
+		res, err = repo.GetReservation(ctx, due.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.ReservationExpired, res.Status)
+		expired, err = repo.ExpireReservations(ctx, time.Now().UTC())
+		require.NoError(t, err)
+		require.Empty(t, expired)
+
+		_, err = repo.GetReservation(ctx, uuid.New())
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("list_filters", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, p := range []models.Product{
+			{ID: uuid.New(), Name: "Red Apple", BasePrice: 3, Stock: 10},
+			{ID: uuid.New(), Name: "green apple", BasePrice: 2, Stock: 0},
+			{ID: uuid.New(), Name: "Banana", BasePrice: 1, Stock: 7},
+			{ID: uuid.New(), Name: "100% juice_box", BasePrice: 5, Stock: 1},
+		} {
+			_, err := repo.Create(ctx, p)
+			require.NoError(t, err)
+		}
+		names := func(q models.ProductQuery) []string {
+			if q.Sort == "" {
+				q.Sort = models.SortByName
+			}
+			ps, err := repo.List(ctx, q)
+			require.NoError(t, err)
+			var out []string
+			for _, p := range ps {
+				out = append(out, p.Name)
+			}
+			return out
+		}
+		min, max := 2.0, 3.0
+		require.Equal(t, []string{"100% juice_box", "Banana", "Red Apple", "green apple"}, names(models.ProductQuery{}))
+		require.Equal(t, []string{"Red Apple", "green apple"}, names(models.ProductQuery{Search: "APPLE"}))
+		require.Equal(t, []string{"100% juice_box"}, names(models.ProductQuery{Search: "0%"}))
+		require.Empty(t, names(models.ProductQuery{Search: "Re_"}))
+		require.Equal(t, []string{"Red Apple", "green apple"}, names(models.ProductQuery{MinPrice: &min, MaxPrice: &max}))
+		require.Equal(t, []string{"100% juice_box", "Banana", "Red Apple"}, names(models.ProductQuery{InStock: true}))
+		require.Equal(t, []string{"Banana", "green apple"}, names(models.ProductQuery{Sort: models.SortByPrice, Limit: 2}))
+		require.Equal(t, []string{"100% juice_box", "Red Apple"}, names(models.ProductQuery{Sort: models.SortByPrice, Desc: true, Limit: 2}))
+	})
+
+	t.Run("archive_delete", func(t *testing.T) {
+		repo := newRepo(t)
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "A", BasePrice: 10, Stock: 5})
+		require.NoError(t, err)
+
+		a, err := repo.Archive(ctx, p.ID)
+		require.NoError(t, err)
+		require.NotNil(t, a.ArchivedAt)
+		got, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.ArchivedAt)
+
+		// Archived products are frozen and hidden from listings by default.
+		_, err = repo.Archive(ctx, p.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.Update(ctx, p.ID, "B", 1, 0)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.UpdateStock(ctx, p.ID, 1, 0)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		listed, err := repo.List(ctx, models.ProductQuery{Sort: models.SortByName})
+		require.NoError(t, err)
+		require.Empty(t, listed)
+		listed, err = repo.List(ctx, models.ProductQuery{Sort: models.SortByName, IncludeArchived: true})
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+
+		d, err := repo.Delete(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, p.ID, d.ID)
+		_, err = repo.Get(ctx, p.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.Delete(ctx, p.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("create_batch", func(t *testing.T) {
+		repo := newRepo(t)
+		c, err := repo.CreateCategory(ctx, models.Category{ID: uuid.New(), Name: "C"})
+		require.NoError(t, err)
+		ps := []models.Product{
+			{ID: uuid.New(), Name: "A", BasePrice: 1, Stock: 1, CategoryID: &c.ID},
+			{ID: uuid.New(), Name: "B", BasePrice: 2, Stock: 0},
+		}
+		created, err := repo.CreateBatch(ctx, ps)
+		require.NoError(t, err)
+		require.Len(t, created, 2)
+		got, err := repo.Get(ctx, ps[0].ID)
+		require.NoError(t, err)
+		require.Equal(t, "A", got.Name)
+		require.Equal(t, c.ID, *got.CategoryID)
+
+		// All or nothing: one bad row keeps the whole batch out.
+		fresh := models.Product{ID: uuid.New(), Name: "C", BasePrice: 1}
+		_, err = repo.CreateBatch(ctx, []models.Product{fresh, {ID: uuid.New(), Name: "D", BasePrice: 1, CategoryID: ptr(uuid.New())}})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.Get(ctx, fresh.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("categories", func(t *testing.T) {
+		repo := newRepo(t)
+		root, err := repo.CreateCategory(ctx, models.Category{ID: uuid.New(), Name: "Food"})
+		require.NoError(t, err)
+		require.False(t, root.CreatedAt.IsZero())
+		child, err := repo.CreateCategory(ctx, models.Category{ID: uuid.New(), ParentID: &root.ID, Name: "Fruit"})
+		require.NoError(t, err)
+		_, err = repo.CreateCategory(ctx, models.Category{ID: uuid.New(), ParentID: ptr(uuid.New()), Name: "Orphan"})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		got, err := repo.GetCategory(ctx, child.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Fruit", got.Name)
+		require.Equal(t, root.ID, *got.ParentID)
+
+		moved, err := repo.UpdateCategory(ctx, child.ID, "Fresh fruit", nil)
+		require.NoError(t, err)
+		require.Nil(t, moved.ParentID)
+		require.Equal(t, "Fresh fruit", moved.Name)
+		_, err = repo.UpdateCategory(ctx, child.ID, "Fruit", &root.ID)
+		require.NoError(t, err)
+
+		all, err := repo.ListCategories(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		require.Equal(t, "Food", all[0].Name)
+
+		p, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "Apple", BasePrice: 1, Stock: 1, CategoryID: &child.ID})
+		require.NoError(t, err)
+		got2, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, child.ID, *got2.CategoryID)
+		other, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "Pear", BasePrice: 1, Stock: 1})
+		require.NoError(t, err)
+		_, err = repo.SetCategory(ctx, other.ID, ptr(uuid.New()))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		assigned, err := repo.SetCategory(ctx, other.ID, &root.ID)
+		require.NoError(t, err)
+		require.Equal(t, root.ID, *assigned.CategoryID)
+
+		listed, err := repo.List(ctx, models.ProductQuery{Sort: models.SortByName, CategoryIDs: []uuid.UUID{child.ID}})
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		require.Equal(t, p.ID, listed[0].ID)
+
+		// In use: a subcategory or products still reference it.
+		require.ErrorIs(t, repo.DeleteCategory(ctx, root.ID), storage.ErrConflict)
+		require.ErrorIs(t, repo.DeleteCategory(ctx, child.ID), storage.ErrConflict)
+		_, err = repo.SetCategory(ctx, p.ID, nil)
+		require.NoError(t, err)
+		require.NoError(t, repo.DeleteCategory(ctx, child.ID))
+		require.ErrorIs(t, repo.DeleteCategory(ctx, child.ID), storage.ErrNotFound)
+		_, err = repo.GetCategory(ctx, child.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("list_keyset", func(t *testing.T) {
+		repo := newRepo(t)
+		// Equal prices force the ID tiebreak.
+		for i := 0; i < 7; i++ {
+			_, err := repo.Create(ctx, models.Product{ID: uuid.New(), Name: "P", BasePrice: float64(i % 3), Stock: 1})
+			require.NoError(t, err)
+		}
+		for _, desc := range []bool{false, true} {
+			all, err := repo.List(ctx, models.ProductQuery{Sort: models.SortByPrice, Desc: desc})
+			require.NoError(t, err)
+			require.Len(t, all, 7)
+
+			var paged []models.Product
+			q := models.ProductQuery{Sort: models.SortByPrice, Desc: desc, Limit: 3}
+			for {
+				page, err := repo.List(ctx, q)
+				require.NoError(t, err)
+				paged = append(paged, page...)
+				if len(page) < q.Limit {
+					break
+				}
+				last := page[len(page)-1]
+				q.After = &last
+			}
+			require.Len(t, paged, 7)
+			for i := range all {
+				require.Equal(t, all[i].ID, paged[i].ID)
+			}
+		}
+	})
+}
+
+// Order runs the order.OrderRepository contract. newRepo must return an
+// empty repository.
+func Order(t *testing.T, newRepo func(t *testing.T) order.OrderRepository) {
+	ctx := context.Background()
+
+	t.Run("create_user_unique_email", func(t *testing.T) {
+		repo := newRepo(t)
+		u, err := repo.CreateUser(ctx, "a@ex.com")
+		require.NoError(t, err)
+		require.NotEqual(t, uuid.Nil, u.ID)
+		require.Equal(t, "a@ex.com", u.Email)
+
+		_, err = repo.CreateUser(ctx, "a@ex.com")
+		require.ErrorIs(t, err, storage.ErrConflict)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		repo := newRepo(t)
+		a, err := repo.CreateUser(ctx, "a@ex.com")
+		require.NoError(t, err)
+		require.True(t, a.Active())
+		time.Sleep(2 * time.Millisecond)
+		b, err := repo.CreateUser(ctx, "b@ex.com")
+		require.NoError(t, err)
+
+		got, err := repo.GetUser(ctx, a.ID)
+		require.NoError(t, err)
+		require.Equal(t, "a@ex.com", got.Email)
+		require.WithinDuration(t, a.CreatedAt, got.CreatedAt, time.Millisecond)
+		_, err = repo.GetUser(ctx, uuid.New())
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		a.Email, a.DeactivatedAt = "a2@ex.com", &at
+		u, err := repo.UpdateUser(ctx, a)
+		require.NoError(t, err)
+		require.Equal(t, "a2@ex.com", u.Email)
+		require.False(t, u.Active())
+		require.False(t, u.UpdatedAt.Before(a.UpdatedAt))
+
+		b.Email = "a2@ex.com"
+		_, err = repo.UpdateUser(ctx, b)
+		require.ErrorIs(t, err, storage.ErrConflict)
+		_, err = repo.UpdateUser(ctx, models.User{ID: uuid.New(), Email: "x@ex.com"})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.CreateUser(ctx, "a@ex.com")
+		require.NoError(t, err, "the old email is free again")
+
+		us, err := repo.ListUsers(ctx, models.UserQuery{Email: "a2@ex.com"})
+		require.NoError(t, err)
+		require.Len(t, us, 1)
+		require.Equal(t, a.ID, us[0].ID)
+		require.NotNil(t, us[0].DeactivatedAt)
+
+		us, err = repo.ListUsers(ctx, models.UserQuery{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, us, 2)
+		require.Equal(t, a.ID, us[0].ID)
+		require.Equal(t, b.ID, us[1].ID)
+		us, err = repo.ListUsers(ctx, models.UserQuery{After: &us[1]})
+		require.NoError(t, err)
+		require.Len(t, us, 1)
+		require.Equal(t, "a@ex.com", us[0].Email)
+	})
+
+	t.Run("user_segment", func(t *testing.T) {
+		repo := newRepo(t)
+		u, err := repo.CreateUser(ctx, "a@ex.com")
+		require.NoError(t, err)
+		require.Equal(t, models.SegmentNew, u.Segment)
+
+		newOrder := func() models.Order {
+			pid := uuid.New()
+			o, err := repo.CreateOrder(ctx, models.Order{ID: uuid.New(), UserID: u.ID, ProductID: pid, Qty: 1, UnitPrice: 1, Currency: "USD", Total: 1,
+				Lines: []models.OrderLine{{ProductID: pid, Qty: 1, UnitPrice: 1, Total: 1}}})
+			require.NoError(t, err)
+			return o
+		}
+		newOrder()
+		o := newOrder()
+		got, err := repo.GetUser(ctx, u.ID)
+		require.NoError(t, err)
+		require.Equal(t, 2, got.OrderCount)
+		_, err = repo.TransitionOrder(ctx, o.ID, models.OrderPlaced, models.OrderCanceled)
+		require.NoError(t, err)
+		us, err := repo.ListUsers(ctx, models.UserQuery{})
+		require.NoError(t, err)
+		require.Len(t, us, 1)
+		require.Equal(t, 1, us[0].OrderCount, "canceled orders do not count")
+
+		got, err = repo.SetUserSegment(ctx, u.ID, models.SegmentNew, models.SegmentLoyal)
+		require.NoError(t, err)
+		require.Equal(t, models.SegmentLoyal, got.Segment)
+		require.Equal(t, 1, got.OrderCount)
+		_, err = repo.SetUserSegment(ctx, u.ID, models.SegmentNew, models.SegmentRegular)
+		require.ErrorIs(t, err, storage.ErrNotFound, "no longer new")
+		_, err = repo.SetUserSegment(ctx, uuid.New(), models.SegmentNew, models.SegmentRegular)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		// Other changes to the user keep its segment.
+		got.Email = "a2@ex.com"
+		got, err = repo.UpdateUser(ctx, got)
+		require.NoError(t, err)
+		require.Equal(t, models.SegmentLoyal, got.Segment)
+	})
+
+	t.Run("place_get_cancel", func(t *testing.T) {
+		repo := newRepo(t)
+		u, err := repo.CreateUser(ctx, "b@ex.com")
+		require.NoError(t, err)
+		pid := uuid.New()
+
+		rid := uuid.New()
+		lines := []models.OrderLine{
+			{ProductID: pid, Qty: 3, UnitPrice: 10.5, Total: 31.5},
+			{ProductID: uuid.New(), Qty: 1, UnitPrice: 2, Total: 2},
+		}
+		o, err := repo.CreateOrder(ctx, models.Order{ID: uuid.New(), UserID: u.ID, ProductID: pid, Qty: 3, ReservationID: &rid, UnitPrice: 10.5, Currency: "USD", Total: 33.5, Lines: lines})
+		require.NoError(t, err)
+		require.Equal(t, "placed", o.Status)
+
+		got, err := repo.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, u.ID, got.UserID)
+		require.Equal(t, pid, got.ProductID)
+		require.Equal(t, 3, got.Qty)
+		require.Equal(t, "placed", got.Status)
+		require.Equal(t, &rid, got.ReservationID)
+		require.Equal(t, 10.5, got.UnitPrice)
+		require.Equal(t, "USD", got.Currency)
+		require.Equal(t, 33.5, got.Total)
+		require.Equal(t, lines, got.Lines)
+
+		c, err := repo.TransitionOrder(ctx, o.ID, models.OrderPlaced, models.OrderCanceled)
+		require.NoError(t, err)
+		require.Equal(t, "canceled", c.Status)
+		require.Equal(t, o.ID, c.ID)
+		require.Equal(t, 3, c.Qty)
+		require.Equal(t, lines, c.Lines)
+	})
+
+	t.Run("list_orders", func(t *testing.T) {
+		repo := newRepo(t)
+		u1, err := repo.CreateUser(ctx, "l1@ex.com")
+		require.NoError(t, err)
+		u2, err := repo.CreateUser(ctx, "l2@ex.com")
+		require.NoError(t, err)
+		p1, p2 := uuid.New(), uuid.New()
+		place := func(u models.User, lines ...models.OrderLine) models.Order {
+			o, err := repo.CreateOrder(ctx, models.Order{ID: uuid.New(), UserID: u.ID, ProductID: lines[0].ProductID, Qty: lines[0].Qty, Lines: lines})
+			require.NoError(t, err)
+			time.Sleep(2 * time.Millisecond)
+			return o
+		}
+		a := place(u1, models.OrderLine{ProductID: p1, Qty: 1})
+		b := place(u2, models.OrderLine{ProductID: p2, Qty: 1}, models.OrderLine{ProductID: p1, Qty: 2})
+		c := place(u1, models.OrderLine{ProductID: p2, Qty: 3})
+		_, err = repo.TransitionOrder(ctx, c.ID, models.OrderPlaced, models.OrderCanceled)
+		require.NoError(t, err)
+
+		ids := func(q models.OrderQuery) []uuid.UUID {
+			os, err := repo.ListOrders(ctx, q)
+			require.NoError(t, err)
+			out := []uuid.UUID{}
+			for _, o := range os {
+				out = append(out, o.ID)
+			}
+			return out
+		}
+		require.Equal(t, []uuid.UUID{c.ID, b.ID, a.ID}, ids(models.OrderQuery{}))
+		require.Equal(t, []uuid.UUID{c.ID, a.ID}, ids(models.OrderQuery{UserID: &u1.ID}))
+		require.Equal(t, []uuid.UUID{b.ID, a.ID}, ids(models.OrderQuery{ProductID: &p1}), "any line matches")
+		require.Equal(t, []uuid.UUID{c.ID}, ids(models.OrderQuery{Status: models.OrderCanceled}))
+		require.Equal(t, []uuid.UUID{b.ID}, ids(models.OrderQuery{From: &b.CreatedAt, To: &c.CreatedAt}))
+		require.Equal(t, []uuid.UUID{c.ID, b.ID}, ids(models.OrderQuery{Limit: 2}))
+		require.Equal(t, []uuid.UUID{a.ID}, ids(models.OrderQuery{After: &b}))
+
+		os, err := repo.ListOrders(ctx, models.OrderQuery{UserID: &u2.ID})
+		require.NoError(t, err)
+		require.Len(t, os, 1)
+		require.Equal(t, b.Lines, os[0].Lines)
+	})
+
+	t.Run("carts", func(t *testing.T) {
+		repo := newRepo(t)
+		u, err := repo.CreateUser(ctx, "c@ex.com")
+		require.NoError(t, err)
+		c, err := repo.CreateCart(ctx, models.Cart{ID: uuid.New(), UserID: u.ID})
+		require.NoError(t, err)
+		require.Equal(t, models.CartOpen, c.Status)
+		_, err = repo.CreateCart(ctx, models.Cart{ID: uuid.New(), UserID: uuid.New()})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		a, b := uuid.New(), uuid.New()
+		_, err = repo.SetCartLine(ctx, c.ID, a, 1)
+		require.NoError(t, err)
+		_, err = repo.SetCartLine(ctx, c.ID, b, 2)
+		require.NoError(t, err)
+		c, err = repo.SetCartLine(ctx, c.ID, a, 3)
+		require.NoError(t, err)
+		require.Equal(t, []models.OrderLine{{ProductID: a, Qty: 3}, {ProductID: b, Qty: 2}}, c.Lines)
+		c, err = repo.SetCartLine(ctx, c.ID, b, 0)
+		require.NoError(t, err)
+		require.Equal(t, []models.OrderLine{{ProductID: a, Qty: 3}}, c.Lines)
+
+		o := models.Order{ID: uuid.New(), UserID: u.ID, ProductID: a, Qty: 3, Lines: []models.OrderLine{{ProductID: a, Qty: 3}}}
+		// A stale view of the cart cannot be checked out.
+		_, err = repo.CheckoutCart(ctx, c.ID, c.UpdatedAt.Add(-time.Second), o)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.GetOrder(ctx, o.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		placed, err := repo.CheckoutCart(ctx, c.ID, c.UpdatedAt, o)
+		require.NoError(t, err)
+		require.Equal(t, models.OrderPlaced, placed.Status)
+		got, err := repo.GetCart(ctx, c.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.CartCheckedOut, got.Status)
+		require.Equal(t, &o.ID, got.OrderID)
+
+		_, err = repo.SetCartLine(ctx, c.ID, a, 1)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.CheckoutCart(ctx, c.ID, got.UpdatedAt, models.Order{ID: uuid.New(), UserID: u.ID, ProductID: a, Qty: 1, Lines: []models.OrderLine{{ProductID: a, Qty: 1}}})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("status_transitions", func(t *testing.T) {
+		repo := newRepo(t)
+		u, err := repo.CreateUser(ctx, "s@ex.com")
+		require.NoError(t, err)
+		o, err := repo.CreateOrder(ctx, models.Order{ID: uuid.New(), UserID: u.ID, ProductID: uuid.New(), Qty: 1})
+		require.NoError(t, err)
+
+		p, err := repo.TransitionOrder(ctx, o.ID, models.OrderPlaced, models.OrderPaid)
+		require.NoError(t, err)
+		require.Equal(t, models.OrderPaid, p.Status)
+		// The guard fails once the order has moved on.
+		_, err = repo.TransitionOrder(ctx, o.ID, models.OrderPlaced, models.OrderCanceled)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		got, err := repo.GetOrder(ctx, o.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.OrderPaid, got.Status)
+
+		_, err = repo.TransitionOrder(ctx, o.ID, models.OrderPaid, models.OrderFulfilled)
+		require.NoError(t, err)
+
+		h, err := repo.OrderHistory(ctx, o.ID)
+		require.NoError(t, err)
+		require.Len(t, h, 3)
+		require.Equal(t, "", h[0].From)
+		require.Equal(t, models.OrderPlaced, h[0].To)
+		require.Equal(t, models.OrderPlaced, h[1].From)
+		require.Equal(t, models.OrderPaid, h[1].To)
+		require.Equal(t, models.OrderFulfilled, h[2].To)
+		require.Equal(t, o.ID, h[2].OrderID)
+	})
+
+	t.Run("retired_products", func(t *testing.T) {
+		repo := newRepo(t)
+		pid := uuid.New()
+		_, err := repo.GetProduct(ctx, pid)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		at := time.Now().UTC().Truncate(time.Microsecond)
+		require.NoError(t, repo.RetireProduct(ctx, pid, at))
+		require.NoError(t, repo.RetireProduct(ctx, pid, at.Add(time.Minute)))
+		p, err := repo.GetProduct(ctx, pid)
+		require.NoError(t, err)
+		require.NotNil(t, p.ArchivedAt)
+		require.True(t, at.Equal(*p.ArchivedAt))
+
+		// A late snapshot does not bring it back.
+		require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: pid, Available: 3, Version: 9}))
+		p, err = repo.GetProduct(ctx, pid)
+		require.NoError(t, err)
+		require.NotNil(t, p.ArchivedAt)
+	})
+
+	t.Run("catalog_products", func(t *testing.T) {
+		repo := newRepo(t)
+		pid := uuid.New()
+		require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: pid, Available: 5, Version: 2}))
+		require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: pid, Available: 9, Version: 1}))
+		p, err := repo.GetProduct(ctx, pid)
+		require.NoError(t, err)
+		require.Equal(t, 5, p.Available)
+		require.Nil(t, p.ArchivedAt)
+
+		require.NoError(t, repo.SaveProduct(ctx, models.CatalogProduct{ID: pid, Available: 0, Backorders: true, Version: 2}))
+		p, err = repo.GetProduct(ctx, pid)
+		require.NoError(t, err)
+		require.Equal(t, 0, p.Available)
+		require.True(t, p.Backorders)
+		require.Equal(t, int64(2), p.Version)
+	})
+
+	t.Run("quoted_prices", func(t *testing.T) {
+		repo := newRepo(t)
+		pid := uuid.New()
+		_, err := repo.GetPrice(ctx, pid)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		at := time.Now().UTC().Truncate(time.Microsecond)
+		require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: pid, UnitPrice: 12, Currency: "USD", PricedAt: at}))
+		// An older price arriving late is ignored.
+		require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: pid, UnitPrice: 10, Currency: "USD", PricedAt: at.Add(-time.Minute)}))
+		p, err := repo.GetPrice(ctx, pid)
+		require.NoError(t, err)
+		require.Equal(t, 12.0, p.UnitPrice)
+		require.Equal(t, "USD", p.Currency)
+		require.True(t, at.Equal(p.PricedAt))
+
+		require.Empty(t, p.SegmentPrices)
+
+		require.NoError(t, repo.SavePrice(ctx, models.QuotedPrice{ProductID: pid, UnitPrice: 14, Currency: "EUR", SegmentPrices: map[string]float64{models.SegmentLoyal: 12.6}, PricedAt: at.Add(time.Minute)}))
+		p, err = repo.GetPrice(ctx, pid)
+		require.NoError(t, err)
+		require.Equal(t, 14.0, p.UnitPrice)
+		require.Equal(t, "EUR", p.Currency)
+		require.Equal(t, map[string]float64{models.SegmentLoyal: 12.6}, p.SegmentPrices)
+		require.Equal(t, 12.6, p.ForSegment(models.SegmentLoyal))
+		require.Equal(t, 14.0, p.ForSegment(models.SegmentNew))
+	})
+
+	t.Run("order_for_unknown_user", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.CreateOrder(ctx, models.Order{ID: uuid.New(), UserID: uuid.New(), ProductID: uuid.New(), Qty: 1})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetOrder(ctx, uuid.New())
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = repo.TransitionOrder(ctx, uuid.New(), models.OrderPlaced, models.OrderCanceled)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+// Price runs the pricing.PriceRepository contract. newRepo must return an
+// empty repository.
+func Price(t *testing.T, newRepo func(t *testing.T) pricing.PriceRepository) {
+	ctx := context.Background()
+
+	t.Run("upsert_get", func(t *testing.T) {
+		repo := newRepo(t)
+		pid := uuid.New()
+		_, err := repo.UpsertPrice(ctx, pid, 10)
+		require.NoError(t, err)
+		_, err = repo.UpsertPrice(ctx, pid, 12.34)
+		require.NoError(t, err)
+
+		got, err := repo.GetPrice(ctx, pid)
+		require.NoError(t, err)
+		require.Equal(t, pid, got.ProductID)
+		require.Equal(t, 12.34, got.CurrentPrice)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetPrice(ctx, uuid.New())
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("retire", func(t *testing.T) {
+		repo := newRepo(t)
+		priced, unpriced := uuid.New(), uuid.New()
+		_, err := repo.UpsertPrice(ctx, priced, 10)
+		require.NoError(t, err)
+
+		at := time.Now().UTC()
+		require.NoError(t, repo.RetirePrice(ctx, priced, at))
+		require.NoError(t, repo.RetirePrice(ctx, unpriced, at))
+		require.NoError(t, repo.RetirePrice(ctx, priced, at), "retiring twice is fine")
+		_, err = repo.GetPrice(ctx, priced)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		ids, err := repo.RetiredProducts(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []uuid.UUID{priced, unpriced}, ids)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		repo := newRepo(t)
+		live, retired := uuid.New(), uuid.New()
+		_, err := repo.UpsertPrice(ctx, live, 10)
+		require.NoError(t, err)
+		_, err = repo.UpsertPrice(ctx, retired, 20)
+		require.NoError(t, err)
+		require.NoError(t, repo.RetirePrice(ctx, retired, time.Now().UTC()))
+
+		ps, err := repo.ListPrices(ctx)
+		require.NoError(t, err)
+		require.Len(t, ps, 1)
+		require.Equal(t, live, ps[0].ProductID)
+		require.Equal(t, 10.0, ps[0].CurrentPrice)
+	})
+
+	t.Run("concurrent_upserts", func(t *testing.T) {
+		repo := newRepo(t)
+		pid := uuid.New()
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(v float64) {
+				defer wg.Done()
+				_, err := repo.UpsertPrice(ctx, pid, v)
+				require.NoError(t, err)
+			}(float64(i))
+		}
+		wg.Wait()
+		got, err := repo.GetPrice(ctx, pid)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, got.CurrentPrice, 0.0)
+	})
+}
+
+// Policy runs the pricing.PolicyRepository contract. newRepo must return an
+// empty repository.
+func Policy(t *testing.T, newRepo func(t *testing.T) pricing.PolicyRepository) {
+	ctx := context.Background()
+
+	t.Run("upsert_list_delete", func(t *testing.T) {
+		repo := newRepo(t)
+		id := uuid.New()
+		cat := models.ScopedPolicy{
+			Scope:     models.PolicyScopeCategory,
+			ID:        id,
+			Policy:    models.PricingPolicy{DemandStep: ptr(0.05)},
+			UpdatedAt: time.Now().UTC(),
+		}
+		_, err := repo.UpsertPolicy(ctx, cat)
+		require.NoError(t, err)
+		// Same ID, other scope: a separate policy.
+		_, err = repo.UpsertPolicy(ctx, models.ScopedPolicy{Scope: models.PolicyScopeProduct, ID: id, UpdatedAt: time.Now().UTC()})
+		require.NoError(t, err)
+		cat.Policy = models.PricingPolicy{Strategy: ptr("fixed")}
+		_, err = repo.UpsertPolicy(ctx, cat)
+		require.NoError(t, err)
+
+		ps, err := repo.ListPolicies(ctx)
+		require.NoError(t, err)
+		require.Len(t, ps, 2)
+		require.Equal(t, models.PolicyScopeCategory, ps[0].Scope)
+		require.Equal(t, "fixed", *ps[0].Policy.Strategy)
+		require.Nil(t, ps[0].Policy.DemandStep)
+
+		require.NoError(t, repo.DeletePolicy(ctx, models.PolicyScopeCategory, id))
+		require.ErrorIs(t, repo.DeletePolicy(ctx, models.PolicyScopeCategory, id), storage.ErrNotFound)
+		ps, err = repo.ListPolicies(ctx)
+		require.NoError(t, err)
+		require.Len(t, ps, 1)
+	})
+}
+
+// Segment runs the pricing.SegmentRepository contract. newRepo must return
+// a repository without multipliers.
+func Segment(t *testing.T, newRepo func(t *testing.T) pricing.SegmentRepository) {
+	ctx := context.Background()
+
+	t.Run("upsert_list_delete", func(t *testing.T) {
+		repo := newRepo(t)
+		// The pg migration seeds defaults; start from none.
+		ms, err := repo.ListSegmentMultipliers(ctx)
+		require.NoError(t, err)
+		for _, m := range ms {
+			require.NoError(t, repo.DeleteSegmentMultiplier(ctx, m.Segment))
+		}
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		_, err = repo.UpsertSegmentMultiplier(ctx, models.SegmentMultiplier{Segment: models.SegmentLoyal, Multiplier: 0.9, UpdatedAt: now})
+		require.NoError(t, err)
+		_, err = repo.UpsertSegmentMultiplier(ctx, models.SegmentMultiplier{Segment: models.SegmentNew, Multiplier: 0.95, UpdatedAt: now})
+		require.NoError(t, err)
+		_, err = repo.UpsertSegmentMultiplier(ctx, models.SegmentMultiplier{Segment: models.SegmentLoyal, Multiplier: 0.8, UpdatedAt: now})
+		require.NoError(t, err)
+
+		ms, err = repo.ListSegmentMultipliers(ctx)
+		require.NoError(t, err)
+		require.Len(t, ms, 2)
+		require.Equal(t, models.SegmentLoyal, ms[0].Segment)
+		require.Equal(t, 0.8, ms[0].Multiplier)
+		require.WithinDuration(t, now, ms[0].UpdatedAt, time.Millisecond)
+
+		require.NoError(t, repo.DeleteSegmentMultiplier(ctx, models.SegmentLoyal))
+		require.ErrorIs(t, repo.DeleteSegmentMultiplier(ctx, models.SegmentLoyal), storage.ErrNotFound)
+		ms, err = repo.ListSegmentMultipliers(ctx)
+		require.NoError(t, err)
+		require.Len(t, ms, 1)
+	})
+}
+
+// Idempotency runs the httpserver.IdempotencyStore contract. newStore must
+// return an empty store.
+func Idempotency(t *testing.T, newStore func(t *testing.T) httpserver.IdempotencyStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := func(key, fp string, at time.Time) models.IdempotencyRecord {
+		return models.IdempotencyRecord{Key: key, Fingerprint: fp, CreatedAt: at, ExpiresAt: at.Add(time.Hour)}
+	}
+
+	t.Run("claim_complete_replay", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.ClaimKey(ctx, record("k", "fp", now))
+		require.NoError(t, err)
+
+		cur, err := s.ClaimKey(ctx, record("k", "fp", now.Add(time.Second)))
+		require.ErrorIs(t, err, storage.ErrConflict)
+		require.True(t, cur.Pending())
+		require.Equal(t, "fp", cur.Fingerprint)
+
+		header := http.Header{"Content-Type": {"application/json"}, "Etag": {`"1"`}, "Location": {"/things/1"}}
+		require.NoError(t, s.CompleteKey(ctx, "k", 201, header, []byte(`{"id":1}`)))
+		cur, err = s.ClaimKey(ctx, record("k", "other", now.Add(time.Second)))
+		require.ErrorIs(t, err, storage.ErrConflict)
+		require.Equal(t, "fp", cur.Fingerprint)
+		require.Equal(t, 201, cur.Status)
+		require.Equal(t, header, cur.Header)
+		require.Equal(t, []byte(`{"id":1}`), cur.Body)
+		require.WithinDuration(t, now.Add(time.Hour), cur.ExpiresAt, time.Millisecond)
+	})
+
+	t.Run("expired_key_is_reclaimed", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.ClaimKey(ctx, record("k", "fp", now))
+		require.NoError(t, err)
+		require.NoError(t, s.CompleteKey(ctx, "k", 201, nil, nil))
+
+		_, err = s.ClaimKey(ctx, record("k", "other", now.Add(time.Hour)))
+		require.NoError(t, err)
+		cur, err := s.ClaimKey(ctx, record("k", "other", now.Add(time.Hour+time.Second)))
+		require.ErrorIs(t, err, storage.ErrConflict)
+		require.True(t, cur.Pending())
+		require.Equal(t, "other", cur.Fingerprint)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.ClaimKey(ctx, record("k", "fp", now))
+		require.NoError(t, err)
+		require.NoError(t, s.ReleaseKey(ctx, "k"))
+		_, err = s.ClaimKey(ctx, record("k", "fp", now))
+		require.NoError(t, err)
+		require.ErrorIs(t, s.CompleteKey(ctx, "missing", 200, nil, nil), storage.ErrNotFound)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.ClaimKey(ctx, record("old", "fp", now.Add(-2*time.Hour)))
+		require.NoError(t, err)
+		_, err = s.ClaimKey(ctx, record("new", "fp", now))
+		require.NoError(t, err)
+
+		n, err := s.PurgeKeys(ctx, now)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		_, err = s.ClaimKey(ctx, record("old", "fp", now))
+		require.NoError(t, err)
+		_, err = s.ClaimKey(ctx, record("new", "fp", now))
+		require.ErrorIs(t, err, storage.ErrConflict)
+	})
+
+	t.Run("concurrent_claims", func(t *testing.T) {
+		s := newStore(t)
+		var won atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.ClaimKey(ctx, record("k", "fp", now)); err == nil {
+					won.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), won.Load())
+	})
+}
+
+func ptr[T any](v T) *T { return &v }